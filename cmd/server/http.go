@@ -2,21 +2,86 @@ package server
 
 import (
 	"anyway/config"
+	"context"
 	"errors"
-	"log"
+	"fmt"
 	"net/http"
+	"os/signal"
+	"syscall"
 
 	"anyway/internal/domain"
 	httphandler "anyway/internal/interfaces/http"
+	"github.com/rs/zerolog/log"
 )
 
-func Run(cfg config.Config, usecase domain.Usecase) {
+// Run starts the HTTP server and blocks until it fails or SIGINT/SIGTERM is received.
+// On a signal the server stops accepting connections and waits up to cfg.ShutdownTimeout
+// for in-flight requests to finish and the buffered asynchronous messages to be sent; the ones
+// still sending then are canceled. Once the usecase is closed, release closes the producer and the rest of
+// the resources within what is left of the timeout; the process is expected to exit if it does not return by then.
+// It returns nil only when the shutdown was clean.
+func Run(cfg config.Config, usecase domain.Usecase, release func(ctx context.Context), opts ...httphandler.Option) error {
 	// Configure router
-	router := httphandler.SetupRouter(usecase)
+	router := httphandler.SetupRouter(cfg, usecase, opts...)
+
+	server := &http.Server{
+		Addr:    ":" + cfg.Port,
+		Handler: router,
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
 	// Start server
-	serverAddr := ":" + cfg.Port
-	if err := router.Run(serverAddr); err != nil && !errors.Is(err, http.ErrServerClosed) {
-		log.Fatalf("Failed to start server: %v", err)
+	serverErr := make(chan error, 1)
+	go func() {
+		log.Info().Msgf("Starting server on %s", server.Addr)
+		serverErr <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serverErr:
+		usecase.Close(context.Background())
+		releaseCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+		defer cancel()
+		releaseErr := releaseWithin(releaseCtx, release)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			return fmt.Errorf("failed to start server: %w", err)
+		}
+		return releaseErr
+	case <-ctx.Done():
+	}
+	stop()
+	log.Info().Msgf("Shutting down server, waiting up to %s for in-flight requests", cfg.ShutdownTimeout)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	shutdownErr := server.Shutdown(shutdownCtx)
+	// Send the buffered asynchronous messages within what is left of the timeout
+	usecase.Close(shutdownCtx)
+	// Then close the producer, which flushes and replays its spool, within what is left as well
+	releaseErr := releaseWithin(shutdownCtx, release)
+	if shutdownErr != nil {
+		return fmt.Errorf("failed to drain in-flight requests: %w", shutdownErr)
+	}
+	if releaseErr != nil {
+		return releaseErr
+	}
+	log.Info().Msg("Server stopped")
+	return nil
+}
+
+// releaseWithin runs release until it returns or ctx is done, so a producer that does not close in time
+// cannot hold the process past the shutdown timeout
+func releaseWithin(ctx context.Context, release func(ctx context.Context)) error {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		release(ctx)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("failed to close the producer: %w", ctx.Err())
 	}
 }
@@ -1,15 +1,160 @@
 package config
 
 import (
+	"fmt"
 	"github.com/joho/godotenv"
 	anysherlog "github.com/narumayase/anysher/log"
 	"github.com/rs/zerolog/log"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// Destination is a backend the messages are produced to, with its own connection settings
+type Destination struct {
+	// Name identifies the destination in the fan-out, the logs, the metrics and the spool directory
+	Name string
+	// Backend is where the messages are produced: kafka, redis-streams, file or stdout
+	Backend string
+	// KafkaBrokers are the Kafka bootstrap servers of the kafka backend
+	KafkaBrokers []string
+	// RedisAddress, RedisPassword and RedisDatabase select the Redis server of the redis-streams backend
+	RedisAddress  string
+	RedisPassword string
+	RedisDatabase int
+	// FilePath is the file the file backend appends the messages to
+	FilePath string
+}
+
 // Config contains the application configuration
 type Config struct {
 	Port string
+	// ProducerDestinations are where messages are produced, one per PRODUCER_BACKEND entry.
+	// With more than one destination every message is fanned out to all of them.
+	ProducerDestinations []Destination
+	// ProducerFanoutMode selects when a fanned out message counts as produced: all, any or primary
+	ProducerFanoutMode string
+	// ProducerFilePath is the file the file destinations append the messages to unless they set their own
+	ProducerFilePath string
+	// RedisAddress is the address of the Redis server of the redis-streams destinations unless they set their own
+	RedisAddress string
+	// RedisPassword is the password of the Redis server
+	RedisPassword string
+	// RedisDatabase is the Redis database number
+	RedisDatabase int
+	// RedisStreamMaxLen trims the streams to approximately this many entries; zero disables trimming
+	RedisStreamMaxLen int64
+	// KafkaBrokers are the Kafka bootstrap servers of the dead-letter queue and of the kafka destinations unless they set their own
+	KafkaBrokers []string
+	// KafkaTopic is the default topic (the stream name for redis-streams), used when a message does not select one
+	KafkaTopic string
+	// KafkaAllowedTopics are the topics (or path.Match patterns) callers may select besides the default one
+	KafkaAllowedTopics []string
+	// BatchMaxItems is the maximum number of messages accepted by a batch request
+	BatchMaxItems int
+	// BatchMaxBytes is the maximum size in bytes of a batch request body
+	BatchMaxBytes int64
+	// RawMaxBytes is the maximum size in bytes of a raw request body
+	RawMaxBytes int64
+	// AsyncMode selects when messages are produced in the background: off, optional or always
+	AsyncMode string
+	// AsyncBufferSize is the number of messages the asynchronous buffer holds
+	AsyncBufferSize int
+	// AsyncWorkers is the number of workers producing the asynchronous messages
+	AsyncWorkers int
+	// SpoolDir is the directory of the local disk spool for undeliverable messages; empty disables the spool
+	SpoolDir string
+	// SpoolMaxBytes is the maximum size of the messages waiting in the spool
+	SpoolMaxBytes int64
+	// SpoolSegmentBytes is the size after which the spool starts a new segment file
+	SpoolSegmentBytes int64
+	// SpoolRetryInterval is how often the spooled messages are replayed while the broker fails
+	SpoolRetryInterval time.Duration
+	// PropagateHeaders are the request headers forwarded as message headers; entries ending in "*" match a prefix
+	PropagateHeaders []string
+	// PropagateHeadersRename maps request headers to the name of the message header they are forwarded as
+	PropagateHeadersRename map[string]string
+	// PropagateHeadersDeny are request headers never forwarded, besides Authorization and cookies
+	PropagateHeadersDeny []string
+	// StaticHeaders are added to every message
+	StaticHeaders map[string]string
+	// IdempotencyStore is where the idempotency keys are kept: memory, file or none
+	IdempotencyStore string
+	// IdempotencyTTL is how long an idempotency key is remembered
+	IdempotencyTTL time.Duration
+	// IdempotencyMaxKeys is the number of idempotency keys kept by the memory and file stores
+	IdempotencyMaxKeys int
+	// IdempotencyFile is the file of the file store
+	IdempotencyFile string
+	// RetryMaxAttempts is the number of times a message is produced before failing, counting the first one
+	RetryMaxAttempts int
+	// RetryInitialBackoff and RetryMaxBackoff bound the exponential wait between attempts
+	RetryInitialBackoff time.Duration
+	RetryMaxBackoff     time.Duration
+	// RetryJitter is the randomised fraction of every wait, from 0 to 1
+	RetryJitter float64
+	// RetryTimeout bounds all the attempts of a message
+	RetryTimeout time.Duration
+	// RetryAttemptTimeout bounds every attempt of a message, so a backend that does not answer leaves time to retry
+	RetryAttemptTimeout time.Duration
+	// BreakerFailureThreshold is the number of consecutive failures that opens the circuit breaker of a backend; zero disables it
+	BreakerFailureThreshold int
+	// BreakerOpenTimeout is how long an open circuit breaker rejects messages before probing the backend
+	BreakerOpenTimeout time.Duration
+	// BreakerHalfOpenRequests is the number of probes that must succeed to close the circuit breaker
+	BreakerHalfOpenRequests int
+	// DedupWindow is how long identical messages are dropped after one is sent; zero disables deduplication
+	DedupWindow time.Duration
+	// DeadLetterBackend is where the rejected and undeliverable messages are routed: kafka or file; empty disables it
+	DeadLetterBackend string
+	// DeadLetterTopic is the Kafka topic of the dead-letter queue
+	DeadLetterTopic string
+	// DeadLetterFilePath is the file the dead letters are appended to by the file backend
+	DeadLetterFilePath string
+	// PolicyFile is the YAML file with the rules authorising the callers; empty disables the policy
+	PolicyFile string
+	// SchemaDir is the directory of the JSON Schemas the messages are validated against; empty disables validation
+	SchemaDir string
+	// SchemaValidationMode selects what happens to invalid messages: reject or warn
+	SchemaValidationMode string
+	// SchemaRegistryURL is the URL of the schema registry the messages are encoded with; empty disables encoding
+	SchemaRegistryURL string
+	// SchemaRegistryUsername and SchemaRegistryPassword are the basic authentication credentials of the schema registry
+	SchemaRegistryUsername string
+	SchemaRegistryPassword string
+	// SchemaRegistryFile is a local file with the schemas, used instead of SchemaRegistryURL
+	SchemaRegistryFile string
+	// SchemaRegistryCacheTTL is how long the schemas looked up in the registry are cached
+	SchemaRegistryCacheTTL time.Duration
+	// AuthAPIKeys maps each caller to the hex encoded SHA-256 hash of its API key
+	AuthAPIKeys map[string]string
+	// AuthAPIKeysFile is a file with more caller=hash API keys
+	AuthAPIKeysFile string
+	// AuthJWKSFile is a JSON Web Key Set file with the keys verifying the bearer tokens
+	AuthJWKSFile string
+	// AuthJWTIssuer and AuthJWTAudience, when set, must match the iss and aud claims of the tokens
+	AuthJWTIssuer   string
+	AuthJWTAudience string
+	// AuthJWTCallerClaim is the claim of the tokens identifying the caller
+	AuthJWTCallerClaim string
+	// RateLimitKey selects what identifies a client for the rate limits: api_key, ip or header:<name>
+	RateLimitKey string
+	// RateLimitRequests and RateLimitBytes are the requests and body bytes per second of each client; zero disables them
+	RateLimitRequests float64
+	RateLimitBytes    float64
+	// RateLimitGlobalRequests and RateLimitGlobalBytes are the requests and body bytes per second of all the clients
+	RateLimitGlobalRequests float64
+	RateLimitGlobalBytes    float64
+	// CORSAllowedOrigins are the origins allowed to call the API from a browser; empty allows every origin
+	CORSAllowedOrigins []string
+	// TracingExporter selects where the spans are exported: none, otlp or stdout
+	TracingExporter string
+	// TracingServiceName is the service name of the exported spans
+	TracingServiceName string
+	// ShutdownTimeout is how long the server waits for in-flight requests when stopping
+	ShutdownTimeout time.Duration
 }
 
 // Load loads configuration from environment variables or an .env file
@@ -19,9 +164,171 @@ func Load() Config {
 	if err := godotenv.Load(); err != nil {
 		log.Debug().Msgf("No .env file found or error loading .env file: %v", err)
 	}
-	return Config{
-		Port: getEnv("PORT", "8080"),
+	cfg := Config{
+		Port:               getEnv("PORT", "8080"),
+		ProducerFanoutMode: getEnv("PRODUCER_FANOUT_MODE", "all"),
+		ProducerFilePath:   getEnv("PRODUCER_FILE_PATH", "messages.jsonl"),
+		RedisAddress:       getEnv("REDIS_ADDRESS", "localhost:6379"),
+		RedisPassword:      getEnv("REDIS_PASSWORD", ""),
+		RedisDatabase:      getEnvAsInt("REDIS_DATABASE", 0),
+		RedisStreamMaxLen:  int64(getEnvAsInt("REDIS_STREAM_MAX_LEN", 0)),
+		KafkaBrokers:       getEnvAsSlice("KAFKA_BROKER", []string{"localhost:9092"}),
+		KafkaTopic:         getEnv("KAFKA_TOPIC", "anyway-topic"),
+		KafkaAllowedTopics: getEnvAsSlice("KAFKA_ALLOWED_TOPICS", nil),
+		BatchMaxItems:      getEnvAsInt("BATCH_MAX_ITEMS", 500),
+		BatchMaxBytes:      int64(getEnvAsInt("BATCH_MAX_BYTES", 5<<20)),
+		RawMaxBytes:        int64(getEnvAsInt("RAW_MAX_BYTES", 1<<20)),
+		AsyncMode:          getEnv("ASYNC_MODE", "off"),
+		AsyncBufferSize:    getEnvAsInt("ASYNC_BUFFER_SIZE", 1000),
+		AsyncWorkers:       getEnvAsInt("ASYNC_WORKERS", 4),
+		SpoolDir:           getEnv("SPOOL_DIR", ""),
+		SpoolMaxBytes:      int64(getEnvAsInt("SPOOL_MAX_BYTES", 1<<30)),
+		SpoolSegmentBytes:  int64(getEnvAsInt("SPOOL_SEGMENT_BYTES", 64<<20)),
+		SpoolRetryInterval: getEnvAsDuration("SPOOL_RETRY_INTERVAL", 5*time.Second),
+		ShutdownTimeout:    getEnvAsDuration("SHUTDOWN_TIMEOUT", 30*time.Second),
+		TracingExporter:    getEnv("TRACING_EXPORTER", "none"),
+		TracingServiceName: getEnv("TRACING_SERVICE_NAME", "anyway"),
+
+		IdempotencyStore:        getEnv("IDEMPOTENCY_STORE", "memory"),
+		IdempotencyTTL:          getEnvAsDuration("IDEMPOTENCY_TTL", 24*time.Hour),
+		IdempotencyMaxKeys:      getEnvAsInt("IDEMPOTENCY_MAX_KEYS", 100000),
+		IdempotencyFile:         getEnv("IDEMPOTENCY_FILE", "idempotency.jsonl"),
+		RetryMaxAttempts:        getEnvAsInt("RETRY_MAX_ATTEMPTS", 3),
+		RetryInitialBackoff:     getEnvAsDuration("RETRY_INITIAL_BACKOFF", 100*time.Millisecond),
+		RetryMaxBackoff:         getEnvAsDuration("RETRY_MAX_BACKOFF", 2*time.Second),
+		RetryJitter:             getEnvAsFloat("RETRY_JITTER", 0.5),
+		RetryTimeout:            getEnvAsDuration("RETRY_TIMEOUT", 10*time.Second),
+		RetryAttemptTimeout:     getEnvAsDuration("RETRY_ATTEMPT_TIMEOUT", 3*time.Second),
+		BreakerFailureThreshold: getEnvAsInt("BREAKER_FAILURE_THRESHOLD", 5),
+		BreakerOpenTimeout:      getEnvAsDuration("BREAKER_OPEN_TIMEOUT", 30*time.Second),
+		BreakerHalfOpenRequests: getEnvAsInt("BREAKER_HALF_OPEN_REQUESTS", 1),
+		DedupWindow:             getEnvAsDuration("DEDUP_WINDOW", 0),
+		DeadLetterBackend:       getEnv("DEAD_LETTER_BACKEND", ""),
+		DeadLetterTopic:         getEnv("DEAD_LETTER_TOPIC", "anyway-dead-letter"),
+		DeadLetterFilePath:      getEnv("DEAD_LETTER_FILE_PATH", "dead-letter.jsonl"),
+		PolicyFile:              getEnv("POLICY_FILE", ""),
+		SchemaDir:               getEnv("SCHEMA_DIR", ""),
+		SchemaValidationMode:    getEnv("SCHEMA_VALIDATION_MODE", "reject"),
+
+		SchemaRegistryURL:      getEnv("SCHEMA_REGISTRY_URL", ""),
+		SchemaRegistryUsername: getEnv("SCHEMA_REGISTRY_USERNAME", ""),
+		SchemaRegistryPassword: getEnv("SCHEMA_REGISTRY_PASSWORD", ""),
+		SchemaRegistryFile:     getEnv("SCHEMA_REGISTRY_FILE", ""),
+		SchemaRegistryCacheTTL: getEnvAsDuration("SCHEMA_REGISTRY_CACHE_TTL", 5*time.Minute),
+
+		AuthAPIKeys:        getEnvAsMap("AUTH_API_KEYS"),
+		AuthAPIKeysFile:    getEnv("AUTH_API_KEYS_FILE", ""),
+		AuthJWKSFile:       getEnv("AUTH_JWKS_FILE", ""),
+		AuthJWTIssuer:      getEnv("AUTH_JWT_ISSUER", ""),
+		AuthJWTAudience:    getEnv("AUTH_JWT_AUDIENCE", ""),
+		AuthJWTCallerClaim: getEnv("AUTH_JWT_CALLER_CLAIM", "sub"),
+		CORSAllowedOrigins: getEnvAsSlice("CORS_ALLOWED_ORIGINS", nil),
+
+		RateLimitKey:            getEnv("RATE_LIMIT_KEY", "api_key"),
+		RateLimitRequests:       getEnvAsFloat("RATE_LIMIT_REQUESTS", 0),
+		RateLimitBytes:          getEnvAsFloat("RATE_LIMIT_BYTES", 0),
+		RateLimitGlobalRequests: getEnvAsFloat("RATE_LIMIT_GLOBAL_REQUESTS", 0),
+		RateLimitGlobalBytes:    getEnvAsFloat("RATE_LIMIT_GLOBAL_BYTES", 0),
+
+		PropagateHeaders:       getEnvAsSlice("PROPAGATE_HEADERS", nil),
+		PropagateHeadersRename: getEnvAsMap("PROPAGATE_HEADERS_RENAME"),
+		PropagateHeadersDeny:   getEnvAsSlice("PROPAGATE_HEADERS_DENY", nil),
+		StaticHeaders:          getEnvAsMap("STATIC_HEADERS"),
+	}
+	cfg.ProducerDestinations = loadDestinations(getEnvAsSlice("PRODUCER_BACKEND", []string{"kafka"}), cfg)
+	return cfg
+}
+
+// loadDestinations creates a destination for every PRODUCER_BACKEND entry, either a backend or name=backend.
+// A destination is named after its backend unless the entry names it, and takes its connection settings
+// from PRODUCER_<NAME>_ variables (e.g. PRODUCER_NEW_KAFKA_BROKER for "new=kafka"), falling back to the global ones.
+func loadDestinations(entries []string, cfg Config) []Destination {
+	destinations := make([]Destination, 0, len(entries))
+	for _, entry := range entries {
+		name, backend, found := strings.Cut(entry, "=")
+		name, backend = strings.TrimSpace(name), strings.TrimSpace(backend)
+		if !found {
+			backend = name
+		}
+		prefix := "PRODUCER_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+		destinations = append(destinations, Destination{
+			Name:          name,
+			Backend:       backend,
+			KafkaBrokers:  getEnvAsSlice(prefix+"KAFKA_BROKER", cfg.KafkaBrokers),
+			RedisAddress:  getEnv(prefix+"REDIS_ADDRESS", cfg.RedisAddress),
+			RedisPassword: getEnv(prefix+"REDIS_PASSWORD", cfg.RedisPassword),
+			RedisDatabase: getEnvAsInt(prefix+"REDIS_DATABASE", cfg.RedisDatabase),
+			FilePath:      getEnv(prefix+"FILE_PATH", cfg.ProducerFilePath),
+		})
+	}
+	return destinations
+}
+
+// The valid values of the settings that select a behaviour
+var (
+	asyncModes            = []string{"off", "optional", "always"}
+	producerBackends      = []string{"kafka", "redis-streams", "file", "stdout"}
+	fanoutModes           = []string{"all", "any", "primary"}
+	idempotencyStores     = []string{"memory", "file", "none"}
+	deadLetterBackends    = []string{"kafka", "file"}
+	schemaValidationModes = []string{"reject", "warn"}
+	tracingExporters      = []string{"none", "otlp", "stdout"}
+	rateLimitKeys         = []string{"api_key", "ip"}
+)
+
+// rateLimitKeyHeaderPrefix followed by a header name identifies the rate limited clients by that header
+const rateLimitKeyHeaderPrefix = "header:"
+
+// Validate checks the values that cannot fall back to a default, so a typo stops the service at startup
+func (c Config) Validate() error {
+	if err := oneOf("ASYNC_MODE", c.AsyncMode, asyncModes); err != nil {
+		return err
+	}
+	if err := oneOf("PRODUCER_FANOUT_MODE", c.ProducerFanoutMode, fanoutModes); err != nil {
+		return err
+	}
+	if err := oneOf("IDEMPOTENCY_STORE", c.IdempotencyStore, idempotencyStores); err != nil {
+		return err
+	}
+	if c.DeadLetterBackend != "" {
+		if err := oneOf("DEAD_LETTER_BACKEND", c.DeadLetterBackend, deadLetterBackends); err != nil {
+			return err
+		}
+	}
+	if err := oneOf("SCHEMA_VALIDATION_MODE", c.SchemaValidationMode, schemaValidationModes); err != nil {
+		return err
+	}
+	if err := oneOf("TRACING_EXPORTER", c.TracingExporter, tracingExporters); err != nil {
+		return err
+	}
+	if header, ok := strings.CutPrefix(c.RateLimitKey, rateLimitKeyHeaderPrefix); !(ok && header != "") && !slices.Contains(rateLimitKeys, c.RateLimitKey) {
+		return fmt.Errorf("invalid RATE_LIMIT_KEY %q, expected one of %s, %s<name>", c.RateLimitKey, strings.Join(rateLimitKeys, ", "), rateLimitKeyHeaderPrefix)
+	}
+	names := make(map[string]bool, len(c.ProducerDestinations))
+	for _, destination := range c.ProducerDestinations {
+		if destination.Name == "" || destination.Backend == "" {
+			return fmt.Errorf("invalid PRODUCER_BACKEND entry %q, expected a backend or name=backend", destination.Name+"="+destination.Backend)
+		}
+		if err := oneOf("PRODUCER_BACKEND", destination.Backend, producerBackends); err != nil {
+			return err
+		}
+		if names[destination.Name] {
+			return fmt.Errorf("duplicate PRODUCER_BACKEND destination %q, name every destination of the same backend, e.g. new=%s", destination.Name, destination.Backend)
+		}
+		names[destination.Name] = true
 	}
+	if c.SpoolDir != "" && c.SpoolRetryInterval <= 0 {
+		return fmt.Errorf("invalid SPOOL_RETRY_INTERVAL %s, expected a positive duration", c.SpoolRetryInterval)
+	}
+	return nil
+}
+
+// oneOf checks that the value of the setting is one of the given values
+func oneOf(name, value string, values []string) error {
+	if !slices.Contains(values, value) {
+		return fmt.Errorf("invalid %s %q, expected one of %s", name, value, strings.Join(values, ", "))
+	}
+	return nil
 }
 
 // getEnv gets an environment variable or returns a default value
@@ -31,3 +338,79 @@ func getEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+// getEnvAsSlice gets a comma separated environment variable as a slice or returns a default value
+func getEnvAsSlice(key string, defaultValue []string) []string {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	var values []string
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// getEnvAsMap gets a comma separated list of name=value pairs as a map, or nil when it is not set.
+// Pairs without "=" or without a name are skipped.
+func getEnvAsMap(key string) map[string]string {
+	var values map[string]string
+	for _, pair := range getEnvAsSlice(key, nil) {
+		name, value, found := strings.Cut(pair, "=")
+		name = strings.TrimSpace(name)
+		if !found || name == "" {
+			log.Warn().Msgf("invalid value %q for %s, expected name=value", pair, key)
+			continue
+		}
+		if values == nil {
+			values = map[string]string{}
+		}
+		values[name] = strings.TrimSpace(value)
+	}
+	return values
+}
+
+// getEnvAsInt gets an environment variable as an integer or returns a default value
+func getEnvAsInt(key string, defaultValue int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	intValue, err := strconv.Atoi(value)
+	if err != nil {
+		log.Warn().Err(err).Msgf("invalid value for %s, using default %d", key, defaultValue)
+		return defaultValue
+	}
+	return intValue
+}
+
+// getEnvAsFloat gets an environment variable as a number or returns a default value
+func getEnvAsFloat(key string, defaultValue float64) float64 {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	floatValue, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		log.Warn().Err(err).Msgf("invalid value for %s, using default %g", key, defaultValue)
+		return defaultValue
+	}
+	return floatValue
+}
+
+// getEnvAsDuration gets an environment variable as a duration (e.g. "30s") or returns a default value
+func getEnvAsDuration(key string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		log.Warn().Err(err).Msgf("invalid value for %s, using default %s", key, defaultValue)
+		return defaultValue
+	}
+	return duration
+}
@@ -4,6 +4,7 @@ import (
 	anysherlog "github.com/narumayase/anysher/log"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/rs/zerolog"
 	"github.com/stretchr/testify/assert"
@@ -264,3 +265,309 @@ func TestLoad_WithoutEnvFile(t *testing.T) {
 
 	assert.Equal(t, "8080", config.Port)
 }
+
+func TestGetEnvAsSlice(t *testing.T) {
+	tests := []struct {
+		name     string
+		envValue string
+		expected []string
+	}{
+		{
+			name:     "comma separated values",
+			envValue: "orders,events.*",
+			expected: []string{"orders", "events.*"},
+		},
+		{
+			name:     "values with spaces and empty items",
+			envValue: " orders , ,events.* ",
+			expected: []string{"orders", "events.*"},
+		},
+		{
+			name:     "environment variable does not exist",
+			envValue: "",
+			expected: []string{"default"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Unsetenv("TEST_SLICE_KEY")
+			if tt.envValue != "" {
+				os.Setenv("TEST_SLICE_KEY", tt.envValue)
+				defer os.Unsetenv("TEST_SLICE_KEY")
+			}
+
+			result := getEnvAsSlice("TEST_SLICE_KEY", []string{"default"})
+			assert.Equal(t, tt.expected, result)
+		})
+	}
+}
+
+func TestGetEnvAsMap(t *testing.T) {
+	tests := []struct {
+		name     string
+		envValue string
+		expected map[string]string
+	}{
+		{
+			name:     "comma separated pairs",
+			envValue: "X-Tenant-Id=tenant_id, environment = production",
+			expected: map[string]string{"X-Tenant-Id": "tenant_id", "environment": "production"},
+		},
+		{
+			name:     "invalid pairs are skipped",
+			envValue: "region=eu-west-1,invalid,=value",
+			expected: map[string]string{"region": "eu-west-1"},
+		},
+		{
+			name:     "environment variable does not exist",
+			envValue: "",
+			expected: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Unsetenv("TEST_MAP_KEY")
+			if tt.envValue != "" {
+				os.Setenv("TEST_MAP_KEY", tt.envValue)
+				defer os.Unsetenv("TEST_MAP_KEY")
+			}
+
+			result := getEnvAsMap("TEST_MAP_KEY")
+			assert.Equal(t, tt.expected, result)
+		})
+	}
+}
+
+func TestLoadDestinations(t *testing.T) {
+	os.Setenv("PRODUCER_NEW_CLUSTER_KAFKA_BROKER", "new-1:9092,new-2:9092")
+	defer os.Unsetenv("PRODUCER_NEW_CLUSTER_KAFKA_BROKER")
+	os.Setenv("PRODUCER_ARCHIVE_FILE_PATH", "archive.jsonl")
+	defer os.Unsetenv("PRODUCER_ARCHIVE_FILE_PATH")
+
+	cfg := Config{
+		KafkaBrokers:     []string{"old:9092"},
+		RedisAddress:     "localhost:6379",
+		ProducerFilePath: "messages.jsonl",
+	}
+	destinations := loadDestinations([]string{"kafka", "new-cluster=kafka", "archive = file"}, cfg)
+
+	// Assert that a plain backend is named after it and takes the global settings
+	assert.Equal(t, Destination{
+		Name:         "kafka",
+		Backend:      "kafka",
+		KafkaBrokers: []string{"old:9092"},
+		RedisAddress: "localhost:6379",
+		FilePath:     "messages.jsonl",
+	}, destinations[0])
+
+	// Assert that a named destination takes the settings prefixed by its name
+	assert.Equal(t, "new-cluster", destinations[1].Name)
+	assert.Equal(t, "kafka", destinations[1].Backend)
+	assert.Equal(t, []string{"new-1:9092", "new-2:9092"}, destinations[1].KafkaBrokers)
+	assert.Equal(t, "archive", destinations[2].Name)
+	assert.Equal(t, "file", destinations[2].Backend)
+	assert.Equal(t, "archive.jsonl", destinations[2].FilePath)
+}
+
+func TestGetEnvAsInt(t *testing.T) {
+	tests := []struct {
+		name     string
+		envValue string
+		expected int
+	}{
+		{
+			name:     "valid integer",
+			envValue: "100",
+			expected: 100,
+		},
+		{
+			name:     "invalid integer uses default",
+			envValue: "not-a-number",
+			expected: 500,
+		},
+		{
+			name:     "environment variable does not exist",
+			envValue: "",
+			expected: 500,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Unsetenv("TEST_INT_KEY")
+			if tt.envValue != "" {
+				os.Setenv("TEST_INT_KEY", tt.envValue)
+				defer os.Unsetenv("TEST_INT_KEY")
+			}
+
+			result := getEnvAsInt("TEST_INT_KEY", 500)
+			assert.Equal(t, tt.expected, result)
+		})
+	}
+}
+
+func TestGetEnvAsDuration(t *testing.T) {
+	tests := []struct {
+		name     string
+		envValue string
+		expected time.Duration
+	}{
+		{
+			name:     "valid duration",
+			envValue: "5s",
+			expected: 5 * time.Second,
+		},
+		{
+			name:     "invalid duration uses default",
+			envValue: "five seconds",
+			expected: 30 * time.Second,
+		},
+		{
+			name:     "environment variable does not exist",
+			envValue: "",
+			expected: 30 * time.Second,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Unsetenv("TEST_DURATION_KEY")
+			if tt.envValue != "" {
+				os.Setenv("TEST_DURATION_KEY", tt.envValue)
+				defer os.Unsetenv("TEST_DURATION_KEY")
+			}
+
+			result := getEnvAsDuration("TEST_DURATION_KEY", 30*time.Second)
+			assert.Equal(t, tt.expected, result)
+		})
+	}
+}
+
+func TestGetEnvAsFloat(t *testing.T) {
+	tests := []struct {
+		name     string
+		envValue string
+		expected float64
+	}{
+		{
+			name:     "valid number",
+			envValue: "0.5",
+			expected: 0.5,
+		},
+		{
+			name:     "invalid number uses default",
+			envValue: "half",
+			expected: 10,
+		},
+		{
+			name:     "environment variable does not exist",
+			envValue: "",
+			expected: 10,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Unsetenv("TEST_FLOAT_KEY")
+			if tt.envValue != "" {
+				os.Setenv("TEST_FLOAT_KEY", tt.envValue)
+				defer os.Unsetenv("TEST_FLOAT_KEY")
+			}
+
+			result := getEnvAsFloat("TEST_FLOAT_KEY", 10)
+			assert.Equal(t, tt.expected, result)
+		})
+	}
+}
+
+// validConfig returns a configuration with the default value of every setting that is validated
+func validConfig() Config {
+	return Config{
+		ProducerDestinations: []Destination{{Name: "kafka", Backend: "kafka"}},
+		ProducerFanoutMode:   "all",
+		AsyncMode:            "off",
+		IdempotencyStore:     "memory",
+		SchemaValidationMode: "reject",
+		TracingExporter:      "none",
+		RateLimitKey:         "api_key",
+	}
+}
+
+func TestConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(cfg *Config)
+		wantErr string
+	}{
+		{name: "defaults", modify: func(cfg *Config) {}},
+		{name: "async optional", modify: func(cfg *Config) { cfg.AsyncMode = "optional" }},
+		{name: "async always", modify: func(cfg *Config) { cfg.AsyncMode = "always" }},
+		{name: "unknown async mode", modify: func(cfg *Config) { cfg.AsyncMode = "alwyas" }, wantErr: "ASYNC_MODE"},
+		{name: "empty async mode", modify: func(cfg *Config) { cfg.AsyncMode = "" }, wantErr: "ASYNC_MODE"},
+		{name: "fan-out primary", modify: func(cfg *Config) { cfg.ProducerFanoutMode = "primary" }},
+		{name: "unknown fan-out mode", modify: func(cfg *Config) { cfg.ProducerFanoutMode = "some" }, wantErr: "PRODUCER_FANOUT_MODE"},
+		{name: "file idempotency store", modify: func(cfg *Config) { cfg.IdempotencyStore = "file" }},
+		{name: "unknown idempotency store", modify: func(cfg *Config) { cfg.IdempotencyStore = "redis" }, wantErr: "IDEMPOTENCY_STORE"},
+		{name: "kafka dead-letter backend", modify: func(cfg *Config) { cfg.DeadLetterBackend = "kafka" }},
+		{name: "unknown dead-letter backend", modify: func(cfg *Config) { cfg.DeadLetterBackend = "sqs" }, wantErr: "DEAD_LETTER_BACKEND"},
+		{name: "warn schema validation", modify: func(cfg *Config) { cfg.SchemaValidationMode = "warn" }},
+		{name: "unknown schema validation mode", modify: func(cfg *Config) { cfg.SchemaValidationMode = "warning" }, wantErr: "SCHEMA_VALIDATION_MODE"},
+		{name: "otlp tracing exporter", modify: func(cfg *Config) { cfg.TracingExporter = "otlp" }},
+		{name: "unknown tracing exporter", modify: func(cfg *Config) { cfg.TracingExporter = "jaeger" }, wantErr: "TRACING_EXPORTER"},
+		{name: "rate limit by header", modify: func(cfg *Config) { cfg.RateLimitKey = "header:X-Tenant-Id" }},
+		{name: "rate limit by ip", modify: func(cfg *Config) { cfg.RateLimitKey = "ip" }},
+		{name: "rate limit by header without a name", modify: func(cfg *Config) { cfg.RateLimitKey = "header:" }, wantErr: "RATE_LIMIT_KEY"},
+		{name: "unknown rate limit key", modify: func(cfg *Config) { cfg.RateLimitKey = "tenant" }, wantErr: "RATE_LIMIT_KEY"},
+		{
+			name: "unknown producer backend",
+			modify: func(cfg *Config) {
+				cfg.ProducerDestinations = append(cfg.ProducerDestinations, Destination{Name: "new", Backend: "kafak"})
+			},
+			wantErr: "PRODUCER_BACKEND",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := validConfig()
+			tt.modify(&cfg)
+			err := cfg.Validate()
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestConfig_ValidateDestinations(t *testing.T) {
+	cfg := validConfig()
+	cfg.ProducerDestinations = []Destination{
+		{Name: "kafka", Backend: "kafka"},
+		{Name: "new", Backend: "kafka"},
+	}
+	assert.NoError(t, cfg.Validate())
+
+	// Two destinations with the same name would share their spool
+	cfg.ProducerDestinations[1].Name = "kafka"
+	assert.ErrorContains(t, cfg.Validate(), "duplicate PRODUCER_BACKEND destination")
+
+	cfg.ProducerDestinations[1] = Destination{Name: "new"}
+	assert.ErrorContains(t, cfg.Validate(), "invalid PRODUCER_BACKEND entry")
+}
+
+func TestConfig_ValidateSpoolRetryInterval(t *testing.T) {
+	cfg := validConfig()
+	cfg.SpoolDir, cfg.SpoolRetryInterval = "spool", 5*time.Second
+	assert.NoError(t, cfg.Validate())
+
+	cfg.SpoolRetryInterval = 0
+	assert.ErrorContains(t, cfg.Validate(), "SPOOL_RETRY_INTERVAL")
+
+	// The interval is not used without a spool
+	cfg.SpoolDir = ""
+	assert.NoError(t, cfg.Validate())
+}
@@ -0,0 +1,124 @@
+package application
+
+import (
+	"anyway/internal/domain"
+	"anyway/internal/metrics"
+	"context"
+	"crypto/sha256"
+	"github.com/rs/zerolog/log"
+	"sync"
+	"time"
+)
+
+// asyncJob is a message waiting in the buffer to be produced
+type asyncJob struct {
+	ctx        context.Context
+	id         string
+	message    domain.Message
+	receivedAt time.Time
+	// hash is the content hash claimed by the deduplicator, if any
+	hash *[sha256.Size]byte
+}
+
+// asyncDispatcher buffers messages in memory and produces them with a pool of workers
+type asyncDispatcher struct {
+	queue   chan asyncJob
+	produce func(ctx context.Context, message domain.Message) (domain.Receipt, error)
+	// failed is called with the jobs that could not be produced, and dropped with the ones dropped at shutdown
+	failed  func(job asyncJob, receipt domain.Receipt, err error)
+	dropped func(job asyncJob)
+	// stop cancels the jobs being produced and drops the buffered ones once the shutdown deadline passes
+	stop context.Context
+
+	mu     sync.RWMutex
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// newAsyncDispatcher creates a dispatcher with a buffer of bufferSize messages and starts its workers.
+// The jobs that could not be produced are handed to failed, and the ones left in the buffer once stop is done to dropped.
+func newAsyncDispatcher(stop context.Context, bufferSize, workers int, produce func(ctx context.Context, message domain.Message) (domain.Receipt, error), failed func(job asyncJob, receipt domain.Receipt, err error), dropped func(job asyncJob)) *asyncDispatcher {
+	d := &asyncDispatcher{
+		queue:   make(chan asyncJob, bufferSize),
+		produce: produce,
+		failed:  failed,
+		dropped: dropped,
+		stop:    stop,
+	}
+	if workers < 1 {
+		workers = 1
+	}
+	d.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go d.work()
+	}
+	return d
+}
+
+// enqueue adds the job to the buffer without blocking
+func (d *asyncDispatcher) enqueue(job asyncJob) error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if d.closed {
+		return domain.ErrShuttingDown
+	}
+	select {
+	case d.queue <- job:
+		metrics.AsyncBufferedMessages.Inc()
+		return nil
+	default:
+		metrics.AsyncMessages.WithLabelValues("rejected").Inc()
+		return domain.ErrQueueFull
+	}
+}
+
+// close stops accepting jobs and waits until the buffered ones are produced, or dropped once stop is done
+func (d *asyncDispatcher) close() {
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return
+	}
+	d.closed = true
+	close(d.queue)
+	d.mu.Unlock()
+
+	d.wg.Wait()
+}
+
+// work produces the buffered jobs until the buffer is closed and drained
+func (d *asyncDispatcher) work() {
+	defer d.wg.Done()
+
+	for job := range d.queue {
+		metrics.AsyncBufferedMessages.Dec()
+		if d.stop.Err() != nil {
+			metrics.AsyncMessages.WithLabelValues("dropped").Inc()
+			log.Ctx(job.ctx).Error().Str("message_id", job.id).Msg("Dropped asynchronous message at shutdown")
+			d.dropped(job)
+			continue
+		}
+		ctx, cancel := bind(job.ctx, d.stop)
+		receipt, err := d.produce(ctx, job.message)
+		cancel()
+		if err != nil {
+			metrics.AsyncMessages.WithLabelValues("failed").Inc()
+			log.Ctx(job.ctx).Error().Err(err).Str("message_id", job.id).Msg("Failed to send asynchronous message")
+			d.failed(job, receipt, err)
+			continue
+		}
+		metrics.AsyncMessages.WithLabelValues("sent").Inc()
+		log.Ctx(job.ctx).Debug().Str("message_id", job.id).Msg("Sent asynchronous message")
+	}
+}
+
+// bind derives a context from ctx that is also canceled once stop is done
+func bind(ctx, stop context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	unbind := context.AfterFunc(stop, cancel)
+	return ctx, func() {
+		unbind()
+		cancel()
+	}
+}
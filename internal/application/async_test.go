@@ -0,0 +1,256 @@
+package application_test
+
+import (
+	"anyway/internal/application"
+	"anyway/internal/domain"
+	"anyway/internal/infrastructure/idempotency"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// TestSendAsync tests that the SendAsync method produces the message in the background
+func TestSendAsync(t *testing.T) {
+	// Create a mock producer repository signalling when the message is produced
+	mockRepo := new(MockProducerRepository)
+	produced := make(chan domain.Message, 1)
+	mockRepo.On("Produce", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		produced <- args.Get(1).(domain.Message)
+	}).Return(domain.Receipt{}, nil).Once()
+
+	// Create a new use case instance with a buffer
+	usecase := application.NewUsecase(mockRepo,
+		application.WithTopics("default-topic", nil),
+		application.WithAsync(10, 2),
+	)
+	defer usecase.Close(context.Background())
+
+	// Call the SendAsync method with a context that is cancelled right after, as a finished request would be
+	ctx, cancel := context.WithCancel(context.Background())
+	receipt, err := usecase.SendAsync(ctx, domain.Message{Content: []byte("test-content")})
+	cancel()
+
+	// Assert that an ID is returned and the message is produced to the resolved topic
+	assert.NoError(t, err)
+	assert.NotEmpty(t, receipt.ID)
+	message := <-produced
+	assert.Equal(t, "default-topic", message.Topic)
+}
+
+// TestSendAsyncRejectedTopic tests that the topic is checked before the message is buffered
+func TestSendAsyncRejectedTopic(t *testing.T) {
+	// Create a mock producer repository (it should not be called)
+	mockRepo := new(MockProducerRepository)
+
+	// Create a new use case instance with a buffer
+	usecase := application.NewUsecase(mockRepo,
+		application.WithTopics("default-topic", nil),
+		application.WithAsync(10, 1),
+	)
+
+	// Call the SendAsync method
+	_, err := usecase.SendAsync(context.Background(), domain.Message{Topic: "payments", Content: []byte("test-content")})
+
+	// Assert that the topic was rejected
+	assert.ErrorIs(t, err, domain.ErrTopicNotAllowed)
+	usecase.Close(context.Background())
+	mockRepo.AssertExpectations(t)
+}
+
+// TestSendAsyncBufferFull tests that messages are rejected while the buffer is full
+func TestSendAsyncBufferFull(t *testing.T) {
+	// Create a mock producer repository that blocks until it is released
+	mockRepo := new(MockProducerRepository)
+	started := make(chan struct{}, 3)
+	release := make(chan struct{})
+	mockRepo.On("Produce", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		started <- struct{}{}
+		<-release
+	}).Return(domain.Receipt{}, nil).Twice()
+
+	// Create a new use case instance with a buffer of one message and a single worker
+	usecase := application.NewUsecase(mockRepo, application.WithAsync(1, 1))
+
+	// The first message is taken by the worker and the second one waits in the buffer
+	_, err := usecase.SendAsync(context.Background(), domain.Message{Content: []byte("first")})
+	assert.NoError(t, err)
+	<-started
+	_, err = usecase.SendAsync(context.Background(), domain.Message{Content: []byte("second")})
+	assert.NoError(t, err)
+
+	// Assert that the third message is rejected
+	_, err = usecase.SendAsync(context.Background(), domain.Message{Content: []byte("third")})
+	assert.ErrorIs(t, err, domain.ErrQueueFull)
+
+	// Release the worker and assert that Close waits for the buffered message
+	close(release)
+	usecase.Close(context.Background())
+	mockRepo.AssertExpectations(t)
+
+	// Assert that no message is accepted after Close
+	_, err = usecase.SendAsync(context.Background(), domain.Message{Content: []byte("fourth")})
+	assert.ErrorIs(t, err, domain.ErrShuttingDown)
+}
+
+// TestSendAsyncDisabled tests the SendAsync method when no buffer is configured
+func TestSendAsyncDisabled(t *testing.T) {
+	// Create a mock producer repository (it should not be called)
+	mockRepo := new(MockProducerRepository)
+
+	// Create a new use case instance without a buffer
+	usecase := application.NewUsecase(mockRepo)
+
+	// Call the SendAsync method
+	_, err := usecase.SendAsync(context.Background(), domain.Message{Content: []byte("test-content")})
+
+	// Assert that asynchronous mode is disabled
+	assert.ErrorIs(t, err, domain.ErrAsyncDisabled)
+	usecase.Close(context.Background())
+	mockRepo.AssertExpectations(t)
+}
+
+// TestSendAsyncIdempotency tests that a repeated idempotency key returns the ID assigned to the buffered message
+// without buffering it again
+func TestSendAsyncIdempotency(t *testing.T) {
+	message := domain.Message{Content: []byte("test-content")}
+
+	// Create a mock producer repository expecting a single message
+	mockRepo := new(MockProducerRepository)
+	mockRepo.On("Produce", mock.Anything, mock.Anything).Return(domain.Receipt{}, nil).Once()
+
+	// Create a new use case instance with a buffer and an idempotency store
+	usecase := application.NewUsecase(mockRepo,
+		application.WithAsync(10, 1),
+		application.WithIdempotency(idempotency.NewMemoryStore(10, time.Hour)),
+	)
+
+	// The retry returns the ID of the first request
+	first, err := usecase.SendAsync(idempotentContext("billing", "key-1"), message)
+	assert.NoError(t, err)
+	retry, err := usecase.SendAsync(idempotentContext("billing", "key-1"), message)
+	assert.NoError(t, err)
+	assert.Equal(t, first, retry)
+
+	// The key reused with a different message is rejected
+	_, err = usecase.SendAsync(idempotentContext("billing", "key-1"), domain.Message{Content: []byte("other-content")})
+	assert.ErrorIs(t, err, domain.ErrIdempotencyKeyReused)
+
+	// Assert that the message was produced once
+	usecase.Close(context.Background())
+	mockRepo.AssertExpectations(t)
+}
+
+// TestSendAsyncDeduplication tests that a message identical to one buffered within the window is not buffered again
+func TestSendAsyncDeduplication(t *testing.T) {
+	message := domain.Message{Content: []byte("test-content")}
+
+	// Create a mock producer repository expecting a single message
+	mockRepo := new(MockProducerRepository)
+	mockRepo.On("Produce", mock.Anything, mock.Anything).Return(domain.Receipt{}, nil).Once()
+
+	// Create a new use case instance with a buffer and a deduplication window
+	usecase := application.NewUsecase(mockRepo,
+		application.WithAsync(10, 1),
+		application.WithDeduplication(time.Hour),
+	)
+
+	// Send the message twice
+	first, err := usecase.SendAsync(context.Background(), message)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, first.ID)
+	assert.False(t, first.Deduplicated)
+	repeated, err := usecase.SendAsync(context.Background(), message)
+	assert.NoError(t, err)
+
+	// Assert that the repeated message is reported as deduplicated, without an ID since it is never produced
+	assert.True(t, repeated.Deduplicated)
+	assert.Empty(t, repeated.ID)
+
+	// Assert that the message was produced once
+	usecase.Close(context.Background())
+	mockRepo.AssertExpectations(t)
+}
+
+// TestCloseDeadline tests that Close cancels the message being produced and drops the buffered ones once its context is done
+func TestCloseDeadline(t *testing.T) {
+	// Create a mock producer repository that blocks until its context is canceled
+	mockRepo := new(MockProducerRepository)
+	started := make(chan struct{})
+	mockRepo.On("Produce", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		close(started)
+		<-args.Get(0).(context.Context).Done()
+	}).Return(domain.Receipt{}, context.Canceled).Once()
+
+	// Create a mock dead-letter queue expecting the cancelled message and the dropped one
+	mockQueue := new(MockDeadLetterQueue)
+	mockQueue.On("Publish", mock.Anything, mock.MatchedBy(func(deadLetter domain.DeadLetter) bool {
+		return string(deadLetter.Content) == "first" && deadLetter.Error == context.Canceled.Error()
+	})).Return(nil).Once()
+	mockQueue.On("Publish", mock.Anything, mock.MatchedBy(func(deadLetter domain.DeadLetter) bool {
+		return string(deadLetter.Content) == "second" && deadLetter.Error == domain.ErrShuttingDown.Error()
+	})).Return(nil).Once()
+
+	// Create a new use case instance with a single worker and a dead-letter queue
+	usecase := application.NewUsecase(mockRepo, application.WithAsync(10, 1), application.WithDeadLetter(mockQueue))
+
+	// The first message is taken by the worker and the second one waits in the buffer
+	_, err := usecase.SendAsync(context.Background(), domain.Message{Content: []byte("first")})
+	assert.NoError(t, err)
+	<-started
+	_, err = usecase.SendAsync(context.Background(), domain.Message{Content: []byte("second")})
+	assert.NoError(t, err)
+
+	// Call the Close method with a short deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	usecase.Close(ctx)
+
+	// Assert that only the first message was produced, and both were routed to the dead-letter queue
+	mockRepo.AssertExpectations(t)
+	mockQueue.AssertExpectations(t)
+}
+
+// TestCloseSendInFlight tests that Close waits for the messages sent in flight, canceling them once its context is done
+func TestCloseSendInFlight(t *testing.T) {
+	// Create a mock producer repository that blocks until its context is canceled
+	mockRepo := new(MockProducerRepository)
+	started := make(chan struct{})
+	mockRepo.On("Produce", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		close(started)
+		<-args.Get(0).(context.Context).Done()
+	}).Return(domain.Receipt{}, context.Canceled).Once()
+
+	// Create a new use case instance
+	usecase := application.NewUsecase(mockRepo)
+
+	// Send a message in the background
+	sent := make(chan error, 1)
+	go func() {
+		_, err := usecase.Send(context.Background(), domain.Message{Content: []byte("test-content")})
+		sent <- err
+	}()
+	<-started
+
+	// Call the Close method with a short deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	usecase.Close(ctx)
+
+	// Assert that the send was canceled
+	select {
+	case err := <-sent:
+		assert.ErrorIs(t, err, context.Canceled)
+	case <-time.After(time.Second):
+		t.Fatal("the message in flight was not canceled")
+	}
+
+	// Assert that no message is accepted after Close
+	_, err := usecase.Send(context.Background(), domain.Message{Content: []byte("test-content")})
+	assert.ErrorIs(t, err, domain.ErrShuttingDown)
+	_, errs := usecase.SendBatch(context.Background(), []domain.Message{{Content: []byte("test-content")}})
+	assert.ErrorIs(t, errs[0], domain.ErrShuttingDown)
+	mockRepo.AssertExpectations(t)
+}
@@ -0,0 +1,107 @@
+package application
+
+import (
+	"anyway/internal/domain"
+	"anyway/internal/metrics"
+	"context"
+	"errors"
+	"github.com/rs/zerolog/log"
+	"time"
+)
+
+// deadLetterInvalid routes the message rejected by prepare to the dead-letter queue when its content is invalid,
+// telling whether it was routed. Messages the caller may not send are not routed.
+func (uc *UsecaseImpl) deadLetterInvalid(ctx context.Context, message domain.Message, receivedAt time.Time, err error) bool {
+	var validationErr *domain.ValidationError
+	if !errors.As(err, &validationErr) {
+		return false
+	}
+	return uc.deadLetter(ctx, message, domain.DeadLetterInvalid, receivedAt, 0, err)
+}
+
+// deadLetterUndeliverable routes the message that could not be produced to the dead-letter queue, telling whether it was routed.
+// Only the messages given up on are routed: the ones that failed with a permanent error or used all their attempts,
+// not the ones stopped by an open circuit breaker or a cancelled request, which may be sent again.
+func (uc *UsecaseImpl) deadLetterUndeliverable(ctx context.Context, message domain.Message, receivedAt time.Time, err error) bool {
+	var undeliverableErr *domain.UndeliverableError
+	if !errors.As(err, &undeliverableErr) {
+		return false
+	}
+	return uc.deadLetter(ctx, message, domain.DeadLetterUndeliverable, receivedAt, undeliverableErr.Attempts, err)
+}
+
+// deadLetter publishes the message to the dead-letter queue wrapped in an envelope with the reason it failed.
+// The request context is detached from its cancellation, so the message is routed even when the client is gone.
+func (uc *UsecaseImpl) deadLetter(ctx context.Context, message domain.Message, reason string, receivedAt time.Time, attempts int, err error) bool {
+	if uc.deadLetters == nil {
+		return false
+	}
+	metadata := domain.MetadataFromContext(ctx)
+	deadLetter := domain.DeadLetter{
+		Topic:         message.Topic,
+		Key:           message.Key,
+		Headers:       deadLetterHeaders(message, metadata),
+		Content:       message.Content,
+		Reason:        reason,
+		Error:         err.Error(),
+		Attempts:      attempts,
+		RequestID:     metadata.RequestID,
+		CorrelationID: metadata.CorrelationID,
+		Caller:        metadata.Caller,
+		ReceivedAt:    receivedAt.UTC(),
+		FailedAt:      time.Now().UTC(),
+	}
+	if deadLetter.Key == "" {
+		deadLetter.Key = metadata.RoutingID
+	}
+	var validationErr *domain.ValidationError
+	if errors.As(err, &validationErr) {
+		deadLetter.Violations = validationErr.Violations
+	}
+
+	if err := uc.deadLetters.Publish(context.WithoutCancel(ctx), deadLetter); err != nil {
+		metrics.DeadLetters.WithLabelValues(reason, "failed").Inc()
+		log.Error().Err(err).Str("reason", reason).Msg("Failed to route message to the dead-letter queue")
+		return false
+	}
+	metrics.DeadLetters.WithLabelValues(reason, "published").Inc()
+	log.Info().Str("reason", reason).Msg("Routed message to the dead-letter queue")
+	return true
+}
+
+// deadLetterHeaders returns the headers the message was sent with: the request headers forwarded to the message,
+// overridden by the headers of the message
+func deadLetterHeaders(message domain.Message, metadata domain.Metadata) map[string]string {
+	if len(metadata.Headers)+len(message.Headers) == 0 {
+		return nil
+	}
+	headers := make(map[string]string, len(metadata.Headers)+len(message.Headers))
+	for k, v := range metadata.Headers {
+		headers[k] = v
+	}
+	for k, v := range message.Headers {
+		headers[k] = v
+	}
+	return headers
+}
+
+// asyncFailed routes the asynchronous message that could not be produced to the dead-letter queue,
+// and releases its hash from the deduplication window. Unlike a synchronous message, every failure is routed,
+// also an open circuit breaker or a timeout, since the client was already told the message was accepted
+// and will not send it again.
+func (uc *UsecaseImpl) asyncFailed(job asyncJob, receipt domain.Receipt, err error) {
+	uc.forget(job.hash)
+	attempts := receipt.Attempts
+	var undeliverableErr *domain.UndeliverableError
+	if errors.As(err, &undeliverableErr) {
+		attempts = undeliverableErr.Attempts
+	}
+	uc.deadLetter(job.ctx, job.message, domain.DeadLetterUndeliverable, job.receivedAt, attempts, err)
+}
+
+// asyncDropped routes the asynchronous message dropped at shutdown to the dead-letter queue,
+// and releases its hash from the deduplication window
+func (uc *UsecaseImpl) asyncDropped(job asyncJob) {
+	uc.forget(job.hash)
+	uc.deadLetter(job.ctx, job.message, domain.DeadLetterUndeliverable, job.receivedAt, 0, domain.ErrShuttingDown)
+}
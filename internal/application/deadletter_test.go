@@ -0,0 +1,306 @@
+package application_test
+
+import (
+	"anyway/internal/application"
+	"anyway/internal/domain"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// MockDeadLetterQueue is a mock implementation of domain.DeadLetterQueue
+type MockDeadLetterQueue struct {
+	mock.Mock
+}
+
+// Publish mocks the Publish method of DeadLetterQueue
+func (m *MockDeadLetterQueue) Publish(ctx context.Context, deadLetter domain.DeadLetter) error {
+	args := m.Called(ctx, deadLetter)
+	return args.Error(0)
+}
+
+// Close mocks the Close method of DeadLetterQueue
+func (m *MockDeadLetterQueue) Close() {
+	m.Called()
+}
+
+// TestSendDeadLetterInvalid tests that a message rejected by its schema is routed to the dead-letter queue
+func TestSendDeadLetterInvalid(t *testing.T) {
+	message := domain.Message{Content: []byte(`{}`), Headers: map[string]string{"source": "message"}}
+	invalid := &domain.ValidationError{Schema: "orders", Violations: []string{"/id: missing property"}}
+	ctx := domain.WithMetadata(context.Background(), domain.Metadata{
+		RequestID: "request-1",
+		RoutingID: "routing-1",
+		Caller:    "billing",
+		Headers:   map[string]string{"source": "request", "tenant": "acme"},
+	})
+
+	// Create a mock validator rejecting the message resolved to the default topic
+	resolved := message
+	resolved.Topic = "orders"
+	mockValidator := new(MockValidator)
+	mockValidator.On("Validate", resolved).Return(invalid).Once()
+
+	// Create a mock dead-letter queue capturing the envelope
+	mockQueue := new(MockDeadLetterQueue)
+	var deadLetter domain.DeadLetter
+	mockQueue.On("Publish", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		deadLetter = args.Get(1).(domain.DeadLetter)
+	}).Return(nil).Once()
+
+	// Create a new use case instance with validation and a dead-letter queue (the repository should not be called)
+	mockRepo := new(MockProducerRepository)
+	usecase := application.NewUsecase(mockRepo,
+		application.WithTopics("orders", nil),
+		application.WithValidation(mockValidator, false),
+		application.WithDeadLetter(mockQueue))
+
+	// Call the Send method
+	receipt, err := usecase.Send(ctx, message)
+
+	// Assert that the client gets the error and the envelope describes the message and the failure
+	assert.ErrorIs(t, err, domain.ErrInvalidMessage)
+	assert.True(t, receipt.DeadLettered)
+	assert.Equal(t, "orders", deadLetter.Topic)
+	assert.Equal(t, "routing-1", deadLetter.Key)
+	assert.Equal(t, map[string]string{"source": "message", "tenant": "acme"}, deadLetter.Headers)
+	assert.Equal(t, message.Content, deadLetter.Content)
+	assert.Equal(t, domain.DeadLetterInvalid, deadLetter.Reason)
+	assert.Equal(t, invalid.Error(), deadLetter.Error)
+	assert.Equal(t, invalid.Violations, deadLetter.Violations)
+	assert.Equal(t, "request-1", deadLetter.RequestID)
+	assert.Equal(t, "billing", deadLetter.Caller)
+	assert.False(t, deadLetter.ReceivedAt.IsZero())
+	assert.False(t, deadLetter.FailedAt.Before(deadLetter.ReceivedAt))
+
+	// Assert that the expected methods were called on the mocks
+	mockValidator.AssertExpectations(t)
+	mockQueue.AssertExpectations(t)
+	mockRepo.AssertExpectations(t)
+}
+
+// givenUp returns the error of a message the repository gave up on after the given attempts
+func givenUp(attempts int) error {
+	return &domain.UndeliverableError{Err: errors.New("broker down"), Attempts: attempts}
+}
+
+// TestSendDeadLetterUndeliverable tests that a message that could not be produced is routed with its attempts
+func TestSendDeadLetterUndeliverable(t *testing.T) {
+	message := domain.Message{Topic: "orders", Key: "order-1", Content: []byte("test-content")}
+
+	// Create a mock producer repository failing after three attempts
+	mockRepo := new(MockProducerRepository)
+	mockRepo.On("Produce", mock.Anything, message).Return(domain.Receipt{Attempts: 3}, givenUp(3)).Once()
+
+	// Create a mock dead-letter queue expecting the undeliverable message
+	mockQueue := new(MockDeadLetterQueue)
+	mockQueue.On("Publish", mock.Anything, mock.MatchedBy(func(deadLetter domain.DeadLetter) bool {
+		return deadLetter.Reason == domain.DeadLetterUndeliverable && deadLetter.Error == "broker down" &&
+			deadLetter.Attempts == 3 && deadLetter.Key == "order-1" && deadLetter.Topic == "orders"
+	})).Return(nil).Once()
+
+	// Create a new use case instance with a dead-letter queue
+	usecase := application.NewUsecase(mockRepo, application.WithDeadLetter(mockQueue))
+
+	// Call the Send method
+	receipt, err := usecase.Send(context.Background(), message)
+
+	// Assert that the client gets the error and the message was routed
+	assert.EqualError(t, err, "broker down")
+	assert.True(t, receipt.DeadLettered)
+
+	// Assert that the expected methods were called on the mocks
+	mockRepo.AssertExpectations(t)
+	mockQueue.AssertExpectations(t)
+}
+
+// TestSendAsyncDeadLetterNotGivenUp tests that an asynchronous message is routed whatever it failed with,
+// since the client was already told it was accepted
+func TestSendAsyncDeadLetterNotGivenUp(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+	}{
+		{name: "circuit open", err: &domain.CircuitOpenError{Backend: "kafka", RetryAfter: time.Second}},
+		{name: "timeout", err: context.DeadlineExceeded},
+		{name: "cancelled at shutdown", err: context.Canceled},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Create a mock producer repository that fails the message
+			mockRepo := new(MockProducerRepository)
+			mockRepo.On("Produce", mock.Anything, mock.Anything).Return(domain.Receipt{Attempts: 2}, tt.err).Once()
+
+			// Create a mock dead-letter queue expecting the message with the error it failed with
+			mockQueue := new(MockDeadLetterQueue)
+			mockQueue.On("Publish", mock.Anything, mock.MatchedBy(func(deadLetter domain.DeadLetter) bool {
+				return deadLetter.Reason == domain.DeadLetterUndeliverable && deadLetter.Error == tt.err.Error() && deadLetter.Attempts == 2
+			})).Return(nil).Once()
+
+			// Create a new use case instance with a buffer and a dead-letter queue
+			usecase := application.NewUsecase(mockRepo,
+				application.WithAsync(10, 1),
+				application.WithDeadLetter(mockQueue))
+
+			// Call the SendAsync method and wait until the buffer is drained
+			_, err := usecase.SendAsync(context.Background(), domain.Message{Content: []byte("test-content")})
+			assert.NoError(t, err)
+			usecase.Close(context.Background())
+
+			// Assert that the expected methods were called on the mocks
+			mockRepo.AssertExpectations(t)
+			mockQueue.AssertExpectations(t)
+		})
+	}
+}
+
+// TestSendDeadLetterNotGivenUp tests that messages that may be sent again are not routed:
+// an open circuit breaker, a cancelled request or a failure the repository did not give up on
+func TestSendDeadLetterNotGivenUp(t *testing.T) {
+	for _, produceErr := range []error{
+		&domain.CircuitOpenError{Backend: "kafka", RetryAfter: time.Second},
+		context.Canceled,
+		errors.New("broker down"),
+	} {
+		t.Run(produceErr.Error(), func(t *testing.T) {
+			// Create a mock producer repository that fails the message
+			mockRepo := new(MockProducerRepository)
+			mockRepo.On("Produce", mock.Anything, mock.Anything).Return(domain.Receipt{}, produceErr).Once()
+
+			// Create a new use case instance with a dead-letter queue (it should not be called)
+			mockQueue := new(MockDeadLetterQueue)
+			usecase := application.NewUsecase(mockRepo, application.WithDeadLetter(mockQueue))
+
+			// Assert that the client gets the error and the message was not routed
+			receipt, err := usecase.Send(context.Background(), domain.Message{Content: []byte("test-content")})
+			assert.ErrorIs(t, err, produceErr)
+			assert.False(t, receipt.DeadLettered)
+			mockRepo.AssertExpectations(t)
+			mockQueue.AssertExpectations(t)
+		})
+	}
+}
+
+// TestSendDeadLetterNotRouted tests that sent messages and messages the caller may not send are not routed
+func TestSendDeadLetterNotRouted(t *testing.T) {
+	// Create a mock producer repository expecting the allowed message
+	mockRepo := new(MockProducerRepository)
+	mockRepo.On("Produce", mock.Anything, mock.Anything).Return(domain.Receipt{}, nil).Once()
+
+	// Create a new use case instance with a dead-letter queue (it should not be called)
+	mockQueue := new(MockDeadLetterQueue)
+	usecase := application.NewUsecase(mockRepo,
+		application.WithTopics("orders", nil),
+		application.WithDeadLetter(mockQueue))
+
+	receipt, err := usecase.Send(context.Background(), domain.Message{Content: []byte("test-content")})
+	assert.NoError(t, err)
+	assert.False(t, receipt.DeadLettered)
+
+	receipt, err = usecase.Send(context.Background(), domain.Message{Topic: "payments", Content: []byte("test-content")})
+	assert.ErrorIs(t, err, domain.ErrTopicNotAllowed)
+	assert.False(t, receipt.DeadLettered)
+
+	// Assert that the expected methods were called on the mocks
+	mockRepo.AssertExpectations(t)
+	mockQueue.AssertExpectations(t)
+}
+
+// TestSendDeadLetterPublishFailure tests that a message the dead-letter queue fails to take is reported as not routed
+func TestSendDeadLetterPublishFailure(t *testing.T) {
+	// Create a mock producer repository and dead-letter queue that both fail
+	mockRepo := new(MockProducerRepository)
+	mockRepo.On("Produce", mock.Anything, mock.Anything).Return(domain.Receipt{}, givenUp(1)).Once()
+	mockQueue := new(MockDeadLetterQueue)
+	mockQueue.On("Publish", mock.Anything, mock.Anything).Return(errors.New("disk full")).Once()
+
+	// Create a new use case instance with a dead-letter queue
+	usecase := application.NewUsecase(mockRepo, application.WithDeadLetter(mockQueue))
+
+	// Call the Send method
+	receipt, err := usecase.Send(context.Background(), domain.Message{Content: []byte("test-content")})
+
+	// Assert that the produce error is returned
+	assert.EqualError(t, err, "broker down")
+	assert.False(t, receipt.DeadLettered)
+
+	// Assert that the expected methods were called on the mocks
+	mockRepo.AssertExpectations(t)
+	mockQueue.AssertExpectations(t)
+}
+
+// TestSendBatchDeadLetter tests that the invalid and undeliverable messages of a batch are routed one by one
+func TestSendBatchDeadLetter(t *testing.T) {
+	valid := domain.Message{Content: []byte(`{"id":1}`)}
+	undeliverable := domain.Message{Content: []byte(`{"id":2}`)}
+	invalid := domain.Message{Content: []byte(`{}`)}
+
+	// Create a mock validator rejecting the last message
+	mockValidator := new(MockValidator)
+	mockValidator.On("Validate", valid).Return(nil).Once()
+	mockValidator.On("Validate", undeliverable).Return(nil).Once()
+	mockValidator.On("Validate", invalid).Return(&domain.ValidationError{Schema: "orders"}).Once()
+
+	// Create a mock producer repository failing the second message
+	mockRepo := new(MockProducerRepository)
+	mockRepo.On("ProduceBatch", mock.Anything, []domain.Message{valid, undeliverable}).Return([]error{nil, givenUp(2)}).Once()
+
+	// Create a mock dead-letter queue expecting both failed messages
+	mockQueue := new(MockDeadLetterQueue)
+	mockQueue.On("Publish", mock.Anything, mock.MatchedBy(func(deadLetter domain.DeadLetter) bool {
+		return deadLetter.Reason == domain.DeadLetterInvalid && string(deadLetter.Content) == `{}`
+	})).Return(nil).Once()
+	mockQueue.On("Publish", mock.Anything, mock.MatchedBy(func(deadLetter domain.DeadLetter) bool {
+		return deadLetter.Reason == domain.DeadLetterUndeliverable && string(deadLetter.Content) == `{"id":2}` && deadLetter.Attempts == 2
+	})).Return(nil).Once()
+
+	// Create a new use case instance with validation and a dead-letter queue
+	usecase := application.NewUsecase(mockRepo,
+		application.WithValidation(mockValidator, false),
+		application.WithDeadLetter(mockQueue))
+
+	// Call the SendBatch method
+	_, errs := usecase.SendBatch(context.Background(), []domain.Message{valid, undeliverable, invalid})
+
+	// Assert the outcome of every message
+	assert.NoError(t, errs[0])
+	assert.EqualError(t, errs[1], "broker down")
+	assert.ErrorIs(t, errs[2], domain.ErrInvalidMessage)
+
+	// Assert that the expected methods were called on the mocks
+	mockValidator.AssertExpectations(t)
+	mockRepo.AssertExpectations(t)
+	mockQueue.AssertExpectations(t)
+}
+
+// TestSendAsyncDeadLetter tests that an asynchronous message that could not be produced is routed in the background
+func TestSendAsyncDeadLetter(t *testing.T) {
+	// Create a mock producer repository that fails the message
+	mockRepo := new(MockProducerRepository)
+	mockRepo.On("Produce", mock.Anything, mock.Anything).Return(domain.Receipt{}, givenUp(1)).Once()
+
+	// Create a mock dead-letter queue expecting the undeliverable message
+	mockQueue := new(MockDeadLetterQueue)
+	mockQueue.On("Publish", mock.Anything, mock.MatchedBy(func(deadLetter domain.DeadLetter) bool {
+		return deadLetter.Reason == domain.DeadLetterUndeliverable && !deadLetter.ReceivedAt.IsZero()
+	})).Return(nil).Once()
+
+	// Create a new use case instance with a buffer and a dead-letter queue
+	usecase := application.NewUsecase(mockRepo,
+		application.WithAsync(10, 1),
+		application.WithDeadLetter(mockQueue))
+
+	// Call the SendAsync method and wait until the buffer is drained
+	_, err := usecase.SendAsync(context.Background(), domain.Message{Content: []byte("test-content")})
+	assert.NoError(t, err)
+	usecase.Close(context.Background())
+
+	// Assert that the expected methods were called on the mocks
+	mockRepo.AssertExpectations(t)
+	mockQueue.AssertExpectations(t)
+}
@@ -0,0 +1,81 @@
+package application
+
+import (
+	"anyway/internal/domain"
+	"crypto/sha256"
+	"encoding/binary"
+	"sync"
+	"time"
+)
+
+// deduplicator remembers the messages sent within a time window, so identical messages are dropped.
+// A message is remembered from the moment it is sent until the window ends after it;
+// the repeats do not extend the window.
+type deduplicator struct {
+	window time.Duration
+
+	mu        sync.Mutex
+	seen      map[[sha256.Size]byte]time.Time
+	lastSweep time.Time
+}
+
+// newDeduplicator creates a deduplicator dropping the repeats within window
+func newDeduplicator(window time.Duration) *deduplicator {
+	return &deduplicator{
+		window:    window,
+		seen:      map[[sha256.Size]byte]time.Time{},
+		lastSweep: time.Now(),
+	}
+}
+
+// claim records the message and tells whether it is new, i.e. it was not sent within the window.
+// The message is remembered right away, so a concurrent repeat is dropped while it is being sent.
+func (d *deduplicator) claim(hash [sha256.Size]byte, now time.Time) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if now.Sub(d.lastSweep) > d.window {
+		d.sweep(now)
+	}
+	if sentAt, ok := d.seen[hash]; ok && now.Sub(sentAt) < d.window {
+		return false
+	}
+	d.seen[hash] = now
+	return true
+}
+
+// forget drops a message that could not be sent, so its retries are not dropped
+func (d *deduplicator) forget(hash [sha256.Size]byte) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.seen, hash)
+}
+
+// sweep drops the messages whose window has ended
+func (d *deduplicator) sweep(now time.Time) {
+	for hash, sentAt := range d.seen {
+		if now.Sub(sentAt) >= d.window {
+			delete(d.seen, hash)
+		}
+	}
+	d.lastSweep = now
+}
+
+// contentHash hashes the topic, key and content of the message; a message without a key uses its routing ID.
+// Every field is prefixed with its length so their boundaries are unambiguous.
+func contentHash(message domain.Message, metadata domain.Metadata) [sha256.Size]byte {
+	key := message.Key
+	if key == "" {
+		key = metadata.RoutingID
+	}
+	hash := sha256.New()
+	for _, field := range [][]byte{[]byte(message.Topic), []byte(key), message.Content} {
+		var length [8]byte
+		binary.BigEndian.PutUint64(length[:], uint64(len(field)))
+		hash.Write(length[:])
+		hash.Write(field)
+	}
+	var sum [sha256.Size]byte
+	hash.Sum(sum[:0])
+	return sum
+}
@@ -0,0 +1,151 @@
+package application_test
+
+import (
+	"anyway/internal/application"
+	"anyway/internal/domain"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// TestSendDeduplication tests that identical messages within the window are dropped
+func TestSendDeduplication(t *testing.T) {
+	message := domain.Message{Topic: "orders", Key: "order-1", Content: []byte("test-content")}
+	otherKey := domain.Message{Topic: "orders", Key: "order-2", Content: message.Content}
+	otherTopic := domain.Message{Topic: "invoices", Key: "order-1", Content: message.Content}
+	otherContent := domain.Message{Topic: "orders", Key: "order-1", Content: []byte("other-content")}
+
+	// Create a mock producer repository expecting every distinct message once
+	mockRepo := new(MockProducerRepository)
+	for _, expected := range []domain.Message{message, otherKey, otherTopic, otherContent} {
+		mockRepo.On("Produce", mock.Anything, expected).Return(domain.Receipt{}, nil).Once()
+	}
+
+	// Create a new use case instance with a deduplication window
+	usecase := application.NewUsecase(mockRepo, application.WithDeduplication(time.Hour))
+
+	receipt, err := usecase.Send(context.Background(), message)
+	assert.NoError(t, err)
+	assert.False(t, receipt.Deduplicated)
+
+	// The repeat is dropped
+	receipt, err = usecase.Send(context.Background(), message)
+	assert.NoError(t, err)
+	assert.True(t, receipt.Deduplicated)
+
+	// Messages differing in topic, key or content are produced
+	for _, other := range []domain.Message{otherKey, otherTopic, otherContent} {
+		receipt, err = usecase.Send(context.Background(), other)
+		assert.NoError(t, err)
+		assert.False(t, receipt.Deduplicated)
+	}
+
+	// Assert that the expected methods were called on the mock
+	mockRepo.AssertExpectations(t)
+}
+
+// TestSendDeduplicationRoutingID tests that messages without a key are told apart by their routing ID
+func TestSendDeduplicationRoutingID(t *testing.T) {
+	message := domain.Message{Content: []byte("test-content")}
+
+	// Create a mock producer repository expecting the message once per routing ID
+	mockRepo := new(MockProducerRepository)
+	mockRepo.On("Produce", mock.Anything, message).Return(domain.Receipt{}, nil).Twice()
+
+	// Create a new use case instance with a deduplication window
+	usecase := application.NewUsecase(mockRepo, application.WithDeduplication(time.Hour))
+
+	for _, routingID := range []string{"customer-1", "customer-2", "customer-1"} {
+		ctx := domain.WithMetadata(context.Background(), domain.Metadata{RoutingID: routingID})
+		_, err := usecase.Send(ctx, message)
+		assert.NoError(t, err)
+	}
+
+	// Assert that the expected methods were called on the mock
+	mockRepo.AssertExpectations(t)
+}
+
+// TestSendDeduplicationWindow tests that repeats are produced once the window ends
+func TestSendDeduplicationWindow(t *testing.T) {
+	message := domain.Message{Content: []byte("test-content")}
+
+	// Create a mock producer repository expecting the message twice
+	mockRepo := new(MockProducerRepository)
+	mockRepo.On("Produce", mock.Anything, message).Return(domain.Receipt{}, nil).Twice()
+
+	// Create a new use case instance with a short deduplication window
+	usecase := application.NewUsecase(mockRepo, application.WithDeduplication(20*time.Millisecond))
+
+	_, err := usecase.Send(context.Background(), message)
+	assert.NoError(t, err)
+	time.Sleep(30 * time.Millisecond)
+	receipt, err := usecase.Send(context.Background(), message)
+	assert.NoError(t, err)
+	assert.False(t, receipt.Deduplicated)
+
+	// Assert that the expected methods were called on the mock
+	mockRepo.AssertExpectations(t)
+}
+
+// TestSendDeduplicationFailure tests that messages that fail are not remembered, so their retries are produced
+func TestSendDeduplicationFailure(t *testing.T) {
+	message := domain.Message{Content: []byte("test-content")}
+
+	// Create a mock producer repository failing the first time
+	mockRepo := new(MockProducerRepository)
+	mockRepo.On("Produce", mock.Anything, message).Return(domain.Receipt{}, errors.New("broker down")).Once()
+	mockRepo.On("Produce", mock.Anything, message).Return(domain.Receipt{}, nil).Once()
+
+	// Create a new use case instance with a deduplication window
+	usecase := application.NewUsecase(mockRepo, application.WithDeduplication(time.Hour))
+
+	_, err := usecase.Send(context.Background(), message)
+	assert.Error(t, err)
+	receipt, err := usecase.Send(context.Background(), message)
+	assert.NoError(t, err)
+	assert.False(t, receipt.Deduplicated)
+
+	// Assert that the expected methods were called on the mock
+	mockRepo.AssertExpectations(t)
+}
+
+// TestSendBatchDeduplication tests that the batch messages identical to one sent within the window,
+// or to an earlier one of the same batch, are dropped and reported as deduplicated
+func TestSendBatchDeduplication(t *testing.T) {
+	sent := domain.Message{Content: []byte("sent")}
+	fresh := domain.Message{Content: []byte("fresh")}
+	failing := domain.Message{Content: []byte("failing")}
+
+	// Create a mock producer repository expecting the sent message, then only the first copy of the new ones
+	mockRepo := new(MockProducerRepository)
+	mockRepo.On("Produce", mock.Anything, sent).Return(domain.Receipt{}, nil).Once()
+	mockRepo.On("ProduceBatch", mock.Anything, []domain.Message{fresh, failing}).Return([]error{nil, errors.New("broker down")}).Once()
+	mockRepo.On("ProduceBatch", mock.Anything, []domain.Message{failing}).Return([]error{nil}).Once()
+
+	// Create a new use case instance with a deduplication window
+	usecase := application.NewUsecase(mockRepo, application.WithDeduplication(time.Hour))
+
+	_, err := usecase.Send(context.Background(), sent)
+	assert.NoError(t, err)
+
+	// The message already sent and the repeat within the batch are dropped
+	receipts, errs := usecase.SendBatch(context.Background(), []domain.Message{sent, fresh, fresh, failing})
+	assert.Equal(t, []bool{true, false, true, false}, []bool{
+		receipts[0].Deduplicated, receipts[1].Deduplicated, receipts[2].Deduplicated, receipts[3].Deduplicated,
+	})
+	assert.NoError(t, errs[0])
+	assert.NoError(t, errs[2])
+	assert.Error(t, errs[3])
+
+	// The message that failed is not remembered, so its retry is produced
+	receipts, errs = usecase.SendBatch(context.Background(), []domain.Message{failing})
+	assert.NoError(t, errs[0])
+	assert.False(t, receipts[0].Deduplicated)
+
+	// Assert that the expected methods were called on the mock
+	mockRepo.AssertExpectations(t)
+}
@@ -0,0 +1,80 @@
+package application
+
+import (
+	"anyway/internal/domain"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+)
+
+// idempotency records the outcome of the messages sent with an idempotency key.
+// Requests with the same key are serialised, so concurrent retries wait for the first one
+// and get its outcome instead of sending the message again.
+type idempotency struct {
+	store domain.IdempotencyStore
+
+	mu       sync.Mutex
+	inflight map[string]chan struct{}
+}
+
+// newIdempotency creates the idempotency stage backed by the store
+func newIdempotency(store domain.IdempotencyStore) *idempotency {
+	return &idempotency{
+		store:    store,
+		inflight: map[string]chan struct{}{},
+	}
+}
+
+// acquire waits until no other request holds the key and returns its record when the message was already sent.
+// Otherwise the key is held until release is called.
+func (i *idempotency) acquire(ctx context.Context, key string) (domain.IdempotencyRecord, bool, error) {
+	for {
+		i.mu.Lock()
+		done, held := i.inflight[key]
+		if !held {
+			i.inflight[key] = make(chan struct{})
+			i.mu.Unlock()
+			break
+		}
+		i.mu.Unlock()
+
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return domain.IdempotencyRecord{}, false, ctx.Err()
+		}
+	}
+
+	record, found, err := i.store.Get(ctx, key)
+	if err != nil || found {
+		i.release(key)
+	}
+	return record, found, err
+}
+
+// release lets the next request with the key proceed
+func (i *idempotency) release(key string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if done, held := i.inflight[key]; held {
+		close(done)
+		delete(i.inflight, key)
+	}
+}
+
+// idempotencyKey scopes the key of the request to its caller, so the keys of different callers do not collide
+func idempotencyKey(metadata domain.Metadata) string {
+	return metadata.Caller + "\x00" + metadata.IdempotencyKey
+}
+
+// fingerprint identifies the message as requested, before its topic is resolved and it is encoded
+func fingerprint(message domain.Message, metadata domain.Metadata) string {
+	data, _ := json.Marshal(struct {
+		Message   domain.Message `json:"message"`
+		RoutingID string         `json:"routing_id"`
+	}{message, metadata.RoutingID})
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
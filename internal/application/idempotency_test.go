@@ -0,0 +1,161 @@
+package application_test
+
+import (
+	"anyway/internal/application"
+	"anyway/internal/domain"
+	"anyway/internal/infrastructure/idempotency"
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// idempotentContext returns a context carrying the idempotency key of the caller
+func idempotentContext(caller, key string) context.Context {
+	return domain.WithMetadata(context.Background(), domain.Metadata{Caller: caller, IdempotencyKey: key})
+}
+
+// TestSendIdempotency tests that a repeated idempotency key returns the recorded receipt without producing the message again
+func TestSendIdempotency(t *testing.T) {
+	message := domain.Message{Content: []byte("test-content")}
+	receipt := domain.Receipt{Destinations: []domain.Destination{{Name: "kafka", Delivered: true}}}
+
+	// Create a mock producer repository expecting one message per caller, plus the one without a key
+	mockRepo := new(MockProducerRepository)
+	mockRepo.On("Produce", mock.Anything, message).Return(receipt, nil).Times(3)
+
+	// Create a new use case instance with an idempotency store
+	usecase := application.NewUsecase(mockRepo, application.WithIdempotency(idempotency.NewMemoryStore(10, time.Hour)))
+
+	// The first request is produced
+	first, err := usecase.Send(idempotentContext("billing", "key-1"), message)
+	assert.NoError(t, err)
+	assert.False(t, first.Replayed)
+
+	// The retry returns the recorded receipt
+	retry, err := usecase.Send(idempotentContext("billing", "key-1"), message)
+	assert.NoError(t, err)
+	assert.True(t, retry.Replayed)
+	assert.Equal(t, receipt.Destinations, retry.Destinations)
+
+	// The key reused with a different message is rejected
+	_, err = usecase.Send(idempotentContext("billing", "key-1"), domain.Message{Content: []byte("other-content")})
+	assert.ErrorIs(t, err, domain.ErrIdempotencyKeyReused)
+
+	// The keys of other callers do not collide
+	other, err := usecase.Send(idempotentContext("checkout", "key-1"), message)
+	assert.NoError(t, err)
+	assert.False(t, other.Replayed)
+
+	// Messages without a key are always produced
+	_, err = usecase.Send(context.Background(), message)
+	assert.NoError(t, err)
+
+	// Assert that the expected methods were called on the mock
+	mockRepo.AssertExpectations(t)
+}
+
+// TestSendIdempotencyFailure tests that failed messages are not recorded, so their retries are produced
+func TestSendIdempotencyFailure(t *testing.T) {
+	message := domain.Message{Content: []byte("test-content")}
+
+	// Create a mock producer repository failing the first time
+	mockRepo := new(MockProducerRepository)
+	mockRepo.On("Produce", mock.Anything, message).Return(domain.Receipt{}, errors.New("broker down")).Once()
+	mockRepo.On("Produce", mock.Anything, message).Return(domain.Receipt{}, nil).Once()
+
+	// Create a new use case instance with an idempotency store
+	usecase := application.NewUsecase(mockRepo, application.WithIdempotency(idempotency.NewMemoryStore(10, time.Hour)))
+
+	_, err := usecase.Send(idempotentContext("billing", "key-1"), message)
+	assert.Error(t, err)
+
+	receipt, err := usecase.Send(idempotentContext("billing", "key-1"), message)
+	assert.NoError(t, err)
+	assert.False(t, receipt.Replayed)
+
+	// Assert that the expected methods were called on the mock
+	mockRepo.AssertExpectations(t)
+}
+
+// TestSendIdempotencyConcurrent tests that concurrent requests with the same key produce the message once
+func TestSendIdempotencyConcurrent(t *testing.T) {
+	message := domain.Message{Content: []byte("test-content")}
+
+	// Create a mock producer repository that holds the first message until every request has started
+	release := make(chan struct{})
+	mockRepo := new(MockProducerRepository)
+	mockRepo.On("Produce", mock.Anything, message).Run(func(mock.Arguments) {
+		<-release
+	}).Return(domain.Receipt{}, nil).Once()
+
+	// Create a new use case instance with an idempotency store
+	usecase := application.NewUsecase(mockRepo, application.WithIdempotency(idempotency.NewMemoryStore(10, time.Hour)))
+
+	const requests = 10
+	replayed := make([]bool, requests)
+	errs := make([]error, requests)
+	var wg sync.WaitGroup
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			receipt, err := usecase.Send(idempotentContext("billing", "key-1"), message)
+			replayed[i], errs[i] = receipt.Replayed, err
+		}(i)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	produced := 0
+	for i := range errs {
+		assert.NoError(t, errs[i])
+		if !replayed[i] {
+			produced++
+		}
+	}
+	assert.Equal(t, 1, produced)
+
+	// Assert that the expected methods were called on the mock
+	mockRepo.AssertExpectations(t)
+}
+
+// TestSendIdempotencyCancelled tests that a request waiting for a concurrent one with the same key gives up with its context
+func TestSendIdempotencyCancelled(t *testing.T) {
+	message := domain.Message{Content: []byte("test-content")}
+
+	// Create a mock producer repository that holds the message until the test ends
+	release := make(chan struct{})
+	started := make(chan struct{})
+	mockRepo := new(MockProducerRepository)
+	mockRepo.On("Produce", mock.Anything, message).Run(func(mock.Arguments) {
+		close(started)
+		<-release
+	}).Return(domain.Receipt{}, nil).Once()
+
+	// Create a new use case instance with an idempotency store
+	usecase := application.NewUsecase(mockRepo, application.WithIdempotency(idempotency.NewMemoryStore(10, time.Hour)))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, _ = usecase.Send(idempotentContext("billing", "key-1"), message)
+	}()
+	<-started
+
+	ctx, cancel := context.WithTimeout(idempotentContext("billing", "key-1"), 10*time.Millisecond)
+	defer cancel()
+	_, err := usecase.Send(ctx, message)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	close(release)
+	<-done
+
+	// Assert that the expected methods were called on the mock
+	mockRepo.AssertExpectations(t)
+}
@@ -0,0 +1,27 @@
+package application
+
+import (
+	"anyway/internal/domain"
+	"fmt"
+	"path"
+)
+
+// topicAllowList resolves the topic of a message and checks it against the configured patterns
+type topicAllowList struct {
+	defaultTopic string
+	patterns     []string
+}
+
+// resolve returns the topic the message must be produced to.
+// An empty topic resolves to the default topic, which is always allowed.
+func (t *topicAllowList) resolve(topic string) (string, error) {
+	if topic == "" || topic == t.defaultTopic {
+		return t.defaultTopic, nil
+	}
+	for _, pattern := range t.patterns {
+		if matched, err := path.Match(pattern, topic); err == nil && matched {
+			return topic, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %s", domain.ErrTopicNotAllowed, topic)
+}
@@ -2,28 +2,462 @@ package application
 
 import (
 	"anyway/internal/domain"
+	"anyway/internal/metrics"
+	"anyway/internal/tracing"
 	"context"
+	"crypto/sha256"
+	"errors"
+	"github.com/google/uuid"
 	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"sync"
+	"time"
 )
 
 // UsecaseImpl implements Usecase
 type UsecaseImpl struct {
 	producerRepository domain.ProducerRepository
+	topics             *topicAllowList
+	policy             domain.Policy
+	validator          domain.Validator
+	validationWarnOnly bool
+	encoder            domain.Encoder
+	idempotency        *idempotency
+	deduplicator       *deduplicator
+	deadLetters        domain.DeadLetterQueue
+
+	asyncBufferSize int
+	asyncWorkers    int
+	async           *asyncDispatcher
+
+	// inflight tracks the sends producing messages, so Close does not return while one still is
+	mu       sync.RWMutex
+	closing  bool
+	inflight sync.WaitGroup
+	// stop cancels the sends in flight once the shutdown deadline passes
+	stop       context.Context
+	cancelStop context.CancelFunc
+}
+
+// Option configures optional stages of the usecase
+type Option func(*UsecaseImpl)
+
+// WithTopics restricts the topics messages can be sent to.
+// Messages without a topic are sent to defaultTopic; any other topic must match
+// one of the allowed path.Match patterns (e.g. "orders", "events.*").
+func WithTopics(defaultTopic string, allowed []string) Option {
+	return func(uc *UsecaseImpl) {
+		uc.topics = &topicAllowList{
+			defaultTopic: defaultTopic,
+			patterns:     allowed,
+		}
+	}
+}
+
+// WithAsync enables SendAsync, buffering up to bufferSize messages in memory
+// that are produced in the background by the given number of workers.
+func WithAsync(bufferSize, workers int) Option {
+	return func(uc *UsecaseImpl) {
+		uc.asyncBufferSize = bufferSize
+		uc.asyncWorkers = workers
+	}
+}
+
+// WithPolicy authorises every message with the policy, once its topic is resolved
+func WithPolicy(policy domain.Policy) Option {
+	return func(uc *UsecaseImpl) {
+		uc.policy = policy
+	}
+}
+
+// WithValidation validates the content of every message before it is sent.
+// Invalid messages are rejected with a *domain.ValidationError, or only logged when warnOnly is set.
+func WithValidation(validator domain.Validator, warnOnly bool) Option {
+	return func(uc *UsecaseImpl) {
+		uc.validator = validator
+		uc.validationWarnOnly = warnOnly
+	}
+}
+
+// WithEncoder encodes the content of every message after it is validated, e.g. with a schema registry
+func WithEncoder(encoder domain.Encoder) Option {
+	return func(uc *UsecaseImpl) {
+		uc.encoder = encoder
+	}
+}
+
+// WithIdempotency records the receipt of the messages sent with an idempotency key in the store,
+// answering the repeated keys with it instead of sending the message again
+func WithIdempotency(store domain.IdempotencyStore) Option {
+	return func(uc *UsecaseImpl) {
+		uc.idempotency = newIdempotency(store)
+	}
+}
+
+// WithDeduplication drops the messages identical to one sent within the window: same topic, key and content.
+// Dropped messages are not produced and their receipt is marked as deduplicated.
+func WithDeduplication(window time.Duration) Option {
+	return func(uc *UsecaseImpl) {
+		uc.deduplicator = newDeduplicator(window)
+	}
+}
+
+// WithDeadLetter routes the messages rejected as invalid and the ones that could not be produced to the queue,
+// wrapped in an envelope with the reason they failed. The client still gets the error.
+func WithDeadLetter(queue domain.DeadLetterQueue) Option {
+	return func(uc *UsecaseImpl) {
+		uc.deadLetters = queue
+	}
 }
 
 // NewUsecase creates a new instance of the usecase
-func NewUsecase(producerRepository domain.ProducerRepository) domain.Usecase {
-	return &UsecaseImpl{
+func NewUsecase(producerRepository domain.ProducerRepository, opts ...Option) domain.Usecase {
+	uc := &UsecaseImpl{
 		producerRepository: producerRepository,
 	}
+	uc.stop, uc.cancelStop = context.WithCancel(context.Background())
+	for _, opt := range opts {
+		opt(uc)
+	}
+	if uc.asyncBufferSize > 0 {
+		uc.async = newAsyncDispatcher(uc.stop, uc.asyncBufferSize, uc.asyncWorkers, uc.producerRepository.Produce, uc.asyncFailed, uc.asyncDropped)
+	}
+	return uc
 }
 
-// Send sends the request
-func (uc *UsecaseImpl) Send(ctx context.Context, message domain.Message) error {
-	err := uc.producerRepository.Produce(ctx, message)
+// Send sends the request.
+// A message with an idempotency key already sent is not sent again; the recorded receipt is returned instead.
+func (uc *UsecaseImpl) Send(ctx context.Context, message domain.Message) (receipt domain.Receipt, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "UsecaseImpl.Send")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+	ctx, done, err := uc.begin(ctx)
 	if err != nil {
+		return domain.Receipt{}, err
+	}
+	defer done()
+
+	metadata := domain.MetadataFromContext(ctx)
+	if uc.idempotency == nil || metadata.IdempotencyKey == "" {
+		return uc.send(ctx, message)
+	}
+
+	key := idempotencyKey(metadata)
+	messageFingerprint := fingerprint(message, metadata)
+	record, found, err := uc.idempotency.acquire(ctx, key)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to look up idempotency key")
+		return domain.Receipt{}, err
+	}
+	if found {
+		if err := uc.replay(ctx, record, messageFingerprint); err != nil {
+			return domain.Receipt{}, err
+		}
+		record.Receipt.Replayed = true
+		return record.Receipt, nil
+	}
+	defer uc.idempotency.release(key)
+
+	// Failures are not recorded, so the retries send the message again
+	receipt, err = uc.send(ctx, message)
+	if err != nil {
+		return receipt, err
+	}
+	record = domain.IdempotencyRecord{
+		Fingerprint: messageFingerprint,
+		Receipt:     receipt,
+		CreatedAt:   time.Now(),
+	}
+	if err := uc.idempotency.store.Put(ctx, key, record); err != nil {
+		log.Error().Err(err).Str("idempotency_key", metadata.IdempotencyKey).Msg("Failed to record idempotency key")
+	}
+	return receipt, nil
+}
+
+// replay checks that the message sent again with an idempotency key is the one recorded with it
+func (uc *UsecaseImpl) replay(ctx context.Context, record domain.IdempotencyRecord, messageFingerprint string) error {
+	metadata := domain.MetadataFromContext(ctx)
+	if record.Fingerprint != messageFingerprint {
+		log.Warn().Str("idempotency_key", metadata.IdempotencyKey).Msg("Rejected message")
+		return domain.ErrIdempotencyKeyReused
+	}
+	metrics.IdempotentReplays.Inc()
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Bool("anyway.idempotent_replay", true))
+	log.Info().Str("idempotency_key", metadata.IdempotencyKey).Msg("Message already sent")
+	return nil
+}
+
+// send checks and produces the message, unless an identical one was sent within the deduplication window.
+// Invalid and undeliverable messages are routed to the dead-letter queue.
+func (uc *UsecaseImpl) send(ctx context.Context, message domain.Message) (domain.Receipt, error) {
+	span := trace.SpanFromContext(ctx)
+	receivedAt := time.Now()
+
+	message, err := uc.prepare(ctx, message)
+	if err != nil {
+		log.Warn().Err(err).Msg("Rejected message")
+		return domain.Receipt{DeadLettered: uc.deadLetterInvalid(ctx, message, receivedAt, err)}, err
+	}
+	span.SetAttributes(attribute.String("messaging.destination.name", message.Topic))
+
+	hash, duplicate := uc.deduplicate(ctx, message)
+	if duplicate {
+		return domain.Receipt{Deduplicated: true}, nil
+	}
+
+	receipt, err := uc.producerRepository.Produce(ctx, message)
+	if err != nil {
+		uc.forget(hash)
 		log.Error().Err(err).Msg("Failed to send message")
+		receipt.DeadLettered = uc.deadLetterUndeliverable(ctx, message, receivedAt, err)
+		return receipt, err
+	}
+	return receipt, nil
+}
+
+// SendAsync checks the message and buffers it to be produced in the background.
+// The request context is detached from its cancellation, so the message is produced
+// after the response is written while keeping the request values.
+// A message with an idempotency key already buffered is not buffered again; the receipt recorded back then is returned instead.
+func (uc *UsecaseImpl) SendAsync(ctx context.Context, message domain.Message) (domain.Receipt, error) {
+	if uc.async == nil {
+		return domain.Receipt{}, domain.ErrAsyncDisabled
+	}
+	metadata := domain.MetadataFromContext(ctx)
+	if uc.idempotency == nil || metadata.IdempotencyKey == "" {
+		return uc.sendAsync(ctx, message)
+	}
+
+	key := idempotencyKey(metadata)
+	messageFingerprint := fingerprint(message, metadata)
+	record, found, err := uc.idempotency.acquire(ctx, key)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to look up idempotency key")
+		return domain.Receipt{}, err
+	}
+	if found {
+		if err := uc.replay(ctx, record, messageFingerprint); err != nil {
+			return domain.Receipt{}, err
+		}
+		receipt := record.Receipt
+		receipt.ID = record.AsyncID
+		return receipt, nil
+	}
+	defer uc.idempotency.release(key)
+
+	// The key is recorded once the message is buffered, so the retries do not buffer it again
+	// even if it cannot be produced later; it is then routed to the dead-letter queue
+	receipt, err := uc.sendAsync(ctx, message)
+	if err != nil {
+		return domain.Receipt{}, err
+	}
+	record = domain.IdempotencyRecord{
+		Fingerprint: messageFingerprint,
+		Receipt:     receipt,
+		AsyncID:     receipt.ID,
+		CreatedAt:   time.Now(),
+	}
+	if err := uc.idempotency.store.Put(ctx, key, record); err != nil {
+		log.Error().Err(err).Str("idempotency_key", metadata.IdempotencyKey).Msg("Failed to record idempotency key")
+	}
+	return receipt, nil
+}
+
+// sendAsync checks the message and buffers it, unless an identical one was sent within the deduplication window.
+// A dropped message gets no ID, since it is never produced.
+func (uc *UsecaseImpl) sendAsync(ctx context.Context, message domain.Message) (domain.Receipt, error) {
+	receivedAt := time.Now()
+	message, err := uc.prepare(ctx, message)
+	if err != nil {
+		log.Warn().Err(err).Msg("Rejected message")
+		uc.deadLetterInvalid(ctx, message, receivedAt, err)
+		return domain.Receipt{}, err
+	}
+	hash, duplicate := uc.deduplicate(ctx, message)
+	if duplicate {
+		return domain.Receipt{Deduplicated: true}, nil
+	}
+	id := uuid.NewString()
+	if err := uc.async.enqueue(asyncJob{ctx: context.WithoutCancel(ctx), id: id, message: message, receivedAt: receivedAt, hash: hash}); err != nil {
+		uc.forget(hash)
+		log.Warn().Err(err).Msg("Failed to buffer message")
+		return domain.Receipt{}, err
+	}
+	return domain.Receipt{ID: id}, nil
+}
+
+// deduplicate claims the message in the deduplication window, telling whether an identical one was already sent.
+// It returns the claimed hash, or nil when deduplication is disabled.
+func (uc *UsecaseImpl) deduplicate(ctx context.Context, message domain.Message) (*[sha256.Size]byte, bool) {
+	if uc.deduplicator == nil {
+		return nil, false
+	}
+	hash := contentHash(message, domain.MetadataFromContext(ctx))
+	if !uc.deduplicator.claim(hash, time.Now()) {
+		metrics.DeduplicatedMessages.WithLabelValues(message.Topic).Inc()
+		trace.SpanFromContext(ctx).SetAttributes(attribute.Bool("anyway.deduplicated", true))
+		log.Info().Msgf("Dropped message already sent to %s", message.Topic)
+		return nil, true
+	}
+	return &hash, false
+}
+
+// forget releases the hash claimed by deduplicate for a message that could not be sent, so its retries are not dropped
+func (uc *UsecaseImpl) forget(hash *[sha256.Size]byte) {
+	if hash != nil {
+		uc.deduplicator.forget(*hash)
+	}
+}
+
+// SendBatch sends the messages of a batch request.
+// Rejected messages and the ones identical to a message sent within the deduplication window,
+// including an earlier one of the same batch, are not produced; the rest are produced in a single batch.
+// Invalid and undeliverable messages are routed to the dead-letter queue one by one.
+func (uc *UsecaseImpl) SendBatch(ctx context.Context, messages []domain.Message) ([]domain.Receipt, []error) {
+	ctx, span := tracing.Tracer().Start(ctx, "UsecaseImpl.SendBatch")
+	defer span.End()
+	span.SetAttributes(attribute.Int("messaging.batch.message_count", len(messages)))
+
+	receipts := make([]domain.Receipt, len(messages))
+	errs := make([]error, len(messages))
+	ctx, done, err := uc.begin(ctx)
+	if err != nil {
+		for i := range errs {
+			errs[i] = err
+		}
+		return receipts, errs
+	}
+	defer done()
+
+	receivedAt := time.Now()
+	accepted := make([]domain.Message, 0, len(messages))
+	positions := make([]int, 0, len(messages))
+	hashes := make([]*[sha256.Size]byte, 0, len(messages))
+
+	for i, message := range messages {
+		message, err := uc.prepare(ctx, message)
+		if err != nil {
+			log.Warn().Err(err).Msgf("Rejected message %d of the batch", i)
+			uc.deadLetterInvalid(ctx, message, receivedAt, err)
+			errs[i] = err
+			continue
+		}
+		hash, duplicate := uc.deduplicate(ctx, message)
+		if duplicate {
+			receipts[i].Deduplicated = true
+			continue
+		}
+		accepted = append(accepted, message)
+		positions = append(positions, i)
+		hashes = append(hashes, hash)
+	}
+	if len(accepted) == 0 {
+		return receipts, errs
+	}
+	for i, err := range uc.producerRepository.ProduceBatch(ctx, accepted) {
+		if err != nil {
+			uc.forget(hashes[i])
+			log.Error().Err(err).Msgf("Failed to send message %d of the batch", positions[i])
+			uc.deadLetterUndeliverable(ctx, accepted[i], receivedAt, err)
+			errs[positions[i]] = err
+		}
+	}
+	return receipts, errs
+}
+
+// HealthCheck reports the health of the producer dependencies
+func (uc *UsecaseImpl) HealthCheck(ctx context.Context) []domain.DependencyStatus {
+	return uc.producerRepository.HealthCheck(ctx)
+}
+
+// Close stops accepting messages and waits until the sends in flight and the buffered asynchronous messages
+// are done, or ctx is done. Then the sends in flight are canceled and the buffered messages left are dropped,
+// so nothing is producing when it returns.
+func (uc *UsecaseImpl) Close(ctx context.Context) {
+	uc.mu.Lock()
+	uc.closing = true
+	uc.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if uc.async != nil {
+			uc.async.close()
+		}
+		uc.inflight.Wait()
+	}()
+	select {
+	case <-done:
+		uc.cancelStop()
+		return
+	case <-ctx.Done():
+	}
+	log.Warn().Msg("Shutdown deadline passed, canceling the messages still being sent")
+	uc.cancelStop()
+	<-done
+}
+
+// begin registers a send in flight and binds its context to the shutdown.
+// It fails with domain.ErrShuttingDown once Close was called; done must be called when the send returns.
+func (uc *UsecaseImpl) begin(ctx context.Context) (context.Context, func(), error) {
+	uc.mu.RLock()
+	defer uc.mu.RUnlock()
+
+	if uc.closing {
+		return ctx, nil, domain.ErrShuttingDown
+	}
+	uc.inflight.Add(1)
+	ctx, cancel := bind(ctx, uc.stop)
+	return ctx, func() {
+		cancel()
+		uc.inflight.Done()
+	}, nil
+}
+
+// prepare resolves the topic of the message, checks the caller may send it and it is valid, and encodes it
+func (uc *UsecaseImpl) prepare(ctx context.Context, message domain.Message) (domain.Message, error) {
+	if uc.topics != nil {
+		topic, err := uc.topics.resolve(message.Topic)
+		if err != nil {
+			return message, err
+		}
+		message.Topic = topic
+	}
+	if uc.policy != nil {
+		if err := uc.policy.Authorize(ctx, message); err != nil {
+			return message, err
+		}
+	}
+	if err := uc.validate(message); err != nil {
+		return message, err
+	}
+	if uc.encoder != nil {
+		return uc.encoder.Encode(ctx, message)
+	}
+	return message, nil
+}
+
+// validate checks the content of the message, only logging the violations in warn-only mode
+func (uc *UsecaseImpl) validate(message domain.Message) error {
+	if uc.validator == nil {
+		return nil
+	}
+	err := uc.validator.Validate(message)
+	if err == nil {
+		return nil
+	}
+	var validationErr *domain.ValidationError
+	if !errors.As(err, &validationErr) {
 		return err
 	}
-	return nil
+	if uc.validationWarnOnly {
+		metrics.ValidationFailures.WithLabelValues(message.Topic, "warned").Inc()
+		log.Warn().Strs("violations", validationErr.Violations).Msgf("Message does not match schema %s", validationErr.Schema)
+		return nil
+	}
+	metrics.ValidationFailures.WithLabelValues(message.Topic, "rejected").Inc()
+	return err
 }
@@ -9,6 +9,12 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
 )
 
 // MockProducerRepository is a mock implementation of domain.ProducerRepository
@@ -17,9 +23,21 @@ type MockProducerRepository struct {
 }
 
 // Produce mocks the Produce method of ProducerRepository
-func (m *MockProducerRepository) Produce(ctx context.Context, message domain.Message) error {
+func (m *MockProducerRepository) Produce(ctx context.Context, message domain.Message) (domain.Receipt, error) {
 	args := m.Called(ctx, message)
-	return args.Error(0)
+	return args.Get(0).(domain.Receipt), args.Error(1)
+}
+
+// ProduceBatch mocks the ProduceBatch method of ProducerRepository
+func (m *MockProducerRepository) ProduceBatch(ctx context.Context, messages []domain.Message) []error {
+	args := m.Called(ctx, messages)
+	return args.Get(0).([]error)
+}
+
+// HealthCheck mocks the HealthCheck method of ProducerRepository
+func (m *MockProducerRepository) HealthCheck(ctx context.Context) []domain.DependencyStatus {
+	args := m.Called(ctx)
+	return args.Get(0).([]domain.DependencyStatus)
 }
 
 // Close mocks the Close method of ProducerRepository
@@ -27,6 +45,39 @@ func (m *MockProducerRepository) Close() {
 	m.Called()
 }
 
+// MockValidator is a mock implementation of domain.Validator
+type MockValidator struct {
+	mock.Mock
+}
+
+// Validate mocks the Validate method of Validator
+func (m *MockValidator) Validate(message domain.Message) error {
+	args := m.Called(message)
+	return args.Error(0)
+}
+
+// MockEncoder is a mock implementation of domain.Encoder
+type MockEncoder struct {
+	mock.Mock
+}
+
+// Encode mocks the Encode method of Encoder
+func (m *MockEncoder) Encode(ctx context.Context, message domain.Message) (domain.Message, error) {
+	args := m.Called(ctx, message)
+	return args.Get(0).(domain.Message), args.Error(1)
+}
+
+// MockPolicy is a mock implementation of domain.Policy
+type MockPolicy struct {
+	mock.Mock
+}
+
+// Authorize mocks the Authorize method of Policy
+func (m *MockPolicy) Authorize(ctx context.Context, message domain.Message) error {
+	args := m.Called(ctx, message)
+	return args.Error(0)
+}
+
 // TestNewUsecase tests the NewUsecase constructor
 func TestNewUsecase(t *testing.T) {
 	// Create a mock producer repository
@@ -49,7 +100,7 @@ func TestSendSuccess(t *testing.T) {
 	mockRepo := new(MockProducerRepository)
 
 	// Expect Produce to be called and return nil (success)
-	mockRepo.On("Produce", mock.Anything, mock.Anything).Return(nil).Once()
+	mockRepo.On("Produce", mock.Anything, mock.Anything).Return(domain.Receipt{}, nil).Once()
 
 	// Create a new use case instance
 	usecase := application.NewUsecase(mockRepo)
@@ -60,7 +111,7 @@ func TestSendSuccess(t *testing.T) {
 	}
 
 	// Call the Send method
-	err := usecase.Send(context.Background(), message)
+	_, err := usecase.Send(context.Background(), message)
 
 	// Assert that no error is returned
 	assert.NoError(t, err)
@@ -69,6 +120,36 @@ func TestSendSuccess(t *testing.T) {
 	mockRepo.AssertExpectations(t)
 }
 
+// TestSendTracing tests that Send records a span that is the parent of the repository spans
+func TestSendTracing(t *testing.T) {
+	// Record the spans in memory, disabling the tracing afterwards
+	defer otel.SetTracerProvider(noop.NewTracerProvider())
+	exporter := tracetest.NewInMemoryExporter()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+
+	// Create a mock producer repository that fails, keeping the span of the context it receives
+	mockRepo := new(MockProducerRepository)
+	var produceSpan trace.SpanContext
+	mockRepo.On("Produce", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		produceSpan = trace.SpanContextFromContext(args.Get(0).(context.Context))
+	}).Return(domain.Receipt{}, errors.New("failed to produce message")).Once()
+
+	// Create a new use case instance and call the Send method
+	usecase := application.NewUsecase(mockRepo, application.WithTopics("default-topic", nil))
+	_, err := usecase.Send(context.Background(), domain.Message{Content: []byte("test-content")})
+	assert.Error(t, err)
+
+	// Assert that the span was recorded as failed and passed to the repository
+	spans := exporter.GetSpans()
+	assert.Len(t, spans, 1)
+	assert.Equal(t, "UsecaseImpl.Send", spans[0].Name)
+	assert.Equal(t, codes.Error, spans[0].Status.Code)
+	assert.Equal(t, spans[0].SpanContext.SpanID(), produceSpan.SpanID())
+
+	// Assert that the expected methods were called on the mock
+	mockRepo.AssertExpectations(t)
+}
+
 // TestSendProduceError tests the Send method when Produce returns an error
 func TestSendProduceError(t *testing.T) {
 	// Create a mock producer repository
@@ -78,7 +159,7 @@ func TestSendProduceError(t *testing.T) {
 	expectedErr := errors.New("failed to produce message")
 
 	// Expect Produce to be called and return an error
-	mockRepo.On("Produce", mock.Anything, mock.Anything).Return(expectedErr).Once()
+	mockRepo.On("Produce", mock.Anything, mock.Anything).Return(domain.Receipt{}, expectedErr).Once()
 
 	// Create a new use case instance
 	usecase := application.NewUsecase(mockRepo)
@@ -88,7 +169,7 @@ func TestSendProduceError(t *testing.T) {
 		Content: []byte("test-content")}
 
 	// Call the Send method
-	err := usecase.Send(context.Background(), message)
+	_, err := usecase.Send(context.Background(), message)
 
 	// Assert that the expected error is returned
 	assert.EqualError(t, err, expectedErr.Error())
@@ -96,3 +177,338 @@ func TestSendProduceError(t *testing.T) {
 	// Assert that the expected methods were called on the mock
 	mockRepo.AssertExpectations(t)
 }
+
+// TestSendTopics tests how the Send method resolves and restricts the topic of the message
+func TestSendTopics(t *testing.T) {
+	tests := []struct {
+		name          string
+		topic         string
+		expectedTopic string
+		expectedErr   error
+	}{
+		{
+			name:          "empty topic uses the default topic",
+			topic:         "",
+			expectedTopic: "default-topic",
+		},
+		{
+			name:          "default topic is always allowed",
+			topic:         "default-topic",
+			expectedTopic: "default-topic",
+		},
+		{
+			name:          "topic in the allow-list",
+			topic:         "orders",
+			expectedTopic: "orders",
+		},
+		{
+			name:          "topic matching an allowed pattern",
+			topic:         "events.created",
+			expectedTopic: "events.created",
+		},
+		{
+			name:        "topic outside the allow-list",
+			topic:       "payments",
+			expectedErr: domain.ErrTopicNotAllowed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Create a mock producer repository
+			mockRepo := new(MockProducerRepository)
+			if tt.expectedErr == nil {
+				mockRepo.On("Produce", mock.Anything, domain.Message{
+					Topic:   tt.expectedTopic,
+					Content: []byte("test-content"),
+				}).Return(domain.Receipt{}, nil).Once()
+			}
+
+			// Create a new use case instance restricted to some topics
+			usecase := application.NewUsecase(mockRepo,
+				application.WithTopics("default-topic", []string{"orders", "events.*"}),
+			)
+
+			// Call the Send method
+			_, err := usecase.Send(context.Background(), domain.Message{
+				Topic:   tt.topic,
+				Content: []byte("test-content"),
+			})
+
+			// Assert the returned error
+			if tt.expectedErr != nil {
+				assert.ErrorIs(t, err, tt.expectedErr)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			// Assert that the expected methods were called on the mock
+			mockRepo.AssertExpectations(t)
+		})
+	}
+}
+
+// TestSendBatch tests that the SendBatch method produces the accepted messages and reports every outcome
+func TestSendBatch(t *testing.T) {
+	// Create a mock producer repository
+	mockRepo := new(MockProducerRepository)
+
+	// Define an error to be returned by the repository for one of the messages
+	produceErr := errors.New("failed to produce message")
+
+	// Expect ProduceBatch to be called only with the allowed messages, resolved to their topic
+	mockRepo.On("ProduceBatch", mock.Anything, []domain.Message{
+		{Topic: "default-topic", Content: []byte("first")},
+		{Topic: "orders", Content: []byte("third")},
+	}).Return([]error{nil, produceErr}).Once()
+
+	// Create a new use case instance restricted to some topics
+	usecase := application.NewUsecase(mockRepo,
+		application.WithTopics("default-topic", []string{"orders"}),
+	)
+
+	// Call the SendBatch method
+	_, errs := usecase.SendBatch(context.Background(), []domain.Message{
+		{Content: []byte("first")},
+		{Topic: "payments", Content: []byte("second")},
+		{Topic: "orders", Content: []byte("third")},
+	})
+
+	// Assert the outcome of every message
+	assert.Len(t, errs, 3)
+	assert.NoError(t, errs[0])
+	assert.ErrorIs(t, errs[1], domain.ErrTopicNotAllowed)
+	assert.Equal(t, produceErr, errs[2])
+
+	// Assert that the expected methods were called on the mock
+	mockRepo.AssertExpectations(t)
+}
+
+// TestSendBatchAllRejected tests that the repository is not called when every message is rejected
+func TestSendBatchAllRejected(t *testing.T) {
+	// Create a mock producer repository (it should not be called)
+	mockRepo := new(MockProducerRepository)
+
+	// Create a new use case instance restricted to the default topic
+	usecase := application.NewUsecase(mockRepo, application.WithTopics("default-topic", nil))
+
+	// Call the SendBatch method
+	_, errs := usecase.SendBatch(context.Background(), []domain.Message{
+		{Topic: "payments", Content: []byte("first")},
+	})
+
+	// Assert that the message was rejected
+	assert.Len(t, errs, 1)
+	assert.ErrorIs(t, errs[0], domain.ErrTopicNotAllowed)
+
+	// Assert that no methods were called on the mock
+	mockRepo.AssertExpectations(t)
+}
+
+// TestSendPolicy tests that messages are authorised with their resolved topic before they are produced
+func TestSendPolicy(t *testing.T) {
+	denied := &domain.PolicyError{Rule: "billing-invoices", Reason: "key not allowed"}
+	tests := []struct {
+		name         string
+		authorizeErr error
+	}{
+		{name: "authorised message"},
+		{name: "denied message", authorizeErr: denied},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			message := domain.Message{Key: "order-1", Content: []byte("test-content")}
+			resolved := domain.Message{Topic: "invoices", Key: "order-1", Content: message.Content}
+
+			// Create a mock policy returning the outcome
+			mockPolicy := new(MockPolicy)
+			mockPolicy.On("Authorize", mock.Anything, resolved).Return(tt.authorizeErr).Once()
+
+			// Create a mock producer repository, only called for authorised messages
+			mockRepo := new(MockProducerRepository)
+			if tt.authorizeErr == nil {
+				mockRepo.On("Produce", mock.Anything, resolved).Return(domain.Receipt{}, nil).Once()
+			}
+
+			// Create a new use case instance with the policy
+			usecase := application.NewUsecase(mockRepo,
+				application.WithTopics("invoices", nil),
+				application.WithPolicy(mockPolicy))
+
+			// Call the Send method
+			_, err := usecase.Send(context.Background(), message)
+
+			// Assert the outcome
+			if tt.authorizeErr == nil {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, domain.ErrPolicyViolation)
+			}
+
+			// Assert that the expected methods were called on the mocks
+			mockPolicy.AssertExpectations(t)
+			mockRepo.AssertExpectations(t)
+		})
+	}
+}
+
+// TestSendValidation tests that invalid messages are rejected, or only logged in warn-only mode
+func TestSendValidation(t *testing.T) {
+	invalid := &domain.ValidationError{Schema: "orders", Violations: []string{"/id: missing property"}}
+	tests := []struct {
+		name        string
+		warnOnly    bool
+		validateErr error
+		expectSent  bool
+	}{
+		{name: "valid message", validateErr: nil, expectSent: true},
+		{name: "invalid message", validateErr: invalid, expectSent: false},
+		{name: "invalid message in warn-only mode", warnOnly: true, validateErr: invalid, expectSent: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			message := domain.Message{Topic: "orders", Content: []byte(`{}`)}
+
+			// Create a mock validator returning the validation outcome
+			mockValidator := new(MockValidator)
+			mockValidator.On("Validate", message).Return(tt.validateErr).Once()
+
+			// Create a mock producer repository, only called when the message is sent
+			mockRepo := new(MockProducerRepository)
+			if tt.expectSent {
+				mockRepo.On("Produce", mock.Anything, message).Return(domain.Receipt{}, nil).Once()
+			}
+
+			// Create a new use case instance with validation
+			usecase := application.NewUsecase(mockRepo,
+				application.WithTopics("orders", nil),
+				application.WithValidation(mockValidator, tt.warnOnly))
+
+			// Call the Send method
+			_, err := usecase.Send(context.Background(), message)
+
+			// Assert the outcome
+			if tt.expectSent {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, domain.ErrInvalidMessage)
+				var validationErr *domain.ValidationError
+				assert.ErrorAs(t, err, &validationErr)
+				assert.Equal(t, invalid.Violations, validationErr.Violations)
+			}
+
+			// Assert that the expected methods were called on the mocks
+			mockValidator.AssertExpectations(t)
+			mockRepo.AssertExpectations(t)
+		})
+	}
+}
+
+// TestSendBatchValidation tests that only the invalid messages of a batch are rejected
+func TestSendBatchValidation(t *testing.T) {
+	valid := domain.Message{Topic: "orders", Content: []byte(`{"id":1}`)}
+	invalid := domain.Message{Topic: "orders", Content: []byte(`{}`)}
+
+	// Create a mock validator rejecting the second message
+	mockValidator := new(MockValidator)
+	mockValidator.On("Validate", valid).Return(nil).Once()
+	mockValidator.On("Validate", invalid).Return(&domain.ValidationError{Schema: "orders"}).Once()
+
+	// Create a mock producer repository expecting only the valid message
+	mockRepo := new(MockProducerRepository)
+	mockRepo.On("ProduceBatch", mock.Anything, []domain.Message{valid}).Return([]error{nil}).Once()
+
+	// Create a new use case instance with validation
+	usecase := application.NewUsecase(mockRepo, application.WithValidation(mockValidator, false))
+
+	// Call the SendBatch method
+	_, errs := usecase.SendBatch(context.Background(), []domain.Message{valid, invalid})
+
+	// Assert the outcome of every message
+	assert.Len(t, errs, 2)
+	assert.NoError(t, errs[0])
+	assert.ErrorIs(t, errs[1], domain.ErrInvalidMessage)
+
+	// Assert that the expected methods were called on the mocks
+	mockValidator.AssertExpectations(t)
+	mockRepo.AssertExpectations(t)
+}
+
+// TestSendEncoder tests that validated messages are encoded before they are produced
+func TestSendEncoder(t *testing.T) {
+	message := domain.Message{Content: []byte(`{"id":1}`)}
+	resolved := domain.Message{Topic: "orders", Content: message.Content}
+	encoded := domain.Message{Topic: "orders", Content: []byte{0, 0, 0, 0, 1, 2}}
+
+	// Create a mock validator accepting the message with its resolved topic
+	mockValidator := new(MockValidator)
+	mockValidator.On("Validate", resolved).Return(nil).Once()
+
+	// Create a mock encoder
+	mockEncoder := new(MockEncoder)
+	mockEncoder.On("Encode", mock.Anything, resolved).Return(encoded, nil).Once()
+
+	// Create a mock producer repository expecting the encoded message
+	mockRepo := new(MockProducerRepository)
+	mockRepo.On("Produce", mock.Anything, encoded).Return(domain.Receipt{}, nil).Once()
+
+	// Create a new use case instance with validation and encoding
+	usecase := application.NewUsecase(mockRepo,
+		application.WithTopics("orders", nil),
+		application.WithValidation(mockValidator, false),
+		application.WithEncoder(mockEncoder))
+
+	// Call the Send method
+	_, err := usecase.Send(context.Background(), message)
+	assert.NoError(t, err)
+
+	// Assert that the expected methods were called on the mocks
+	mockValidator.AssertExpectations(t)
+	mockEncoder.AssertExpectations(t)
+	mockRepo.AssertExpectations(t)
+}
+
+// TestSendEncoderError tests that messages that cannot be encoded are not produced
+func TestSendEncoderError(t *testing.T) {
+	message := domain.Message{Topic: "orders", Content: []byte(`{"id":"one"}`)}
+	encodeErr := &domain.ValidationError{Schema: "orders-value (id 1)", Violations: []string{"cannot encode id"}}
+
+	// Create a mock encoder rejecting the message
+	mockEncoder := new(MockEncoder)
+	mockEncoder.On("Encode", mock.Anything, message).Return(message, encodeErr).Once()
+
+	// Create a mock producer repository (it should not be called)
+	mockRepo := new(MockProducerRepository)
+
+	// Create a new use case instance with encoding
+	usecase := application.NewUsecase(mockRepo, application.WithEncoder(mockEncoder))
+
+	// Call the Send method
+	_, err := usecase.Send(context.Background(), message)
+	assert.ErrorIs(t, err, domain.ErrInvalidMessage)
+
+	// Assert that the expected methods were called on the mocks
+	mockEncoder.AssertExpectations(t)
+	mockRepo.AssertExpectations(t)
+}
+
+// TestHealthCheck tests that the HealthCheck method reports the health of the producer repository
+func TestHealthCheck(t *testing.T) {
+	// Create a mock producer repository
+	mockRepo := new(MockProducerRepository)
+
+	// Expect HealthCheck to be called and report an unhealthy dependency
+	expected := []domain.DependencyStatus{{Name: "kafka", Healthy: false, Error: "no Kafka broker is reachable"}}
+	mockRepo.On("HealthCheck", mock.Anything).Return(expected).Once()
+
+	// Create a new use case instance
+	usecase := application.NewUsecase(mockRepo)
+
+	// Assert that the status of the repository is returned
+	assert.Equal(t, expected, usecase.HealthCheck(context.Background()))
+
+	// Assert that the expected methods were called on the mock
+	mockRepo.AssertExpectations(t)
+}
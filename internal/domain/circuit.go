@@ -0,0 +1,24 @@
+package domain
+
+import (
+	"fmt"
+	"time"
+)
+
+// CircuitOpenError is returned when a backend is not called because its circuit breaker is open
+type CircuitOpenError struct {
+	// Backend is the name of the backend
+	Backend string
+	// RetryAfter is how long until the breaker lets a message through again
+	RetryAfter time.Duration
+}
+
+// Error implements error
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrCircuitOpen, e.Backend)
+}
+
+// Unwrap makes errors.Is match ErrCircuitOpen
+func (e *CircuitOpenError) Unwrap() error {
+	return ErrCircuitOpen
+}
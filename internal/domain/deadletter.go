@@ -0,0 +1,59 @@
+package domain
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	// DeadLetterInvalid is the reason of the messages rejected because their content does not match its schema
+	DeadLetterInvalid = "invalid"
+	// DeadLetterUndeliverable is the reason of the messages that could not be produced
+	DeadLetterUndeliverable = "undeliverable"
+)
+
+// DeadLetter is the envelope of a message routed to the dead-letter queue, with why and when it failed
+type DeadLetter struct {
+	Topic   string            `json:"topic,omitempty"`
+	Key     string            `json:"key,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Content []byte            `json:"content"`
+	// Reason is DeadLetterInvalid or DeadLetterUndeliverable, and Error the error the message failed with
+	Reason     string   `json:"reason"`
+	Error      string   `json:"error"`
+	Violations []string `json:"violations,omitempty"`
+	// Attempts is the number of times the message was produced, when it was
+	Attempts      int    `json:"attempts,omitempty"`
+	RequestID     string `json:"request_id,omitempty"`
+	CorrelationID string `json:"correlation_id,omitempty"`
+	Caller        string `json:"caller,omitempty"`
+	// ReceivedAt is when the message was received and FailedAt when it was given up on
+	ReceivedAt time.Time `json:"received_at"`
+	FailedAt   time.Time `json:"failed_at"`
+}
+
+// UndeliverableError is returned when a message is given up on: it failed with a permanent error
+// or all the attempts to produce it failed. It wraps the error of the last attempt.
+type UndeliverableError struct {
+	Err error
+	// Attempts is the number of times the message was produced
+	Attempts int
+}
+
+// Error implements error
+func (e *UndeliverableError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap makes errors.Is match ErrUndeliverable and the error of the last attempt
+func (e *UndeliverableError) Unwrap() []error {
+	return []error{ErrUndeliverable, e.Err}
+}
+
+// DeadLetterQueue keeps the messages that could not be produced, so they can be inspected and replayed
+type DeadLetterQueue interface {
+	// Publish adds the dead letter to the queue
+	Publish(ctx context.Context, deadLetter DeadLetter) error
+	// Close releases the resources of the queue
+	Close()
+}
@@ -0,0 +1,9 @@
+package domain
+
+import "context"
+
+// Encoder serialises the content of a message before it is sent, e.g. to the wire format of a schema registry
+type Encoder interface {
+	// Encode returns the message with its encoded content, or the message unchanged when it has nothing to encode
+	Encode(ctx context.Context, message Message) (Message, error)
+}
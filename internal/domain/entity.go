@@ -1,5 +1,12 @@
 package domain
 
+// Message represents a message to be produced to the queue.
+// An empty Topic means the default topic of the deployment.
+// Key and Headers are optional; when present they take precedence over the
+// values derived from the X-Routing-Id and X-Correlation-Id request headers.
 type Message struct {
-	Content []byte `json:"content"`
+	Topic   string            `json:"topic,omitempty"`
+	Key     string            `json:"key,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Content []byte            `json:"content"`
 }
@@ -0,0 +1,26 @@
+package domain
+
+import "errors"
+
+var (
+	// ErrTopicNotAllowed is returned when a message targets a topic outside the configured allow-list
+	ErrTopicNotAllowed = errors.New("topic not allowed")
+	// ErrPolicyViolation is returned when a message breaks a rule of the authorisation policy
+	ErrPolicyViolation = errors.New("policy violation")
+	// ErrRateLimited is returned when a caller sends messages faster than it is allowed to
+	ErrRateLimited = errors.New("rate limit exceeded")
+	// ErrQueueFull is returned when an asynchronous message cannot be buffered because the buffer is full
+	ErrQueueFull = errors.New("message buffer is full")
+	// ErrAsyncDisabled is returned when an asynchronous send is requested but no buffer is configured
+	ErrAsyncDisabled = errors.New("asynchronous mode is disabled")
+	// ErrInvalidMessage is returned when the content of a message does not conform to its schema
+	ErrInvalidMessage = errors.New("invalid message")
+	// ErrIdempotencyKeyReused is returned when an idempotency key is repeated with a different message
+	ErrIdempotencyKeyReused = errors.New("idempotency key reused for a different message")
+	// ErrCircuitOpen is returned when the backend is failing and messages are rejected without trying it
+	ErrCircuitOpen = errors.New("circuit breaker open")
+	// ErrShuttingDown is returned when a message arrives while the service is stopping
+	ErrShuttingDown = errors.New("service is shutting down")
+	// ErrUndeliverable matches the errors of the messages given up on, see UndeliverableError
+	ErrUndeliverable = errors.New("message is undeliverable")
+)
@@ -0,0 +1,11 @@
+package domain
+
+// DependencyStatus reports the health of a dependency, such as the message broker.
+// An unhealthy Optional dependency is reported but does not make the service unavailable.
+type DependencyStatus struct {
+	Name     string            `json:"name"`
+	Healthy  bool              `json:"healthy"`
+	Optional bool              `json:"optional,omitempty"`
+	Error    string            `json:"error,omitempty"`
+	Details  map[string]string `json:"details,omitempty"`
+}
@@ -0,0 +1,24 @@
+package domain
+
+import (
+	"context"
+	"time"
+)
+
+// IdempotencyRecord is the outcome of a message sent with an idempotency key
+type IdempotencyRecord struct {
+	// Fingerprint identifies the message, so a key repeated with another message is detected
+	Fingerprint string  `json:"fingerprint"`
+	Receipt     Receipt `json:"receipt"`
+	// AsyncID is the ID assigned to the message when it was buffered to be produced in the background
+	AsyncID   string    `json:"async_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// IdempotencyStore keeps the outcome of the messages sent with an idempotency key
+type IdempotencyStore interface {
+	// Get returns the record of the key, or false when the key is unknown or expired
+	Get(ctx context.Context, key string) (IdempotencyRecord, bool, error)
+	// Put records the outcome of the key
+	Put(ctx context.Context, key string, record IdempotencyRecord) error
+}
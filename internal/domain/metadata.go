@@ -0,0 +1,34 @@
+package domain
+
+import "context"
+
+// Metadata carries the identifiers of the request a message belongs to.
+// The HTTP layer populates it; code paths without a request get the zero value.
+type Metadata struct {
+	RequestID     string `json:"request_id,omitempty"`
+	CorrelationID string `json:"correlation_id,omitempty"`
+	RoutingID     string `json:"routing_id,omitempty"`
+	// Caller is the identity of the authenticated client, when known
+	Caller string `json:"caller,omitempty"`
+	// Claims are the claims of the JWT the caller authenticated with, used to authorise its messages.
+	// They are not forwarded nor kept with spooled messages.
+	Claims map[string]interface{} `json:"-"`
+	// IdempotencyKey identifies the retries of a request, so its message is only sent once
+	IdempotencyKey string `json:"-"`
+	// Headers are the request headers selected to be forwarded as message headers, by message header name
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// metadataKey is the context key of the request metadata
+type metadataKey struct{}
+
+// WithMetadata returns a copy of ctx carrying the request metadata
+func WithMetadata(ctx context.Context, metadata Metadata) context.Context {
+	return context.WithValue(ctx, metadataKey{}, metadata)
+}
+
+// MetadataFromContext returns the request metadata carried by ctx, or the zero value when there is none
+func MetadataFromContext(ctx context.Context) Metadata {
+	metadata, _ := ctx.Value(metadataKey{}).(Metadata)
+	return metadata
+}
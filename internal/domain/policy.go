@@ -0,0 +1,36 @@
+package domain
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Policy decides whether the caller of the request may send a message
+type Policy interface {
+	// Authorize returns a *PolicyError when the caller in the metadata of ctx may not send the message
+	Authorize(ctx context.Context, message Message) error
+}
+
+// PolicyError is returned when a message breaks a rule of the policy
+type PolicyError struct {
+	// Rule is the name of the violated rule
+	Rule string
+	// Reason describes the violation
+	Reason string
+	// RetryAfter is how long the caller should wait when the rule limits its rate
+	RetryAfter time.Duration
+}
+
+// Error implements error
+func (e *PolicyError) Error() string {
+	return fmt.Sprintf("%s: rule %s: %s", e.Unwrap(), e.Rule, e.Reason)
+}
+
+// Unwrap makes errors.Is match ErrRateLimited for rate violations and ErrPolicyViolation otherwise
+func (e *PolicyError) Unwrap() error {
+	if e.RetryAfter > 0 {
+		return ErrRateLimited
+	}
+	return ErrPolicyViolation
+}
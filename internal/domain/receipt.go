@@ -0,0 +1,30 @@
+package domain
+
+// Receipt describes how a message was produced
+type Receipt struct {
+	// ID is the ID assigned to a message buffered to be produced in the background
+	ID string `json:"-"`
+	// Destinations is the outcome of every backend a fan-out forwarded the message to.
+	// It is empty when the message is produced to a single backend.
+	Destinations []Destination `json:"destinations,omitempty"`
+	// Deduplicated tells whether the message was dropped because an identical one was sent recently
+	Deduplicated bool `json:"deduplicated,omitempty"`
+	// Attempts is the number of times the message was produced, when it was retried
+	Attempts int `json:"-"`
+	// Replayed tells whether the message was already sent with the same idempotency key,
+	// in which case the receipt is the one recorded back then
+	Replayed bool `json:"-"`
+	// DeadLettered tells whether the message that could not be sent was routed to the dead-letter queue
+	DeadLettered bool `json:"-"`
+}
+
+// Destination is the outcome of producing a message to one backend of a fan-out
+type Destination struct {
+	Name      string `json:"name"`
+	Role      string `json:"role,omitempty"`
+	Delivered bool   `json:"delivered"`
+	// Pending tells whether a shadow was still producing the message when the receipt was built
+	Pending  bool   `json:"pending,omitempty"`
+	Attempts int    `json:"attempts,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
@@ -6,6 +6,11 @@ import (
 
 // ProducerRepository defines the interface for the producer repository for queue messages
 type ProducerRepository interface {
-	Produce(ctx context.Context, message Message) error
+	// Produce produces the message and returns the receipt describing where it was produced
+	Produce(ctx context.Context, message Message) (Receipt, error)
+	// ProduceBatch produces every message and returns one error (nil on success) per message, in the same order
+	ProduceBatch(ctx context.Context, messages []Message) []error
+	// HealthCheck reports whether the dependencies of the repository can accept messages
+	HealthCheck(ctx context.Context) []DependencyStatus
 	Close()
 }
@@ -0,0 +1,14 @@
+package domain
+
+// SpoolStats describes the messages waiting in the local disk spool to be produced
+type SpoolStats struct {
+	Messages int   `json:"messages"`
+	Bytes    int64 `json:"bytes"`
+	Segments int   `json:"segments"`
+	MaxBytes int64 `json:"max_bytes"`
+}
+
+// SpoolInspector gives access to the depth of the spool
+type SpoolInspector interface {
+	SpoolStats() SpoolStats
+}
@@ -6,5 +6,15 @@ import (
 
 // Usecase defines the interface for the use case
 type Usecase interface {
-	Send(ctx context.Context, message Message) error
+	// Send sends the message and returns the receipt describing where it was produced
+	Send(ctx context.Context, message Message) (Receipt, error)
+	// SendAsync buffers the message to be sent in the background and returns a receipt with the ID assigned to it
+	SendAsync(ctx context.Context, message Message) (Receipt, error)
+	// SendBatch sends every message and returns one receipt and one error (nil on success) per message, in the same order
+	SendBatch(ctx context.Context, messages []Message) ([]Receipt, []error)
+	// HealthCheck reports whether the dependencies needed to send messages are healthy
+	HealthCheck(ctx context.Context) []DependencyStatus
+	// Close waits until the messages in flight and the buffered ones are sent, or ctx is done;
+	// the ones left are then canceled or dropped. No message is accepted afterwards
+	Close(ctx context.Context)
 }
@@ -0,0 +1,30 @@
+package domain
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Validator checks the content of a message before it is sent
+type Validator interface {
+	// Validate returns a *ValidationError when the message does not conform to its schema
+	Validate(message Message) error
+}
+
+// ValidationError is returned when the content of a message does not conform to its schema
+type ValidationError struct {
+	// Schema is the name of the schema the message was validated against
+	Schema string
+	// Violations describe every part of the content that does not conform to the schema
+	Violations []string
+}
+
+// Error implements error
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%s: message does not match schema %s: %s", ErrInvalidMessage, e.Schema, strings.Join(e.Violations, "; "))
+}
+
+// Unwrap makes errors.Is(err, ErrInvalidMessage) match validation errors
+func (e *ValidationError) Unwrap() error {
+	return ErrInvalidMessage
+}
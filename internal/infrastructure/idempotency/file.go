@@ -0,0 +1,221 @@
+package idempotency
+
+import (
+	"anyway/internal/domain"
+	"bufio"
+	"container/list"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// compactMinLines is the number of lines below which the file is never compacted
+const compactMinLines = 1024
+
+// fileEntry is a line of the store file
+type fileEntry struct {
+	Key    string                   `json:"key"`
+	Record domain.IdempotencyRecord `json:"record"`
+}
+
+// FileStore keeps the records for ttl in memory and in a file of JSON lines, so they survive restarts.
+// Every record is appended and synced to disk before Put returns. Put evicts the oldest records
+// once they expire or the store holds more than capacity keys, and the file is rewritten without
+// the evicted and overwritten records when it is opened and once they make up most of it.
+type FileStore struct {
+	path     string
+	ttl      time.Duration
+	capacity int
+
+	mu   sync.Mutex
+	file *os.File
+	// entries holds the records from the oldest key to the newest one
+	entries *list.List
+	index   map[string]*list.Element
+	lines   int
+}
+
+// NewFileStore opens the store of up to capacity keys kept in the file at path, creating it if needed;
+// a zero capacity or ttl is unlimited
+func NewFileStore(path string, capacity int, ttl time.Duration) (*FileStore, error) {
+	s := &FileStore{
+		path:     path,
+		ttl:      ttl,
+		capacity: capacity,
+		entries:  list.New(),
+		index:    map[string]*list.Element{},
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	if err := s.compact(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Get returns the record of the key, or false when the key is unknown or expired
+func (s *FileStore) Get(_ context.Context, key string) (domain.IdempotencyRecord, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	element, ok := s.index[key]
+	if !ok {
+		return domain.IdempotencyRecord{}, false, nil
+	}
+	record := element.Value.(*memoryEntry).record
+	if expired(record, s.ttl, time.Now()) {
+		s.remove(element)
+		return domain.IdempotencyRecord{}, false, nil
+	}
+	return record, true, nil
+}
+
+// Put appends the record of the key to the file and syncs it to disk, evicting the oldest records
+// that expired or are over the capacity
+func (s *FileStore) Put(_ context.Context, key string, record domain.IdempotencyRecord) error {
+	line, err := json.Marshal(fileEntry{Key: key, Record: record})
+	if err != nil {
+		return fmt.Errorf("failed to encode idempotency record: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write idempotency record: %w", err)
+	}
+	if err := s.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync idempotency record: %w", err)
+	}
+	s.set(key, record)
+	s.lines++
+	s.evict(time.Now())
+
+	if s.lines > compactMinLines && s.lines > 2*len(s.index) {
+		if err := s.compact(); err != nil {
+			log.Warn().Err(err).Msgf("Failed to compact %s", s.path)
+		}
+	}
+	return nil
+}
+
+// Close closes the file
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
+
+// load reads the records of the file, the last line of a key taking precedence.
+// Unreadable lines, such as a line cut short by a crash, are skipped.
+func (s *FileStore) load() error {
+	file, err := os.Open(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", s.path, err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for number := 1; scanner.Scan(); number++ {
+		var entry fileEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			log.Warn().Err(err).Msgf("Skipping line %d of %s", number, s.path)
+			continue
+		}
+		s.set(entry.Key, entry.Record)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read %s: %w", s.path, err)
+	}
+	return nil
+}
+
+// set records the outcome of the key; an overwritten key keeps its place, since its record keeps the creation time
+func (s *FileStore) set(key string, record domain.IdempotencyRecord) {
+	if element, ok := s.index[key]; ok {
+		element.Value.(*memoryEntry).record = record
+		return
+	}
+	s.index[key] = s.entries.PushBack(&memoryEntry{key: key, record: record})
+}
+
+// evict drops the oldest records while they are expired or over the capacity
+func (s *FileStore) evict(now time.Time) {
+	for element := s.entries.Front(); element != nil; element = s.entries.Front() {
+		if !expired(element.Value.(*memoryEntry).record, s.ttl, now) && (s.capacity <= 0 || s.entries.Len() <= s.capacity) {
+			return
+		}
+		s.remove(element)
+	}
+}
+
+// remove drops the entry of the list and the index
+func (s *FileStore) remove(element *list.Element) {
+	s.entries.Remove(element)
+	delete(s.index, element.Value.(*memoryEntry).key)
+}
+
+// compact rewrites the file with the records that have not expired, from the oldest to the newest,
+// and reopens it for appending
+func (s *FileStore) compact() error {
+	now := time.Now()
+	s.evict(now)
+	tmpPath := s.path + ".tmp"
+	tmp, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", tmpPath, err)
+	}
+	writer := bufio.NewWriter(tmp)
+	for element := s.entries.Front(); element != nil; {
+		entry := element.Value.(*memoryEntry)
+		next := element.Next()
+		if expired(entry.record, s.ttl, now) {
+			s.remove(element)
+			element = next
+			continue
+		}
+		element = next
+		line, err := json.Marshal(fileEntry{Key: entry.key, Record: entry.record})
+		if err != nil {
+			tmp.Close()
+			return fmt.Errorf("failed to encode idempotency record: %w", err)
+		}
+		writer.Write(append(line, '\n'))
+	}
+	if err := writer.Flush(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write %s: %w", tmpPath, err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync %s: %w", tmpPath, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close %s: %w", tmpPath, err)
+	}
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", s.path, err)
+	}
+
+	file, err := os.OpenFile(s.path, os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", s.path, err)
+	}
+	if s.file != nil {
+		s.file.Close()
+	}
+	s.file = file
+	s.lines = len(s.index)
+	return nil
+}
@@ -0,0 +1,92 @@
+package idempotency_test
+
+import (
+	"anyway/internal/domain"
+	"anyway/internal/infrastructure/idempotency"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestFileStore tests that the records survive reopening the store
+func TestFileStore(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "idempotency.jsonl")
+
+	store, err := idempotency.NewFileStore(path, 0, time.Hour)
+	assert.NoError(t, err)
+	receipt := domain.Receipt{Destinations: []domain.Destination{{Name: "kafka", Delivered: true}}}
+	assert.NoError(t, store.Put(ctx, "key-1", domain.IdempotencyRecord{Fingerprint: "first", Receipt: receipt, CreatedAt: time.Now()}))
+	assert.NoError(t, store.Put(ctx, "key-1", domain.IdempotencyRecord{Fingerprint: "second", Receipt: receipt, CreatedAt: time.Now()}))
+	assert.NoError(t, store.Put(ctx, "key-2", record("expired", time.Now().Add(-2*time.Hour))))
+	assert.NoError(t, store.Close())
+
+	// A line cut short by a crash is skipped
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o644)
+	assert.NoError(t, err)
+	_, _ = file.WriteString(`{"key":"key-3","rec`)
+	file.Close()
+
+	store, err = idempotency.NewFileStore(path, 0, time.Hour)
+	assert.NoError(t, err)
+	defer store.Close()
+
+	got, found, err := store.Get(ctx, "key-1")
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "second", got.Fingerprint)
+	assert.Equal(t, receipt, got.Receipt)
+
+	_, found, _ = store.Get(ctx, "key-2")
+	assert.False(t, found)
+	_, found, _ = store.Get(ctx, "key-3")
+	assert.False(t, found)
+
+	// Reopening compacts the file to the records that have not expired
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, strings.Count(string(data), "\n"))
+}
+
+// TestFileStoreInvalidPath tests that a file that cannot be created is reported
+func TestFileStoreInvalidPath(t *testing.T) {
+	_, err := idempotency.NewFileStore(filepath.Join(t.TempDir(), "missing", "idempotency.jsonl"), 0, time.Hour)
+	assert.Error(t, err)
+}
+
+// TestFileStoreEviction tests that Put evicts the expired records and the oldest ones over the capacity,
+// and that the file is compacted once they make up most of it
+func TestFileStoreEviction(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "idempotency.jsonl")
+
+	// Create a store of up to 2 keys and put an expired record and three others
+	store, err := idempotency.NewFileStore(path, 2, time.Hour)
+	assert.NoError(t, err)
+	defer store.Close()
+	assert.NoError(t, store.Put(ctx, "expired", record("expired", time.Now().Add(-2*time.Hour))))
+	for _, key := range []string{"key-1", "key-2", "key-3"} {
+		assert.NoError(t, store.Put(ctx, key, record(key, time.Now())))
+	}
+
+	// Assert that only the two newest keys remain
+	for key, want := range map[string]bool{"expired": false, "key-1": false, "key-2": true, "key-3": true} {
+		_, found, err := store.Get(ctx, key)
+		assert.NoError(t, err)
+		assert.Equal(t, want, found, key)
+	}
+
+	// Put many unique keys and assert that the file stays bounded
+	for i := 0; i < 3000; i++ {
+		assert.NoError(t, store.Put(ctx, fmt.Sprintf("unique-%d", i), record("unique", time.Now())))
+	}
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.LessOrEqual(t, strings.Count(string(data), "\n"), 1024+1)
+}
@@ -0,0 +1,89 @@
+package idempotency
+
+import (
+	"anyway/internal/domain"
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// memoryEntry is a key of the LRU list with its record
+type memoryEntry struct {
+	key    string
+	record domain.IdempotencyRecord
+}
+
+// MemoryStore keeps the records in memory for ttl after they are created.
+// Once it holds capacity records, the least recently used one is evicted.
+type MemoryStore struct {
+	capacity int
+	ttl      time.Duration
+
+	mu      sync.Mutex
+	entries *list.List
+	index   map[string]*list.Element
+}
+
+// NewMemoryStore creates a store of up to capacity records kept for ttl; a zero capacity or ttl is unlimited
+func NewMemoryStore(capacity int, ttl time.Duration) *MemoryStore {
+	return &MemoryStore{
+		capacity: capacity,
+		ttl:      ttl,
+		entries:  list.New(),
+		index:    map[string]*list.Element{},
+	}
+}
+
+// Get returns the record of the key, or false when the key is unknown or expired
+func (s *MemoryStore) Get(_ context.Context, key string) (domain.IdempotencyRecord, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	element, ok := s.index[key]
+	if !ok {
+		return domain.IdempotencyRecord{}, false, nil
+	}
+	entry := element.Value.(*memoryEntry)
+	if expired(entry.record, s.ttl, time.Now()) {
+		s.remove(element)
+		return domain.IdempotencyRecord{}, false, nil
+	}
+	s.entries.MoveToFront(element)
+	return entry.record, true, nil
+}
+
+// Put records the outcome of the key, evicting the least recently used records over the capacity
+func (s *MemoryStore) Put(_ context.Context, key string, record domain.IdempotencyRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if element, ok := s.index[key]; ok {
+		element.Value.(*memoryEntry).record = record
+		s.entries.MoveToFront(element)
+		return nil
+	}
+	s.index[key] = s.entries.PushFront(&memoryEntry{key: key, record: record})
+	for s.capacity > 0 && s.entries.Len() > s.capacity {
+		s.remove(s.entries.Back())
+	}
+	return nil
+}
+
+// Len returns the number of records held, including the expired ones not evicted yet
+func (s *MemoryStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.entries.Len()
+}
+
+// remove drops the entry of the list and the index
+func (s *MemoryStore) remove(element *list.Element) {
+	s.entries.Remove(element)
+	delete(s.index, element.Value.(*memoryEntry).key)
+}
+
+// expired tells whether the record is older than the ttl
+func expired(record domain.IdempotencyRecord, ttl time.Duration, now time.Time) bool {
+	return ttl > 0 && now.Sub(record.CreatedAt) > ttl
+}
@@ -0,0 +1,61 @@
+package idempotency_test
+
+import (
+	"anyway/internal/domain"
+	"anyway/internal/infrastructure/idempotency"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// record returns a record of the fingerprint created at the given time
+func record(fingerprint string, createdAt time.Time) domain.IdempotencyRecord {
+	return domain.IdempotencyRecord{Fingerprint: fingerprint, CreatedAt: createdAt}
+}
+
+// TestMemoryStore tests that the records are returned until they expire
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	store := idempotency.NewMemoryStore(10, time.Hour)
+
+	_, found, err := store.Get(ctx, "key-1")
+	assert.NoError(t, err)
+	assert.False(t, found)
+
+	assert.NoError(t, store.Put(ctx, "key-1", record("first", time.Now())))
+	assert.NoError(t, store.Put(ctx, "key-2", record("second", time.Now().Add(-2*time.Hour))))
+
+	got, found, err := store.Get(ctx, "key-1")
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "first", got.Fingerprint)
+
+	// Expired records are not returned and are evicted
+	_, found, _ = store.Get(ctx, "key-2")
+	assert.False(t, found)
+	assert.Equal(t, 1, store.Len())
+}
+
+// TestMemoryStoreEviction tests that the least recently used records are evicted over the capacity
+func TestMemoryStoreEviction(t *testing.T) {
+	ctx := context.Background()
+	store := idempotency.NewMemoryStore(2, 0)
+
+	assert.NoError(t, store.Put(ctx, "key-1", record("first", time.Now())))
+	assert.NoError(t, store.Put(ctx, "key-2", record("second", time.Now())))
+
+	// Using key-1 leaves key-2 as the least recently used record
+	_, found, _ := store.Get(ctx, "key-1")
+	assert.True(t, found)
+	assert.NoError(t, store.Put(ctx, "key-3", record("third", time.Now())))
+
+	_, found, _ = store.Get(ctx, "key-2")
+	assert.False(t, found)
+	_, found, _ = store.Get(ctx, "key-1")
+	assert.True(t, found)
+	_, found, _ = store.Get(ctx, "key-3")
+	assert.True(t, found)
+	assert.Equal(t, 2, store.Len())
+}
@@ -0,0 +1,28 @@
+package idempotency
+
+import (
+	"anyway/config"
+	"anyway/internal/domain"
+	"fmt"
+)
+
+const (
+	// StoreMemory keeps the idempotency keys in memory
+	StoreMemory = "memory"
+	// StoreFile keeps the idempotency keys in a file
+	StoreFile = "file"
+	// StoreNone disables the idempotency keys
+	StoreNone = "none"
+)
+
+// NewStore creates the idempotency store of the configuration
+func NewStore(cfg config.Config) (domain.IdempotencyStore, error) {
+	switch cfg.IdempotencyStore {
+	case StoreMemory:
+		return NewMemoryStore(cfg.IdempotencyMaxKeys, cfg.IdempotencyTTL), nil
+	case StoreFile:
+		return NewFileStore(cfg.IdempotencyFile, cfg.IdempotencyMaxKeys, cfg.IdempotencyTTL)
+	default:
+		return nil, fmt.Errorf("unknown idempotency store %q", cfg.IdempotencyStore)
+	}
+}
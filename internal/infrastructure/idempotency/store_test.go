@@ -0,0 +1,26 @@
+package idempotency_test
+
+import (
+	"anyway/config"
+	"anyway/internal/infrastructure/idempotency"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestNewStore tests that the configured store is created
+func TestNewStore(t *testing.T) {
+	store, err := idempotency.NewStore(config.Config{IdempotencyStore: idempotency.StoreMemory, IdempotencyMaxKeys: 10, IdempotencyTTL: time.Hour})
+	assert.NoError(t, err)
+	assert.IsType(t, &idempotency.MemoryStore{}, store)
+
+	store, err = idempotency.NewStore(config.Config{IdempotencyStore: idempotency.StoreFile, IdempotencyFile: filepath.Join(t.TempDir(), "idempotency.jsonl")})
+	assert.NoError(t, err)
+	assert.IsType(t, &idempotency.FileStore{}, store)
+	store.(*idempotency.FileStore).Close()
+
+	_, err = idempotency.NewStore(config.Config{IdempotencyStore: "redis"})
+	assert.Error(t, err)
+}
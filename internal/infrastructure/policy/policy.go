@@ -0,0 +1,249 @@
+package policy
+
+import (
+	"anyway/internal/domain"
+	"anyway/internal/metrics"
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"path"
+	"sync"
+
+	"golang.org/x/time/rate"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// DefaultAllow lets callers send to the topics no rule applies to
+	DefaultAllow = "allow"
+	// DefaultDeny rejects the messages to the topics no rule applies to
+	DefaultDeny = "deny"
+)
+
+// defaultRule is the rule reported when no rule allows the topic
+const defaultRule = "default"
+
+// anonymous names the caller of the requests without credentials
+const anonymous = "anonymous"
+
+// Rule allows the callers it selects to send to some topics, with optional limits.
+// Clients, Topics and Keys are path.Match patterns (e.g. "events.*").
+type Rule struct {
+	// Name identifies the rule in the errors and metrics
+	Name string `yaml:"name"`
+	// Clients are the callers the rule applies to; empty selects every caller
+	Clients []string `yaml:"clients"`
+	// Claims are JWT claims the caller must have, by claim name; list claims must contain the value
+	Claims map[string]string `yaml:"claims"`
+	// Topics are the topics the rule allows
+	Topics []string `yaml:"topics"`
+	// Keys are the message keys allowed; empty allows any key
+	Keys []string `yaml:"keys"`
+	// MaxMessageBytes is the maximum size of the content; zero means no limit
+	MaxMessageBytes int `yaml:"max_message_bytes"`
+	// Rate is the number of messages per second each caller may send; zero means no limit
+	Rate float64 `yaml:"rate"`
+	// Burst is the number of messages a caller may send at once; by default the rate rounded up
+	Burst int `yaml:"burst"`
+}
+
+// document is the content of a policy file
+type document struct {
+	Default string `yaml:"default"`
+	Rules   []Rule `yaml:"rules"`
+}
+
+// limiterKey identifies the rate limiter of a caller under a rule
+type limiterKey struct {
+	rule   string
+	caller string
+}
+
+// Engine authorises the messages with the first rule that selects the caller and allows the topic
+type Engine struct {
+	deny  bool
+	rules []Rule
+
+	mu       sync.Mutex
+	limiters map[limiterKey]*rate.Limiter
+}
+
+// Load reads the policy of a YAML file such as
+//
+//	default: deny
+//	rules:
+//	  - name: billing-invoices
+//	    clients: [billing]
+//	    topics: [invoices, "invoices.*"]
+//	    keys: ["invoice-*"]
+//	    max_message_bytes: 65536
+//	    rate: 100
+func Load(filePath string) (*Engine, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	decoder := yaml.NewDecoder(file)
+	decoder.KnownFields(true)
+	var doc document
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", filePath, err)
+	}
+	return NewEngine(doc.Default, doc.Rules)
+}
+
+// NewEngine checks the rules and creates the engine; defaultAction is DefaultAllow or DefaultDeny (the default)
+func NewEngine(defaultAction string, rules []Rule) (*Engine, error) {
+	switch defaultAction {
+	case "", DefaultDeny, DefaultAllow:
+	default:
+		return nil, fmt.Errorf("unknown default %q, expected %s or %s", defaultAction, DefaultAllow, DefaultDeny)
+	}
+	names := map[string]bool{}
+	for i := range rules {
+		rule := &rules[i]
+		if rule.Name == "" {
+			return nil, fmt.Errorf("rule %d has no name", i)
+		}
+		if names[rule.Name] {
+			return nil, fmt.Errorf("rule %s is defined twice", rule.Name)
+		}
+		names[rule.Name] = true
+		if len(rule.Topics) == 0 {
+			return nil, fmt.Errorf("rule %s allows no topics", rule.Name)
+		}
+		for _, patterns := range [][]string{rule.Clients, rule.Topics, rule.Keys} {
+			for _, pattern := range patterns {
+				if _, err := path.Match(pattern, ""); err != nil {
+					return nil, fmt.Errorf("rule %s: invalid pattern %q", rule.Name, pattern)
+				}
+			}
+		}
+		if rule.Rate < 0 || rule.Burst < 0 || rule.MaxMessageBytes < 0 {
+			return nil, fmt.Errorf("rule %s has negative limits", rule.Name)
+		}
+		if rule.Rate > 0 && rule.Burst == 0 {
+			rule.Burst = int(math.Ceil(rule.Rate))
+		}
+	}
+	return &Engine{
+		deny:     defaultAction != DefaultAllow,
+		rules:    rules,
+		limiters: map[limiterKey]*rate.Limiter{},
+	}, nil
+}
+
+// Authorize checks the message against the first rule selecting the caller and allowing its topic.
+// Without such a rule the message is rejected by the default rule, unless the default is allow.
+func (e *Engine) Authorize(ctx context.Context, message domain.Message) error {
+	metadata := domain.MetadataFromContext(ctx)
+	caller := metadata.Caller
+	if caller == "" {
+		caller = anonymous
+	}
+	for i := range e.rules {
+		rule := &e.rules[i]
+		if !rule.selects(metadata) || !matchAny(rule.Topics, message.Topic) {
+			continue
+		}
+		err := e.check(rule, caller, metadata, message)
+		if err != nil {
+			metrics.PolicyViolations.WithLabelValues(rule.Name).Inc()
+		}
+		return err
+	}
+	if !e.deny {
+		return nil
+	}
+	metrics.PolicyViolations.WithLabelValues(defaultRule).Inc()
+	return &domain.PolicyError{
+		Rule:   defaultRule,
+		Reason: fmt.Sprintf("%s may not send to topic %s", caller, message.Topic),
+	}
+}
+
+// check applies the limits of the rule to the message
+func (e *Engine) check(rule *Rule, caller string, metadata domain.Metadata, message domain.Message) error {
+	key := message.Key
+	if key == "" {
+		key = metadata.RoutingID
+	}
+	if len(rule.Keys) > 0 && !matchAny(rule.Keys, key) {
+		return &domain.PolicyError{Rule: rule.Name, Reason: fmt.Sprintf("key %q is not allowed", key)}
+	}
+	if rule.MaxMessageBytes > 0 && len(message.Content) > rule.MaxMessageBytes {
+		return &domain.PolicyError{
+			Rule:   rule.Name,
+			Reason: fmt.Sprintf("message of %d bytes exceeds the maximum of %d", len(message.Content), rule.MaxMessageBytes),
+		}
+	}
+	if rule.Rate > 0 {
+		reservation := e.limiter(rule, caller).Reserve()
+		if delay := reservation.Delay(); delay > 0 {
+			reservation.Cancel()
+			return &domain.PolicyError{
+				Rule:       rule.Name,
+				Reason:     fmt.Sprintf("%s exceeds %g messages per second", caller, rule.Rate),
+				RetryAfter: delay,
+			}
+		}
+	}
+	return nil
+}
+
+// limiter returns the rate limiter of the caller under the rule
+func (e *Engine) limiter(rule *Rule, caller string) *rate.Limiter {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	key := limiterKey{rule: rule.Name, caller: caller}
+	limiter, ok := e.limiters[key]
+	if !ok {
+		limiter = rate.NewLimiter(rate.Limit(rule.Rate), rule.Burst)
+		e.limiters[key] = limiter
+	}
+	return limiter
+}
+
+// selects tells whether the rule applies to the caller of the metadata
+func (r *Rule) selects(metadata domain.Metadata) bool {
+	if len(r.Clients) > 0 && !matchAny(r.Clients, metadata.Caller) {
+		return false
+	}
+	for name, expected := range r.Claims {
+		if !claimMatches(metadata.Claims[name], expected) {
+			return false
+		}
+	}
+	return true
+}
+
+// claimMatches tells whether the claim value, or any value of a list claim, matches the pattern
+func claimMatches(value interface{}, pattern string) bool {
+	switch value := value.(type) {
+	case nil:
+		return false
+	case []interface{}:
+		for _, item := range value {
+			if claimMatches(item, pattern) {
+				return true
+			}
+		}
+		return false
+	default:
+		matched, _ := path.Match(pattern, fmt.Sprint(value))
+		return matched
+	}
+}
+
+// matchAny tells whether the value matches any of the path.Match patterns
+func matchAny(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, value); matched {
+			return true
+		}
+	}
+	return false
+}
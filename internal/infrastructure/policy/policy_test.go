@@ -0,0 +1,174 @@
+package policy_test
+
+import (
+	"anyway/internal/domain"
+	"anyway/internal/infrastructure/policy"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const policyFile = `
+default: deny
+rules:
+  - name: billing-invoices
+    clients: [billing]
+    topics: [invoices, "invoices.*"]
+    keys: ["invoice-*"]
+    max_message_bytes: 16
+  - name: payments-team
+    claims:
+      groups: payments
+    topics: [payments]
+    rate: 1
+    burst: 2
+  - name: public-events
+    topics: ["events.*"]
+`
+
+// writePolicy writes the policy file and loads it
+func writePolicy(t *testing.T, content string) (*policy.Engine, error) {
+	path := filepath.Join(t.TempDir(), "policy.yaml")
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	return policy.Load(path)
+}
+
+// callerContext returns a context with the caller and claims in the request metadata
+func callerContext(caller string, claims map[string]interface{}) context.Context {
+	return domain.WithMetadata(context.Background(), domain.Metadata{Caller: caller, Claims: claims, RoutingID: "invoice-routed"})
+}
+
+// TestAuthorize tests that messages are checked against the first rule selecting the caller and allowing the topic
+func TestAuthorize(t *testing.T) {
+	engine, err := writePolicy(t, policyFile)
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name         string
+		ctx          context.Context
+		message      domain.Message
+		expectedRule string
+	}{
+		{
+			name:    "allowed topic and key",
+			ctx:     callerContext("billing", nil),
+			message: domain.Message{Topic: "invoices.eu", Key: "invoice-1", Content: []byte("{}")},
+		},
+		{
+			name:    "routing ID as key",
+			ctx:     callerContext("billing", nil),
+			message: domain.Message{Topic: "invoices", Content: []byte("{}")},
+		},
+		{
+			name:         "key not allowed",
+			ctx:          callerContext("billing", nil),
+			message:      domain.Message{Topic: "invoices", Key: "order-1", Content: []byte("{}")},
+			expectedRule: "billing-invoices",
+		},
+		{
+			name:         "message too large",
+			ctx:          callerContext("billing", nil),
+			message:      domain.Message{Topic: "invoices", Key: "invoice-1", Content: []byte("0123456789abcdefg")},
+			expectedRule: "billing-invoices",
+		},
+		{
+			name:         "topic of another client",
+			ctx:          callerContext("checkout", nil),
+			message:      domain.Message{Topic: "invoices", Key: "invoice-1", Content: []byte("{}")},
+			expectedRule: "default",
+		},
+		{
+			name:    "claim in a list claim",
+			ctx:     callerContext("user-1", map[string]interface{}{"groups": []interface{}{"admins", "payments"}}),
+			message: domain.Message{Topic: "payments", Content: []byte("{}")},
+		},
+		{
+			name:         "missing claim",
+			ctx:          callerContext("user-2", map[string]interface{}{"groups": "admins"}),
+			message:      domain.Message{Topic: "payments", Content: []byte("{}")},
+			expectedRule: "default",
+		},
+		{
+			name:    "rule for every caller",
+			ctx:     context.Background(),
+			message: domain.Message{Topic: "events.clicks", Content: []byte("{}")},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := engine.Authorize(tt.ctx, tt.message)
+			if tt.expectedRule == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, domain.ErrPolicyViolation)
+			var policyErr *domain.PolicyError
+			if assert.ErrorAs(t, err, &policyErr) {
+				assert.Equal(t, tt.expectedRule, policyErr.Rule)
+			}
+		})
+	}
+}
+
+// TestAuthorizeRate tests that each caller is limited to the rate of the rule
+func TestAuthorizeRate(t *testing.T) {
+	engine, err := writePolicy(t, policyFile)
+	assert.NoError(t, err)
+
+	payments := map[string]interface{}{"groups": "payments"}
+	message := domain.Message{Topic: "payments", Content: []byte("{}")}
+
+	// The burst is allowed, the next message is rate limited
+	assert.NoError(t, engine.Authorize(callerContext("user-1", payments), message))
+	assert.NoError(t, engine.Authorize(callerContext("user-1", payments), message))
+	err = engine.Authorize(callerContext("user-1", payments), message)
+	assert.ErrorIs(t, err, domain.ErrRateLimited)
+	var policyErr *domain.PolicyError
+	if assert.ErrorAs(t, err, &policyErr) {
+		assert.Equal(t, "payments-team", policyErr.Rule)
+		assert.Greater(t, policyErr.RetryAfter.Seconds(), 0.0)
+	}
+
+	// Other callers have their own limit
+	assert.NoError(t, engine.Authorize(callerContext("user-2", payments), message))
+}
+
+// TestAuthorizeDefaultAllow tests that the topics no rule applies to are allowed with default allow
+func TestAuthorizeDefaultAllow(t *testing.T) {
+	engine, err := policy.NewEngine(policy.DefaultAllow, []policy.Rule{
+		{Name: "only-billing", Clients: []string{"billing"}, Topics: []string{"invoices"}},
+	})
+	assert.NoError(t, err)
+
+	assert.NoError(t, engine.Authorize(callerContext("checkout", nil), domain.Message{Topic: "orders"}))
+}
+
+// TestLoadInvalidPolicy tests that invalid policies are reported
+func TestLoadInvalidPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{name: "unknown default", content: "default: maybe\n"},
+		{name: "unknown field", content: "rules:\n  - name: a\n    topic: [orders]\n"},
+		{name: "rule without name", content: "rules:\n  - topics: [orders]\n"},
+		{name: "duplicated rule", content: "rules:\n  - name: a\n    topics: [orders]\n  - name: a\n    topics: [events]\n"},
+		{name: "rule without topics", content: "rules:\n  - name: a\n"},
+		{name: "invalid pattern", content: "rules:\n  - name: a\n    topics: [\"[orders\"]\n"},
+		{name: "negative rate", content: "rules:\n  - name: a\n    topics: [orders]\n    rate: -1\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := writePolicy(t, tt.content)
+			assert.Error(t, err)
+		})
+	}
+
+	_, err := policy.Load(filepath.Join(t.TempDir(), "missing.yaml"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,182 @@
+package registry
+
+import (
+	"anyway/internal/domain"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/bufbuild/protocompile"
+	"github.com/linkedin/goavro/v2"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// magicByte starts every message in the Confluent wire format
+const magicByte = 0
+
+// contentTypeHeader is the message header describing the encoding of the content
+const contentTypeHeader = "content-type"
+
+// Content types of the encoded messages
+const (
+	avroContentType     = "application/vnd.apache.avro+binary"
+	protobufContentType = "application/x-protobuf"
+)
+
+// serializer converts JSON content to the binary encoding of a schema
+type serializer interface {
+	serialize(content []byte) ([]byte, error)
+	contentType() string
+}
+
+// Encoder serialises the JSON content of the messages with the schema registered for their topic,
+// in the Confluent wire format: the magic byte, the schema ID and the encoded content.
+// Messages of topics without a registered schema are sent unchanged.
+type Encoder struct {
+	registry Registry
+
+	mu          sync.Mutex
+	serializers map[int]serializer
+}
+
+// NewEncoder creates an encoder looking up the schemas in the registry
+func NewEncoder(registry Registry) *Encoder {
+	return &Encoder{
+		registry:    registry,
+		serializers: map[int]serializer{},
+	}
+}
+
+// Encode serialises the content with the latest schema of the subject of the topic
+func (e *Encoder) Encode(ctx context.Context, message domain.Message) (domain.Message, error) {
+	subject := Subject(message.Topic)
+	schema, err := e.registry.Latest(ctx, subject)
+	if errors.Is(err, ErrSubjectNotFound) {
+		return message, nil
+	}
+	if err != nil {
+		return message, fmt.Errorf("failed to look up the schema of %s: %w", subject, err)
+	}
+	serializer, err := e.serializer(schema)
+	if err != nil {
+		return message, err
+	}
+	payload, err := serializer.serialize(message.Content)
+	if err != nil {
+		return message, &domain.ValidationError{
+			Schema:     fmt.Sprintf("%s (id %d)", subject, schema.ID),
+			Violations: []string{err.Error()},
+		}
+	}
+
+	content := make([]byte, 5, 5+len(payload))
+	content[0] = magicByte
+	binary.BigEndian.PutUint32(content[1:], uint32(schema.ID))
+	message.Content = append(content, payload...)
+
+	headers := make(map[string]string, len(message.Headers)+1)
+	for k, v := range message.Headers {
+		headers[k] = v
+	}
+	headers[contentTypeHeader] = serializer.contentType()
+	message.Headers = headers
+	return message, nil
+}
+
+// serializer returns the serializer of the schema, compiling it the first time it is used
+func (e *Encoder) serializer(schema Schema) (serializer, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if s, ok := e.serializers[schema.ID]; ok {
+		return s, nil
+	}
+
+	var s serializer
+	var err error
+	switch schema.SchemaType {
+	case "", SchemaTypeAvro:
+		s, err = newAvroSerializer(schema.Schema)
+	case SchemaTypeProtobuf:
+		s, err = newProtobufSerializer(schema.Schema)
+	default:
+		err = fmt.Errorf("unsupported schema type %s", schema.SchemaType)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile schema %d of %s: %w", schema.ID, schema.Subject, err)
+	}
+	e.serializers[schema.ID] = s
+	return s, nil
+}
+
+// avroSerializer encodes JSON content with an Avro schema.
+// Union values are plain JSON values, without the {"type": value} wrapper of the Avro JSON encoding.
+type avroSerializer struct {
+	codec *goavro.Codec
+}
+
+// newAvroSerializer compiles the Avro schema
+func newAvroSerializer(schema string) (*avroSerializer, error) {
+	codec, err := goavro.NewCodecForStandardJSONFull(schema)
+	if err != nil {
+		return nil, err
+	}
+	return &avroSerializer{codec: codec}, nil
+}
+
+// serialize implements serializer
+func (s *avroSerializer) serialize(content []byte) ([]byte, error) {
+	native, _, err := s.codec.NativeFromTextual(content)
+	if err != nil {
+		return nil, err
+	}
+	return s.codec.BinaryFromNative(nil, native)
+}
+
+// contentType implements serializer
+func (s *avroSerializer) contentType() string {
+	return avroContentType
+}
+
+// protobufSerializer encodes JSON content, in the protobuf JSON mapping, as the first message of a Protobuf schema
+type protobufSerializer struct {
+	descriptor protoreflect.MessageDescriptor
+}
+
+// newProtobufSerializer compiles the Protobuf schema
+func newProtobufSerializer(schema string) (*protobufSerializer, error) {
+	const file = "schema.proto"
+	compiler := protocompile.Compiler{
+		Resolver: protocompile.WithStandardImports(&protocompile.SourceResolver{
+			Accessor: protocompile.SourceAccessorFromMap(map[string]string{file: schema}),
+		}),
+	}
+	files, err := compiler.Compile(context.Background(), file)
+	if err != nil {
+		return nil, err
+	}
+	messages := files[0].Messages()
+	if messages.Len() == 0 {
+		return nil, errors.New("the schema has no messages")
+	}
+	return &protobufSerializer{descriptor: messages.Get(0)}, nil
+}
+
+// serialize implements serializer.
+// The payload starts with the message indexes of the wire format; [0], the first message, is a single zero byte.
+func (s *protobufSerializer) serialize(content []byte) ([]byte, error) {
+	message := dynamicpb.NewMessage(s.descriptor)
+	if err := protojson.Unmarshal(content, message); err != nil {
+		return nil, err
+	}
+	return proto.MarshalOptions{Deterministic: true}.MarshalAppend([]byte{0}, message)
+}
+
+// contentType implements serializer
+func (s *protobufSerializer) contentType() string {
+	return protobufContentType
+}
@@ -0,0 +1,153 @@
+package registry_test
+
+import (
+	"anyway/internal/domain"
+	"anyway/internal/infrastructure/registry"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/linkedin/goavro/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+const orderSchema = `{
+	"type": "record",
+	"name": "Order",
+	"fields": [
+		{"name": "id", "type": "long"},
+		{"name": "note", "type": ["null", "string"], "default": null}
+	]
+}`
+
+const eventSchema = `syntax = "proto3";
+package events;
+
+message Event {
+	int64 id = 1;
+	string name = 2;
+}
+
+message Other {
+	bool flag = 1;
+}`
+
+// MockRegistry is a mock implementation of registry.Registry
+type MockRegistry struct {
+	mock.Mock
+}
+
+// Latest mocks the Latest method of Registry
+func (m *MockRegistry) Latest(ctx context.Context, subject string) (registry.Schema, error) {
+	args := m.Called(ctx, subject)
+	return args.Get(0).(registry.Schema), args.Error(1)
+}
+
+// TestEncodeAvro tests that JSON content is encoded with the Avro schema in the Confluent wire format
+func TestEncodeAvro(t *testing.T) {
+	// Create a mock registry with the schema of the topic, looked up for every message
+	mockRegistry := new(MockRegistry)
+	mockRegistry.On("Latest", mock.Anything, "orders-value").
+		Return(registry.Schema{Subject: "orders-value", ID: 258, Schema: orderSchema}, nil).Twice()
+
+	encoder := registry.NewEncoder(mockRegistry)
+
+	for _, content := range []string{`{"id": 42, "note": "gift"}`, `{"id": 43, "note": null}`} {
+		message, err := encoder.Encode(context.Background(), domain.Message{
+			Topic:   "orders",
+			Key:     "order-42",
+			Headers: map[string]string{"content-type": "application/json", "source": "my-app"},
+			Content: []byte(content),
+		})
+		assert.NoError(t, err)
+
+		// Assert the wire format prefix: the magic byte and the schema ID
+		assert.Equal(t, []byte{0, 0, 0, 1, 2}, message.Content[:5])
+		assert.Equal(t, "order-42", message.Key)
+		assert.Equal(t, map[string]string{"content-type": "application/vnd.apache.avro+binary", "source": "my-app"}, message.Headers)
+
+		// Assert the payload decodes back to the content
+		codec, err := goavro.NewCodecForStandardJSONFull(orderSchema)
+		assert.NoError(t, err)
+		native, _, err := codec.NativeFromBinary(message.Content[5:])
+		assert.NoError(t, err)
+		textual, err := codec.TextualFromNative(nil, native)
+		assert.NoError(t, err)
+		assert.JSONEq(t, content, string(textual))
+	}
+
+	// Assert that the expected methods were called on the mock
+	mockRegistry.AssertExpectations(t)
+}
+
+// TestEncodeProtobuf tests that JSON content is encoded as the first message of the Protobuf schema
+func TestEncodeProtobuf(t *testing.T) {
+	// Create a mock registry with the schema of the topic
+	mockRegistry := new(MockRegistry)
+	mockRegistry.On("Latest", mock.Anything, "events-value").
+		Return(registry.Schema{Subject: "events-value", ID: 3, SchemaType: registry.SchemaTypeProtobuf, Schema: eventSchema}, nil).Once()
+
+	encoder := registry.NewEncoder(mockRegistry)
+
+	message, err := encoder.Encode(context.Background(), domain.Message{
+		Topic:   "events",
+		Content: []byte(`{"id": "150", "name": "hi"}`),
+	})
+	assert.NoError(t, err)
+
+	// Assert the magic byte, the schema ID, the [0] message indexes and the protobuf payload:
+	// field 1 varint 150, field 2 string "hi"
+	expected := []byte{0, 0, 0, 0, 3, 0, 0x08, 0x96, 0x01, 0x12, 0x02, 'h', 'i'}
+	assert.Equal(t, expected, message.Content)
+	assert.Equal(t, "application/x-protobuf", message.Headers["content-type"])
+
+	// Assert that the expected methods were called on the mock
+	mockRegistry.AssertExpectations(t)
+}
+
+// TestEncodeErrors tests the messages that are sent unchanged or cannot be encoded
+func TestEncodeErrors(t *testing.T) {
+	registryErr := errors.New("connection refused")
+	tests := []struct {
+		name        string
+		schema      registry.Schema
+		registryErr error
+		content     string
+		unchanged   bool
+		invalid     bool
+	}{
+		{name: "subject not found", registryErr: registry.ErrSubjectNotFound, content: "plain text", unchanged: true},
+		{name: "registry error", registryErr: registryErr, content: `{"id": 1}`},
+		{name: "content does not match the Avro schema", schema: registry.Schema{ID: 1, Schema: orderSchema}, content: `{"id": "one"}`, invalid: true},
+		{name: "content does not match the Protobuf schema", schema: registry.Schema{ID: 2, SchemaType: registry.SchemaTypeProtobuf, Schema: eventSchema}, content: `{"unknown": 1}`, invalid: true},
+		{name: "invalid schema", schema: registry.Schema{ID: 3, Schema: `{"type": "unknown"}`}, content: `{"id": 1}`},
+		{name: "unsupported schema type", schema: registry.Schema{ID: 4, SchemaType: "JSON", Schema: `{}`}, content: `{"id": 1}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Create a mock registry returning the schema or the error
+			mockRegistry := new(MockRegistry)
+			mockRegistry.On("Latest", mock.Anything, "orders-value").Return(tt.schema, tt.registryErr).Once()
+
+			encoder := registry.NewEncoder(mockRegistry)
+			message := domain.Message{Topic: "orders", Content: []byte(tt.content)}
+
+			encoded, err := encoder.Encode(context.Background(), message)
+			switch {
+			case tt.unchanged:
+				assert.NoError(t, err)
+				assert.Equal(t, message, encoded)
+			case tt.invalid:
+				assert.ErrorIs(t, err, domain.ErrInvalidMessage)
+			default:
+				assert.Error(t, err)
+				assert.NotErrorIs(t, err, domain.ErrInvalidMessage)
+			}
+
+			// Assert that the expected methods were called on the mock
+			mockRegistry.AssertExpectations(t)
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// fileEntry is a schema of the registry file, given inline or as a path relative to the registry file
+type fileEntry struct {
+	Schema
+	File string `json:"file,omitempty"`
+}
+
+// FileRegistry is a registry backed by a local JSON file, to run and test the encoder offline.
+// The file is an array of schemas such as
+// [{"subject": "orders-value", "id": 1, "schemaType": "AVRO", "file": "orders.avsc"}].
+type FileRegistry struct {
+	schemas map[string]Schema
+}
+
+// NewFileRegistry loads the schemas of the registry file
+func NewFileRegistry(path string) (*FileRegistry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var entries []fileEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	schemas := make(map[string]Schema, len(entries))
+	for _, entry := range entries {
+		if entry.File != "" {
+			schema, err := os.ReadFile(filepath.Join(filepath.Dir(path), entry.File))
+			if err != nil {
+				return nil, fmt.Errorf("failed to read the schema of %s: %w", entry.Subject, err)
+			}
+			entry.Schema.Schema = string(schema)
+		}
+		schemas[entry.Subject] = entry.Schema
+	}
+	return &FileRegistry{schemas: schemas}, nil
+}
+
+// Latest returns the schema of the subject
+func (r *FileRegistry) Latest(_ context.Context, subject string) (Schema, error) {
+	schema, ok := r.schemas[subject]
+	if !ok {
+		return Schema{}, ErrSubjectNotFound
+	}
+	return schema, nil
+}
@@ -0,0 +1,52 @@
+package registry_test
+
+import (
+	"anyway/internal/infrastructure/registry"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestFileRegistry tests that the schemas are loaded inline or from the referenced files
+func TestFileRegistry(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "orders.avsc"), []byte(orderSchema), 0o644))
+	path := filepath.Join(dir, "registry.json")
+	assert.NoError(t, os.WriteFile(path, []byte(`[
+		{"subject": "orders-value", "id": 1, "file": "orders.avsc"},
+		{"subject": "events-value", "id": 2, "schemaType": "PROTOBUF", "schema": "syntax = \"proto3\";"}
+	]`), 0o644))
+
+	fileRegistry, err := registry.NewFileRegistry(path)
+	assert.NoError(t, err)
+
+	schema, err := fileRegistry.Latest(context.Background(), "orders-value")
+	assert.NoError(t, err)
+	assert.Equal(t, registry.Schema{Subject: "orders-value", ID: 1, Schema: orderSchema}, schema)
+
+	schema, err = fileRegistry.Latest(context.Background(), "events-value")
+	assert.NoError(t, err)
+	assert.Equal(t, registry.SchemaTypeProtobuf, schema.SchemaType)
+
+	_, err = fileRegistry.Latest(context.Background(), "payments-value")
+	assert.ErrorIs(t, err, registry.ErrSubjectNotFound)
+}
+
+// TestNewFileRegistryErrors tests that missing and malformed registry files are reported
+func TestNewFileRegistryErrors(t *testing.T) {
+	dir := t.TempDir()
+	_, err := registry.NewFileRegistry(filepath.Join(dir, "missing.json"))
+	assert.Error(t, err)
+
+	path := filepath.Join(dir, "registry.json")
+	assert.NoError(t, os.WriteFile(path, []byte(`[{"subject": "orders-value", "id": 1, "file": "missing.avsc"}]`), 0o644))
+	_, err = registry.NewFileRegistry(path)
+	assert.Error(t, err)
+
+	assert.NoError(t, os.WriteFile(path, []byte(`{`), 0o644))
+	_, err = registry.NewFileRegistry(path)
+	assert.Error(t, err)
+}
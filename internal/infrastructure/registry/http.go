@@ -0,0 +1,97 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cachedSchema is the outcome of a lookup, kept until it expires
+type cachedSchema struct {
+	schema  Schema
+	err     error
+	expires time.Time
+}
+
+// HTTPRegistry looks up the schemas of a Confluent compatible schema registry.
+// Lookups, including the subjects that are not found, are cached for cacheTTL.
+type HTTPRegistry struct {
+	url      string
+	username string
+	password string
+	cacheTTL time.Duration
+	client   *http.Client
+
+	mu    sync.Mutex
+	cache map[string]cachedSchema
+}
+
+// NewHTTPRegistry creates a client of the schema registry at the given URL.
+// Basic authentication is used when username is set.
+func NewHTTPRegistry(registryURL, username, password string, cacheTTL time.Duration) *HTTPRegistry {
+	return &HTTPRegistry{
+		url:      strings.TrimSuffix(registryURL, "/"),
+		username: username,
+		password: password,
+		cacheTTL: cacheTTL,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		cache:    map[string]cachedSchema{},
+	}
+}
+
+// Latest returns the latest schema of the subject from the cache or the registry
+func (r *HTTPRegistry) Latest(ctx context.Context, subject string) (Schema, error) {
+	r.mu.Lock()
+	cached, ok := r.cache[subject]
+	r.mu.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.schema, cached.err
+	}
+
+	schema, err := r.fetch(ctx, subject)
+	if err != nil && err != ErrSubjectNotFound {
+		return Schema{}, err
+	}
+	r.mu.Lock()
+	r.cache[subject] = cachedSchema{schema: schema, err: err, expires: time.Now().Add(r.cacheTTL)}
+	r.mu.Unlock()
+	return schema, err
+}
+
+// fetch requests the latest version of the subject
+func (r *HTTPRegistry) fetch(ctx context.Context, subject string) (Schema, error) {
+	endpoint := fmt.Sprintf("%s/subjects/%s/versions/latest", r.url, url.PathEscape(subject))
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return Schema{}, err
+	}
+	request.Header.Set("Accept", "application/vnd.schemaregistry.v1+json")
+	if r.username != "" {
+		request.SetBasicAuth(r.username, r.password)
+	}
+
+	response, err := r.client.Do(request)
+	if err != nil {
+		return Schema{}, fmt.Errorf("failed to reach the schema registry: %w", err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusNotFound {
+		return Schema{}, ErrSubjectNotFound
+	}
+	if response.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(response.Body, 1024))
+		return Schema{}, fmt.Errorf("schema registry returned %d: %s", response.StatusCode, strings.TrimSpace(string(body)))
+	}
+	var schema Schema
+	if err := json.NewDecoder(response.Body).Decode(&schema); err != nil {
+		return Schema{}, fmt.Errorf("failed to decode the schema of %s: %w", subject, err)
+	}
+	return schema, nil
+}
@@ -0,0 +1,59 @@
+package registry_test
+
+import (
+	"anyway/internal/infrastructure/registry"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestHTTPRegistry tests that the latest schema is looked up with basic authentication and cached
+func TestHTTPRegistry(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		username, password, _ := r.BasicAuth()
+		assert.Equal(t, "user", username)
+		assert.Equal(t, "secret", password)
+		switch r.URL.Path {
+		case "/subjects/orders-value/versions/latest":
+			_, _ = w.Write([]byte(`{"subject": "orders-value", "version": 3, "id": 7, "schema": "\"string\""}`))
+		case "/subjects/broken-value/versions/latest":
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write([]byte(`{"error_code": 50001, "message": "store error"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"error_code": 40401, "message": "Subject not found."}`))
+		}
+	}))
+	defer server.Close()
+
+	httpRegistry := registry.NewHTTPRegistry(server.URL+"/", "user", "secret", time.Minute)
+
+	// The schema is fetched once and then served from the cache
+	for i := 0; i < 2; i++ {
+		schema, err := httpRegistry.Latest(context.Background(), "orders-value")
+		assert.NoError(t, err)
+		assert.Equal(t, registry.Schema{Subject: "orders-value", ID: 7, Schema: `"string"`}, schema)
+	}
+	assert.Equal(t, int32(1), requests.Load())
+
+	// Subjects not found are cached too
+	for i := 0; i < 2; i++ {
+		_, err := httpRegistry.Latest(context.Background(), "payments-value")
+		assert.ErrorIs(t, err, registry.ErrSubjectNotFound)
+	}
+	assert.Equal(t, int32(2), requests.Load())
+
+	// Registry errors are not cached
+	for i := 0; i < 2; i++ {
+		_, err := httpRegistry.Latest(context.Background(), "broken-value")
+		assert.ErrorContains(t, err, "schema registry returned 500")
+	}
+	assert.Equal(t, int32(4), requests.Load())
+}
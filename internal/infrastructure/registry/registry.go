@@ -0,0 +1,36 @@
+package registry
+
+import (
+	"context"
+	"errors"
+)
+
+const (
+	// SchemaTypeAvro is the type of the Avro schemas
+	SchemaTypeAvro = "AVRO"
+	// SchemaTypeProtobuf is the type of the Protobuf schemas
+	SchemaTypeProtobuf = "PROTOBUF"
+)
+
+// ErrSubjectNotFound is returned when the subject has no registered schema
+var ErrSubjectNotFound = errors.New("subject not found")
+
+// Schema is a schema registered under a subject.
+// An empty SchemaType means Avro, as in the Confluent Schema Registry.
+type Schema struct {
+	Subject    string `json:"subject"`
+	ID         int    `json:"id"`
+	SchemaType string `json:"schemaType,omitempty"`
+	Schema     string `json:"schema"`
+}
+
+// Registry looks up the schemas of a schema registry
+type Registry interface {
+	// Latest returns the latest schema registered under the subject, or ErrSubjectNotFound
+	Latest(ctx context.Context, subject string) (Schema, error)
+}
+
+// Subject returns the subject of the message values of a topic, following the TopicNameStrategy
+func Subject(topic string) string {
+	return topic + "-value"
+}
@@ -0,0 +1,128 @@
+package repository
+
+import (
+	"anyway/config"
+	"anyway/internal/domain"
+	"anyway/internal/infrastructure/spool"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// BackendKafka produces the messages to Kafka
+	BackendKafka = "kafka"
+	// BackendRedisStreams adds the messages to Redis streams
+	BackendRedisStreams = "redis-streams"
+	// BackendFile appends the messages to a file as JSON lines
+	BackendFile = "file"
+	// BackendStdout writes the messages to the standard output as JSON lines
+	BackendStdout = "stdout"
+)
+
+// NewBackend creates the producer repository of the given destination with its connection settings.
+// The Kafka backend produces with the producer of its brokers shared by kafkaProducers.
+func NewBackend(destination config.Destination, cfg config.Config, kafkaProducers *KafkaProducers) (domain.ProducerRepository, error) {
+	switch destination.Backend {
+	case BackendKafka:
+		return NewSharedKafkaRepository(cfg.KafkaTopic, destination.KafkaBrokers, kafkaProducers)
+	case BackendRedisStreams:
+		client := redis.NewClient(&redis.Options{
+			Addr:     destination.RedisAddress,
+			Password: destination.RedisPassword,
+			DB:       destination.RedisDatabase,
+		})
+		return NewRedisStreamsRepository(client, cfg.KafkaTopic, cfg.RedisStreamMaxLen), nil
+	case BackendFile:
+		return NewFileRepository(destination.FilePath, cfg.KafkaTopic)
+	case BackendStdout:
+		return NewStdoutRepository(cfg.KafkaTopic), nil
+	default:
+		return nil, fmt.Errorf("unknown producer backend %q", destination.Backend)
+	}
+}
+
+// NewProducer creates the producer repository of the configured destinations.
+// With more than one destination, every message is fanned out to all of them in the configured mode.
+// Every destination has its own circuit breaker, retries its failed messages on its own and,
+// with cfg.SpoolDir set, spools them on its own, so a fan-out does not produce them again to the rest.
+// The spooled messages that fail permanently are published to deadLetters, which may be nil.
+func NewProducer(cfg config.Config, kafkaProducers *KafkaProducers, deadLetters domain.DeadLetterQueue) (domain.ProducerRepository, error) {
+	if len(cfg.ProducerDestinations) == 1 {
+		destination := cfg.ProducerDestinations[0]
+		producerRepository, err := NewBackend(destination, cfg, kafkaProducers)
+		if err != nil {
+			return nil, err
+		}
+		return spooled(decorate(producerRepository, destination.Name, cfg), destination.Name, cfg.SpoolDir, cfg, deadLetters)
+	}
+	destinations := make([]FanoutDestination, 0, len(cfg.ProducerDestinations))
+	closeAll := func() {
+		for _, destination := range destinations {
+			destination.Repository.Close()
+		}
+	}
+	for _, destination := range cfg.ProducerDestinations {
+		producerRepository, err := NewBackend(destination, cfg, kafkaProducers)
+		if err != nil {
+			closeAll()
+			return nil, fmt.Errorf("failed to create %s destination: %w", destination.Name, err)
+		}
+		producerRepository, err = spooled(decorate(producerRepository, destination.Name, cfg), destination.Name, filepath.Join(cfg.SpoolDir, destination.Name), cfg, deadLetters)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		destinations = append(destinations, FanoutDestination{Name: destination.Name, Repository: producerRepository})
+	}
+	fanoutRepository, err := NewFanoutRepository(FanoutMode(cfg.ProducerFanoutMode), destinations)
+	if err != nil {
+		closeAll()
+		return nil, err
+	}
+	return fanoutRepository, nil
+}
+
+// spooled wraps the repository of the backend with a spool in dir when cfg.SpoolDir is set
+func spooled(producerRepository domain.ProducerRepository, backend, dir string, cfg config.Config, deadLetters domain.DeadLetterQueue) (domain.ProducerRepository, error) {
+	if cfg.SpoolDir == "" {
+		return producerRepository, nil
+	}
+	messageSpool, err := spool.Open(dir, cfg.SpoolMaxBytes, cfg.SpoolSegmentBytes)
+	if err != nil {
+		producerRepository.Close()
+		return nil, fmt.Errorf("failed to open %s spool: %w", backend, err)
+	}
+	return NewSpoolRepository(producerRepository, backend, messageSpool, cfg.SpoolRetryInterval, deadLetters), nil
+}
+
+// KafkaMessageTimeout bounds the delivery of a Kafka message by the time of a single attempt,
+// or of all the attempts when attempts are not bound
+func KafkaMessageTimeout(cfg config.Config) time.Duration {
+	if cfg.RetryAttemptTimeout > 0 {
+		return cfg.RetryAttemptTimeout
+	}
+	return cfg.RetryTimeout
+}
+
+// decorate wraps the repository of the backend with the circuit breaker and the retry policy of the configuration.
+// The retries go through the breaker, so they stop as soon as it opens. The retry policy wraps the backend
+// even with a single attempt, so the messages given up on are reported as undeliverable.
+func decorate(producerRepository domain.ProducerRepository, backend string, cfg config.Config) domain.ProducerRepository {
+	if cfg.BreakerFailureThreshold > 0 {
+		producerRepository = NewBreakerRepository(producerRepository, backend, BreakerPolicy{
+			FailureThreshold: cfg.BreakerFailureThreshold,
+			OpenTimeout:      cfg.BreakerOpenTimeout,
+			HalfOpenRequests: cfg.BreakerHalfOpenRequests,
+		})
+	}
+	return NewRetryRepository(producerRepository, backend, RetryPolicy{
+		MaxAttempts:    max(cfg.RetryMaxAttempts, 1),
+		InitialBackoff: cfg.RetryInitialBackoff,
+		MaxBackoff:     cfg.RetryMaxBackoff,
+		Jitter:         cfg.RetryJitter,
+		Timeout:        cfg.RetryTimeout,
+		AttemptTimeout: cfg.RetryAttemptTimeout,
+	})
+}
@@ -0,0 +1,133 @@
+package repository_test
+
+import (
+	"anyway/config"
+	"anyway/internal/domain"
+	"anyway/internal/infrastructure/repository"
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestNewBackend tests that the configured backend is created
+func TestNewBackend(t *testing.T) {
+	cfg := config.Config{KafkaTopic: "default-topic"}
+	filePath := filepath.Join(t.TempDir(), "messages.jsonl")
+
+	tests := []struct {
+		backend      string
+		expectedType interface{}
+	}{
+		{backend: repository.BackendFile, expectedType: &repository.WriterRepository{}},
+		{backend: repository.BackendStdout, expectedType: &repository.WriterRepository{}},
+		{backend: repository.BackendRedisStreams, expectedType: &repository.RedisStreamsRepository{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.backend, func(t *testing.T) {
+			destination := config.Destination{
+				Name:         tt.backend,
+				Backend:      tt.backend,
+				FilePath:     filePath,
+				RedisAddress: "localhost:6379",
+			}
+			producerRepository, err := repository.NewBackend(destination, cfg, nil)
+			assert.NoError(t, err)
+			assert.IsType(t, tt.expectedType, producerRepository)
+			producerRepository.Close()
+		})
+	}
+}
+
+// TestNewBackendUnknown tests that an unknown backend is rejected
+func TestNewBackendUnknown(t *testing.T) {
+	_, err := repository.NewBackend(config.Destination{Name: "carrier-pigeon", Backend: "carrier-pigeon"}, config.Config{}, nil)
+	assert.ErrorContains(t, err, "unknown producer backend")
+}
+
+// TestNewProducer tests that several backends are fanned out
+func TestNewProducer(t *testing.T) {
+	file := config.Destination{Name: repository.BackendFile, Backend: repository.BackendFile, FilePath: filepath.Join(t.TempDir(), "messages.jsonl")}
+	stdout := config.Destination{Name: repository.BackendStdout, Backend: repository.BackendStdout}
+	cfg := config.Config{
+		ProducerDestinations: []config.Destination{file, stdout},
+		ProducerFanoutMode:   string(repository.FanoutPrimary),
+	}
+
+	// Assert that a fan-out is created for several backends
+	producerRepository, err := repository.NewProducer(cfg, nil, nil)
+	assert.NoError(t, err)
+	assert.IsType(t, &repository.FanoutRepository{}, producerRepository)
+	producerRepository.Close()
+
+	// Assert that a single backend is not fanned out, and is wrapped by the retry policy even with a single attempt
+	cfg.ProducerDestinations = []config.Destination{stdout}
+	producerRepository, err = repository.NewProducer(cfg, nil, nil)
+	assert.NoError(t, err)
+	assert.IsType(t, &repository.RetryRepository{}, producerRepository)
+	assert.NotContains(t, producerRepository.HealthCheck(context.Background())[0].Details, "circuit_breaker")
+
+	// Assert that the backend has a circuit breaker when a failure threshold is configured
+	cfg.BreakerFailureThreshold = 5
+	producerRepository, err = repository.NewProducer(cfg, nil, nil)
+	assert.NoError(t, err)
+	assert.IsType(t, &repository.RetryRepository{}, producerRepository)
+	assert.Equal(t, repository.CircuitClosed, producerRepository.HealthCheck(context.Background())[0].Details["circuit_breaker"])
+
+	// Assert that an unknown backend or mode is rejected
+	cfg.ProducerDestinations = []config.Destination{stdout, {Name: "carrier-pigeon", Backend: "carrier-pigeon"}}
+	_, err = repository.NewProducer(cfg, nil, nil)
+	assert.ErrorContains(t, err, "unknown producer backend")
+	cfg.ProducerDestinations = []config.Destination{stdout, {Name: "shadow", Backend: repository.BackendStdout}}
+	cfg.ProducerFanoutMode = "some"
+	_, err = repository.NewProducer(cfg, nil, nil)
+	assert.ErrorContains(t, err, "unknown fan-out mode")
+
+	// Assert that two destinations with the same name are rejected, so they never share a spool
+	cfg.ProducerDestinations = []config.Destination{stdout, stdout}
+	cfg.ProducerFanoutMode = string(repository.FanoutAll)
+	_, err = repository.NewProducer(cfg, nil, nil)
+	assert.ErrorContains(t, err, "duplicate fan-out destination")
+}
+
+// TestNewProducerSpool tests that every backend is wrapped with its own spool
+func TestNewProducerSpool(t *testing.T) {
+	spoolDir := t.TempDir()
+	cfg := config.Config{
+		ProducerDestinations: []config.Destination{
+			{Name: repository.BackendFile, Backend: repository.BackendFile, FilePath: filepath.Join(t.TempDir(), "messages.jsonl")},
+			{Name: "shadow", Backend: repository.BackendStdout},
+		},
+		ProducerFanoutMode: string(repository.FanoutAll),
+		SpoolDir:           spoolDir,
+		SpoolMaxBytes:      1 << 20,
+		SpoolSegmentBytes:  1 << 16,
+		SpoolRetryInterval: time.Hour,
+	}
+
+	// Assert that a fan-out spools every destination in the directory of its name and reports all spools
+	producerRepository, err := repository.NewProducer(cfg, nil, nil)
+	assert.NoError(t, err)
+	assert.DirExists(t, filepath.Join(spoolDir, repository.BackendFile))
+	assert.DirExists(t, filepath.Join(spoolDir, "shadow"))
+	assert.Implements(t, (*domain.SpoolInspector)(nil), producerRepository)
+	assert.Equal(t, int64(2<<20), producerRepository.(domain.SpoolInspector).SpoolStats().MaxBytes)
+	var spools []string
+	for _, status := range producerRepository.HealthCheck(context.Background()) {
+		if status.Name == "spool" {
+			spools = append(spools, status.Details["backend"])
+		}
+	}
+	assert.Equal(t, []string{repository.BackendFile, "shadow"}, spools)
+	producerRepository.Close()
+
+	// Assert that a single backend is spooled in the spool directory itself
+	cfg.ProducerDestinations = []config.Destination{{Name: repository.BackendStdout, Backend: repository.BackendStdout}}
+	producerRepository, err = repository.NewProducer(cfg, nil, nil)
+	assert.NoError(t, err)
+	assert.IsType(t, &repository.SpoolRepository{}, producerRepository)
+	producerRepository.Close()
+}
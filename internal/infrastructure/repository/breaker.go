@@ -0,0 +1,247 @@
+package repository
+
+import (
+	"anyway/internal/domain"
+	"anyway/internal/metrics"
+	"context"
+	"github.com/rs/zerolog/log"
+	"sync"
+	"time"
+)
+
+// Circuit breaker states
+const (
+	// CircuitClosed lets every message through
+	CircuitClosed = "closed"
+	// CircuitOpen rejects every message without calling the backend
+	CircuitOpen = "open"
+	// CircuitHalfOpen lets a few probe messages through to find out whether the backend recovered
+	CircuitHalfOpen = "half-open"
+)
+
+// halfOpenRetryAfter is the wait suggested to the messages rejected while the probes are in flight
+const halfOpenRetryAfter = time.Second
+
+// circuitStateValues are the values of the states in the circuit breaker metric
+var circuitStateValues = map[string]float64{
+	CircuitClosed:   0,
+	CircuitHalfOpen: 1,
+	CircuitOpen:     2,
+}
+
+// BreakerPolicy configures when a circuit breaker opens and closes again
+type BreakerPolicy struct {
+	// FailureThreshold is the number of consecutive failures that opens the breaker
+	FailureThreshold int
+	// OpenTimeout is how long the breaker stays open before letting probes through
+	OpenTimeout time.Duration
+	// HalfOpenRequests is the number of probes let through while half-open; all of them must succeed to close the breaker
+	HalfOpenRequests int
+}
+
+// BreakerRepository wraps a ProducerRepository with a circuit breaker, so messages fail fast while the backend is down.
+// Only the retryable errors count as failures, including the attempts that run out of time while the backend
+// does not answer; rejected messages and cancelled requests do not.
+type BreakerRepository struct {
+	next   domain.ProducerRepository
+	name   string
+	policy BreakerPolicy
+
+	mu       sync.Mutex
+	state    string
+	failures int
+	openedAt time.Time
+	// probes are the messages let through while half-open, and successes the ones produced
+	probes    int
+	successes int
+	// generation changes on every transition, so the outcome of a message let through in a previous state is ignored
+	generation uint64
+}
+
+// NewBreakerRepository wraps next with a closed circuit breaker; name identifies the backend in logs, metrics and errors
+func NewBreakerRepository(next domain.ProducerRepository, name string, policy BreakerPolicy) *BreakerRepository {
+	if policy.HalfOpenRequests < 1 {
+		policy.HalfOpenRequests = 1
+	}
+	r := &BreakerRepository{
+		next:   next,
+		name:   name,
+		policy: policy,
+		state:  CircuitClosed,
+	}
+	metrics.CircuitBreakerState.WithLabelValues(name).Set(circuitStateValues[CircuitClosed])
+	return r
+}
+
+// Produce produces the message unless the breaker is open, in which case it returns a *domain.CircuitOpenError
+func (r *BreakerRepository) Produce(ctx context.Context, message domain.Message) (domain.Receipt, error) {
+	generation, probe, err := r.allow(time.Now())
+	if err != nil {
+		return domain.Receipt{}, err
+	}
+	receipt, err := r.next.Produce(ctx, message)
+	r.record(generation, probe, outcome(err == nil, failed(err)))
+	return receipt, err
+}
+
+// ProduceBatch produces the batch unless the breaker is open. The batch counts as a success when any
+// message is produced and as a failure when every message fails with a retryable error.
+func (r *BreakerRepository) ProduceBatch(ctx context.Context, messages []domain.Message) []error {
+	generation, probe, err := r.allow(time.Now())
+	if err != nil {
+		errs := make([]error, len(messages))
+		for i := range errs {
+			errs[i] = err
+		}
+		return errs
+	}
+	errs := r.next.ProduceBatch(ctx, messages)
+	produced, allFailed := false, len(errs) > 0
+	for _, err := range errs {
+		produced = produced || err == nil
+		allFailed = allFailed && failed(err)
+	}
+	r.record(generation, probe, outcome(produced, allFailed))
+	return errs
+}
+
+// HealthCheck reports the health of the wrapped repository along with the state of the breaker.
+// The backend is unhealthy while the breaker is open.
+func (r *BreakerRepository) HealthCheck(ctx context.Context) []domain.DependencyStatus {
+	state := r.State()
+	var statuses []domain.DependencyStatus
+	for _, status := range r.next.HealthCheck(ctx) {
+		details := map[string]string{}
+		for key, value := range status.Details {
+			details[key] = value
+		}
+		details["circuit_breaker"] = state
+		status.Details = details
+		if state == CircuitOpen {
+			status.Healthy = false
+			if status.Error == "" {
+				status.Error = domain.ErrCircuitOpen.Error()
+			}
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// Close closes the wrapped repository
+func (r *BreakerRepository) Close() {
+	r.next.Close()
+}
+
+// State returns the state of the breaker; an open breaker whose timeout ended is reported half-open
+func (r *BreakerRepository) State() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.state == CircuitOpen && time.Since(r.openedAt) >= r.policy.OpenTimeout {
+		return CircuitHalfOpen
+	}
+	return r.state
+}
+
+// messageOutcome is how the outcome of a message affects the breaker
+type messageOutcome int
+
+const (
+	outcomeNeutral messageOutcome = iota
+	outcomeSuccess
+	outcomeFailure
+)
+
+// failed tells whether the error of a message counts as a failure of the backend: a retryable error,
+// which includes an attempt that ran out of time, since a backend that is down does not answer.
+// A cancelled request is left out, since it was stopped by the caller.
+func failed(err error) bool {
+	return err != nil && IsRetryable(err)
+}
+
+// outcome classifies the outcome of a message
+func outcome(produced, failed bool) messageOutcome {
+	switch {
+	case produced:
+		return outcomeSuccess
+	case failed:
+		return outcomeFailure
+	default:
+		return outcomeNeutral
+	}
+}
+
+// allow tells whether a message may be sent, returning the generation it is sent in and whether it is a probe
+func (r *BreakerRepository) allow(now time.Time) (uint64, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.state == CircuitOpen {
+		if wait := r.openedAt.Add(r.policy.OpenTimeout).Sub(now); wait > 0 {
+			metrics.CircuitBreakerRejected.WithLabelValues(r.name).Inc()
+			return 0, false, &domain.CircuitOpenError{Backend: r.name, RetryAfter: wait}
+		}
+		r.transition(CircuitHalfOpen)
+	}
+	if r.state == CircuitHalfOpen {
+		if r.probes >= r.policy.HalfOpenRequests {
+			metrics.CircuitBreakerRejected.WithLabelValues(r.name).Inc()
+			return 0, false, &domain.CircuitOpenError{Backend: r.name, RetryAfter: halfOpenRetryAfter}
+		}
+		r.probes++
+		return r.generation, true, nil
+	}
+	return r.generation, false, nil
+}
+
+// record updates the breaker with the outcome of a message sent in the given generation
+func (r *BreakerRepository) record(generation uint64, probe bool, result messageOutcome) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if generation != r.generation {
+		return
+	}
+	switch {
+	case result == outcomeFailure && probe:
+		r.open()
+	case result == outcomeFailure:
+		r.failures++
+		if r.failures >= r.policy.FailureThreshold {
+			r.open()
+		}
+	case result == outcomeSuccess && probe:
+		r.successes++
+		if r.successes >= r.policy.HalfOpenRequests {
+			r.transition(CircuitClosed)
+		}
+	case result == outcomeSuccess:
+		r.failures = 0
+	case probe:
+		// The probe told nothing, so another message may probe the backend
+		r.probes--
+	}
+}
+
+// open opens the breaker from now on
+func (r *BreakerRepository) open() {
+	r.openedAt = time.Now()
+	r.transition(CircuitOpen)
+}
+
+// transition moves the breaker to the state, starting a new generation
+func (r *BreakerRepository) transition(state string) {
+	previous := r.state
+	r.state = state
+	r.generation++
+	r.failures = 0
+	r.probes = 0
+	r.successes = 0
+
+	metrics.CircuitBreakerState.WithLabelValues(r.name).Set(circuitStateValues[state])
+	event := log.Info()
+	if state == CircuitOpen {
+		event = log.Warn()
+	}
+	event.Str("backend", r.name).Msgf("Circuit breaker moved from %s to %s", previous, state)
+}
@@ -0,0 +1,201 @@
+package repository_test
+
+import (
+	"anyway/internal/domain"
+	"anyway/internal/infrastructure/repository"
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// TestBreakerOpens tests that the breaker opens after the consecutive failures and rejects messages without calling the backend
+func TestBreakerOpens(t *testing.T) {
+	message := domain.Message{Content: []byte("test-content")}
+	transient := errors.New("connection refused")
+
+	// Create a mock producer repository failing twice, once after a success
+	mockRepo := new(MockProducerRepository)
+	mockRepo.On("Produce", mock.Anything, message).Return(domain.Receipt{}, transient).Once()
+	mockRepo.On("Produce", mock.Anything, message).Return(domain.Receipt{}, nil).Once()
+	mockRepo.On("Produce", mock.Anything, message).Return(domain.Receipt{}, transient).Twice()
+
+	breaker := repository.NewBreakerRepository(mockRepo, "kafka", repository.BreakerPolicy{FailureThreshold: 2, OpenTimeout: time.Minute})
+
+	// A success resets the consecutive failures
+	for i := 0; i < 3; i++ {
+		_, _ = breaker.Produce(context.Background(), message)
+		assert.Equal(t, repository.CircuitClosed, breaker.State())
+	}
+	_, err := breaker.Produce(context.Background(), message)
+	assert.Equal(t, transient, err)
+	assert.Equal(t, repository.CircuitOpen, breaker.State())
+
+	// The open breaker rejects the message with the time left
+	_, err = breaker.Produce(context.Background(), message)
+	var circuitErr *domain.CircuitOpenError
+	assert.ErrorAs(t, err, &circuitErr)
+	assert.Equal(t, "kafka", circuitErr.Backend)
+	assert.InDelta(t, time.Minute, circuitErr.RetryAfter, float64(time.Second))
+
+	// Assert that the expected methods were called on the mock
+	mockRepo.AssertExpectations(t)
+}
+
+// TestBreakerOpensOnTimeouts tests that the attempts that run out of time open the breaker
+func TestBreakerOpensOnTimeouts(t *testing.T) {
+	message := domain.Message{Content: []byte("test-content")}
+
+	// Create a mock producer repository that does not answer before the attempt ends
+	mockRepo := new(MockProducerRepository)
+	mockRepo.On("Produce", mock.Anything, message).Return(domain.Receipt{}, fmt.Errorf("send: %w", context.DeadlineExceeded)).Once()
+
+	breaker := repository.NewBreakerRepository(mockRepo, "kafka", repository.BreakerPolicy{FailureThreshold: 1, OpenTimeout: time.Minute})
+	_, _ = breaker.Produce(context.Background(), message)
+	assert.Equal(t, repository.CircuitOpen, breaker.State())
+
+	// Assert that the expected methods were called on the mock
+	mockRepo.AssertExpectations(t)
+}
+
+// TestBreakerIgnoresPermanentErrors tests that rejected messages and cancelled requests do not open the breaker
+func TestBreakerIgnoresPermanentErrors(t *testing.T) {
+	message := domain.Message{Content: []byte("test-content")}
+
+	// Create a mock producer repository with errors a retry would not fix
+	mockRepo := new(MockProducerRepository)
+	mockRepo.On("Produce", mock.Anything, message).Return(domain.Receipt{}, domain.ErrInvalidMessage).Once()
+	mockRepo.On("Produce", mock.Anything, message).Return(domain.Receipt{}, context.Canceled).Once()
+
+	breaker := repository.NewBreakerRepository(mockRepo, "kafka", repository.BreakerPolicy{FailureThreshold: 1, OpenTimeout: time.Minute})
+	_, _ = breaker.Produce(context.Background(), message)
+	_, _ = breaker.Produce(context.Background(), message)
+	assert.Equal(t, repository.CircuitClosed, breaker.State())
+
+	// Assert that the expected methods were called on the mock
+	mockRepo.AssertExpectations(t)
+}
+
+// TestBreakerHalfOpen tests that the breaker probes the backend once the timeout ends, closing or opening again
+func TestBreakerHalfOpen(t *testing.T) {
+	tests := []struct {
+		name          string
+		probeErr      error
+		expectedState string
+	}{
+		{name: "probe succeeds", expectedState: repository.CircuitClosed},
+		{name: "probe fails", probeErr: errors.New("connection refused"), expectedState: repository.CircuitOpen},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			message := domain.Message{Content: []byte("test-content")}
+
+			// Create a mock producer repository that fails and then answers the probe
+			mockRepo := new(MockProducerRepository)
+			mockRepo.On("Produce", mock.Anything, message).Return(domain.Receipt{}, errors.New("connection refused")).Once()
+			mockRepo.On("Produce", mock.Anything, message).Return(domain.Receipt{}, tt.probeErr).Once()
+
+			breaker := repository.NewBreakerRepository(mockRepo, "kafka", repository.BreakerPolicy{FailureThreshold: 1, OpenTimeout: 20 * time.Millisecond})
+			_, _ = breaker.Produce(context.Background(), message)
+			assert.Equal(t, repository.CircuitOpen, breaker.State())
+
+			time.Sleep(30 * time.Millisecond)
+			assert.Equal(t, repository.CircuitHalfOpen, breaker.State())
+
+			_, err := breaker.Produce(context.Background(), message)
+			assert.Equal(t, tt.probeErr, err)
+			assert.Equal(t, tt.expectedState, breaker.State())
+
+			// Assert that the expected methods were called on the mock
+			mockRepo.AssertExpectations(t)
+		})
+	}
+}
+
+// TestBreakerHalfOpenProbes tests that only the configured probes are let through while half-open
+func TestBreakerHalfOpenProbes(t *testing.T) {
+	message := domain.Message{Content: []byte("test-content")}
+
+	// Create a mock producer repository that fails and then holds the probe
+	release := make(chan struct{})
+	probing := make(chan struct{})
+	mockRepo := new(MockProducerRepository)
+	mockRepo.On("Produce", mock.Anything, message).Return(domain.Receipt{}, errors.New("connection refused")).Once()
+	mockRepo.On("Produce", mock.Anything, message).Run(func(mock.Arguments) {
+		close(probing)
+		<-release
+	}).Return(domain.Receipt{}, nil).Once()
+
+	breaker := repository.NewBreakerRepository(mockRepo, "kafka", repository.BreakerPolicy{FailureThreshold: 1, OpenTimeout: time.Millisecond})
+	_, _ = breaker.Produce(context.Background(), message)
+	time.Sleep(5 * time.Millisecond)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, _ = breaker.Produce(context.Background(), message)
+	}()
+	<-probing
+
+	// The message sent while the probe is in flight is rejected
+	_, err := breaker.Produce(context.Background(), message)
+	assert.ErrorIs(t, err, domain.ErrCircuitOpen)
+
+	close(release)
+	<-done
+	assert.Equal(t, repository.CircuitClosed, breaker.State())
+
+	// Assert that the expected methods were called on the mock
+	mockRepo.AssertExpectations(t)
+}
+
+// TestBreakerProduceBatch tests that a batch failing with retryable errors opens the breaker and is rejected afterwards
+func TestBreakerProduceBatch(t *testing.T) {
+	messages := []domain.Message{{Content: []byte("first")}, {Content: []byte("second")}}
+	transient := errors.New("connection refused")
+
+	// Create a mock producer repository failing every message of the batch
+	mockRepo := new(MockProducerRepository)
+	mockRepo.On("ProduceBatch", mock.Anything, messages).Return([]error{transient, transient}).Once()
+
+	breaker := repository.NewBreakerRepository(mockRepo, "kafka", repository.BreakerPolicy{FailureThreshold: 1, OpenTimeout: time.Minute})
+	assert.Equal(t, []error{transient, transient}, breaker.ProduceBatch(context.Background(), messages))
+
+	errs := breaker.ProduceBatch(context.Background(), messages)
+	assert.Len(t, errs, 2)
+	for _, err := range errs {
+		assert.ErrorIs(t, err, domain.ErrCircuitOpen)
+	}
+
+	// Assert that the expected methods were called on the mock
+	mockRepo.AssertExpectations(t)
+}
+
+// TestBreakerHealthCheck tests that the state of the breaker is reported and an open breaker is unhealthy
+func TestBreakerHealthCheck(t *testing.T) {
+	message := domain.Message{Content: []byte("test-content")}
+
+	// Create a mock producer repository that is healthy but fails the message
+	mockRepo := new(MockProducerRepository)
+	mockRepo.On("HealthCheck", mock.Anything).Return([]domain.DependencyStatus{{Name: "kafka", Healthy: true, Details: map[string]string{"topic": "orders"}}})
+	mockRepo.On("Produce", mock.Anything, message).Return(domain.Receipt{}, errors.New("connection refused")).Once()
+
+	breaker := repository.NewBreakerRepository(mockRepo, "kafka", repository.BreakerPolicy{FailureThreshold: 1, OpenTimeout: time.Minute})
+	statuses := breaker.HealthCheck(context.Background())
+	assert.True(t, statuses[0].Healthy)
+	assert.Equal(t, map[string]string{"topic": "orders", "circuit_breaker": repository.CircuitClosed}, statuses[0].Details)
+
+	_, _ = breaker.Produce(context.Background(), message)
+	statuses = breaker.HealthCheck(context.Background())
+	assert.False(t, statuses[0].Healthy)
+	assert.Equal(t, domain.ErrCircuitOpen.Error(), statuses[0].Error)
+	assert.Equal(t, repository.CircuitOpen, statuses[0].Details["circuit_breaker"])
+
+	// Assert that the expected methods were called on the mock
+	mockRepo.AssertExpectations(t)
+}
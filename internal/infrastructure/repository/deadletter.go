@@ -0,0 +1,110 @@
+package repository
+
+import (
+	"anyway/config"
+	"anyway/internal/domain"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"io"
+	"os"
+	"sync"
+)
+
+// deadLetterReasonHeader is the header of the dead-letter messages with the reason they failed,
+// so consumers can tell them apart without decoding the envelope
+const deadLetterReasonHeader = "dead_letter_reason"
+
+// NewDeadLetterQueue creates the dead-letter queue of the configured backend: kafka or file.
+// The Kafka queue produces with the producer of KAFKA_BROKER shared by kafkaProducers, the one of the Kafka backend,
+// and has its own circuit breaker and retry policy, named dead-letter.
+func NewDeadLetterQueue(cfg config.Config, kafkaProducers *KafkaProducers) (domain.DeadLetterQueue, error) {
+	switch cfg.DeadLetterBackend {
+	case BackendKafka:
+		producerRepository, err := NewSharedKafkaRepository(cfg.DeadLetterTopic, cfg.KafkaBrokers, kafkaProducers)
+		if err != nil {
+			return nil, err
+		}
+		return NewProducerDeadLetterQueue(decorate(producerRepository, "dead-letter", cfg), cfg.DeadLetterTopic), nil
+	case BackendFile:
+		return NewFileDeadLetterQueue(cfg.DeadLetterFilePath)
+	default:
+		return nil, fmt.Errorf("unknown dead-letter backend %q", cfg.DeadLetterBackend)
+	}
+}
+
+// ProducerDeadLetterQueue implements the DeadLetterQueue interface producing every dead letter
+// to a topic with a ProducerRepository. The message content is the JSON envelope, keyed as the original message.
+type ProducerDeadLetterQueue struct {
+	producerRepository domain.ProducerRepository
+	topic              string
+}
+
+// NewProducerDeadLetterQueue creates a dead-letter queue producing to the topic with the repository, which it closes
+func NewProducerDeadLetterQueue(producerRepository domain.ProducerRepository, topic string) *ProducerDeadLetterQueue {
+	return &ProducerDeadLetterQueue{
+		producerRepository: producerRepository,
+		topic:              topic,
+	}
+}
+
+// Publish produces the envelope of the dead letter to the dead-letter topic
+func (q *ProducerDeadLetterQueue) Publish(ctx context.Context, deadLetter domain.DeadLetter) error {
+	envelope, err := json.Marshal(deadLetter)
+	if err != nil {
+		return fmt.Errorf("failed to encode dead letter: %w", err)
+	}
+	_, err = q.producerRepository.Produce(ctx, domain.Message{
+		Topic:   q.topic,
+		Key:     deadLetter.Key,
+		Headers: map[string]string{deadLetterReasonHeader: deadLetter.Reason},
+		Content: envelope,
+	})
+	return err
+}
+
+// Close closes the repository the dead letters are produced with
+func (q *ProducerDeadLetterQueue) Close() {
+	q.producerRepository.Close()
+}
+
+// FileDeadLetterQueue implements the DeadLetterQueue interface appending every envelope to a file as a JSON line
+type FileDeadLetterQueue struct {
+	mu     sync.Mutex
+	writer io.Writer
+	closer io.Closer
+}
+
+// NewFileDeadLetterQueue creates a dead-letter queue appending to the file at path
+func NewFileDeadLetterQueue(path string) (*FileDeadLetterQueue, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file %s: %w", path, err)
+	}
+	return &FileDeadLetterQueue{
+		writer: file,
+		closer: file,
+	}, nil
+}
+
+// Publish appends the envelope of the dead letter as a JSON line
+func (q *FileDeadLetterQueue) Publish(ctx context.Context, deadLetter domain.DeadLetter) error {
+	line, err := json.Marshal(deadLetter)
+	if err != nil {
+		return fmt.Errorf("failed to encode dead letter: %w", err)
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	_, err = q.writer.Write(append(line, '\n'))
+	return err
+}
+
+// Close closes the file the dead letters are appended to
+func (q *FileDeadLetterQueue) Close() {
+	if err := q.closer.Close(); err != nil {
+		log.Err(err).Msg("Failed to close dead-letter file")
+	}
+}
@@ -0,0 +1,108 @@
+package repository_test
+
+import (
+	"anyway/config"
+	"anyway/internal/domain"
+	"anyway/internal/infrastructure/repository"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// testDeadLetter returns a dead letter of an undeliverable message
+func testDeadLetter() domain.DeadLetter {
+	return domain.DeadLetter{
+		Topic:      "orders",
+		Key:        "order-1",
+		Headers:    map[string]string{"source": "my-app"},
+		Content:    []byte("test-content"),
+		Reason:     domain.DeadLetterUndeliverable,
+		Error:      "broker down",
+		Attempts:   3,
+		RequestID:  "request-1",
+		ReceivedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		FailedAt:   time.Date(2024, 1, 1, 0, 0, 1, 0, time.UTC),
+	}
+}
+
+// TestProducerDeadLetterQueue tests that the envelope is produced to the dead-letter topic with the original key
+func TestProducerDeadLetterQueue(t *testing.T) {
+	deadLetter := testDeadLetter()
+
+	// Create a mock producer repository capturing the dead-letter message
+	mockRepo := new(MockProducerRepository)
+	var produced domain.Message
+	mockRepo.On("Produce", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		produced = args.Get(1).(domain.Message)
+	}).Return(domain.Receipt{}, nil).Once()
+	mockRepo.On("Close").Return().Once()
+
+	queue := repository.NewProducerDeadLetterQueue(mockRepo, "dead-letters")
+	assert.NoError(t, queue.Publish(context.Background(), deadLetter))
+	queue.Close()
+
+	// Assert the message carries the envelope
+	assert.Equal(t, "dead-letters", produced.Topic)
+	assert.Equal(t, "order-1", produced.Key)
+	assert.Equal(t, map[string]string{"dead_letter_reason": domain.DeadLetterUndeliverable}, produced.Headers)
+	var envelope domain.DeadLetter
+	assert.NoError(t, json.Unmarshal(produced.Content, &envelope))
+	assert.Equal(t, deadLetter, envelope)
+
+	// Assert that the expected methods were called on the mock
+	mockRepo.AssertExpectations(t)
+}
+
+// TestProducerDeadLetterQueueError tests that a failure to produce the dead letter is returned
+func TestProducerDeadLetterQueueError(t *testing.T) {
+	// Create a mock producer repository that fails
+	mockRepo := new(MockProducerRepository)
+	mockRepo.On("Produce", mock.Anything, mock.Anything).Return(domain.Receipt{}, errors.New("broker down")).Once()
+
+	queue := repository.NewProducerDeadLetterQueue(mockRepo, "dead-letters")
+	assert.EqualError(t, queue.Publish(context.Background(), testDeadLetter()), "broker down")
+
+	// Assert that the expected methods were called on the mock
+	mockRepo.AssertExpectations(t)
+}
+
+// TestFileDeadLetterQueue tests that every envelope is appended to the file as a JSON line
+func TestFileDeadLetterQueue(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dead-letter.jsonl")
+	deadLetter := testDeadLetter()
+
+	// Create the queue from the configuration and publish two dead letters
+	queue, err := repository.NewDeadLetterQueue(config.Config{DeadLetterBackend: repository.BackendFile, DeadLetterFilePath: path}, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, queue.Publish(context.Background(), deadLetter))
+	assert.NoError(t, queue.Publish(context.Background(), deadLetter))
+	queue.Close()
+
+	// Assert the written lines
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	assert.Len(t, lines, 2)
+	var envelope map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(lines[0]), &envelope))
+	assert.Equal(t, "orders", envelope["topic"])
+	assert.Equal(t, "undeliverable", envelope["reason"])
+	assert.Equal(t, "broker down", envelope["error"])
+	assert.Equal(t, float64(3), envelope["attempts"])
+	assert.Equal(t, "2024-01-01T00:00:00Z", envelope["received_at"])
+	assert.Equal(t, "2024-01-01T00:00:01Z", envelope["failed_at"])
+}
+
+// TestNewDeadLetterQueueUnknown tests that an unknown dead-letter backend is rejected
+func TestNewDeadLetterQueueUnknown(t *testing.T) {
+	_, err := repository.NewDeadLetterQueue(config.Config{DeadLetterBackend: "carrier-pigeon"}, nil)
+	assert.ErrorContains(t, err, "unknown dead-letter backend")
+}
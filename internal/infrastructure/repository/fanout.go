@@ -0,0 +1,327 @@
+package repository
+
+import (
+	"anyway/internal/domain"
+	"anyway/internal/metrics"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"sync"
+	"time"
+)
+
+// FanoutMode selects when a fanned out message counts as produced
+type FanoutMode string
+
+const (
+	// FanoutAll requires every destination to produce the message
+	FanoutAll FanoutMode = "all"
+	// FanoutAny requires at least one destination to produce the message
+	FanoutAny FanoutMode = "any"
+	// FanoutPrimary requires the first destination to produce the message;
+	// the rest are shadows written on a best-effort basis
+	FanoutPrimary FanoutMode = "primary"
+)
+
+const (
+	// RolePrimary is the destination whose outcome decides the result in FanoutPrimary mode
+	RolePrimary = "primary"
+	// RoleShadow is a best-effort destination in FanoutPrimary mode
+	RoleShadow = "shadow"
+)
+
+// fanoutShadowTimeout bounds how long a shadow produces a message in FanoutPrimary mode,
+// since it is not bound to the request once the primary destination is done
+const fanoutShadowTimeout = 30 * time.Second
+
+// FanoutDestination is a named backend of a fan-out
+type FanoutDestination struct {
+	Name       string
+	Repository domain.ProducerRepository
+}
+
+// FanoutRepository forwards every message to several backends concurrently,
+// e.g. to dual-write during a broker migration.
+// In FanoutPrimary mode the shadows produce in the background, so they never delay the response.
+type FanoutRepository struct {
+	mode         FanoutMode
+	destinations []FanoutDestination
+	// shadows tracks the messages the shadows are still producing
+	shadows sync.WaitGroup
+}
+
+// NewFanoutRepository creates a repository that forwards every message to the given destinations.
+// In FanoutPrimary mode the first destination is the primary one.
+func NewFanoutRepository(mode FanoutMode, destinations []FanoutDestination) (*FanoutRepository, error) {
+	switch mode {
+	case FanoutAll, FanoutAny, FanoutPrimary:
+	default:
+		return nil, fmt.Errorf("unknown fan-out mode %q", mode)
+	}
+	if len(destinations) == 0 {
+		return nil, errors.New("fan-out requires at least one destination")
+	}
+	names := make(map[string]bool, len(destinations))
+	for _, destination := range destinations {
+		if names[destination.Name] {
+			return nil, fmt.Errorf("duplicate fan-out destination %q", destination.Name)
+		}
+		names[destination.Name] = true
+	}
+	return &FanoutRepository{
+		mode:         mode,
+		destinations: destinations,
+	}, nil
+}
+
+// Produce forwards the message to every destination and reports the outcome of each one in the receipt.
+// The attempts of the receipt are the most any destination needed.
+func (r *FanoutRepository) Produce(ctx context.Context, message domain.Message) (domain.Receipt, error) {
+	if r.mode == FanoutPrimary {
+		return r.producePrimary(ctx, message)
+	}
+	errs := make([]error, len(r.destinations))
+	receipts := make([]domain.Receipt, len(r.destinations))
+
+	var wg sync.WaitGroup
+	for i, destination := range r.destinations {
+		wg.Add(1)
+		go func(i int, destination FanoutDestination) {
+			defer wg.Done()
+			receipts[i], errs[i] = destination.Repository.Produce(ctx, message)
+		}(i, destination)
+	}
+	wg.Wait()
+
+	receipt, err := r.result(ctx, errs)
+	for i, destinationReceipt := range receipts {
+		receipt.Destinations[i].Attempts = destinationReceipt.Attempts
+		receipt.Attempts = max(receipt.Attempts, destinationReceipt.Attempts)
+	}
+	return receipt, err
+}
+
+// ProduceBatch forwards the batch to every destination and combines the outcome of each message
+func (r *FanoutRepository) ProduceBatch(ctx context.Context, messages []domain.Message) []error {
+	if r.mode == FanoutPrimary {
+		return r.produceBatchPrimary(ctx, messages)
+	}
+	destinationErrs := make([][]error, len(r.destinations))
+
+	var wg sync.WaitGroup
+	for i, destination := range r.destinations {
+		wg.Add(1)
+		go func(i int, destination FanoutDestination) {
+			defer wg.Done()
+			destinationErrs[i] = destination.Repository.ProduceBatch(ctx, messages)
+		}(i, destination)
+	}
+	wg.Wait()
+
+	errs := make([]error, len(messages))
+	for m := range messages {
+		messageErrs := make([]error, len(r.destinations))
+		for d := range r.destinations {
+			messageErrs[d] = destinationErrs[d][m]
+		}
+		_, errs[m] = r.result(ctx, messageErrs)
+	}
+	return errs
+}
+
+// HealthCheck reports the health of every destination.
+// Shadows are optional, and so are the unhealthy destinations in FanoutAny mode while another one is healthy.
+func (r *FanoutRepository) HealthCheck(ctx context.Context) []domain.DependencyStatus {
+	statuses := make([][]domain.DependencyStatus, len(r.destinations))
+	anyHealthy := false
+	for i, destination := range r.destinations {
+		statuses[i] = destination.Repository.HealthCheck(ctx)
+		if healthy(statuses[i]) {
+			anyHealthy = true
+		}
+	}
+	var result []domain.DependencyStatus
+	for i, destinationStatuses := range statuses {
+		shadow := r.mode == FanoutPrimary && i > 0
+		for _, status := range destinationStatuses {
+			if !status.Healthy && (shadow || r.mode == FanoutAny && anyHealthy) {
+				status.Optional = true
+			}
+			result = append(result, status)
+		}
+	}
+	return result
+}
+
+// SpoolStats returns the depth of the spools of the destinations added up
+func (r *FanoutRepository) SpoolStats() domain.SpoolStats {
+	var stats domain.SpoolStats
+	for _, destination := range r.destinations {
+		inspector, ok := destination.Repository.(domain.SpoolInspector)
+		if !ok {
+			continue
+		}
+		destinationStats := inspector.SpoolStats()
+		stats.Messages += destinationStats.Messages
+		stats.Bytes += destinationStats.Bytes
+		stats.Segments += destinationStats.Segments
+		stats.MaxBytes += destinationStats.MaxBytes
+	}
+	return stats
+}
+
+// Close waits until the shadows are done and closes every destination
+func (r *FanoutRepository) Close() {
+	r.shadows.Wait()
+	for _, destination := range r.destinations {
+		destination.Repository.Close()
+	}
+}
+
+// producePrimary produces the message to the primary destination, and to the shadows in the background
+// with their own timeout. The shadows still producing once the primary is done are reported as pending.
+func (r *FanoutRepository) producePrimary(ctx context.Context, message domain.Message) (domain.Receipt, error) {
+	type shadowResult struct {
+		index   int
+		receipt domain.Receipt
+		err     error
+	}
+	results := make(chan shadowResult, len(r.destinations)-1)
+	r.shadow(ctx, func(ctx context.Context, i int, destination FanoutDestination) {
+		receipt, err := destination.Repository.Produce(ctx, message)
+		r.record(ctx, i, err)
+		results <- shadowResult{index: i, receipt: receipt, err: err}
+	})
+
+	primaryReceipt, err := r.destinations[0].Repository.Produce(ctx, message)
+	r.record(ctx, 0, err)
+	receipt := domain.Receipt{
+		Destinations: make([]domain.Destination, len(r.destinations)),
+		Attempts:     primaryReceipt.Attempts,
+	}
+	receipt.Destinations[0] = r.destination(0, primaryReceipt, err)
+	for i := 1; i < len(r.destinations); i++ {
+		receipt.Destinations[i] = domain.Destination{Name: r.destinations[i].Name, Role: r.role(i), Pending: true}
+	}
+	for done := false; !done; {
+		select {
+		case result := <-results:
+			receipt.Destinations[result.index] = r.destination(result.index, result.receipt, result.err)
+		default:
+			done = true
+		}
+	}
+	if err != nil {
+		return receipt, fmt.Errorf("%s: %w", r.destinations[0].Name, err)
+	}
+	return receipt, nil
+}
+
+// produceBatchPrimary produces the batch to the primary destination, and to the shadows in the background
+func (r *FanoutRepository) produceBatchPrimary(ctx context.Context, messages []domain.Message) []error {
+	r.shadow(ctx, func(ctx context.Context, i int, destination FanoutDestination) {
+		for _, err := range destination.Repository.ProduceBatch(ctx, messages) {
+			r.record(ctx, i, err)
+		}
+	})
+
+	errs := r.destinations[0].Repository.ProduceBatch(ctx, messages)
+	for m, err := range errs {
+		r.record(ctx, 0, err)
+		if err != nil {
+			errs[m] = fmt.Errorf("%s: %w", r.destinations[0].Name, err)
+		}
+	}
+	return errs
+}
+
+// shadow runs produce for every shadow in the background, detached from the cancellation of the request
+// and bound by fanoutShadowTimeout
+func (r *FanoutRepository) shadow(ctx context.Context, produce func(ctx context.Context, i int, destination FanoutDestination)) {
+	for i := 1; i < len(r.destinations); i++ {
+		r.shadows.Add(1)
+		go func(i int, destination FanoutDestination) {
+			defer r.shadows.Done()
+			ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), fanoutShadowTimeout)
+			defer cancel()
+			produce(ctx, i, destination)
+		}(i, r.destinations[i])
+	}
+}
+
+// result builds the receipt from the error of every destination and decides the outcome by the mode
+func (r *FanoutRepository) result(ctx context.Context, errs []error) (domain.Receipt, error) {
+	receipt := domain.Receipt{
+		Destinations: make([]domain.Destination, len(r.destinations)),
+	}
+	var failed []error
+	delivered := 0
+	for i, destination := range r.destinations {
+		r.record(ctx, i, errs[i])
+		receipt.Destinations[i] = r.destination(i, domain.Receipt{}, errs[i])
+		if errs[i] != nil {
+			failed = append(failed, fmt.Errorf("%s: %w", destination.Name, errs[i]))
+		} else {
+			delivered++
+		}
+	}
+
+	switch r.mode {
+	case FanoutAny:
+		if delivered > 0 {
+			return receipt, nil
+		}
+	default:
+		if len(failed) == 0 {
+			return receipt, nil
+		}
+	}
+	return receipt, errors.Join(failed...)
+}
+
+// destination reports the outcome of the i-th destination
+func (r *FanoutRepository) destination(i int, receipt domain.Receipt, err error) domain.Destination {
+	result := domain.Destination{
+		Name:      r.destinations[i].Name,
+		Role:      r.role(i),
+		Delivered: err == nil,
+		Attempts:  receipt.Attempts,
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// record counts the outcome of the i-th destination and logs its failures
+func (r *FanoutRepository) record(ctx context.Context, i int, err error) {
+	name := r.destinations[i].Name
+	if err != nil {
+		metrics.FanoutDeliveries.WithLabelValues(name, "failed").Inc()
+		log.Ctx(ctx).Warn().Err(err).Msgf("Failed to produce message to %s", name)
+		return
+	}
+	metrics.FanoutDeliveries.WithLabelValues(name, "delivered").Inc()
+}
+
+// role returns the role of the i-th destination, only set in FanoutPrimary mode
+func (r *FanoutRepository) role(i int) string {
+	if r.mode != FanoutPrimary {
+		return ""
+	}
+	if i == 0 {
+		return RolePrimary
+	}
+	return RoleShadow
+}
+
+// healthy tells whether every status is healthy
+func healthy(statuses []domain.DependencyStatus) bool {
+	for _, status := range statuses {
+		if !status.Healthy {
+			return false
+		}
+	}
+	return true
+}
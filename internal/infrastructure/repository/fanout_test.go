@@ -0,0 +1,206 @@
+package repository_test
+
+import (
+	"anyway/internal/domain"
+	"anyway/internal/infrastructure/repository"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// newFanoutRepository creates a FanoutRepository over a "kafka" and a "redis-streams" mock repository
+func newFanoutRepository(t *testing.T, mode repository.FanoutMode, kafkaRepo, redisRepo *MockProducerRepository) *repository.FanoutRepository {
+	fanoutRepository, err := repository.NewFanoutRepository(mode, []repository.FanoutDestination{
+		{Name: "kafka", Repository: kafkaRepo},
+		{Name: "redis-streams", Repository: redisRepo},
+	})
+	assert.NoError(t, err)
+	return fanoutRepository
+}
+
+// TestFanoutProduce tests the outcome of every mode when one of the destinations fails
+func TestFanoutProduce(t *testing.T) {
+	tests := []struct {
+		name          string
+		mode          repository.FanoutMode
+		kafkaErr      error
+		redisErr      error
+		expectedError string
+		expectedRoles []string
+	}{
+		{name: "all succeed", mode: repository.FanoutAll, expectedRoles: []string{"", ""}},
+		{name: "all with a failure", mode: repository.FanoutAll, redisErr: errors.New("connection refused"), expectedError: "redis-streams: connection refused", expectedRoles: []string{"", ""}},
+		{name: "any with a failure", mode: repository.FanoutAny, kafkaErr: errors.New("broker unavailable"), expectedRoles: []string{"", ""}},
+		{name: "any with every failure", mode: repository.FanoutAny, kafkaErr: errors.New("broker unavailable"), redisErr: errors.New("connection refused"), expectedError: "kafka: broker unavailable\nredis-streams: connection refused", expectedRoles: []string{"", ""}},
+		{name: "primary with a shadow failure", mode: repository.FanoutPrimary, redisErr: errors.New("connection refused"), expectedRoles: []string{repository.RolePrimary, repository.RoleShadow}},
+		{name: "primary failure", mode: repository.FanoutPrimary, kafkaErr: errors.New("broker unavailable"), expectedError: "kafka: broker unavailable", expectedRoles: []string{repository.RolePrimary, repository.RoleShadow}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			message := domain.Message{Content: []byte("test-content")}
+
+			// Create mock producer repositories for both destinations
+			kafkaRepo := new(MockProducerRepository)
+			kafkaRepo.On("Produce", mock.Anything, message).Return(domain.Receipt{}, tt.kafkaErr).Once()
+			redisRepo := new(MockProducerRepository)
+			redisRepo.On("Produce", mock.Anything, message).Return(domain.Receipt{}, tt.redisErr).Once()
+
+			kafkaRepo.On("Close").Return().Once()
+			redisRepo.On("Close").Return().Once()
+
+			// Call the Produce method
+			fanoutRepository := newFanoutRepository(t, tt.mode, kafkaRepo, redisRepo)
+			receipt, err := fanoutRepository.Produce(context.Background(), message)
+
+			// Assert the error and the outcome of every destination
+			if tt.expectedError == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tt.expectedError)
+			}
+			assert.Len(t, receipt.Destinations, 2)
+			assert.Equal(t, "kafka", receipt.Destinations[0].Name)
+			assert.Equal(t, tt.kafkaErr == nil, receipt.Destinations[0].Delivered)
+			assert.Equal(t, tt.expectedRoles[0], receipt.Destinations[0].Role)
+			assert.Equal(t, "redis-streams", receipt.Destinations[1].Name)
+			// A shadow may still be producing when the primary is done
+			if !receipt.Destinations[1].Pending {
+				assert.Equal(t, tt.redisErr == nil, receipt.Destinations[1].Delivered)
+			}
+			assert.Equal(t, tt.expectedRoles[1], receipt.Destinations[1].Role)
+
+			// Close waits until the shadows are done
+			fanoutRepository.Close()
+			kafkaRepo.AssertExpectations(t)
+			redisRepo.AssertExpectations(t)
+		})
+	}
+}
+
+// TestFanoutProduceAttempts tests that the receipt reports the attempts of every destination and the most of them
+func TestFanoutProduceAttempts(t *testing.T) {
+	message := domain.Message{Content: []byte("test-content")}
+
+	// Create mock producer repositories that were retried
+	kafkaRepo := new(MockProducerRepository)
+	kafkaRepo.On("Produce", mock.Anything, message).Return(domain.Receipt{Attempts: 3}, nil).Once()
+	redisRepo := new(MockProducerRepository)
+	redisRepo.On("Produce", mock.Anything, message).Return(domain.Receipt{Attempts: 1}, nil).Once()
+
+	// Call the Produce method
+	receipt, err := newFanoutRepository(t, repository.FanoutAll, kafkaRepo, redisRepo).Produce(context.Background(), message)
+
+	// Assert the attempts
+	assert.NoError(t, err)
+	assert.Equal(t, 3, receipt.Attempts)
+	assert.Equal(t, 3, receipt.Destinations[0].Attempts)
+	assert.Equal(t, 1, receipt.Destinations[1].Attempts)
+	kafkaRepo.AssertExpectations(t)
+	redisRepo.AssertExpectations(t)
+}
+
+// TestFanoutProducePrimaryDoesNotWaitForShadows tests that a slow shadow does not delay the primary mode
+func TestFanoutProducePrimaryDoesNotWaitForShadows(t *testing.T) {
+	message := domain.Message{Content: []byte("test-content")}
+	release := make(chan struct{})
+
+	// Create mock producer repositories where the shadow blocks until released
+	kafkaRepo := new(MockProducerRepository)
+	kafkaRepo.On("Produce", mock.Anything, message).Return(domain.Receipt{Attempts: 1}, nil).Once()
+	kafkaRepo.On("Close").Return().Once()
+	redisRepo := new(MockProducerRepository)
+	redisRepo.On("Produce", mock.Anything, message).Run(func(args mock.Arguments) {
+		<-release
+	}).Return(domain.Receipt{}, nil).Once()
+	redisRepo.On("Close").Return().Once()
+
+	// Call the Produce method with a request context that is canceled right after
+	fanoutRepository := newFanoutRepository(t, repository.FanoutPrimary, kafkaRepo, redisRepo)
+	ctx, cancel := context.WithCancel(context.Background())
+	receipt, err := fanoutRepository.Produce(ctx, message)
+	cancel()
+
+	// Assert that the primary was delivered and the shadow is pending
+	assert.NoError(t, err)
+	assert.True(t, receipt.Destinations[0].Delivered)
+	assert.True(t, receipt.Destinations[1].Pending)
+	assert.False(t, receipt.Destinations[1].Delivered)
+
+	// Release the shadow and assert that Close waited for it
+	close(release)
+	fanoutRepository.Close()
+	kafkaRepo.AssertExpectations(t)
+	redisRepo.AssertExpectations(t)
+}
+
+// TestFanoutProduceBatch tests that the outcome of every message of a batch combines all destinations
+func TestFanoutProduceBatch(t *testing.T) {
+	messages := []domain.Message{{Content: []byte("first")}, {Content: []byte("second")}}
+	failure := errors.New("connection refused")
+
+	// Create mock producer repositories where the second destination fails the second message
+	kafkaRepo := new(MockProducerRepository)
+	kafkaRepo.On("ProduceBatch", mock.Anything, messages).Return([]error{nil, nil}).Once()
+	redisRepo := new(MockProducerRepository)
+	redisRepo.On("ProduceBatch", mock.Anything, messages).Return([]error{nil, failure}).Once()
+
+	// Call the ProduceBatch method
+	errs := newFanoutRepository(t, repository.FanoutAll, kafkaRepo, redisRepo).ProduceBatch(context.Background(), messages)
+
+	// Assert that only the second message failed
+	assert.Len(t, errs, 2)
+	assert.NoError(t, errs[0])
+	assert.ErrorIs(t, errs[1], failure)
+	kafkaRepo.AssertExpectations(t)
+	redisRepo.AssertExpectations(t)
+}
+
+// TestFanoutHealthCheck tests which unhealthy destinations are optional in every mode
+func TestFanoutHealthCheck(t *testing.T) {
+	tests := []struct {
+		name             string
+		mode             repository.FanoutMode
+		expectedOptional bool
+	}{
+		{name: "all", mode: repository.FanoutAll, expectedOptional: false},
+		{name: "any", mode: repository.FanoutAny, expectedOptional: true},
+		{name: "primary", mode: repository.FanoutPrimary, expectedOptional: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Create mock producer repositories where the second destination is unhealthy
+			kafkaRepo := new(MockProducerRepository)
+			kafkaRepo.On("HealthCheck", mock.Anything).Return([]domain.DependencyStatus{{Name: "kafka", Healthy: true}}).Once()
+			redisRepo := new(MockProducerRepository)
+			redisRepo.On("HealthCheck", mock.Anything).Return([]domain.DependencyStatus{{Name: "redis-streams", Healthy: false}}).Once()
+
+			// Call the HealthCheck method
+			status := newFanoutRepository(t, tt.mode, kafkaRepo, redisRepo).HealthCheck(context.Background())
+
+			// Assert that both destinations are reported
+			assert.Len(t, status, 2)
+			assert.False(t, status[0].Optional)
+			assert.Equal(t, tt.expectedOptional, status[1].Optional)
+		})
+	}
+}
+
+// TestNewFanoutRepositoryError tests that an unknown mode, an empty fan-out or duplicate destinations are rejected
+func TestNewFanoutRepositoryError(t *testing.T) {
+	_, err := repository.NewFanoutRepository("some", []repository.FanoutDestination{{Name: "kafka", Repository: new(MockProducerRepository)}})
+	assert.ErrorContains(t, err, "unknown fan-out mode")
+
+	_, err = repository.NewFanoutRepository(repository.FanoutAll, nil)
+	assert.Error(t, err)
+
+	_, err = repository.NewFanoutRepository(repository.FanoutAll, []repository.FanoutDestination{
+		{Name: "kafka", Repository: new(MockProducerRepository)},
+		{Name: "kafka", Repository: new(MockProducerRepository)},
+	})
+	assert.ErrorContains(t, err, "duplicate fan-out destination")
+}
@@ -2,52 +2,324 @@ package repository
 
 import (
 	"anyway/internal/domain"
+	"anyway/internal/metrics"
+	"anyway/internal/tracing"
 	"context"
+	"fmt"
 	"github.com/narumayase/anysher/kafka"
 	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"net"
+	"strconv"
+	"sync"
+	"time"
 )
 
-// AnysherKafkaClient defines the methods used from the external kafka.Repository
+const (
+	// deliveryFailureThreshold is the number of consecutive failed deliveries that makes the repository unhealthy,
+	// so a transient error seen by every replica does not take the whole fleet out of the load balancer
+	deliveryFailureThreshold = 5
+	// deliveryFailureWindow is how long the failed deliveries keep the repository unhealthy
+	deliveryFailureWindow = 30 * time.Second
+	// maxTopicClients is the number of clients for selected topics kept open; the least recently used one
+	// is closed to make room for another topic, so callers cannot open clients without bound
+	maxTopicClients = 64
+)
+
+// AnysherKafkaClient defines the methods used to produce anysher kafka messages to a topic
 type AnysherKafkaClient interface {
 	Send(ctx context.Context, message kafka.Message) error
 	Close()
 }
 
+// KafkaClientFactory creates an anysher kafka client that produces to the given topic
+type KafkaClientFactory func(topic string) (AnysherKafkaClient, error)
+
 // KafkaRepository implements the ProducerRepository interface for Kafka.
 type KafkaRepository struct {
 	kafkaClient AnysherKafkaClient
+	topic       string
+	brokers     []string
+	newClient   KafkaClientFactory
+
+	mu      sync.Mutex
+	clients map[string]*topicClient
+	uses    uint64
+
+	healthMu            sync.Mutex
+	lastError           error
+	lastFailureAt       time.Time
+	consecutiveFailures int
+}
+
+// topicClient is a cached client for a selected topic
+type topicClient struct {
+	client AnysherKafkaClient
+	// lastUsed orders the clients by their last use, so the least recently used one is evicted first
+	lastUsed uint64
 }
 
+// NewKafkaRepository creates a repository that produces every message with the given client.
+// Messages that select a topic are rejected, since the client is bound to a single topic.
 func NewKafkaRepository(kafkaClient AnysherKafkaClient) domain.ProducerRepository {
 	return &KafkaRepository{
 		kafkaClient: kafkaClient,
 	}
 }
 
+// NewTopicKafkaRepository creates a repository that produces to defaultTopic unless the message selects another topic.
+// Clients for other topics are created on first use with newClient and reused afterwards; up to
+// maxTopicClients are kept, and the least recently used one is closed when another topic is selected.
+// The brokers are only used by HealthCheck to verify that they are reachable.
+func NewTopicKafkaRepository(defaultTopic string, brokers []string, newClient KafkaClientFactory) (domain.ProducerRepository, error) {
+	kafkaClient, err := newClient(defaultTopic)
+	if err != nil {
+		return nil, err
+	}
+	return &KafkaRepository{
+		kafkaClient: kafkaClient,
+		topic:       defaultTopic,
+		brokers:     brokers,
+		newClient:   newClient,
+		clients:     map[string]*topicClient{},
+	}, nil
+}
+
+// NewSharedKafkaRepository creates a repository that produces to defaultTopic unless the message selects another topic,
+// with the producer of the brokers shared by kafkaProducers. Closing the repository leaves the producer open.
+func NewSharedKafkaRepository(defaultTopic string, brokers []string, kafkaProducers *KafkaProducers) (domain.ProducerRepository, error) {
+	producer, err := kafkaProducers.Get(brokers)
+	if err != nil {
+		return nil, err
+	}
+	return NewTopicKafkaRepository(defaultTopic, brokers, producer.Client)
+}
+
 // Produce a message to a Kafka topic.
-func (r *KafkaRepository) Produce(ctx context.Context, message domain.Message) error {
-	correlationID, _ := ctx.Value("X-Correlation-Id").(string)
-	routingID, _ := ctx.Value("X-Routing-Id").(string)
-	requestId := ctx.Value("X-Request-Id").(string)
+// The message key and headers sent in the body take precedence over the values
+// taken from the X-Routing-Id, X-Correlation-Id and X-Request-Id request headers.
+func (r *KafkaRepository) Produce(ctx context.Context, message domain.Message) (_ domain.Receipt, err error) {
+	topic := r.topicLabel(message.Topic)
+	ctx, span := tracing.Tracer().Start(ctx, "KafkaRepository.Produce",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "kafka"),
+			attribute.String("messaging.destination.name", topic),
+		))
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
 
-	// Create a payload
+	kafkaClient, err := r.client(message.Topic)
+	if err != nil {
+		metrics.MessagesFailed.WithLabelValues(topic).Inc()
+		log.Err(err).Msg("Failed to get Kafka client")
+		return domain.Receipt{}, err
+	}
+	// Create a payload; the headers carry the trace context of the producer span
 	payload := kafka.Message{
-		Key: routingID,
-		Headers: map[string]string{
-			"correlation_id": correlationID,
-			"request_id":     requestId,
-		},
+		Key:     messageKey(ctx, message),
+		Headers: messageHeaders(ctx, message),
 		Content: message.Content,
 	}
+	metrics.MessageSize.WithLabelValues(topic).Observe(float64(len(message.Content)))
+
 	// Send the message
-	if err := r.kafkaClient.Send(ctx, payload); err != nil {
+	start := time.Now()
+	err = kafkaClient.Send(ctx, payload)
+	metrics.ProduceDuration.WithLabelValues(topic).Observe(time.Since(start).Seconds())
+	r.recordDelivery(err)
+	if err != nil {
+		metrics.MessagesFailed.WithLabelValues(topic).Inc()
 		log.Err(err).Msg("Failed to send message to Kafka")
-		return err
+		return domain.Receipt{}, err
+	}
+	metrics.MessagesProduced.WithLabelValues(topic).Inc()
+	return domain.Receipt{}, nil
+}
+
+// ProduceBatch produces a batch of messages to Kafka.
+// Messages sharing topic and key are produced sequentially to keep their order,
+// while different topic/key groups are produced concurrently.
+func (r *KafkaRepository) ProduceBatch(ctx context.Context, messages []domain.Message) []error {
+	errs := make([]error, len(messages))
+
+	groups := map[string][]int{}
+	for i, message := range messages {
+		group := message.Topic + "\x00" + messageKey(ctx, message)
+		groups[group] = append(groups[group], i)
+	}
+	var wg sync.WaitGroup
+	for _, positions := range groups {
+		wg.Add(1)
+		go func(positions []int) {
+			defer wg.Done()
+			for _, i := range positions {
+				_, errs[i] = r.Produce(ctx, messages[i])
+			}
+		}(positions)
+	}
+	wg.Wait()
+	return errs
+}
+
+// HealthCheck reports Kafka as unhealthy when no broker is reachable or the last deliveries failed in a row recently.
+func (r *KafkaRepository) HealthCheck(ctx context.Context) []domain.DependencyStatus {
+	status := domain.DependencyStatus{
+		Name:    "kafka",
+		Healthy: true,
+		Details: map[string]string{},
+	}
+	if r.topic != "" {
+		status.Details["topic"] = r.topic
+	}
+	if len(r.brokers) > 0 {
+		if err := r.dialBrokers(ctx); err != nil {
+			status.Healthy = false
+			status.Error = err.Error()
+		}
+	}
+	r.healthMu.Lock()
+	lastError, lastFailureAt, failures := r.lastError, r.lastFailureAt, r.consecutiveFailures
+	r.healthMu.Unlock()
+
+	if lastError != nil {
+		status.Details["last_delivery_error"] = lastError.Error()
+		status.Details["last_delivery_failure_at"] = lastFailureAt.Format(time.RFC3339)
+		status.Details["consecutive_delivery_failures"] = strconv.Itoa(failures)
+		if status.Healthy && failures >= deliveryFailureThreshold && time.Since(lastFailureAt) < deliveryFailureWindow {
+			status.Healthy = false
+			status.Error = fmt.Sprintf("last %d deliveries failed: %s", failures, lastError)
+		}
 	}
-	return nil
+	return []domain.DependencyStatus{status}
 }
 
-// Close closes the Kafka producer.
+// Close closes the clients of the topics; a producer shared by KafkaProducers stays open
 func (r *KafkaRepository) Close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, cached := range r.clients {
+		cached.client.Close()
+	}
 	r.kafkaClient.Close()
 }
+
+// client returns the client that produces to the given topic
+func (r *KafkaRepository) client(topic string) (AnysherKafkaClient, error) {
+	if topic == "" || topic == r.topic {
+		return r.kafkaClient, nil
+	}
+	if r.newClient == nil {
+		return nil, fmt.Errorf("topic %s cannot be selected: the Kafka client is bound to a single topic", topic)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.uses++
+	if cached, ok := r.clients[topic]; ok {
+		cached.lastUsed = r.uses
+		return cached.client, nil
+	}
+	kafkaClient, err := r.newClient(topic)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kafka client for topic %s: %w", topic, err)
+	}
+	if len(r.clients) >= maxTopicClients {
+		r.evictClient()
+	}
+	r.clients[topic] = &topicClient{client: kafkaClient, lastUsed: r.uses}
+	return kafkaClient, nil
+}
+
+// evictClient closes and drops the least recently used client for a selected topic
+func (r *KafkaRepository) evictClient() {
+	var oldest string
+	for topic, cached := range r.clients {
+		if oldest == "" || cached.lastUsed < r.clients[oldest].lastUsed {
+			oldest = topic
+		}
+	}
+	log.Info().Msgf("Closing Kafka client for topic %s to make room for another topic", oldest)
+	r.clients[oldest].client.Close()
+	delete(r.clients, oldest)
+}
+
+// messageKey returns the key of the message, falling back to the X-Routing-Id request header
+func messageKey(ctx context.Context, message domain.Message) string {
+	if message.Key != "" {
+		return message.Key
+	}
+	return domain.MetadataFromContext(ctx).RoutingID
+}
+
+// messageHeaders builds the Kafka headers from the request headers and the message headers.
+// The request and correlation IDs override the propagated request headers, and
+// headers sent in the message override all the ones taken from the request except
+// the caller, which is the authenticated identity and cannot be forged.
+// The trace context of ctx is added last, as traceparent and tracestate.
+func messageHeaders(ctx context.Context, message domain.Message) map[string]string {
+	metadata := domain.MetadataFromContext(ctx)
+	headers := make(map[string]string, len(metadata.Headers)+len(message.Headers)+3)
+
+	for k, v := range metadata.Headers {
+		headers[k] = v
+	}
+	if metadata.CorrelationID != "" {
+		headers["correlation_id"] = metadata.CorrelationID
+	}
+	if metadata.RequestID != "" {
+		headers["request_id"] = metadata.RequestID
+	}
+	for k, v := range message.Headers {
+		headers[k] = v
+	}
+	if metadata.Caller != "" {
+		headers["caller"] = metadata.Caller
+	}
+	tracing.Inject(ctx, headers)
+	return headers
+}
+
+// topicLabel returns the topic used to label the metrics of a message
+func (r *KafkaRepository) topicLabel(topic string) string {
+	if topic != "" {
+		return topic
+	}
+	if r.topic != "" {
+		return r.topic
+	}
+	return "default"
+}
+
+// recordDelivery keeps the outcome of the last delivery for HealthCheck
+func (r *KafkaRepository) recordDelivery(err error) {
+	r.healthMu.Lock()
+	defer r.healthMu.Unlock()
+
+	if err != nil {
+		r.lastError = err
+		r.lastFailureAt = time.Now()
+		r.consecutiveFailures++
+		return
+	}
+	r.lastError = nil
+	r.consecutiveFailures = 0
+}
+
+// dialBrokers returns nil when at least one of the brokers accepts TCP connections
+func (r *KafkaRepository) dialBrokers(ctx context.Context) error {
+	var dialer net.Dialer
+	var err error
+	for _, broker := range r.brokers {
+		var conn net.Conn
+		if conn, err = dialer.DialContext(ctx, "tcp", broker); err == nil {
+			conn.Close()
+			return nil
+		}
+	}
+	return fmt.Errorf("no Kafka broker is reachable: %w", err)
+}
@@ -0,0 +1,147 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	anysherkafka "github.com/narumayase/anysher/kafka"
+	"github.com/rs/zerolog/log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// producerFlushTimeout is how long Close waits for the pending messages to be delivered
+const producerFlushTimeout = 5 * time.Second
+
+// KafkaProducer produces to every topic with a single librdkafka producer,
+// so selecting another topic does not start another producer
+type KafkaProducer struct {
+	producer *kafka.Producer
+}
+
+// NewKafkaProducer creates a producer connected to the brokers.
+// A positive messageTimeout bounds how long a message is tried before its delivery fails,
+// so a message given up on by its sender is not delivered later; zero keeps the librdkafka default.
+func NewKafkaProducer(brokers []string, messageTimeout time.Duration) (*KafkaProducer, error) {
+	configMap := kafka.ConfigMap{"bootstrap.servers": strings.Join(brokers, ",")}
+	if messageTimeout > 0 {
+		configMap["message.timeout.ms"] = int(messageTimeout.Milliseconds())
+	}
+	producer, err := kafka.NewProducer(&configMap)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kafka producer: %w", err)
+	}
+	log.Info().Msgf("Successfully created Kafka producer for brokers: %s", strings.Join(brokers, ","))
+	p := &KafkaProducer{producer: producer}
+	go p.logEvents()
+	return p, nil
+}
+
+// KafkaProducers shares a single KafkaProducer per broker list between the Kafka backends and the dead-letter queue,
+// so they all produce with the same librdkafka producer. The producers are created on first use.
+type KafkaProducers struct {
+	messageTimeout time.Duration
+
+	mu        sync.Mutex
+	producers map[string]*KafkaProducer
+}
+
+// NewKafkaProducers creates the producers shared by the repositories; see NewKafkaProducer for messageTimeout
+func NewKafkaProducers(messageTimeout time.Duration) *KafkaProducers {
+	return &KafkaProducers{
+		messageTimeout: messageTimeout,
+		producers:      map[string]*KafkaProducer{},
+	}
+}
+
+// Get returns the producer connected to the brokers, creating it on first use
+func (p *KafkaProducers) Get(brokers []string) (*KafkaProducer, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key := strings.Join(brokers, ",")
+	if producer, ok := p.producers[key]; ok {
+		return producer, nil
+	}
+	producer, err := NewKafkaProducer(brokers, p.messageTimeout)
+	if err != nil {
+		return nil, err
+	}
+	p.producers[key] = producer
+	return producer, nil
+}
+
+// Close delivers the pending messages of every producer and closes them.
+// The repositories producing with them must be closed first.
+func (p *KafkaProducers) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for key, producer := range p.producers {
+		producer.Close()
+		delete(p.producers, key)
+	}
+}
+
+// Client returns a client producing to the topic with the shared producer; it is a KafkaClientFactory
+func (p *KafkaProducer) Client(topic string) (AnysherKafkaClient, error) {
+	return &kafkaTopicClient{producer: p.producer, topic: topic}, nil
+}
+
+// Close delivers the pending messages and closes the producer
+func (p *KafkaProducer) Close() {
+	p.producer.Flush(int(producerFlushTimeout.Milliseconds()))
+	p.producer.Close()
+}
+
+// logEvents drains the events not tied to a message, such as broker errors, until the producer is closed
+func (p *KafkaProducer) logEvents() {
+	for event := range p.producer.Events() {
+		if err, ok := event.(kafka.Error); ok {
+			log.Warn().Err(err).Msg("Kafka producer error")
+		}
+	}
+}
+
+// kafkaTopicClient produces to a topic with a shared producer; closing it leaves the producer open
+type kafkaTopicClient struct {
+	producer *kafka.Producer
+	topic    string
+}
+
+// Send produces the message and waits for its delivery report or the end of ctx
+func (c *kafkaTopicClient) Send(ctx context.Context, message anysherkafka.Message) error {
+	headers := make([]kafka.Header, 0, len(message.Headers))
+	for k, v := range message.Headers {
+		headers = append(headers, kafka.Header{Key: k, Value: []byte(v)})
+	}
+	// The delivery report is buffered, so it does not block the producer once ctx ends
+	deliveryChan := make(chan kafka.Event, 1)
+	err := c.producer.Produce(&kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &c.topic, Partition: kafka.PartitionAny},
+		Key:            []byte(message.Key),
+		Value:          message.Content,
+		Headers:        headers,
+	}, deliveryChan)
+	if err != nil {
+		return fmt.Errorf("failed to produce message to Kafka topic %s: %w", c.topic, err)
+	}
+
+	select {
+	case event := <-deliveryChan:
+		delivered, ok := event.(*kafka.Message)
+		if !ok {
+			return fmt.Errorf("unexpected delivery report for Kafka topic %s: %v", c.topic, event)
+		}
+		if delivered.TopicPartition.Error != nil {
+			return fmt.Errorf("delivery failed to Kafka topic %s: %w", c.topic, delivered.TopicPartition.Error)
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close does nothing; the shared producer is closed with the KafkaProducer
+func (c *kafkaTopicClient) Close() {}
@@ -3,13 +3,23 @@ package repository_test
 import (
 	"anyway/internal/domain"
 	"anyway/internal/infrastructure/repository"
+	"anyway/internal/metrics"
 	"context"
 	"errors"
+	"fmt"
+	"net"
 	"testing"
+	"time"
 
 	kafka "github.com/narumayase/anysher/kafka"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
 )
 
 // MockAnysherKafkaClient is a mock implementation of the AnysherKafkaClient interface
@@ -56,12 +66,14 @@ func TestProduceSuccess(t *testing.T) {
 		Content: []byte("test-content"),
 	}
 
-	ctx := context.WithValue(context.Background(), "X-Correlation-Id", "test-correlation-id")
-	ctx = context.WithValue(ctx, "X-Routing-Id", "test-routing-id")
-	ctx = context.WithValue(ctx, "X-Request-Id", "test-request-id")
+	ctx := domain.WithMetadata(context.Background(), domain.Metadata{
+		RequestID:     "test-request-id",
+		CorrelationID: "test-correlation-id",
+		RoutingID:     "test-routing-id",
+	})
 
 	// Call the Produce method
-	err := kRepository.Produce(ctx, domainMessage)
+	_, err := kRepository.Produce(ctx, domainMessage)
 
 	// Assert that no error is returned
 	assert.NoError(t, err)
@@ -90,7 +102,7 @@ func TestProduceError(t *testing.T) {
 	}
 
 	// Call the Produce method
-	err := kRepository.Produce(context.Background(), domainMessage)
+	_, err := kRepository.Produce(context.Background(), domainMessage)
 
 	// Assert that the expected error is returned
 	assert.EqualError(t, err, expectedErr.Error())
@@ -116,3 +128,548 @@ func TestClose(t *testing.T) {
 	// Assert that the expected methods were called on the mock
 	mockAnysherKafkaClient.AssertExpectations(t)
 }
+
+// TestProduceUsesRequestHeaders tests that the request headers are used when the message has no key or headers
+func TestProduceUsesRequestHeaders(t *testing.T) {
+	// Create a mock anysher kafka client
+	mockAnysherKafkaClient := new(MockAnysherKafkaClient)
+
+	// Expect Send to be called with the values taken from the request headers
+	expectedPayload := kafka.Message{
+		Key: "test-routing-id",
+		Headers: map[string]string{
+			"correlation_id": "test-correlation-id",
+			"request_id":     "test-request-id",
+		},
+		Content: []byte("test-content"),
+	}
+	mockAnysherKafkaClient.On("Send", mock.Anything, expectedPayload).Return(nil).Once()
+
+	// Create a new KafkaRepository instance
+	kRepository := repository.NewKafkaRepository(mockAnysherKafkaClient)
+
+	ctx := domain.WithMetadata(context.Background(), domain.Metadata{
+		RequestID:     "test-request-id",
+		CorrelationID: "test-correlation-id",
+		RoutingID:     "test-routing-id",
+	})
+
+	// Call the Produce method
+	_, err := kRepository.Produce(ctx, domain.Message{Content: []byte("test-content")})
+
+	// Assert that no error is returned
+	assert.NoError(t, err)
+
+	// Assert that the expected methods were called on the mock
+	mockAnysherKafkaClient.AssertExpectations(t)
+}
+
+// TestProduceWithoutRequestMetadata tests that messages produced outside an HTTP request have no request headers
+func TestProduceWithoutRequestMetadata(t *testing.T) {
+	// Create a mock anysher kafka client
+	mockAnysherKafkaClient := new(MockAnysherKafkaClient)
+
+	// Expect Send to be called without key or request headers
+	expectedPayload := kafka.Message{
+		Headers: map[string]string{},
+		Content: []byte("test-content"),
+	}
+	mockAnysherKafkaClient.On("Send", mock.Anything, expectedPayload).Return(nil).Once()
+
+	// Create a new KafkaRepository instance
+	kRepository := repository.NewKafkaRepository(mockAnysherKafkaClient)
+
+	// Call the Produce method with a context without request metadata
+	_, err := kRepository.Produce(context.Background(), domain.Message{Content: []byte("test-content")})
+
+	// Assert that no error is returned
+	assert.NoError(t, err)
+
+	// Assert that the expected methods were called on the mock
+	mockAnysherKafkaClient.AssertExpectations(t)
+}
+
+// TestProduceForwardsCaller tests that the caller identity is forwarded as a Kafka header,
+// overriding the caller header of the message
+func TestProduceForwardsCaller(t *testing.T) {
+	// Create a mock anysher kafka client
+	mockAnysherKafkaClient := new(MockAnysherKafkaClient)
+
+	// Expect Send to be called with the caller header
+	expectedPayload := kafka.Message{
+		Headers: map[string]string{
+			"request_id": "test-request-id",
+			"caller":     "billing-service",
+			"source":     "my-app",
+		},
+		Content: []byte("test-content"),
+	}
+	mockAnysherKafkaClient.On("Send", mock.Anything, expectedPayload).Return(nil).Once()
+
+	// Create a new KafkaRepository instance
+	kRepository := repository.NewKafkaRepository(mockAnysherKafkaClient)
+
+	ctx := domain.WithMetadata(context.Background(), domain.Metadata{
+		RequestID: "test-request-id",
+		Caller:    "billing-service",
+	})
+
+	// Call the Produce method
+	_, err := kRepository.Produce(ctx, domain.Message{
+		Headers: map[string]string{"caller": "forged-service", "source": "my-app"},
+		Content: []byte("test-content"),
+	})
+
+	// Assert that no error is returned
+	assert.NoError(t, err)
+
+	// Assert that the expected methods were called on the mock
+	mockAnysherKafkaClient.AssertExpectations(t)
+}
+
+// TestProducePropagatedHeaders tests that the propagated request headers are forwarded,
+// overridden by the request IDs and by the message headers
+func TestProducePropagatedHeaders(t *testing.T) {
+	// Create a mock anysher kafka client
+	mockAnysherKafkaClient := new(MockAnysherKafkaClient)
+
+	// Expect Send to be called with the merged headers
+	expectedPayload := kafka.Message{
+		Headers: map[string]string{
+			"request_id":   "test-request-id",
+			"tenant_id":    "message-tenant",
+			"Content-Type": "application/json",
+		},
+		Content: []byte("test-content"),
+	}
+	mockAnysherKafkaClient.On("Send", mock.Anything, expectedPayload).Return(nil).Once()
+
+	// Create a new KafkaRepository instance
+	kRepository := repository.NewKafkaRepository(mockAnysherKafkaClient)
+
+	ctx := domain.WithMetadata(context.Background(), domain.Metadata{
+		RequestID: "test-request-id",
+		Headers: map[string]string{
+			"request_id":   "propagated-request-id",
+			"tenant_id":    "request-tenant",
+			"Content-Type": "application/json",
+		},
+	})
+
+	// Call the Produce method
+	_, err := kRepository.Produce(ctx, domain.Message{
+		Headers: map[string]string{"tenant_id": "message-tenant"},
+		Content: []byte("test-content"),
+	})
+
+	// Assert that no error is returned
+	assert.NoError(t, err)
+
+	// Assert that the expected methods were called on the mock
+	mockAnysherKafkaClient.AssertExpectations(t)
+}
+
+// TestProduceTraceContext tests that the producer span is recorded and its trace context is injected into the Kafka headers
+func TestProduceTraceContext(t *testing.T) {
+	// Record the spans in memory and propagate the W3C trace context, disabling both afterwards
+	defer func() {
+		otel.SetTracerProvider(noop.NewTracerProvider())
+		otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator())
+	}()
+	exporter := tracetest.NewInMemoryExporter()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	// Create a mock anysher kafka client
+	mockAnysherKafkaClient := new(MockAnysherKafkaClient)
+	var payload kafka.Message
+	mockAnysherKafkaClient.On("Send", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		payload = args.Get(1).(kafka.Message)
+	}).Return(nil).Once()
+
+	// Create a new KafkaRepository instance
+	kRepository := repository.NewKafkaRepository(mockAnysherKafkaClient)
+
+	// Call the Produce method within the trace of an incoming request
+	ctx := propagation.TraceContext{}.Extract(context.Background(), propagation.MapCarrier{
+		"traceparent": "00-4bf92f3577b34da6a3ce929b0e0e4736-00f067aa0ba902b7-01",
+	})
+	_, err := kRepository.Produce(ctx, domain.Message{Content: []byte("test-content")})
+	assert.NoError(t, err)
+
+	// Assert that the producer span belongs to the incoming trace and is the parent sent to the consumers
+	spans := exporter.GetSpans()
+	assert.Len(t, spans, 1)
+	assert.Equal(t, "KafkaRepository.Produce", spans[0].Name)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929b0e0e4736", spans[0].SpanContext.TraceID().String())
+	assert.Equal(t, "00f067aa0ba902b7", spans[0].Parent.SpanID().String())
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929b0e0e4736-"+spans[0].SpanContext.SpanID().String()+"-01", payload.Headers["traceparent"])
+
+	// Assert that the expected methods were called on the mock
+	mockAnysherKafkaClient.AssertExpectations(t)
+}
+
+// TestProduceMessageKeyAndHeadersTakePrecedence tests that the key and headers of the message
+// override the values taken from the request headers
+func TestProduceMessageKeyAndHeadersTakePrecedence(t *testing.T) {
+	// Create a mock anysher kafka client
+	mockAnysherKafkaClient := new(MockAnysherKafkaClient)
+
+	// Expect Send to be called with the message key and the merged headers
+	expectedPayload := kafka.Message{
+		Key: "message-key",
+		Headers: map[string]string{
+			"correlation_id": "message-correlation-id",
+			"request_id":     "test-request-id",
+			"source":         "my-app",
+		},
+		Content: []byte("test-content"),
+	}
+	mockAnysherKafkaClient.On("Send", mock.Anything, expectedPayload).Return(nil).Once()
+
+	// Create a new KafkaRepository instance
+	kRepository := repository.NewKafkaRepository(mockAnysherKafkaClient)
+
+	ctx := domain.WithMetadata(context.Background(), domain.Metadata{
+		RequestID:     "test-request-id",
+		CorrelationID: "test-correlation-id",
+		RoutingID:     "test-routing-id",
+	})
+
+	// Define a domain message with key and headers
+	domainMessage := domain.Message{
+		Key: "message-key",
+		Headers: map[string]string{
+			"correlation_id": "message-correlation-id",
+			"source":         "my-app",
+		},
+		Content: []byte("test-content"),
+	}
+
+	// Call the Produce method
+	_, err := kRepository.Produce(ctx, domainMessage)
+
+	// Assert that no error is returned
+	assert.NoError(t, err)
+
+	// Assert that the expected methods were called on the mock
+	mockAnysherKafkaClient.AssertExpectations(t)
+}
+
+// TestProduceToTopic tests that messages are produced with a client bound to their topic
+func TestProduceToTopic(t *testing.T) {
+	// Create mock anysher kafka clients for the default topic and another topic
+	defaultClient := new(MockAnysherKafkaClient)
+	ordersClient := new(MockAnysherKafkaClient)
+	defaultClient.On("Send", mock.Anything, mock.Anything).Return(nil).Once()
+	ordersClient.On("Send", mock.Anything, mock.Anything).Return(nil).Twice()
+	defaultClient.On("Close").Return().Once()
+	ordersClient.On("Close").Return().Once()
+
+	// Create a factory that records the topics it was asked for
+	var created []string
+	factory := func(topic string) (repository.AnysherKafkaClient, error) {
+		created = append(created, topic)
+		if topic == "orders" {
+			return ordersClient, nil
+		}
+		return defaultClient, nil
+	}
+
+	// Create a new KafkaRepository instance
+	kRepository, err := repository.NewTopicKafkaRepository("default-topic", nil, factory)
+	assert.NoError(t, err)
+
+	// Produce to the default topic and twice to another topic
+	_, err = kRepository.Produce(context.Background(), domain.Message{Content: []byte("a")})
+	assert.NoError(t, err)
+	_, err = kRepository.Produce(context.Background(), domain.Message{Topic: "orders", Content: []byte("b")})
+	assert.NoError(t, err)
+	_, err = kRepository.Produce(context.Background(), domain.Message{Topic: "orders", Content: []byte("c")})
+	assert.NoError(t, err)
+	kRepository.Close()
+
+	// Assert that the client for each topic was created only once
+	assert.Equal(t, []string{"default-topic", "orders"}, created)
+
+	// Assert that the expected methods were called on the mocks
+	defaultClient.AssertExpectations(t)
+	ordersClient.AssertExpectations(t)
+}
+
+// TestProduceToTopicEvictsClients tests that the least recently used topic client is closed when too many topics are selected
+func TestProduceToTopicEvictsClients(t *testing.T) {
+	// Create mock anysher kafka clients for the default topic and every other topic
+	defaultClient := new(MockAnysherKafkaClient)
+	defaultClient.On("Close").Return().Once()
+	clients := map[string]*MockAnysherKafkaClient{}
+	factory := func(topic string) (repository.AnysherKafkaClient, error) {
+		if topic == "default-topic" {
+			return defaultClient, nil
+		}
+		client := new(MockAnysherKafkaClient)
+		client.On("Send", mock.Anything, mock.Anything).Return(nil)
+		client.On("Close").Return()
+		clients[topic] = client
+		return client, nil
+	}
+
+	// Create a new KafkaRepository instance
+	kRepository, err := repository.NewTopicKafkaRepository("default-topic", nil, factory)
+	assert.NoError(t, err)
+
+	// Fill the 64 cached clients, then use the first topic again so the second one is the least recently used
+	for i := 0; i < 64; i++ {
+		_, err = kRepository.Produce(context.Background(), domain.Message{Topic: fmt.Sprintf("topic-%d", i), Content: []byte("a")})
+		assert.NoError(t, err)
+	}
+	_, err = kRepository.Produce(context.Background(), domain.Message{Topic: "topic-0", Content: []byte("b")})
+	assert.NoError(t, err)
+
+	// Produce to another topic
+	_, err = kRepository.Produce(context.Background(), domain.Message{Topic: "topic-64", Content: []byte("c")})
+	assert.NoError(t, err)
+
+	// Assert that only the least recently used client was closed
+	clients["topic-1"].AssertCalled(t, "Close")
+	clients["topic-0"].AssertNotCalled(t, "Close")
+	clients["topic-2"].AssertNotCalled(t, "Close")
+
+	// Assert that Close closes the remaining clients
+	kRepository.Close()
+	clients["topic-0"].AssertCalled(t, "Close")
+	clients["topic-64"].AssertCalled(t, "Close")
+	defaultClient.AssertExpectations(t)
+}
+
+// TestProduceToTopicFactoryError tests the Produce method when the client for a topic cannot be created
+func TestProduceToTopicFactoryError(t *testing.T) {
+	// Create a factory that only works for the default topic
+	defaultClient := new(MockAnysherKafkaClient)
+	factory := func(topic string) (repository.AnysherKafkaClient, error) {
+		if topic != "default-topic" {
+			return nil, errors.New("broker unavailable")
+		}
+		return defaultClient, nil
+	}
+
+	// Create a new KafkaRepository instance
+	kRepository, err := repository.NewTopicKafkaRepository("default-topic", nil, factory)
+	assert.NoError(t, err)
+
+	// Call the Produce method
+	_, err = kRepository.Produce(context.Background(), domain.Message{Topic: "orders", Content: []byte("test-content")})
+
+	// Assert that the error is returned and no message was sent
+	assert.ErrorContains(t, err, "broker unavailable")
+	defaultClient.AssertNotCalled(t, "Send", mock.Anything, mock.Anything)
+}
+
+// TestProduceTopicWithSingleTopicClient tests that a single topic repository rejects messages that select a topic
+func TestProduceTopicWithSingleTopicClient(t *testing.T) {
+	// Create a mock anysher kafka client
+	mockAnysherKafkaClient := new(MockAnysherKafkaClient)
+
+	// Create a new KafkaRepository instance
+	kRepository := repository.NewKafkaRepository(mockAnysherKafkaClient)
+
+	// Call the Produce method
+	_, err := kRepository.Produce(context.Background(), domain.Message{Topic: "orders", Content: []byte("test-content")})
+
+	// Assert that an error is returned and no message was sent
+	assert.Error(t, err)
+	mockAnysherKafkaClient.AssertNotCalled(t, "Send", mock.Anything, mock.Anything)
+}
+
+// TestProduceBatch tests that the ProduceBatch method reports the outcome of every message
+func TestProduceBatch(t *testing.T) {
+	// Create a mock anysher kafka client
+	mockAnysherKafkaClient := new(MockAnysherKafkaClient)
+
+	// Define an error to be returned by anysher kafka Send for one of the messages
+	expectedErr := errors.New("failed to send message to anysher kafka")
+
+	// Expect Send to be called for every message
+	mockAnysherKafkaClient.On("Send", mock.Anything, mock.MatchedBy(func(m kafka.Message) bool {
+		return string(m.Content) != "second"
+	})).Return(nil).Twice()
+	mockAnysherKafkaClient.On("Send", mock.Anything, mock.MatchedBy(func(m kafka.Message) bool {
+		return string(m.Content) == "second"
+	})).Return(expectedErr).Once()
+
+	// Create a new KafkaRepository instance
+	kRepository := repository.NewKafkaRepository(mockAnysherKafkaClient)
+
+	// Call the ProduceBatch method
+	errs := kRepository.ProduceBatch(context.Background(), []domain.Message{
+		{Key: "a", Content: []byte("first")},
+		{Key: "b", Content: []byte("second")},
+		{Key: "a", Content: []byte("third")},
+	})
+
+	// Assert the outcome of every message
+	assert.Equal(t, []error{nil, expectedErr, nil}, errs)
+
+	// Assert that the expected methods were called on the mock
+	mockAnysherKafkaClient.AssertExpectations(t)
+}
+
+// TestProduceBatchKeepsOrderPerKey tests that messages with the same key are produced in order
+func TestProduceBatchKeepsOrderPerKey(t *testing.T) {
+	// Create a mock anysher kafka client recording the content of the messages of one key
+	mockAnysherKafkaClient := new(MockAnysherKafkaClient)
+	var sent []string
+	mockAnysherKafkaClient.On("Send", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		if m := args.Get(1).(kafka.Message); m.Key == "same-key" {
+			sent = append(sent, string(m.Content))
+		}
+	}).Return(nil)
+
+	// Create a new KafkaRepository instance
+	kRepository := repository.NewKafkaRepository(mockAnysherKafkaClient)
+
+	// Call the ProduceBatch method
+	errs := kRepository.ProduceBatch(context.Background(), []domain.Message{
+		{Key: "same-key", Content: []byte("1")},
+		{Key: "other-key", Content: []byte("x")},
+		{Key: "same-key", Content: []byte("2")},
+		{Key: "same-key", Content: []byte("3")},
+	})
+
+	// Assert that every message was produced and the order of the key was kept
+	assert.Equal(t, []error{nil, nil, nil, nil}, errs)
+	assert.Equal(t, []string{"1", "2", "3"}, sent)
+}
+
+// TestHealthCheck tests the HealthCheck method with reachable and unreachable brokers
+func TestHealthCheck(t *testing.T) {
+	// Start a listener acting as a reachable broker
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer listener.Close()
+
+	// Get an address where nothing is listening
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	unreachable := closed.Addr().String()
+	closed.Close()
+
+	tests := []struct {
+		name            string
+		brokers         []string
+		expectedHealthy bool
+	}{
+		{
+			name:            "reachable broker",
+			brokers:         []string{listener.Addr().String()},
+			expectedHealthy: true,
+		},
+		{
+			name:            "one of the brokers is reachable",
+			brokers:         []string{unreachable, listener.Addr().String()},
+			expectedHealthy: true,
+		},
+		{
+			name:            "unreachable broker",
+			brokers:         []string{unreachable},
+			expectedHealthy: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Create a new KafkaRepository instance
+			factory := func(topic string) (repository.AnysherKafkaClient, error) {
+				return new(MockAnysherKafkaClient), nil
+			}
+			kRepository, err := repository.NewTopicKafkaRepository("default-topic", tt.brokers, factory)
+			assert.NoError(t, err)
+
+			// Call the HealthCheck method
+			status := kRepository.HealthCheck(context.Background())
+
+			// Assert the reported status
+			assert.Len(t, status, 1)
+			assert.Equal(t, "kafka", status[0].Name)
+			assert.Equal(t, tt.expectedHealthy, status[0].Healthy)
+		})
+	}
+}
+
+// TestHealthCheckLastDelivery tests that consecutive failed deliveries make the repository unhealthy until a delivery succeeds,
+// while a single failure does not
+func TestHealthCheckLastDelivery(t *testing.T) {
+	// Create a mock anysher kafka client that fails five times and then succeeds
+	mockAnysherKafkaClient := new(MockAnysherKafkaClient)
+	mockAnysherKafkaClient.On("Send", mock.Anything, mock.Anything).Return(errors.New("delivery failed")).Times(5)
+	mockAnysherKafkaClient.On("Send", mock.Anything, mock.Anything).Return(nil).Once()
+
+	// Create a new KafkaRepository instance
+	kRepository := repository.NewKafkaRepository(mockAnysherKafkaClient)
+
+	// Assert that the repository is healthy before any delivery
+	assert.True(t, kRepository.HealthCheck(context.Background())[0].Healthy)
+
+	// Assert that the repository stays healthy after a single failed delivery
+	_, _ = kRepository.Produce(context.Background(), domain.Message{Content: []byte("test-content")})
+	status := kRepository.HealthCheck(context.Background())[0]
+	assert.True(t, status.Healthy)
+	assert.Equal(t, "1", status.Details["consecutive_delivery_failures"])
+
+	// Assert that the repository is unhealthy after consecutive failed deliveries
+	for i := 0; i < 4; i++ {
+		_, _ = kRepository.Produce(context.Background(), domain.Message{Content: []byte("test-content")})
+	}
+	status = kRepository.HealthCheck(context.Background())[0]
+	assert.False(t, status.Healthy)
+	assert.Contains(t, status.Error, "delivery failed")
+
+	// Assert that the repository is healthy again after a successful delivery
+	_, _ = kRepository.Produce(context.Background(), domain.Message{Content: []byte("test-content")})
+	assert.True(t, kRepository.HealthCheck(context.Background())[0].Healthy)
+
+	// Assert that the expected methods were called on the mock
+	mockAnysherKafkaClient.AssertExpectations(t)
+}
+
+// TestProduceMetrics tests that the Produce method counts produced and failed messages by topic
+func TestProduceMetrics(t *testing.T) {
+	// Create a mock anysher kafka client that succeeds once and then fails
+	mockAnysherKafkaClient := new(MockAnysherKafkaClient)
+	mockAnysherKafkaClient.On("Send", mock.Anything, mock.Anything).Return(nil).Once()
+	mockAnysherKafkaClient.On("Send", mock.Anything, mock.Anything).Return(errors.New("delivery failed")).Once()
+
+	// Create a new KafkaRepository instance
+	factory := func(topic string) (repository.AnysherKafkaClient, error) {
+		return mockAnysherKafkaClient, nil
+	}
+	kRepository, err := repository.NewTopicKafkaRepository("metrics-topic", nil, factory)
+	assert.NoError(t, err)
+
+	produced := testutil.ToFloat64(metrics.MessagesProduced.WithLabelValues("metrics-topic"))
+	failed := testutil.ToFloat64(metrics.MessagesFailed.WithLabelValues("metrics-topic"))
+
+	// Produce a message that is delivered and another one that fails
+	_, _ = kRepository.Produce(context.Background(), domain.Message{Content: []byte("test-content")})
+	_, _ = kRepository.Produce(context.Background(), domain.Message{Content: []byte("test-content")})
+
+	// Assert that the messages were counted with the default topic
+	assert.Equal(t, produced+1, testutil.ToFloat64(metrics.MessagesProduced.WithLabelValues("metrics-topic")))
+	assert.Equal(t, failed+1, testutil.ToFloat64(metrics.MessagesFailed.WithLabelValues("metrics-topic")))
+}
+
+// TestKafkaProducersShared tests that the repositories producing to the same brokers share a single producer
+func TestKafkaProducersShared(t *testing.T) {
+	kafkaProducers := repository.NewKafkaProducers(time.Second)
+	defer kafkaProducers.Close()
+
+	// Assert that the same brokers get the same producer and other brokers another one
+	producer, err := kafkaProducers.Get([]string{"localhost:9092"})
+	assert.NoError(t, err)
+	shared, err := kafkaProducers.Get([]string{"localhost:9092"})
+	assert.NoError(t, err)
+	assert.Same(t, producer, shared)
+	other, err := kafkaProducers.Get([]string{"localhost:9093"})
+	assert.NoError(t, err)
+	assert.NotSame(t, producer, other)
+}
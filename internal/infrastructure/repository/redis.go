@@ -0,0 +1,102 @@
+package repository
+
+import (
+	"anyway/internal/domain"
+	"anyway/internal/metrics"
+	"context"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+	"sort"
+	"time"
+)
+
+// redisHeaderPrefix prefixes the stream fields that hold the message headers
+const redisHeaderPrefix = "header:"
+
+// RedisStreamsRepository implements the ProducerRepository interface for Redis Streams.
+// Every topic is a stream; the message is added with a "key" field, a "content" field
+// and one "header:<name>" field per header, sorted by name.
+type RedisStreamsRepository struct {
+	client *redis.Client
+	topic  string
+	maxLen int64
+}
+
+// NewRedisStreamsRepository creates a repository that adds the messages to the stream of their topic.
+// When maxLen is positive the streams are trimmed to approximately that many entries.
+func NewRedisStreamsRepository(client *redis.Client, defaultTopic string, maxLen int64) domain.ProducerRepository {
+	return &RedisStreamsRepository{
+		client: client,
+		topic:  defaultTopic,
+		maxLen: maxLen,
+	}
+}
+
+// Produce adds the message to the stream of its topic
+func (r *RedisStreamsRepository) Produce(ctx context.Context, message domain.Message) (domain.Receipt, error) {
+	topic := message.Topic
+	if topic == "" {
+		topic = r.topic
+	}
+	headers := messageHeaders(ctx, message)
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	values := []interface{}{"key", messageKey(ctx, message), "content", message.Content}
+	for _, name := range names {
+		values = append(values, redisHeaderPrefix+name, headers[name])
+	}
+	args := &redis.XAddArgs{
+		Stream: topic,
+		Values: values,
+	}
+	if r.maxLen > 0 {
+		args.MaxLen = r.maxLen
+		args.Approx = true
+	}
+	metrics.MessageSize.WithLabelValues(topic).Observe(float64(len(message.Content)))
+
+	start := time.Now()
+	id, err := r.client.XAdd(ctx, args).Result()
+	metrics.ProduceDuration.WithLabelValues(topic).Observe(time.Since(start).Seconds())
+	if err != nil {
+		metrics.MessagesFailed.WithLabelValues(topic).Inc()
+		log.Err(err).Msg("Failed to add message to Redis stream")
+		return domain.Receipt{}, err
+	}
+	metrics.MessagesProduced.WithLabelValues(topic).Inc()
+	log.Ctx(ctx).Debug().Msgf("added message to Redis stream %s with id %s", topic, id)
+	return domain.Receipt{}, nil
+}
+
+// ProduceBatch adds the messages to their streams, in order
+func (r *RedisStreamsRepository) ProduceBatch(ctx context.Context, messages []domain.Message) []error {
+	errs := make([]error, len(messages))
+	for i, message := range messages {
+		_, errs[i] = r.Produce(ctx, message)
+	}
+	return errs
+}
+
+// HealthCheck reports Redis as unhealthy when it does not answer a PING
+func (r *RedisStreamsRepository) HealthCheck(ctx context.Context) []domain.DependencyStatus {
+	status := domain.DependencyStatus{
+		Name:    "redis-streams",
+		Healthy: true,
+	}
+	if err := r.client.Ping(ctx).Err(); err != nil {
+		status.Healthy = false
+		status.Error = err.Error()
+	}
+	return []domain.DependencyStatus{status}
+}
+
+// Close closes the Redis client
+func (r *RedisStreamsRepository) Close() {
+	if err := r.client.Close(); err != nil {
+		log.Err(err).Msg("Failed to close Redis client")
+	}
+}
@@ -0,0 +1,89 @@
+package repository_test
+
+import (
+	"anyway/internal/domain"
+	"anyway/internal/infrastructure/repository"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/go-redis/redismock/v9"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestRedisStreamsProduce tests that the message is added to the stream of its topic
+func TestRedisStreamsProduce(t *testing.T) {
+	// Create a mock redis client
+	client, redisMock := redismock.NewClientMock()
+
+	// Expect XADD to be called with the key, content and sorted headers of the message
+	redisMock.ExpectXAdd(&redis.XAddArgs{
+		Stream: "orders",
+		MaxLen: 1000,
+		Approx: true,
+		Values: []interface{}{
+			"key", "message-key",
+			"content", []byte("test-content"),
+			"header:request_id", "test-request-id",
+			"header:source", "my-app",
+		},
+	}).SetVal("1-0")
+
+	// Create a new RedisStreamsRepository instance
+	redisRepository := repository.NewRedisStreamsRepository(client, "default-topic", 1000)
+
+	// Call the Produce method
+	ctx := domain.WithMetadata(context.Background(), domain.Metadata{RequestID: "test-request-id"})
+	_, err := redisRepository.Produce(ctx, domain.Message{
+		Topic:   "orders",
+		Key:     "message-key",
+		Headers: map[string]string{"source": "my-app"},
+		Content: []byte("test-content"),
+	})
+
+	// Assert that no error is returned and the expected commands were sent
+	assert.NoError(t, err)
+	assert.NoError(t, redisMock.ExpectationsWereMet())
+}
+
+// TestRedisStreamsProduceError tests the Produce method when Redis returns an error
+func TestRedisStreamsProduceError(t *testing.T) {
+	// Create a mock redis client
+	client, redisMock := redismock.NewClientMock()
+
+	// Expect XADD to the default stream to fail
+	expectedErr := errors.New("connection refused")
+	redisMock.ExpectXAdd(&redis.XAddArgs{
+		Stream: "default-topic",
+		Values: []interface{}{"key", "", "content", []byte("test-content")},
+	}).SetErr(expectedErr)
+
+	// Create a new RedisStreamsRepository instance without trimming
+	redisRepository := repository.NewRedisStreamsRepository(client, "default-topic", 0)
+
+	// Call the Produce method
+	_, err := redisRepository.Produce(context.Background(), domain.Message{Content: []byte("test-content")})
+
+	// Assert that the expected error is returned
+	assert.EqualError(t, err, expectedErr.Error())
+	assert.NoError(t, redisMock.ExpectationsWereMet())
+}
+
+// TestRedisStreamsHealthCheck tests that the health check pings Redis
+func TestRedisStreamsHealthCheck(t *testing.T) {
+	// Create a mock redis client answering the first PING and failing the second one
+	client, redisMock := redismock.NewClientMock()
+	redisMock.ExpectPing().SetVal("PONG")
+	redisMock.ExpectPing().SetErr(errors.New("connection refused"))
+
+	// Create a new RedisStreamsRepository instance
+	redisRepository := repository.NewRedisStreamsRepository(client, "default-topic", 0)
+
+	// Assert the reported status
+	assert.True(t, redisRepository.HealthCheck(context.Background())[0].Healthy)
+	status := redisRepository.HealthCheck(context.Background())[0]
+	assert.False(t, status.Healthy)
+	assert.Equal(t, "connection refused", status.Error)
+	assert.NoError(t, redisMock.ExpectationsWereMet())
+}
@@ -0,0 +1,254 @@
+package repository
+
+import (
+	"anyway/internal/domain"
+	"anyway/internal/metrics"
+	"context"
+	"errors"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/rs/zerolog/log"
+	"math/rand/v2"
+	"strings"
+	"time"
+)
+
+// RetryPolicy configures how a failed message is produced again
+type RetryPolicy struct {
+	// MaxAttempts is the number of times a message is produced, counting the first one
+	MaxAttempts int
+	// InitialBackoff is the wait before the first retry, doubled on every retry up to MaxBackoff
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// Jitter is the fraction of every wait that is randomised, from 0 to 1, so retries do not synchronise
+	Jitter float64
+	// Timeout bounds all the attempts of a message; zero leaves it to the request context
+	Timeout time.Duration
+	// AttemptTimeout bounds every attempt, so a backend that does not answer leaves time to retry; zero disables it
+	AttemptTimeout time.Duration
+}
+
+// permanentKafkaErrors are the Kafka errors that fail again however many times the message is produced
+var permanentKafkaErrors = []kafka.ErrorCode{
+	kafka.ErrInvalidArg,
+	kafka.ErrInvalidMsg,
+	kafka.ErrInvalidMsgSize,
+	kafka.ErrMsgSizeTooLarge,
+	kafka.ErrRecordListTooLarge,
+	kafka.ErrInvalidRecord,
+	kafka.ErrTopicException,
+	kafka.ErrTopicAuthorizationFailed,
+}
+
+// IsRetryable tells whether producing the message again may succeed.
+// Cancelled requests, rejected messages, open circuit breakers and permanent Kafka errors are not retried;
+// any other error is, including an attempt that ran out of time.
+func IsRetryable(err error) bool {
+	switch {
+	case err == nil:
+		return false
+	case errors.Is(err, context.Canceled):
+		return false
+	case errors.Is(err, domain.ErrTopicNotAllowed), errors.Is(err, domain.ErrInvalidMessage):
+		return false
+	case errors.Is(err, domain.ErrCircuitOpen):
+		return false
+	}
+	var kafkaErr kafka.Error
+	if errors.As(err, &kafkaErr) {
+		if kafkaErr.IsFatal() {
+			return false
+		}
+		for _, code := range permanentKafkaErrors {
+			if kafkaErr.Code() == code {
+				return false
+			}
+		}
+		return true
+	}
+	// anysher reports the delivery errors as text, so their code is matched by its description
+	for _, code := range permanentKafkaErrors {
+		if strings.Contains(err.Error(), code.String()) {
+			return false
+		}
+	}
+	return true
+}
+
+// IsPermanent tells whether the message fails however many times it is produced.
+// Unlike IsRetryable, an open circuit breaker and a request that is cancelled or runs out of time are not permanent.
+func IsPermanent(err error) bool {
+	return err != nil && !IsRetryable(err) && !errors.Is(err, domain.ErrCircuitOpen) &&
+		!errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+}
+
+// RetryRepository wraps a ProducerRepository, producing again the messages that fail with a retryable error.
+// The waits between attempts grow exponentially with jitter, and every attempt is bound to the request
+// context, to the timeout of the policy and to its own attempt timeout. Messages given up on, because they
+// failed with a permanent error or used all the attempts or the time, fail with a *domain.UndeliverableError.
+type RetryRepository struct {
+	next      domain.ProducerRepository
+	name      string
+	policy    RetryPolicy
+	retryable func(error) bool
+}
+
+// NewRetryRepository wraps next with the retry policy; name identifies the backend in logs and metrics.
+// The errors are classified by IsRetryable.
+func NewRetryRepository(next domain.ProducerRepository, name string, policy RetryPolicy) *RetryRepository {
+	return &RetryRepository{
+		next:      next,
+		name:      name,
+		policy:    policy,
+		retryable: IsRetryable,
+	}
+}
+
+// Produce produces the message until it succeeds, fails with a permanent error or runs out of attempts or time.
+// The receipt reports the number of attempts.
+func (r *RetryRepository) Produce(ctx context.Context, message domain.Message) (domain.Receipt, error) {
+	budget, cancel := r.deadline(ctx)
+	defer cancel()
+
+	var receipt domain.Receipt
+	var err error
+	attempt := 1
+	for ; ; attempt++ {
+		attemptCtx, cancelAttempt := r.attemptDeadline(budget)
+		receipt, err = r.next.Produce(attemptCtx, message)
+		cancelAttempt()
+		if err == nil || !r.retry(budget, attempt, err) {
+			break
+		}
+	}
+	receipt.Attempts = attempt
+	if err != nil && attempt > 1 {
+		log.Ctx(ctx).Error().Err(err).Int("attempts", attempt).Msgf("Failed to produce message to %s", r.name)
+	} else if attempt > 1 {
+		log.Ctx(ctx).Info().Int("attempts", attempt).Msgf("Produced message to %s after retrying", r.name)
+	}
+	return receipt, r.undeliverable(ctx, attempt, err)
+}
+
+// ProduceBatch produces the batch, producing again only the messages that fail with a retryable error
+func (r *RetryRepository) ProduceBatch(ctx context.Context, messages []domain.Message) []error {
+	budget, cancel := r.deadline(ctx)
+	defer cancel()
+
+	errs := r.produceBatch(budget, messages)
+	attempts := make([]int, len(messages))
+	for i := range attempts {
+		attempts[i] = 1
+	}
+	for attempt := 1; ; attempt++ {
+		var pending []int
+		var pendingErr error
+		for i, err := range errs {
+			if err != nil && r.retryable(err) {
+				pending = append(pending, i)
+				pendingErr = err
+			}
+		}
+		if len(pending) == 0 || !r.retry(budget, attempt, pendingErr) {
+			if len(pending) > 0 && attempt > 1 {
+				log.Ctx(ctx).Error().Int("attempts", attempt).Msgf("Failed to produce %d messages of the batch to %s", len(pending), r.name)
+			}
+			for i, err := range errs {
+				errs[i] = r.undeliverable(ctx, attempts[i], err)
+			}
+			return errs
+		}
+
+		retried := make([]domain.Message, len(pending))
+		for j, i := range pending {
+			retried[j] = messages[i]
+		}
+		for j, err := range r.produceBatch(budget, retried) {
+			errs[pending[j]] = err
+			attempts[pending[j]]++
+		}
+	}
+}
+
+// HealthCheck reports the health of the wrapped repository
+func (r *RetryRepository) HealthCheck(ctx context.Context) []domain.DependencyStatus {
+	return r.next.HealthCheck(ctx)
+}
+
+// Close closes the wrapped repository
+func (r *RetryRepository) Close() {
+	r.next.Close()
+}
+
+// produceBatch produces the batch once, bound by the attempt timeout of the policy
+func (r *RetryRepository) produceBatch(ctx context.Context, messages []domain.Message) []error {
+	ctx, cancel := r.attemptDeadline(ctx)
+	defer cancel()
+	return r.next.ProduceBatch(ctx, messages)
+}
+
+// undeliverable wraps the error of a message in a *domain.UndeliverableError when it is given up on:
+// the error is permanent, or it is retryable and the attempts or the timeout of the policy ran out.
+// Messages stopped by an open circuit breaker or by the end of the request context ctx are not given up on,
+// since they may be sent again.
+func (r *RetryRepository) undeliverable(ctx context.Context, attempts int, err error) error {
+	if err == nil || ctx.Err() != nil {
+		return err
+	}
+	if IsPermanent(err) || r.retryable(err) {
+		return &domain.UndeliverableError{Err: err, Attempts: attempts}
+	}
+	return err
+}
+
+// attemptDeadline bounds the context of a single attempt by the attempt timeout of the policy
+func (r *RetryRepository) attemptDeadline(ctx context.Context) (context.Context, context.CancelFunc) {
+	if r.policy.AttemptTimeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, r.policy.AttemptTimeout)
+}
+
+// deadline bounds the context of all the attempts by the timeout of the policy
+func (r *RetryRepository) deadline(ctx context.Context) (context.Context, context.CancelFunc) {
+	if r.policy.Timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, r.policy.Timeout)
+}
+
+// retry waits before the next attempt after the given one failed with err.
+// It returns false without waiting when the error is permanent, the attempts are exhausted
+// or the context would end before the wait does.
+func (r *RetryRepository) retry(ctx context.Context, attempt int, err error) bool {
+	if attempt >= r.policy.MaxAttempts || !r.retryable(err) {
+		return false
+	}
+	wait := r.backoff(attempt)
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+		return false
+	}
+	log.Ctx(ctx).Warn().Err(err).Int("attempt", attempt).Msgf("Failed to produce message to %s, retrying in %s", r.name, wait)
+	metrics.ProduceRetries.WithLabelValues(r.name).Inc()
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// backoff returns the wait after the given attempt: the initial backoff doubled on every attempt,
+// capped at the maximum and reduced by a random fraction up to the jitter
+func (r *RetryRepository) backoff(attempt int) time.Duration {
+	wait := r.policy.InitialBackoff
+	for i := 1; i < attempt && (r.policy.MaxBackoff <= 0 || wait < r.policy.MaxBackoff); i++ {
+		wait *= 2
+	}
+	if r.policy.MaxBackoff > 0 && wait > r.policy.MaxBackoff {
+		wait = r.policy.MaxBackoff
+	}
+	return wait - time.Duration(r.policy.Jitter*rand.Float64()*float64(wait))
+}
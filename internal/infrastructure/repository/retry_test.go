@@ -0,0 +1,210 @@
+package repository_test
+
+import (
+	"anyway/internal/domain"
+	"anyway/internal/infrastructure/repository"
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// retryPolicy is a policy of three attempts with short waits
+var retryPolicy = repository.RetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: time.Millisecond,
+	MaxBackoff:     5 * time.Millisecond,
+	Jitter:         0.5,
+}
+
+// TestRetryProduce tests that retryable errors are retried until the message is produced or the attempts run out
+func TestRetryProduce(t *testing.T) {
+	transient := errors.New("delivery failed to Kafka topic orders: Local: Message timed out")
+	permanent := errors.New("delivery failed to Kafka topic orders: Broker: Message size too large")
+	circuitOpen := &domain.CircuitOpenError{Backend: "kafka", RetryAfter: time.Second}
+	tests := []struct {
+		name             string
+		errs             []error
+		expectedAttempts int
+		expectedErr      error
+		// expectedGivenUp tells whether the message is reported as undeliverable
+		expectedGivenUp bool
+	}{
+		{name: "first attempt succeeds", errs: []error{nil}, expectedAttempts: 1},
+		{name: "retry succeeds", errs: []error{transient, transient, nil}, expectedAttempts: 3},
+		{name: "attempts run out", errs: []error{transient, transient, transient}, expectedAttempts: 3, expectedErr: transient, expectedGivenUp: true},
+		{name: "permanent error", errs: []error{permanent}, expectedAttempts: 1, expectedErr: permanent, expectedGivenUp: true},
+		{name: "circuit open", errs: []error{circuitOpen}, expectedAttempts: 1, expectedErr: circuitOpen},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			message := domain.Message{Content: []byte("test-content")}
+
+			// Create a mock producer repository returning every error in turn
+			mockRepo := new(MockProducerRepository)
+			for _, err := range tt.errs {
+				mockRepo.On("Produce", mock.Anything, message).Return(domain.Receipt{}, err).Once()
+			}
+
+			// Call the Produce method
+			receipt, err := repository.NewRetryRepository(mockRepo, "kafka", retryPolicy).Produce(context.Background(), message)
+
+			// Assert the outcome and the attempts
+			if tt.expectedErr == nil {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, tt.expectedErr)
+				assert.EqualError(t, err, tt.expectedErr.Error())
+			}
+			assert.Equal(t, tt.expectedGivenUp, errors.Is(err, domain.ErrUndeliverable))
+			assert.Equal(t, tt.expectedAttempts, receipt.Attempts)
+
+			// Assert that the expected methods were called on the mock
+			mockRepo.AssertExpectations(t)
+		})
+	}
+}
+
+// TestRetryProduceTimeout tests that no retry starts once the timeout would end before the wait does,
+// and the message is given up on
+func TestRetryProduceTimeout(t *testing.T) {
+	message := domain.Message{Content: []byte("test-content")}
+
+	// Create a mock producer repository that always fails
+	mockRepo := new(MockProducerRepository)
+	mockRepo.On("Produce", mock.Anything, message).Return(domain.Receipt{}, errors.New("connection refused")).Once()
+
+	policy := repository.RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Second, Timeout: 100 * time.Millisecond}
+	start := time.Now()
+	receipt, err := repository.NewRetryRepository(mockRepo, "kafka", policy).Produce(context.Background(), message)
+
+	// Assert that the message was given up on right away after a single attempt
+	assert.ErrorIs(t, err, domain.ErrUndeliverable)
+	assert.Equal(t, 1, receipt.Attempts)
+	assert.Less(t, time.Since(start), 100*time.Millisecond)
+
+	// Assert that the expected methods were called on the mock
+	mockRepo.AssertExpectations(t)
+}
+
+// TestRetryProduceCancelled tests that the retries stop when the request is cancelled
+func TestRetryProduceCancelled(t *testing.T) {
+	message := domain.Message{Content: []byte("test-content")}
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// Create a mock producer repository that fails and cancels the request
+	mockRepo := new(MockProducerRepository)
+	mockRepo.On("Produce", mock.Anything, message).Run(func(mock.Arguments) {
+		time.AfterFunc(10*time.Millisecond, cancel)
+	}).Return(domain.Receipt{}, errors.New("connection refused")).Once()
+
+	policy := repository.RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Minute}
+	receipt, err := repository.NewRetryRepository(mockRepo, "kafka", policy).Produce(ctx, message)
+
+	// Assert that the wait was interrupted without giving up on the message
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, domain.ErrUndeliverable)
+	assert.Equal(t, 1, receipt.Attempts)
+
+	// Assert that the expected methods were called on the mock
+	mockRepo.AssertExpectations(t)
+}
+
+// TestRetryProduceAttemptTimeout tests that an attempt that runs out of its own time is retried,
+// and the message is given up on once every attempt did
+func TestRetryProduceAttemptTimeout(t *testing.T) {
+	message := domain.Message{Content: []byte("test-content")}
+
+	// Create a mock producer repository that blocks until the attempt ends, as a broker that does not answer
+	mockRepo := new(MockProducerRepository)
+	mockRepo.On("Produce", mock.Anything, message).Run(func(args mock.Arguments) {
+		<-args.Get(0).(context.Context).Done()
+	}).Return(domain.Receipt{}, context.DeadlineExceeded).Times(3)
+
+	policy := retryPolicy
+	policy.Timeout = time.Second
+	policy.AttemptTimeout = 10 * time.Millisecond
+	receipt, err := repository.NewRetryRepository(mockRepo, "kafka", policy).Produce(context.Background(), message)
+
+	// Assert that every attempt was made and the message was given up on
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.ErrorIs(t, err, domain.ErrUndeliverable)
+	assert.Equal(t, 3, receipt.Attempts)
+
+	// Assert that the expected methods were called on the mock
+	mockRepo.AssertExpectations(t)
+}
+
+// TestRetryProduceBatch tests that only the messages failing with a retryable error are produced again,
+// and the ones given up on report their attempts
+func TestRetryProduceBatch(t *testing.T) {
+	messages := []domain.Message{
+		{Content: []byte("first")},
+		{Content: []byte("second")},
+		{Content: []byte("third")},
+		{Content: []byte("fourth")},
+	}
+	transient := errors.New("connection refused")
+
+	// Create a mock producer repository failing the second message once, the third one for good
+	// and the fourth one on every attempt
+	mockRepo := new(MockProducerRepository)
+	mockRepo.On("ProduceBatch", mock.Anything, messages).Return([]error{nil, transient, domain.ErrInvalidMessage, transient}).Once()
+	mockRepo.On("ProduceBatch", mock.Anything, []domain.Message{messages[1], messages[3]}).Return([]error{nil, transient}).Once()
+	mockRepo.On("ProduceBatch", mock.Anything, []domain.Message{messages[3]}).Return([]error{transient}).Once()
+
+	// Call the ProduceBatch method
+	errs := repository.NewRetryRepository(mockRepo, "kafka", retryPolicy).ProduceBatch(context.Background(), messages)
+
+	// Assert the outcome of every message
+	assert.NoError(t, errs[0])
+	assert.NoError(t, errs[1])
+	assert.Equal(t, &domain.UndeliverableError{Err: domain.ErrInvalidMessage, Attempts: 1}, errs[2])
+	assert.Equal(t, &domain.UndeliverableError{Err: transient, Attempts: 3}, errs[3])
+
+	// Assert that the expected methods were called on the mock
+	mockRepo.AssertExpectations(t)
+}
+
+// TestIsPermanent tests that open circuit breakers and cancelled requests are not permanent errors
+func TestIsPermanent(t *testing.T) {
+	assert.False(t, repository.IsPermanent(nil))
+	assert.False(t, repository.IsPermanent(errors.New("dial tcp: connection refused")))
+	assert.False(t, repository.IsPermanent(&domain.CircuitOpenError{Backend: "kafka"}))
+	assert.False(t, repository.IsPermanent(fmt.Errorf("send: %w", context.Canceled)))
+	assert.False(t, repository.IsPermanent(context.DeadlineExceeded))
+	assert.True(t, repository.IsPermanent(domain.ErrInvalidMessage))
+	assert.True(t, repository.IsPermanent(kafka.NewError(kafka.ErrMsgSizeTooLarge, "too large", false)))
+}
+
+// TestIsRetryable tests the classification of the errors
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{name: "no error", err: nil, expected: false},
+		{name: "network error", err: errors.New("dial tcp: connection refused"), expected: true},
+		{name: "cancelled request", err: fmt.Errorf("send: %w", context.Canceled), expected: false},
+		{name: "deadline exceeded", err: context.DeadlineExceeded, expected: true},
+		{name: "invalid message", err: &domain.ValidationError{Schema: "orders"}, expected: false},
+		{name: "topic not allowed", err: domain.ErrTopicNotAllowed, expected: false},
+		{name: "retriable kafka error", err: fmt.Errorf("failed to produce: %w", kafka.NewError(kafka.ErrQueueFull, "queue full", false)), expected: true},
+		{name: "permanent kafka error", err: fmt.Errorf("failed to produce: %w", kafka.NewError(kafka.ErrMsgSizeTooLarge, "too large", false)), expected: false},
+		{name: "fatal kafka error", err: kafka.NewError(kafka.ErrFatal, "fenced", true), expected: false},
+		{name: "permanent delivery error", err: errors.New("delivery failed to Kafka topic orders: Broker: Topic authorization failed"), expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, repository.IsRetryable(tt.err))
+		})
+	}
+}
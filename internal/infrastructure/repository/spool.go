@@ -0,0 +1,290 @@
+package repository
+
+import (
+	"anyway/internal/domain"
+	"anyway/internal/infrastructure/spool"
+	"anyway/internal/metrics"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"strconv"
+	"time"
+)
+
+// spoolRecord is a message stored in the spool along with the request metadata used to produce it
+type spoolRecord struct {
+	Message domain.Message `json:"message"`
+	domain.Metadata
+	SpooledAt time.Time `json:"spooled_at"`
+}
+
+// SpoolRepository wraps a ProducerRepository with a write-ahead spool on local disk.
+// Messages that cannot be produced are appended to the spool and replayed in order in the
+// background once the wrapped repository accepts them again. While the spool holds messages,
+// new ones are appended behind them so the order is kept. Messages that fail with a permanent
+// error are not spooled, and spooled messages that fail with one are dead-lettered or moved aside.
+type SpoolRepository struct {
+	next          domain.ProducerRepository
+	name          string
+	spool         *spool.Spool
+	retryInterval time.Duration
+	deadLetters   domain.DeadLetterQueue
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewSpoolRepository wraps next, the repository of the backend with the given name, with the given spool
+// and starts replaying it. When the wrapped repository fails, the replay is retried every retryInterval.
+// Spooled messages that fail permanently are published to deadLetters, or moved aside
+// to the rejected file of the spool when it is nil or the publication fails.
+func NewSpoolRepository(next domain.ProducerRepository, name string, spool *spool.Spool, retryInterval time.Duration, deadLetters domain.DeadLetterQueue) *SpoolRepository {
+	r := &SpoolRepository{
+		next:          next,
+		name:          name,
+		spool:         spool,
+		retryInterval: retryInterval,
+		deadLetters:   deadLetters,
+		stop:          make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+	r.updateMetrics()
+	go r.replay()
+	return r
+}
+
+// Produce produces the message with the wrapped repository, spooling it when that fails.
+// It returns an error when the message failed permanently or could not be spooled.
+func (r *SpoolRepository) Produce(ctx context.Context, message domain.Message) (domain.Receipt, error) {
+	if r.spool.Stats().Entries == 0 {
+		receipt, err := r.next.Produce(ctx, message)
+		if err == nil {
+			return receipt, nil
+		}
+		if !spoolable(err) {
+			return receipt, err
+		}
+		log.Ctx(ctx).Warn().Err(err).Msg("Failed to produce message, spooling it")
+	}
+	return domain.Receipt{}, r.append(ctx, message)
+}
+
+// ProduceBatch produces the messages with the wrapped repository, spooling the ones that fail
+func (r *SpoolRepository) ProduceBatch(ctx context.Context, messages []domain.Message) []error {
+	errs := make([]error, len(messages))
+	if r.spool.Stats().Entries == 0 {
+		errs = r.next.ProduceBatch(ctx, messages)
+	} else {
+		for i := range errs {
+			errs[i] = errSpoolPending
+		}
+	}
+	for i, err := range errs {
+		if err == nil || !spoolable(err) {
+			continue
+		}
+		errs[i] = r.append(ctx, messages[i])
+	}
+	return errs
+}
+
+// HealthCheck reports the health of the wrapped repository and the depth of the spool.
+// The spool is unhealthy when it is full, since new failures would then be lost.
+func (r *SpoolRepository) HealthCheck(ctx context.Context) []domain.DependencyStatus {
+	stats := r.spool.Stats()
+	status := domain.DependencyStatus{
+		Name:    "spool",
+		Healthy: stats.MaxBytes <= 0 || stats.Bytes < stats.MaxBytes,
+		Details: map[string]string{
+			"backend":   r.name,
+			"messages":  strconv.Itoa(stats.Entries),
+			"bytes":     strconv.FormatInt(stats.Bytes, 10),
+			"max_bytes": strconv.FormatInt(stats.MaxBytes, 10),
+		},
+	}
+	if !status.Healthy {
+		status.Error = spool.ErrFull.Error()
+	}
+	return append(r.next.HealthCheck(ctx), status)
+}
+
+// SpoolStats returns the depth of the spool
+func (r *SpoolRepository) SpoolStats() domain.SpoolStats {
+	stats := r.spool.Stats()
+	return domain.SpoolStats{
+		Messages: stats.Entries,
+		Bytes:    stats.Bytes,
+		Segments: stats.Segments,
+		MaxBytes: stats.MaxBytes,
+	}
+}
+
+// Close stops the replay, closes the spool and the wrapped repository.
+// Messages still in the spool are replayed the next time the service starts.
+func (r *SpoolRepository) Close() {
+	close(r.stop)
+	<-r.done
+	if err := r.spool.Close(); err != nil {
+		log.Error().Err(err).Msg("Failed to close spool")
+	}
+	r.next.Close()
+}
+
+// errSpoolPending marks the messages of a batch that are spooled because older messages are still pending
+var errSpoolPending = errors.New("older messages are pending in the spool")
+
+// spoolable tells whether a message that failed with err may be produced later.
+// Besides the retryable errors, an open circuit and a request that ran out of time are spooled,
+// since they stop failing once the backend recovers.
+func spoolable(err error) bool {
+	return !IsPermanent(err)
+}
+
+// append writes the message to the spool
+func (r *SpoolRepository) append(ctx context.Context, message domain.Message) error {
+	record := spoolRecord{
+		Message:   message,
+		Metadata:  domain.MetadataFromContext(ctx),
+		SpooledAt: time.Now(),
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to encode spool record: %w", err)
+	}
+	if err := r.spool.Append(data); err != nil {
+		metrics.SpoolDropped.WithLabelValues("append_failed").Inc()
+		log.Ctx(ctx).Error().Err(err).Msg("Failed to spool message")
+		// the message is given up on, so it is routed to the dead-letter queue
+		return &domain.UndeliverableError{Err: fmt.Errorf("message could not be produced nor spooled: %w", err)}
+	}
+	metrics.SpoolAppended.Inc()
+	r.updateMetrics()
+	return nil
+}
+
+// replay produces the spooled messages every retryInterval until Close is called
+func (r *SpoolRepository) replay() {
+	defer close(r.done)
+
+	ticker := time.NewTicker(r.retryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+		}
+		r.replayPending()
+	}
+}
+
+// replayPending produces the spooled messages in order, stopping at the first failure that may stop later.
+// Messages that fail permanently, and records that cannot be decoded, are rejected, so they do not block the ones behind them.
+func (r *SpoolRepository) replayPending() {
+	for {
+		select {
+		case <-r.stop:
+			return
+		default:
+		}
+		data, err := r.spool.Peek()
+		if errors.Is(err, spool.ErrEmpty) {
+			return
+		}
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to read spool")
+			return
+		}
+		var record spoolRecord
+		if err := json.Unmarshal(data, &record); err != nil {
+			metrics.SpoolDropped.WithLabelValues("corrupted").Inc()
+			log.Error().Err(err).Msg("Failed to decode spool record, moving it aside")
+			if err := r.spool.Reject(); err != nil {
+				log.Error().Err(err).Msg("Failed to move spool record aside")
+				return
+			}
+			r.updateMetrics()
+			continue
+		}
+		_, err = r.next.Produce(domain.WithMetadata(context.Background(), record.Metadata), record.Message)
+		if err != nil && spoolable(err) {
+			log.Warn().Err(err).Msg("Failed to replay spooled message, retrying later")
+			return
+		}
+		if err != nil {
+			r.reject(record, err)
+			continue
+		}
+		metrics.SpoolReplayed.Inc()
+		r.ack()
+	}
+}
+
+// reject publishes the spooled message that failed permanently to the dead-letter queue and removes it
+// from the spool. When there is no dead-letter queue or it fails, the message is moved aside instead.
+func (r *SpoolRepository) reject(record spoolRecord, err error) {
+	if r.deadLetters != nil {
+		publishErr := r.deadLetters.Publish(context.Background(), spoolDeadLetter(record, err))
+		if publishErr == nil {
+			metrics.DeadLetters.WithLabelValues(domain.DeadLetterUndeliverable, "published").Inc()
+			log.Error().Err(err).Msg("Spooled message failed permanently, routed it to the dead-letter queue")
+			r.ack()
+			return
+		}
+		metrics.DeadLetters.WithLabelValues(domain.DeadLetterUndeliverable, "failed").Inc()
+		log.Error().Err(publishErr).Msg("Failed to route spooled message to the dead-letter queue")
+	}
+	metrics.SpoolDropped.WithLabelValues("rejected").Inc()
+	log.Error().Err(err).Msg("Spooled message failed permanently, moving it aside")
+	if err := r.spool.Reject(); err != nil {
+		log.Error().Err(err).Msg("Failed to move spool record aside")
+	}
+	r.updateMetrics()
+}
+
+// spoolDeadLetter builds the dead letter of a spooled message that failed permanently
+func spoolDeadLetter(record spoolRecord, err error) domain.DeadLetter {
+	deadLetter := domain.DeadLetter{
+		Topic:         record.Message.Topic,
+		Key:           record.Message.Key,
+		Content:       record.Message.Content,
+		Reason:        domain.DeadLetterUndeliverable,
+		Error:         err.Error(),
+		RequestID:     record.RequestID,
+		CorrelationID: record.CorrelationID,
+		Caller:        record.Caller,
+		ReceivedAt:    record.SpooledAt.UTC(),
+		FailedAt:      time.Now().UTC(),
+	}
+	if deadLetter.Key == "" {
+		deadLetter.Key = record.RoutingID
+	}
+	if len(record.Headers)+len(record.Message.Headers) > 0 {
+		deadLetter.Headers = make(map[string]string, len(record.Headers)+len(record.Message.Headers))
+		for k, v := range record.Headers {
+			deadLetter.Headers[k] = v
+		}
+		for k, v := range record.Message.Headers {
+			deadLetter.Headers[k] = v
+		}
+	}
+	return deadLetter
+}
+
+// ack removes the replayed message from the spool
+func (r *SpoolRepository) ack() {
+	if err := r.spool.Ack(); err != nil {
+		log.Error().Err(err).Msg("Failed to acknowledge spool record")
+	}
+	r.updateMetrics()
+}
+
+// updateMetrics publishes the depth of the spool
+func (r *SpoolRepository) updateMetrics() {
+	stats := r.spool.Stats()
+	metrics.SpoolMessages.WithLabelValues(r.name).Set(float64(stats.Entries))
+	metrics.SpoolBytes.WithLabelValues(r.name).Set(float64(stats.Bytes))
+}
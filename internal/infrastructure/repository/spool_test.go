@@ -0,0 +1,279 @@
+package repository_test
+
+import (
+	"anyway/config"
+	"anyway/internal/domain"
+	"anyway/internal/infrastructure/repository"
+	"anyway/internal/infrastructure/spool"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// MockProducerRepository is a mock implementation of domain.ProducerRepository
+type MockProducerRepository struct {
+	mock.Mock
+}
+
+// Produce mocks the Produce method of ProducerRepository
+func (m *MockProducerRepository) Produce(ctx context.Context, message domain.Message) (domain.Receipt, error) {
+	args := m.Called(ctx, message)
+	return args.Get(0).(domain.Receipt), args.Error(1)
+}
+
+// ProduceBatch mocks the ProduceBatch method of ProducerRepository
+func (m *MockProducerRepository) ProduceBatch(ctx context.Context, messages []domain.Message) []error {
+	args := m.Called(ctx, messages)
+	return args.Get(0).([]error)
+}
+
+// HealthCheck mocks the HealthCheck method of ProducerRepository
+func (m *MockProducerRepository) HealthCheck(ctx context.Context) []domain.DependencyStatus {
+	args := m.Called(ctx)
+	return args.Get(0).([]domain.DependencyStatus)
+}
+
+// Close mocks the Close method of ProducerRepository
+func (m *MockProducerRepository) Close() {
+	m.Called()
+}
+
+// newSpoolRepository creates a SpoolRepository over a temporary spool
+func newSpoolRepository(t *testing.T, next domain.ProducerRepository, maxBytes int64, retryInterval time.Duration) *repository.SpoolRepository {
+	messageSpool, err := spool.Open(t.TempDir(), maxBytes, 1<<20)
+	require.NoError(t, err)
+	return repository.NewSpoolRepository(next, "kafka", messageSpool, retryInterval, nil)
+}
+
+// TestSpoolProduceSuccess tests that messages are produced directly while the wrapped repository works
+func TestSpoolProduceSuccess(t *testing.T) {
+	// Create a mock producer repository that succeeds
+	mockRepo := new(MockProducerRepository)
+	mockRepo.On("Produce", mock.Anything, mock.Anything).Return(domain.Receipt{}, nil).Once()
+	mockRepo.On("Close").Return().Once()
+
+	// Create a new SpoolRepository instance
+	spoolRepository := newSpoolRepository(t, mockRepo, 0, time.Hour)
+
+	// Call the Produce method
+	_, err := spoolRepository.Produce(context.Background(), domain.Message{Content: []byte("test-content")})
+
+	// Assert that the message was produced and not spooled
+	assert.NoError(t, err)
+	assert.Equal(t, 0, spoolRepository.SpoolStats().Messages)
+	spoolRepository.Close()
+	mockRepo.AssertExpectations(t)
+}
+
+// TestSpoolProduceFailureIsReplayed tests that failed messages are spooled and replayed in order
+func TestSpoolProduceFailureIsReplayed(t *testing.T) {
+	// Create a mock producer repository that fails once and then succeeds
+	mockRepo := new(MockProducerRepository)
+	replayed := make(chan string, 2)
+	mockRepo.On("Produce", mock.Anything, domain.Message{Content: []byte("first")}).Return(domain.Receipt{}, errors.New("broker unavailable")).Once()
+	mockRepo.On("Produce", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		ctx := args.Get(0).(context.Context)
+		assert.Equal(t, "test-request-id", domain.MetadataFromContext(ctx).RequestID)
+		replayed <- string(args.Get(1).(domain.Message).Content)
+	}).Return(domain.Receipt{}, nil).Twice()
+	mockRepo.On("Close").Return().Once()
+
+	// Create a new SpoolRepository instance replaying often
+	spoolRepository := newSpoolRepository(t, mockRepo, 0, 10*time.Millisecond)
+
+	// Produce a message that fails and another one while the first is still spooled
+	ctx := domain.WithMetadata(context.Background(), domain.Metadata{RequestID: "test-request-id"})
+	_, err := spoolRepository.Produce(ctx, domain.Message{Content: []byte("first")})
+	assert.NoError(t, err)
+	_, err = spoolRepository.Produce(ctx, domain.Message{Content: []byte("second")})
+	assert.NoError(t, err)
+
+	// Assert that both messages are replayed in order with the request values
+	assert.Equal(t, "first", <-replayed)
+	assert.Equal(t, "second", <-replayed)
+	assert.Eventually(t, func() bool {
+		return spoolRepository.SpoolStats().Messages == 0
+	}, time.Second, 10*time.Millisecond)
+	spoolRepository.Close()
+	mockRepo.AssertExpectations(t)
+}
+
+// TestSpoolFull tests that an error is returned when the message can neither be produced nor spooled
+func TestSpoolFull(t *testing.T) {
+	// Create a mock producer repository that fails
+	mockRepo := new(MockProducerRepository)
+	mockRepo.On("Produce", mock.Anything, mock.Anything).Return(domain.Receipt{}, errors.New("broker unavailable"))
+	mockRepo.On("HealthCheck", mock.Anything).Return([]domain.DependencyStatus{{Name: "kafka"}})
+	mockRepo.On("Close").Return().Once()
+
+	// Create a new SpoolRepository instance with room for a single small message
+	spoolRepository := newSpoolRepository(t, mockRepo, 100, time.Hour)
+
+	// Assert that the first message is spooled and the second one is rejected
+	_, err := spoolRepository.Produce(context.Background(), domain.Message{Content: []byte("first")})
+	assert.NoError(t, err)
+	_, err = spoolRepository.Produce(context.Background(), domain.Message{Content: []byte("second")})
+	assert.ErrorIs(t, err, spool.ErrFull)
+	assert.ErrorIs(t, err, domain.ErrUndeliverable)
+
+	// Assert that the health check reports the spool along with the wrapped repository
+	status := spoolRepository.HealthCheck(context.Background())
+	assert.Len(t, status, 2)
+	assert.Equal(t, "spool", status[1].Name)
+	assert.Equal(t, "1", status[1].Details["messages"])
+	spoolRepository.Close()
+}
+
+// TestSpoolProduceBatch tests that the failed messages of a batch are spooled
+func TestSpoolProduceBatch(t *testing.T) {
+	// Create a mock producer repository that fails the second message
+	mockRepo := new(MockProducerRepository)
+	messages := []domain.Message{{Content: []byte("first")}, {Content: []byte("second")}}
+	mockRepo.On("ProduceBatch", mock.Anything, messages).Return([]error{nil, errors.New("broker unavailable")}).Once()
+	mockRepo.On("Close").Return().Once()
+
+	// Create a new SpoolRepository instance
+	spoolRepository := newSpoolRepository(t, mockRepo, 0, time.Hour)
+
+	// Call the ProduceBatch method
+	errs := spoolRepository.ProduceBatch(context.Background(), messages)
+
+	// Assert that both messages are accepted and the failed one is spooled
+	assert.Equal(t, []error{nil, nil}, errs)
+	assert.Equal(t, 1, spoolRepository.SpoolStats().Messages)
+	spoolRepository.Close()
+	mockRepo.AssertExpectations(t)
+}
+
+// TestSpoolProducePermanentError tests that messages failing with a permanent error are not spooled
+func TestSpoolProducePermanentError(t *testing.T) {
+	// Create a mock producer repository that rejects the message
+	mockRepo := new(MockProducerRepository)
+	mockRepo.On("Produce", mock.Anything, mock.Anything).Return(domain.Receipt{}, fmt.Errorf("bad record: %w", domain.ErrInvalidMessage)).Once()
+	mockRepo.On("Close").Return().Once()
+
+	// Create a new SpoolRepository instance
+	spoolRepository := newSpoolRepository(t, mockRepo, 0, time.Hour)
+
+	// Assert that the error is returned and nothing is spooled
+	_, err := spoolRepository.Produce(context.Background(), domain.Message{Content: []byte("first")})
+	assert.ErrorIs(t, err, domain.ErrInvalidMessage)
+	assert.Equal(t, 0, spoolRepository.SpoolStats().Messages)
+	spoolRepository.Close()
+	mockRepo.AssertExpectations(t)
+}
+
+// TestSpoolReplayPermanentFailure tests that spooled messages failing with a permanent error are dead-lettered
+// and do not block the ones behind them
+func TestSpoolReplayPermanentFailure(t *testing.T) {
+	// Create a mock producer repository that fails once, then rejects the first message and accepts the second one
+	mockRepo := new(MockProducerRepository)
+	replayed := make(chan string, 1)
+	mockRepo.On("Produce", mock.Anything, mock.Anything).Return(domain.Receipt{}, errors.New("broker unavailable")).Once()
+	mockRepo.On("Produce", mock.Anything, domain.Message{Content: []byte("first")}).Return(domain.Receipt{}, fmt.Errorf("bad record: %w", domain.ErrInvalidMessage)).Once()
+	mockRepo.On("Produce", mock.Anything, domain.Message{Content: []byte("second")}).Run(func(args mock.Arguments) {
+		replayed <- string(args.Get(1).(domain.Message).Content)
+	}).Return(domain.Receipt{}, nil).Once()
+	mockRepo.On("Close").Return().Once()
+
+	// Create a file dead-letter queue
+	path := filepath.Join(t.TempDir(), "dead-letter.jsonl")
+	queue, err := repository.NewDeadLetterQueue(config.Config{DeadLetterBackend: repository.BackendFile, DeadLetterFilePath: path}, nil)
+	require.NoError(t, err)
+	defer queue.Close()
+
+	// Create a new SpoolRepository instance replaying often
+	messageSpool, err := spool.Open(t.TempDir(), 0, 1<<20)
+	require.NoError(t, err)
+	spoolRepository := repository.NewSpoolRepository(mockRepo, "kafka", messageSpool, 10*time.Millisecond, queue)
+
+	// Produce a message that fails and another one while the first is still spooled
+	ctx := domain.WithMetadata(context.Background(), domain.Metadata{RequestID: "test-request-id"})
+	_, err = spoolRepository.Produce(ctx, domain.Message{Content: []byte("first")})
+	assert.NoError(t, err)
+	_, err = spoolRepository.Produce(ctx, domain.Message{Content: []byte("second")})
+	assert.NoError(t, err)
+
+	// Assert that the second message is replayed and the spool ends empty
+	assert.Equal(t, "second", <-replayed)
+	assert.Eventually(t, func() bool {
+		return spoolRepository.SpoolStats().Messages == 0
+	}, time.Second, 10*time.Millisecond)
+	spoolRepository.Close()
+	mockRepo.AssertExpectations(t)
+
+	// Assert that the first message was dead-lettered
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var envelope map[string]interface{}
+	require.NoError(t, json.Unmarshal(data, &envelope))
+	assert.Equal(t, "Zmlyc3Q=", envelope["content"])
+	assert.Equal(t, "undeliverable", envelope["reason"])
+	assert.Equal(t, "test-request-id", envelope["request_id"])
+}
+
+// TestSpoolReplayPermanentFailureMovedAside tests that spooled messages failing with a permanent error
+// are moved aside when there is no dead-letter queue
+func TestSpoolReplayPermanentFailureMovedAside(t *testing.T) {
+	// Create a mock producer repository that fails once and then rejects the message
+	mockRepo := new(MockProducerRepository)
+	mockRepo.On("Produce", mock.Anything, mock.Anything).Return(domain.Receipt{}, errors.New("broker unavailable")).Once()
+	mockRepo.On("Produce", mock.Anything, mock.Anything).Return(domain.Receipt{}, fmt.Errorf("bad record: %w", domain.ErrInvalidMessage)).Once()
+	mockRepo.On("Close").Return().Once()
+
+	// Create a new SpoolRepository instance replaying often
+	dir := t.TempDir()
+	messageSpool, err := spool.Open(dir, 0, 1<<20)
+	require.NoError(t, err)
+	spoolRepository := repository.NewSpoolRepository(mockRepo, "kafka", messageSpool, 10*time.Millisecond, nil)
+
+	// Produce a message that fails
+	_, err = spoolRepository.Produce(context.Background(), domain.Message{Content: []byte("first")})
+	assert.NoError(t, err)
+
+	// Assert that the message leaves the spool and is kept in the rejected file
+	assert.Eventually(t, func() bool {
+		return spoolRepository.SpoolStats().Messages == 0
+	}, time.Second, 10*time.Millisecond)
+	spoolRepository.Close()
+	mockRepo.AssertExpectations(t)
+	data, err := os.ReadFile(filepath.Join(dir, "rejected.spool"))
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"content":"Zmlyc3Q="`)
+}
+
+// TestSpoolReplayUndecodableMovedAside tests that a spooled record that cannot be decoded is moved aside
+// instead of being deleted
+func TestSpoolReplayUndecodableMovedAside(t *testing.T) {
+	// Create a mock producer repository (it should not be called)
+	mockRepo := new(MockProducerRepository)
+	mockRepo.On("Close").Return().Once()
+
+	// Create a spool holding a record that is not a spooled message
+	dir := t.TempDir()
+	messageSpool, err := spool.Open(dir, 0, 1<<20)
+	require.NoError(t, err)
+	require.NoError(t, messageSpool.Append([]byte("not a spool record")))
+
+	// Create a new SpoolRepository instance replaying often
+	spoolRepository := repository.NewSpoolRepository(mockRepo, "kafka", messageSpool, 10*time.Millisecond, nil)
+
+	// Assert that the record leaves the spool and is kept in the rejected file
+	assert.Eventually(t, func() bool {
+		return spoolRepository.SpoolStats().Messages == 0
+	}, time.Second, 10*time.Millisecond)
+	spoolRepository.Close()
+	mockRepo.AssertExpectations(t)
+	data, err := os.ReadFile(filepath.Join(dir, "rejected.spool"))
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "not a spool record")
+}
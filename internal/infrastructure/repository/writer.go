@@ -0,0 +1,129 @@
+package repository
+
+import (
+	"anyway/internal/domain"
+	"anyway/internal/metrics"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// writtenMessage is the JSON line written for every message
+type writtenMessage struct {
+	Topic     string            `json:"topic"`
+	Key       string            `json:"key,omitempty"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	Content   []byte            `json:"content"`
+	Timestamp time.Time         `json:"timestamp"`
+}
+
+// WriterRepository implements the ProducerRepository interface writing every message
+// as a JSON line, with the same key and headers it would have in Kafka.
+type WriterRepository struct {
+	name   string
+	topic  string
+	writer io.Writer
+	closer io.Closer
+
+	mu        sync.Mutex
+	lastError error
+}
+
+// NewFileRepository creates a repository that appends the messages to the file at path
+func NewFileRepository(path, defaultTopic string) (domain.ProducerRepository, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file %s: %w", path, err)
+	}
+	return &WriterRepository{
+		name:   "file",
+		topic:  defaultTopic,
+		writer: file,
+		closer: file,
+	}, nil
+}
+
+// NewStdoutRepository creates a repository that writes the messages to the standard output
+func NewStdoutRepository(defaultTopic string) domain.ProducerRepository {
+	return NewWriterRepository("stdout", defaultTopic, os.Stdout)
+}
+
+// NewWriterRepository creates a repository that writes the messages to w, which is not closed
+func NewWriterRepository(name, defaultTopic string, w io.Writer) domain.ProducerRepository {
+	return &WriterRepository{
+		name:   name,
+		topic:  defaultTopic,
+		writer: w,
+	}
+}
+
+// Produce writes the message as a JSON line
+func (r *WriterRepository) Produce(ctx context.Context, message domain.Message) (domain.Receipt, error) {
+	topic := message.Topic
+	if topic == "" {
+		topic = r.topic
+	}
+	line, err := json.Marshal(writtenMessage{
+		Topic:     topic,
+		Key:       messageKey(ctx, message),
+		Headers:   messageHeaders(ctx, message),
+		Content:   message.Content,
+		Timestamp: time.Now().UTC(),
+	})
+	if err != nil {
+		return domain.Receipt{}, fmt.Errorf("failed to encode message: %w", err)
+	}
+	metrics.MessageSize.WithLabelValues(topic).Observe(float64(len(message.Content)))
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, err = r.writer.Write(append(line, '\n'))
+	r.lastError = err
+	if err != nil {
+		metrics.MessagesFailed.WithLabelValues(topic).Inc()
+		log.Err(err).Msgf("Failed to write message to %s", r.name)
+		return domain.Receipt{}, err
+	}
+	metrics.MessagesProduced.WithLabelValues(topic).Inc()
+	return domain.Receipt{}, nil
+}
+
+// ProduceBatch writes every message as a JSON line, in order
+func (r *WriterRepository) ProduceBatch(ctx context.Context, messages []domain.Message) []error {
+	errs := make([]error, len(messages))
+	for i, message := range messages {
+		_, errs[i] = r.Produce(ctx, message)
+	}
+	return errs
+}
+
+// HealthCheck reports the writer as unhealthy when the last write failed
+func (r *WriterRepository) HealthCheck(ctx context.Context) []domain.DependencyStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	status := domain.DependencyStatus{
+		Name:    r.name,
+		Healthy: r.lastError == nil,
+	}
+	if r.lastError != nil {
+		status.Error = "last write failed: " + r.lastError.Error()
+	}
+	return []domain.DependencyStatus{status}
+}
+
+// Close closes the file the messages are written to
+func (r *WriterRepository) Close() {
+	if r.closer == nil {
+		return
+	}
+	if err := r.closer.Close(); err != nil {
+		log.Err(err).Msgf("Failed to close %s", r.name)
+	}
+}
@@ -0,0 +1,101 @@
+package repository_test
+
+import (
+	"anyway/internal/domain"
+	"anyway/internal/infrastructure/repository"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// failingWriter is a writer that always fails
+type failingWriter struct{}
+
+// Write returns an error
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+// TestWriterProduce tests that every message is written as a JSON line
+func TestWriterProduce(t *testing.T) {
+	// Create a new WriterRepository instance writing to a buffer
+	var buffer bytes.Buffer
+	writerRepository := repository.NewWriterRepository("buffer", "default-topic", &buffer)
+
+	// Produce a message to the default topic and another one to a selected topic
+	ctx := domain.WithMetadata(context.Background(), domain.Metadata{RoutingID: "test-routing-id"})
+	errs := writerRepository.ProduceBatch(ctx, []domain.Message{
+		{Content: []byte("first")},
+		{Topic: "orders", Key: "message-key", Headers: map[string]string{"source": "my-app"}, Content: []byte("second")},
+	})
+	assert.Equal(t, []error{nil, nil}, errs)
+
+	// Assert the written lines
+	lines := strings.Split(strings.TrimSpace(buffer.String()), "\n")
+	assert.Len(t, lines, 2)
+
+	var first, second map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(lines[0]), &first))
+	assert.NoError(t, json.Unmarshal([]byte(lines[1]), &second))
+	assert.Equal(t, "default-topic", first["topic"])
+	assert.Equal(t, "test-routing-id", first["key"])
+	assert.Equal(t, "Zmlyc3Q=", first["content"])
+	assert.Equal(t, "orders", second["topic"])
+	assert.Equal(t, "message-key", second["key"])
+	assert.Equal(t, map[string]interface{}{"source": "my-app"}, second["headers"])
+	assert.NotEmpty(t, second["timestamp"])
+}
+
+// TestWriterProduceError tests that a failed write is returned and reported by the health check
+func TestWriterProduceError(t *testing.T) {
+	// Create a new WriterRepository instance writing to a failing writer
+	writerRepository := repository.NewWriterRepository("failing", "default-topic", failingWriter{})
+
+	// Assert that the repository is healthy before any write
+	assert.True(t, writerRepository.HealthCheck(context.Background())[0].Healthy)
+
+	// Call the Produce method
+	_, err := writerRepository.Produce(context.Background(), domain.Message{Content: []byte("test-content")})
+
+	// Assert that the error is returned and the repository is unhealthy
+	assert.EqualError(t, err, "disk full")
+	status := writerRepository.HealthCheck(context.Background())[0]
+	assert.Equal(t, "failing", status.Name)
+	assert.False(t, status.Healthy)
+}
+
+// TestFileProduce tests that the messages are appended to the file
+func TestFileProduce(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "messages.jsonl")
+
+	// Create a new file repository and produce a message
+	fileRepository, err := repository.NewFileRepository(path, "default-topic")
+	assert.NoError(t, err)
+	_, err = fileRepository.Produce(context.Background(), domain.Message{Content: []byte("first")})
+	assert.NoError(t, err)
+	fileRepository.Close()
+
+	// Reopen the file repository and assert that messages are appended
+	fileRepository, err = repository.NewFileRepository(path, "default-topic")
+	assert.NoError(t, err)
+	_, err = fileRepository.Produce(context.Background(), domain.Message{Content: []byte("second")})
+	assert.NoError(t, err)
+	fileRepository.Close()
+
+	content, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, strings.Count(string(content), "\n"))
+}
+
+// TestNewFileRepositoryError tests that an error is returned when the file cannot be opened
+func TestNewFileRepositoryError(t *testing.T) {
+	_, err := repository.NewFileRepository(filepath.Join(t.TempDir(), "missing", "messages.jsonl"), "default-topic")
+	assert.Error(t, err)
+}
@@ -0,0 +1,118 @@
+package schema
+
+import (
+	"anyway/internal/domain"
+	"bytes"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"github.com/santhosh-tekuri/jsonschema/v6"
+)
+
+// MessageTypeHeader is the message header selecting the schema of a message type within its topic
+const MessageTypeHeader = "message_type"
+
+// schemaExtension is the extension of the schema files
+const schemaExtension = ".json"
+
+// Validator validates the content of the messages against the JSON Schemas of a directory.
+// The schema of a topic is <dir>/<topic>.json, and the schema of a message type within a
+// topic is <dir>/<topic>/<message_type>.json. Messages without a schema are not validated.
+type Validator struct {
+	schemas map[string]*jsonschema.Schema
+}
+
+// NewValidator compiles every schema of the directory
+func NewValidator(dir string) (*Validator, error) {
+	compiler := jsonschema.NewCompiler()
+	schemas := map[string]*jsonschema.Schema{}
+
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || filepath.Ext(path) != schemaExtension {
+			return nil
+		}
+		relative, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(strings.TrimSuffix(relative, schemaExtension))
+		if strings.Count(name, "/") > 1 {
+			return nil
+		}
+		schema, err := compiler.Compile(path)
+		if err != nil {
+			return fmt.Errorf("failed to compile schema %s: %w", relative, err)
+		}
+		schemas[name] = schema
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	log.Info().Msgf("Loaded %d JSON schemas from %s", len(schemas), dir)
+	return &Validator{schemas: schemas}, nil
+}
+
+// Validate checks the content of the message against the schema of its message type, or of its topic
+func (v *Validator) Validate(message domain.Message) error {
+	name, schema := v.lookup(message)
+	if schema == nil {
+		return nil
+	}
+	content, err := jsonschema.UnmarshalJSON(bytes.NewReader(message.Content))
+	if err != nil {
+		return &domain.ValidationError{
+			Schema:     name,
+			Violations: []string{"content is not valid JSON: " + err.Error()},
+		}
+	}
+	if err := schema.Validate(content); err != nil {
+		validationErr, ok := err.(*jsonschema.ValidationError)
+		if !ok {
+			return err
+		}
+		return &domain.ValidationError{
+			Schema:     name,
+			Violations: violations(validationErr),
+		}
+	}
+	return nil
+}
+
+// lookup returns the schema of the message type when there is one, or else the schema of the topic
+func (v *Validator) lookup(message domain.Message) (string, *jsonschema.Schema) {
+	if messageType := message.Headers[MessageTypeHeader]; messageType != "" {
+		name := message.Topic + "/" + messageType
+		if schema, ok := v.schemas[name]; ok {
+			return name, schema
+		}
+	}
+	return message.Topic, v.schemas[message.Topic]
+}
+
+// violations flattens the validation error into one sorted line per failed keyword
+func violations(err *jsonschema.ValidationError) []string {
+	var result []string
+	for _, unit := range err.BasicOutput().Errors {
+		if unit.Error == nil {
+			continue
+		}
+		location := unit.InstanceLocation
+		if location == "" {
+			location = "/"
+		}
+		result = append(result, location+": "+unit.Error.String())
+	}
+	if len(result) == 0 {
+		result = append(result, err.Error())
+	}
+	sort.Strings(result)
+	return result
+}
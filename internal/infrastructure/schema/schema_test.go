@@ -0,0 +1,121 @@
+package schema_test
+
+import (
+	"anyway/internal/domain"
+	"anyway/internal/infrastructure/schema"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const orderSchema = `{
+	"type": "object",
+	"required": ["id", "status"],
+	"properties": {
+		"id": {"type": "integer"},
+		"status": {"enum": ["created", "paid"]}
+	}
+}`
+
+const refundSchema = `{
+	"type": "object",
+	"required": ["amount"]
+}`
+
+// writeSchemas writes the schemas, named by their path relative to the directory
+func writeSchemas(t *testing.T, schemas map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range schemas {
+		path := filepath.Join(dir, name)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		assert.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	}
+	return dir
+}
+
+// TestValidate tests that messages are validated against the schema of their message type or topic
+func TestValidate(t *testing.T) {
+	dir := writeSchemas(t, map[string]string{
+		"orders.json":        orderSchema,
+		"orders/refund.json": refundSchema,
+		"README.md":          "not a schema",
+	})
+	validator, err := schema.NewValidator(dir)
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name               string
+		message            domain.Message
+		expectedSchema     string
+		expectedViolations []string
+	}{
+		{
+			name:    "valid message",
+			message: domain.Message{Topic: "orders", Content: []byte(`{"id": 1, "status": "paid"}`)},
+		},
+		{
+			name:               "invalid message",
+			message:            domain.Message{Topic: "orders", Content: []byte(`{"id": "1", "status": "lost"}`)},
+			expectedSchema:     "orders",
+			expectedViolations: []string{"/id: got string, want integer", "/status: value must be one of 'created', 'paid'"},
+		},
+		{
+			name:               "content is not JSON",
+			message:            domain.Message{Topic: "orders", Content: []byte("plain text")},
+			expectedSchema:     "orders",
+			expectedViolations: []string{"content is not valid JSON: invalid character 'p' looking for beginning of value"},
+		},
+		{
+			name: "message type schema",
+			message: domain.Message{
+				Topic:   "orders",
+				Headers: map[string]string{schema.MessageTypeHeader: "refund"},
+				Content: []byte(`{"id": 1}`),
+			},
+			expectedSchema:     "orders/refund",
+			expectedViolations: []string{"/: missing property 'amount'"},
+		},
+		{
+			name: "unknown message type falls back to the topic schema",
+			message: domain.Message{
+				Topic:   "orders",
+				Headers: map[string]string{schema.MessageTypeHeader: "unknown"},
+				Content: []byte(`{"id": 1, "status": "created"}`),
+			},
+		},
+		{
+			name:    "topic without schema",
+			message: domain.Message{Topic: "payments", Content: []byte("anything")},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validator.Validate(tt.message)
+			if tt.expectedViolations == nil {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, domain.ErrInvalidMessage)
+			var validationErr *domain.ValidationError
+			if assert.ErrorAs(t, err, &validationErr) {
+				assert.Equal(t, tt.expectedSchema, validationErr.Schema)
+				assert.Equal(t, tt.expectedViolations, validationErr.Violations)
+			}
+		})
+	}
+}
+
+// TestNewValidatorInvalidSchema tests that a schema that does not compile is reported
+func TestNewValidatorInvalidSchema(t *testing.T) {
+	dir := writeSchemas(t, map[string]string{
+		"orders.json": `{"type": "unknown"}`,
+	})
+	_, err := schema.NewValidator(dir)
+	assert.Error(t, err)
+
+	_, err = schema.NewValidator(filepath.Join(dir, "missing"))
+	assert.Error(t, err)
+}
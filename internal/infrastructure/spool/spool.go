@@ -0,0 +1,509 @@
+package spool
+
+import (
+	"anyway/internal/metrics"
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	segmentExtension = ".seg"
+	positionFile     = "position"
+	// rejectedFile keeps the entries moved aside with Reject, in the same format as the segments
+	rejectedFile = "rejected.spool"
+	// corruptedFile keeps the bytes of the entries that fail their checksum or cannot be framed
+	corruptedFile = "corrupted.spool"
+	// entryHeaderSize is the size of the length and checksum written before every entry
+	entryHeaderSize = 8
+)
+
+var (
+	// ErrEmpty is returned by Peek when there are no entries to read
+	ErrEmpty = errors.New("spool is empty")
+	// ErrFull is returned by Append when the entry would exceed the maximum size of the spool
+	ErrFull = errors.New("spool is full")
+
+	// errCorrupted is returned when a complete entry does not match its checksum
+	errCorrupted = errors.New("spool entry is corrupted")
+	// errTruncated is returned when an entry extends past the end of its segment
+	errTruncated = errors.New("spool entry is truncated")
+)
+
+// Stats describes the entries waiting in the spool
+type Stats struct {
+	Entries  int
+	Bytes    int64
+	Segments int
+	MaxBytes int64
+}
+
+// segment is a file holding entries, named after its sequence number
+type segment struct {
+	id   uint64
+	size int64
+	// entries is the number of unacknowledged entries in the segment
+	entries int
+}
+
+// Spool is a durable FIFO queue of entries stored in append-only segment files.
+// Every entry is written as its length, a CRC-32 checksum and the data, and synced to disk
+// before Append returns. The read position is persisted on every Ack, and segments are
+// removed once all their entries are acknowledged. Entries that fail their checksum are
+// moved aside to the corrupted file of the directory instead of blocking the ones behind them.
+type Spool struct {
+	dir          string
+	maxBytes     int64
+	segmentBytes int64
+
+	mu       sync.Mutex
+	segments []segment
+	writer   *os.File
+	// reader position: segment being read and offset of the next entry in it
+	readID     uint64
+	readOffset int64
+	reader     *os.File
+	// pending is the size of the entry returned by Peek and not acknowledged yet
+	pending int64
+	entries int
+	bytes   int64
+}
+
+// Open opens the spool stored in dir, creating it if needed.
+// maxBytes limits the total size of the unacknowledged entries; segmentBytes is the size after
+// which a new segment file is started.
+func Open(dir string, maxBytes, segmentBytes int64) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create spool directory %s: %w", dir, err)
+	}
+	s := &Spool{
+		dir:          dir,
+		maxBytes:     maxBytes,
+		segmentBytes: segmentBytes,
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Append writes an entry at the end of the spool and syncs it to disk
+func (s *Spool) Append(data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	size := int64(entryHeaderSize + len(data))
+	if s.maxBytes > 0 && s.bytes+size > s.maxBytes {
+		return ErrFull
+	}
+	if err := s.rotate(size); err != nil {
+		return err
+	}
+	entry := make([]byte, size)
+	binary.BigEndian.PutUint32(entry[0:4], uint32(len(data)))
+	binary.BigEndian.PutUint32(entry[4:8], crc32.ChecksumIEEE(data))
+	copy(entry[entryHeaderSize:], data)
+
+	if _, err := s.writer.Write(entry); err != nil {
+		return fmt.Errorf("failed to write spool entry: %w", err)
+	}
+	if err := s.writer.Sync(); err != nil {
+		return fmt.Errorf("failed to sync spool entry: %w", err)
+	}
+	s.segments[len(s.segments)-1].size += size
+	s.segments[len(s.segments)-1].entries++
+	s.entries++
+	s.bytes += size
+	return nil
+}
+
+// Peek returns the oldest entry without removing it. Calling Peek again before Ack
+// returns the same entry. It returns ErrEmpty when there are no entries.
+// Corrupted entries on the way are moved aside and skipped.
+func (s *Spool) Peek() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for {
+		if s.entries == 0 {
+			return nil, ErrEmpty
+		}
+		current := s.segments[0]
+		if s.readID != current.id {
+			s.closeReader()
+			s.readID, s.readOffset = current.id, 0
+		}
+		if s.readOffset >= current.size {
+			if len(s.segments) == 1 {
+				return nil, ErrEmpty
+			}
+			// the segment was fully read, continue with the next one
+			if err := s.removeFirstSegment(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if s.reader == nil {
+			reader, err := os.Open(s.segmentPath(s.readID))
+			if err != nil {
+				return nil, fmt.Errorf("failed to open spool segment: %w", err)
+			}
+			s.reader = reader
+		}
+		data, size, err := readEntry(s.reader, s.readOffset, current.size)
+		switch {
+		case err == nil:
+			s.pending = size
+			return data, nil
+		case errors.Is(err, errCorrupted):
+			err = s.quarantine(size, 1)
+		case errors.Is(err, errTruncated):
+			// the length of the entry is corrupted, so the entries behind it cannot be told apart
+			err = s.quarantine(current.size-s.readOffset, current.entries)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Ack removes the entry returned by the last Peek and persists the read position
+func (s *Spool) Ack() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.ack()
+}
+
+// Reject moves the entry returned by the last Peek aside, to the rejected file of the spool directory,
+// and removes it from the spool. It is used for the entries that can never be replayed.
+func (s *Spool) Reject() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.pending == 0 {
+		return nil
+	}
+	entry := make([]byte, s.pending)
+	if _, err := s.reader.ReadAt(entry, s.readOffset); err != nil {
+		return fmt.Errorf("failed to read spool entry: %w", err)
+	}
+	if err := s.moveAside(rejectedFile, entry); err != nil {
+		return err
+	}
+	return s.ack()
+}
+
+// ack removes the entry returned by the last Peek and persists the read position
+func (s *Spool) ack() error {
+	if s.pending == 0 {
+		return nil
+	}
+	s.readOffset += s.pending
+	s.segments[0].entries--
+	s.entries--
+	s.bytes -= s.pending
+	s.pending = 0
+
+	if s.readOffset >= s.segments[0].size && len(s.segments) > 1 {
+		return s.removeFirstSegment()
+	}
+	return s.savePosition()
+}
+
+// Stats returns the number and size of the entries waiting in the spool
+func (s *Spool) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return Stats{
+		Entries:  s.entries,
+		Bytes:    s.bytes,
+		Segments: len(s.segments),
+		MaxBytes: s.maxBytes,
+	}
+}
+
+// Close closes the segment files
+func (s *Spool) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closeReader()
+	var errs []error
+	if s.writer != nil {
+		errs = append(errs, s.writer.Close())
+		s.writer = nil
+	}
+	return errors.Join(errs...)
+}
+
+// load reads the segments and the read position from disk, dropping any torn entry at the end
+func (s *Spool) load() error {
+	files, err := os.ReadDir(s.dir)
+	if err != nil {
+		return fmt.Errorf("failed to read spool directory %s: %w", s.dir, err)
+	}
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || !strings.HasSuffix(name, segmentExtension) {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExtension), 10, 64)
+		if err != nil {
+			continue
+		}
+		s.segments = append(s.segments, segment{id: id})
+	}
+	sort.Slice(s.segments, func(i, j int) bool { return s.segments[i].id < s.segments[j].id })
+
+	s.readID, s.readOffset = s.loadPosition()
+	for len(s.segments) > 0 && s.segments[0].id < s.readID {
+		if err := os.Remove(s.segmentPath(s.segments[0].id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove spool segment: %w", err)
+		}
+		s.segments = s.segments[1:]
+	}
+	for i := range s.segments {
+		if err := s.scan(&s.segments[i], i == len(s.segments)-1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scan counts the entries of a segment. Bytes at the end that do not hold a complete entry are moved aside
+// and truncated: in the last segment they are an entry torn by a crash, elsewhere they are corrupted.
+// Complete entries that fail their checksum are counted, and moved aside when Peek reaches them.
+func (s *Spool) scan(seg *segment, last bool) error {
+	path := s.segmentPath(seg.id)
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open spool segment: %w", err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to read spool segment: %w", err)
+	}
+
+	start := int64(0)
+	if seg.id == s.readID {
+		start = s.readOffset
+	}
+	reader := bufio.NewReader(file)
+	var offset int64
+	for offset < info.Size() {
+		_, size, err := readEntryFrom(reader, info.Size()-offset)
+		if err != nil && !errors.Is(err, errCorrupted) {
+			break
+		}
+		if offset >= start {
+			seg.entries++
+			s.entries++
+			s.bytes += size
+		}
+		offset += size
+	}
+	seg.size = offset
+	if info.Size() == offset {
+		return nil
+	}
+
+	tail := make([]byte, info.Size()-offset)
+	if _, err := file.ReadAt(tail, offset); err != nil {
+		return fmt.Errorf("failed to read spool segment: %w", err)
+	}
+	if err := s.moveAside(corruptedFile, tail); err != nil {
+		return err
+	}
+	if last {
+		metrics.SpoolDropped.WithLabelValues("torn").Inc()
+		log.Warn().Str("segment", path).Int64("offset", offset).Msg("Moved torn spool entry aside")
+	} else {
+		metrics.SpoolDropped.WithLabelValues("corrupted").Inc()
+		log.Error().Str("segment", path).Int64("offset", offset).Msg("Moved corrupted end of spool segment aside")
+	}
+	if err := os.Truncate(path, offset); err != nil {
+		return fmt.Errorf("failed to truncate spool segment: %w", err)
+	}
+	return nil
+}
+
+// quarantine moves the given number of bytes at the read position aside, to the corrupted file of the spool
+// directory, and skips them along with the entries they held
+func (s *Spool) quarantine(size int64, entries int) error {
+	data := make([]byte, size)
+	if _, err := s.reader.ReadAt(data, s.readOffset); err != nil {
+		return fmt.Errorf("failed to read spool segment: %w", err)
+	}
+	if err := s.moveAside(corruptedFile, data); err != nil {
+		return err
+	}
+	metrics.SpoolDropped.WithLabelValues("corrupted").Add(float64(entries))
+	log.Error().Str("segment", s.segmentPath(s.readID)).Int64("offset", s.readOffset).Int("entries", entries).
+		Msg("Moved corrupted spool entries aside")
+
+	s.readOffset += size
+	s.segments[0].entries -= entries
+	s.entries -= entries
+	s.bytes -= size
+	return s.savePosition()
+}
+
+// rotate makes sure there is a writable segment with room for an entry of the given size
+func (s *Spool) rotate(size int64) error {
+	if s.writer != nil && s.segments[len(s.segments)-1].size+size <= s.segmentBytes {
+		return nil
+	}
+	if s.writer == nil && len(s.segments) > 0 && s.segments[len(s.segments)-1].size+size <= s.segmentBytes {
+		writer, err := os.OpenFile(s.segmentPath(s.segments[len(s.segments)-1].id), os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return fmt.Errorf("failed to open spool segment: %w", err)
+		}
+		s.writer = writer
+		return nil
+	}
+	if s.writer != nil {
+		if err := s.writer.Close(); err != nil {
+			return fmt.Errorf("failed to close spool segment: %w", err)
+		}
+		s.writer = nil
+	}
+	id := uint64(1)
+	if len(s.segments) > 0 {
+		id = s.segments[len(s.segments)-1].id + 1
+	}
+	writer, err := os.OpenFile(s.segmentPath(id), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to create spool segment: %w", err)
+	}
+	s.writer = writer
+	s.segments = append(s.segments, segment{id: id})
+	return nil
+}
+
+// removeFirstSegment deletes the segment that was fully read and moves the read position to the next one
+func (s *Spool) removeFirstSegment() error {
+	first := s.segments[0]
+	s.closeReader()
+	if len(s.segments) == 1 {
+		// keep the only segment, since it is the one being written
+		return s.savePosition()
+	}
+	s.segments = s.segments[1:]
+	s.readID, s.readOffset = s.segments[0].id, 0
+	if err := s.savePosition(); err != nil {
+		return err
+	}
+	if err := os.Remove(s.segmentPath(first.id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove spool segment: %w", err)
+	}
+	return nil
+}
+
+// moveAside appends the bytes to the given file of the spool directory and syncs it to disk
+func (s *Spool) moveAside(name string, data []byte) error {
+	file, err := os.OpenFile(filepath.Join(s.dir, name), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", name, err)
+	}
+	defer file.Close()
+
+	if _, err := file.Write(data); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync %s: %w", name, err)
+	}
+	return nil
+}
+
+// closeReader closes the file of the segment being read, if any
+func (s *Spool) closeReader() {
+	if s.reader != nil {
+		s.reader.Close()
+		s.reader = nil
+	}
+}
+
+// savePosition atomically persists the read position
+func (s *Spool) savePosition() error {
+	tmp := filepath.Join(s.dir, positionFile+".tmp")
+	content := fmt.Sprintf("%d %d\n", s.readID, s.readOffset)
+	if err := os.WriteFile(tmp, []byte(content), 0o644); err != nil {
+		return fmt.Errorf("failed to save spool position: %w", err)
+	}
+	if err := os.Rename(tmp, filepath.Join(s.dir, positionFile)); err != nil {
+		return fmt.Errorf("failed to save spool position: %w", err)
+	}
+	return nil
+}
+
+// loadPosition reads the persisted read position, starting at the first segment when there is none
+func (s *Spool) loadPosition() (uint64, int64) {
+	content, err := os.ReadFile(filepath.Join(s.dir, positionFile))
+	if err != nil {
+		return s.firstSegmentID(), 0
+	}
+	var id uint64
+	var offset int64
+	if _, err := fmt.Sscanf(string(content), "%d %d", &id, &offset); err != nil {
+		return s.firstSegmentID(), 0
+	}
+	return id, offset
+}
+
+// firstSegmentID returns the id of the oldest segment, or 0 when there are none
+func (s *Spool) firstSegmentID() uint64 {
+	if len(s.segments) == 0 {
+		return 0
+	}
+	return s.segments[0].id
+}
+
+// segmentPath returns the path of the segment file with the given id
+func (s *Spool) segmentPath(id uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%020d%s", id, segmentExtension))
+}
+
+// readEntry reads the entry stored at the given offset of a segment that ends at end
+func readEntry(file *os.File, offset, end int64) ([]byte, int64, error) {
+	return readEntryFrom(io.NewSectionReader(file, offset, end-offset), end-offset)
+}
+
+// readEntryFrom reads the next entry from at most limit bytes and returns its data and size on disk.
+// It returns errTruncated when the entry does not fit in limit, and errCorrupted along with its size
+// when the entry fails its checksum.
+func readEntryFrom(reader io.Reader, limit int64) ([]byte, int64, error) {
+	if limit < entryHeaderSize {
+		return nil, 0, errTruncated
+	}
+	header := make([]byte, entryHeaderSize)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, 0, fmt.Errorf("failed to read spool entry: %w", err)
+	}
+	size := entryHeaderSize + int64(binary.BigEndian.Uint32(header[0:4]))
+	if size > limit {
+		return nil, 0, errTruncated
+	}
+	data := make([]byte, size-entryHeaderSize)
+	if _, err := io.ReadFull(reader, data); err != nil {
+		return nil, 0, fmt.Errorf("failed to read spool entry: %w", err)
+	}
+	if crc32.ChecksumIEEE(data) != binary.BigEndian.Uint32(header[4:8]) {
+		return nil, size, errCorrupted
+	}
+	return data, size, nil
+}
@@ -0,0 +1,217 @@
+package spool_test
+
+import (
+	"anyway/internal/infrastructure/spool"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// drain reads and acknowledges every entry of the spool
+func drain(t *testing.T, s *spool.Spool) []string {
+	var entries []string
+	for {
+		data, err := s.Peek()
+		if err == spool.ErrEmpty {
+			return entries
+		}
+		require.NoError(t, err)
+		entries = append(entries, string(data))
+		require.NoError(t, s.Ack())
+	}
+}
+
+// TestAppendPeekAck tests that entries are read in the order they were appended
+func TestAppendPeekAck(t *testing.T) {
+	s, err := spool.Open(t.TempDir(), 0, 1<<20)
+	require.NoError(t, err)
+	defer s.Close()
+
+	// Assert that an empty spool has nothing to read
+	_, err = s.Peek()
+	assert.ErrorIs(t, err, spool.ErrEmpty)
+
+	// Append some entries
+	for _, entry := range []string{"first", "second", "third"} {
+		require.NoError(t, s.Append([]byte(entry)))
+	}
+	assert.Equal(t, 3, s.Stats().Entries)
+
+	// Assert that Peek returns the same entry until it is acknowledged
+	data, err := s.Peek()
+	require.NoError(t, err)
+	assert.Equal(t, "first", string(data))
+	data, err = s.Peek()
+	require.NoError(t, err)
+	assert.Equal(t, "first", string(data))
+
+	// Assert that the entries are read in order and the spool ends empty
+	assert.Equal(t, []string{"first", "second", "third"}, drain(t, s))
+	assert.Equal(t, 0, s.Stats().Entries)
+	assert.Equal(t, int64(0), s.Stats().Bytes)
+}
+
+// TestReopen tests that the entries and the read position survive a restart
+func TestReopen(t *testing.T) {
+	dir := t.TempDir()
+
+	// Append some entries and acknowledge the first one
+	s, err := spool.Open(dir, 0, 1<<20)
+	require.NoError(t, err)
+	for _, entry := range []string{"first", "second", "third"} {
+		require.NoError(t, s.Append([]byte(entry)))
+	}
+	_, err = s.Peek()
+	require.NoError(t, err)
+	require.NoError(t, s.Ack())
+	require.NoError(t, s.Close())
+
+	// Reopen the spool and assert that only the unacknowledged entries remain
+	s, err = spool.Open(dir, 0, 1<<20)
+	require.NoError(t, err)
+	defer s.Close()
+	assert.Equal(t, 2, s.Stats().Entries)
+	require.NoError(t, s.Append([]byte("fourth")))
+	assert.Equal(t, []string{"second", "third", "fourth"}, drain(t, s))
+}
+
+// TestSegments tests that entries are spread over segments that are removed once read
+func TestSegments(t *testing.T) {
+	dir := t.TempDir()
+
+	// Open a spool with segments that hold two entries each
+	s, err := spool.Open(dir, 0, int64(2*(8+len("entry-0"))))
+	require.NoError(t, err)
+	defer s.Close()
+
+	var expected []string
+	for i := 0; i < 5; i++ {
+		entry := fmt.Sprintf("entry-%d", i)
+		expected = append(expected, entry)
+		require.NoError(t, s.Append([]byte(entry)))
+	}
+	assert.Equal(t, 3, s.Stats().Segments)
+
+	// Assert that the entries are read in order and the read segments are removed
+	assert.Equal(t, expected, drain(t, s))
+	segments, err := filepath.Glob(filepath.Join(dir, "*.seg"))
+	require.NoError(t, err)
+	assert.Len(t, segments, 1)
+}
+
+// TestFull tests that entries exceeding the maximum size are rejected
+func TestFull(t *testing.T) {
+	// Open a spool with room for a single entry
+	s, err := spool.Open(t.TempDir(), 8+int64(len("first")), 1<<20)
+	require.NoError(t, err)
+	defer s.Close()
+
+	require.NoError(t, s.Append([]byte("first")))
+	assert.ErrorIs(t, s.Append([]byte("second")), spool.ErrFull)
+
+	// Assert that there is room again once the entry is acknowledged
+	assert.Equal(t, []string{"first"}, drain(t, s))
+	assert.NoError(t, s.Append([]byte("third")))
+}
+
+// TestTornEntry tests that a partially written entry at the end of a segment is discarded on open
+func TestTornEntry(t *testing.T) {
+	dir := t.TempDir()
+
+	// Append an entry and simulate a crash in the middle of writing the next one
+	s, err := spool.Open(dir, 0, 1<<20)
+	require.NoError(t, err)
+	require.NoError(t, s.Append([]byte("complete")))
+	require.NoError(t, s.Close())
+
+	segments, err := filepath.Glob(filepath.Join(dir, "*.seg"))
+	require.NoError(t, err)
+	require.Len(t, segments, 1)
+	file, err := os.OpenFile(segments[0], os.O_WRONLY|os.O_APPEND, 0o644)
+	require.NoError(t, err)
+	_, err = file.Write([]byte{0, 0, 0, 10, 1, 2})
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	// Reopen the spool and assert that only the complete entry remains
+	s, err = spool.Open(dir, 0, 1<<20)
+	require.NoError(t, err)
+	defer s.Close()
+	assert.Equal(t, 1, s.Stats().Entries)
+	require.NoError(t, s.Append([]byte("after")))
+	assert.Equal(t, []string{"complete", "after"}, drain(t, s))
+}
+
+// corrupt overwrites the bytes of a segment file of dir at the given offset
+func corrupt(t *testing.T, dir string, segment int, offset int64, data []byte) {
+	segments, err := filepath.Glob(filepath.Join(dir, "*.seg"))
+	require.NoError(t, err)
+	file, err := os.OpenFile(segments[segment], os.O_WRONLY, 0o644)
+	require.NoError(t, err)
+	_, err = file.WriteAt(data, offset)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+}
+
+// TestCorruptedEntry tests that an entry failing its checksum is moved aside without losing the entries behind it
+func TestCorruptedEntry(t *testing.T) {
+	for _, reopen := range []bool{false, true} {
+		t.Run(fmt.Sprintf("reopen=%v", reopen), func(t *testing.T) {
+			dir := t.TempDir()
+			s, err := spool.Open(dir, 0, 1<<20)
+			require.NoError(t, err)
+			for _, entry := range []string{"first", "second", "third"} {
+				require.NoError(t, s.Append([]byte(entry)))
+			}
+
+			// Flip a byte of the second entry, which starts after the 13 bytes of the first one
+			corrupt(t, dir, 0, 13+8, []byte("S"))
+			if reopen {
+				require.NoError(t, s.Close())
+				s, err = spool.Open(dir, 0, 1<<20)
+				require.NoError(t, err)
+				assert.Equal(t, 3, s.Stats().Entries)
+			}
+			defer s.Close()
+
+			// Assert that the corrupted entry is skipped and kept aside
+			assert.Equal(t, []string{"first", "third"}, drain(t, s))
+			assert.Equal(t, 0, s.Stats().Entries)
+			assert.Equal(t, int64(0), s.Stats().Bytes)
+			corrupted, err := os.ReadFile(filepath.Join(dir, "corrupted.spool"))
+			require.NoError(t, err)
+			assert.Contains(t, string(corrupted), "Second")
+		})
+	}
+}
+
+// TestCorruptedLength tests that a segment whose entries cannot be framed is moved aside from the corrupted entry on,
+// and the next segments are still read
+func TestCorruptedLength(t *testing.T) {
+	dir := t.TempDir()
+
+	// Append two entries to the first segment and one to the second
+	s, err := spool.Open(dir, 0, 30)
+	require.NoError(t, err)
+	for _, entry := range []string{"first", "second", "third"} {
+		require.NoError(t, s.Append([]byte(entry)))
+	}
+	require.NoError(t, s.Close())
+
+	// Corrupt the length of the second entry
+	corrupt(t, dir, 0, 13, []byte{0, 0, 1, 0})
+
+	// Reopen the spool and assert that the entries before and after the corrupted one remain
+	s, err = spool.Open(dir, 0, 30)
+	require.NoError(t, err)
+	defer s.Close()
+	assert.Equal(t, 2, s.Stats().Entries)
+	assert.Equal(t, []string{"first", "third"}, drain(t, s))
+	corrupted, err := os.ReadFile(filepath.Join(dir, "corrupted.spool"))
+	require.NoError(t, err)
+	assert.Contains(t, string(corrupted), "second")
+}
@@ -0,0 +1,24 @@
+package handler
+
+import (
+	"anyway/internal/domain"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+// AdminHandler handles the HTTP requests used to operate the service
+type AdminHandler struct {
+	spool domain.SpoolInspector
+}
+
+// NewAdminHandler creates a new instance of the admin http handler
+func NewAdminHandler(spool domain.SpoolInspector) *AdminHandler {
+	return &AdminHandler{
+		spool: spool,
+	}
+}
+
+// Spool reports the depth of the local disk spool
+func (h *AdminHandler) Spool(c *gin.Context) {
+	c.JSON(http.StatusOK, h.spool.SpoolStats())
+}
@@ -0,0 +1,47 @@
+package handler_test
+
+import (
+	"anyway/internal/domain"
+	httpHandler "anyway/internal/interfaces/http/handler"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// stubSpoolInspector returns fixed spool stats
+type stubSpoolInspector struct {
+	stats domain.SpoolStats
+}
+
+// SpoolStats returns the fixed spool stats
+func (s stubSpoolInspector) SpoolStats() domain.SpoolStats {
+	return s.stats
+}
+
+// TestAdminSpool tests that the Spool method reports the depth of the spool
+func TestAdminSpool(t *testing.T) {
+	// Create a new admin handler instance
+	stats := domain.SpoolStats{Messages: 3, Bytes: 300, Segments: 1, MaxBytes: 1000}
+	handler := httpHandler.NewAdminHandler(stubSpoolInspector{stats: stats})
+
+	// Setup gin router and recorder
+	router := SetupRouter()
+	router.GET("/admin/spool", handler.Spool)
+
+	// Create a new HTTP request
+	req, _ := http.NewRequest(http.MethodGet, "/admin/spool", nil)
+
+	// Record the response
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	// Assert the response status code and body
+	assert.Equal(t, http.StatusOK, w.Code)
+	var response domain.SpoolStats
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	assert.NoError(t, err)
+	assert.Equal(t, stats, response)
+}
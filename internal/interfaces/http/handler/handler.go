@@ -2,41 +2,284 @@ package handler
 
 import (
 	"anyway/internal/domain"
+	"errors"
+	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/rs/zerolog/log"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
 )
 
+// AsyncMode selects when Send buffers messages and answers 202 Accepted instead of waiting for the broker
+type AsyncMode string
+
+const (
+	// AsyncOff always waits for the broker
+	AsyncOff AsyncMode = "off"
+	// AsyncOptional buffers the messages of requests with the "Prefer: respond-async" header
+	AsyncOptional AsyncMode = "optional"
+	// AsyncAlways buffers every message
+	AsyncAlways AsyncMode = "always"
+)
+
+// retryAfterSeconds is the Retry-After sent when the message buffer is full
+const retryAfterSeconds = "1"
+
 // Handler handles HTTP requests related to chat
 type Handler struct {
 	producerUsecase domain.Usecase
+	batchMaxItems   int
+	batchMaxBytes   int64
+	rawMaxBytes     int64
+	asyncMode       AsyncMode
+}
+
+// Option configures optional behaviour of the handler
+type Option func(*Handler)
+
+// WithBatchLimits limits the number of messages and the body size of batch requests.
+// A value of zero or less disables the corresponding limit.
+func WithBatchLimits(maxItems int, maxBytes int64) Option {
+	return func(h *Handler) {
+		h.batchMaxItems = maxItems
+		h.batchMaxBytes = maxBytes
+	}
+}
+
+// WithRawMaxBytes limits the body size of raw requests; zero or less disables the limit
+func WithRawMaxBytes(maxBytes int64) Option {
+	return func(h *Handler) {
+		h.rawMaxBytes = maxBytes
+	}
+}
+
+// WithAsyncMode selects when Send answers 202 Accepted and produces the message in the background
+func WithAsyncMode(mode AsyncMode) Option {
+	return func(h *Handler) {
+		h.asyncMode = mode
+	}
 }
 
 // NewHandler creates a new instance of the http handler
-func NewHandler(usecase domain.Usecase) *Handler {
-	return &Handler{
+func NewHandler(usecase domain.Usecase, opts ...Option) *Handler {
+	h := &Handler{
 		producerUsecase: usecase,
+		asyncMode:       AsyncOff,
 	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
 }
 
-// Send processes the POST chat request
+// Send processes the POST chat request.
+// When the route has a :topic parameter it overrides the topic sent in the body.
 func (h *Handler) Send(c *gin.Context) {
-	var request domain.Message
+	var body MessageRequest
 
-	if err := c.ShouldBindJSON(&request); err != nil {
+	if err := c.ShouldBindJSON(&body); err != nil {
 		log.Error().Err(err).Msg(err.Error())
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": "Invalid request format: " + err.Error(),
 		})
 		return
 	}
-	err := h.producerUsecase.Send(c.Request.Context(), request)
+	request, err := body.message()
+	if err != nil {
+		log.Error().Err(err).Msg(err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request format: " + err.Error(),
+		})
+		return
+	}
+	if topic := c.Param("topic"); topic != "" {
+		request.Topic = topic
+	}
+	h.send(c, request)
+}
+
+// send produces the message, in the background when the request is asynchronous, and writes the response
+func (h *Handler) send(c *gin.Context, request domain.Message) {
+	if h.async(c) {
+		h.sendAsync(c, request)
+		return
+	}
+	receipt, err := h.producerUsecase.Send(c.Request.Context(), request)
+	if receipt.Attempts > 0 {
+		c.Header(ProduceAttemptsHeader, strconv.Itoa(receipt.Attempts))
+	}
+	if receipt.DeadLettered {
+		c.Header(DeadLetteredHeader, "true")
+	}
+	if err != nil {
+		log.Error().Err(err).Msg(err.Error())
+		h.writeError(c, err, receipt)
+		return
+	}
+	if receipt.Replayed {
+		c.Header(IdempotentReplayedHeader, "true")
+	}
+	c.JSON(http.StatusOK, SendResponse{Destinations: receipt.Destinations, Deduplicated: receipt.Deduplicated})
+}
+
+// sendAsync buffers the message and answers 202 Accepted with the ID assigned to it
+func (h *Handler) sendAsync(c *gin.Context, request domain.Message) {
+	receipt, err := h.producerUsecase.SendAsync(c.Request.Context(), request)
 	if err != nil {
 		log.Error().Err(err).Msg(err.Error())
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Error processing message: " + err.Error(),
+		h.writeError(c, err, domain.Receipt{})
+		return
+	}
+	c.Header("Preference-Applied", "respond-async")
+	c.JSON(http.StatusAccepted, AsyncResponse{ID: receipt.ID, Deduplicated: receipt.Deduplicated})
+}
+
+// async tells whether the message of the request must be produced in the background
+func (h *Handler) async(c *gin.Context) bool {
+	switch h.asyncMode {
+	case AsyncAlways:
+		return true
+	case AsyncOptional:
+		for _, preference := range strings.Split(c.GetHeader("Prefer"), ",") {
+			if strings.EqualFold(strings.TrimSpace(preference), "respond-async") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// writeError writes the error response for a usecase error, along with the
+// destinations of the receipt when a fan-out reached some of them
+func (h *Handler) writeError(c *gin.Context, err error, receipt domain.Receipt) {
+	status := errorStatus(err)
+	response := gin.H{
+		"error": "Error processing message: " + err.Error(),
+	}
+	var policyErr *domain.PolicyError
+	isPolicyErr := errors.As(err, &policyErr)
+	if isPolicyErr {
+		response["rule"] = policyErr.Rule
+	}
+	if status == http.StatusTooManyRequests {
+		retryAfter := retryAfterSeconds
+		if isPolicyErr && policyErr.RetryAfter > 0 {
+			retryAfter = strconv.Itoa(int(math.Ceil(policyErr.RetryAfter.Seconds())))
+		}
+		c.Header("Retry-After", retryAfter)
+	}
+	var circuitErr *domain.CircuitOpenError
+	if errors.As(err, &circuitErr) {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(circuitErr.RetryAfter.Seconds()))))
+	}
+	if len(receipt.Destinations) > 0 {
+		response["destinations"] = receipt.Destinations
+	}
+	var validationErr *domain.ValidationError
+	if errors.As(err, &validationErr) {
+		response["violations"] = validationErr.Violations
+	}
+	c.JSON(status, response)
+}
+
+// SendBatch processes the POST batch request, reporting the outcome of every message
+func (h *Handler) SendBatch(c *gin.Context) {
+	if h.batchMaxBytes > 0 {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, h.batchMaxBytes)
+	}
+	var body []MessageRequest
+
+	if err := c.ShouldBindJSON(&body); err != nil {
+		log.Error().Err(err).Msg(err.Error())
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+				"error": fmt.Sprintf("Batch exceeds the maximum size of %d bytes", h.batchMaxBytes),
+			})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request format: " + err.Error(),
 		})
 		return
 	}
-	c.Status(http.StatusOK)
+	if len(body) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request format: the batch is empty",
+		})
+		return
+	}
+	if h.batchMaxItems > 0 && len(body) > h.batchMaxItems {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+			"error": fmt.Sprintf("Batch exceeds the maximum of %d messages", h.batchMaxItems),
+		})
+		return
+	}
+	request := make([]domain.Message, len(body))
+	for i, item := range body {
+		message, err := item.message()
+		if err != nil {
+			log.Error().Err(err).Msg(err.Error())
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("Invalid request format: message %d: %s", i, err.Error()),
+			})
+			return
+		}
+		request[i] = message
+	}
+	if topic := c.Param("topic"); topic != "" {
+		for i := range request {
+			request[i].Topic = topic
+		}
+	}
+	response := BatchResponse{
+		Results: make([]BatchItemResult, len(request)),
+	}
+	receipts, errs := h.producerUsecase.SendBatch(c.Request.Context(), request)
+	for i, err := range errs {
+		result := BatchItemResult{Index: i, Status: StatusAccepted, Deduplicated: receipts[i].Deduplicated}
+		if err != nil {
+			result.Status = StatusRejected
+			result.Error = err.Error()
+			var validationErr *domain.ValidationError
+			if errors.As(err, &validationErr) {
+				result.Violations = validationErr.Violations
+			}
+			var policyErr *domain.PolicyError
+			if errors.As(err, &policyErr) {
+				result.Rule = policyErr.Rule
+			}
+			response.Rejected++
+		} else {
+			response.Accepted++
+		}
+		response.Results[i] = result
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// errorStatus maps a usecase error to an HTTP status code
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, domain.ErrTopicNotAllowed):
+		return http.StatusForbidden
+	case errors.Is(err, domain.ErrPolicyViolation):
+		return http.StatusForbidden
+	case errors.Is(err, domain.ErrRateLimited):
+		return http.StatusTooManyRequests
+	case errors.Is(err, domain.ErrInvalidMessage):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, domain.ErrIdempotencyKeyReused):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, domain.ErrQueueFull):
+		return http.StatusTooManyRequests
+	case errors.Is(err, domain.ErrShuttingDown):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, domain.ErrCircuitOpen):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
 }
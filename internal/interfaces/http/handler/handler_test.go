@@ -10,6 +10,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
@@ -22,9 +23,32 @@ type MockUsecase struct {
 }
 
 // Send mocks the Send method of domain.Usecase
-func (m *MockUsecase) Send(ctx context.Context, message domain.Message) error {
+func (m *MockUsecase) Send(ctx context.Context, message domain.Message) (domain.Receipt, error) {
 	args := m.Called(ctx, message)
-	return args.Error(0)
+	return args.Get(0).(domain.Receipt), args.Error(1)
+}
+
+// SendAsync mocks the SendAsync method of domain.Usecase
+func (m *MockUsecase) SendAsync(ctx context.Context, message domain.Message) (domain.Receipt, error) {
+	args := m.Called(ctx, message)
+	return args.Get(0).(domain.Receipt), args.Error(1)
+}
+
+// SendBatch mocks the SendBatch method of domain.Usecase
+func (m *MockUsecase) SendBatch(ctx context.Context, messages []domain.Message) ([]domain.Receipt, []error) {
+	args := m.Called(ctx, messages)
+	return args.Get(0).([]domain.Receipt), args.Get(1).([]error)
+}
+
+// HealthCheck mocks the HealthCheck method of domain.Usecase
+func (m *MockUsecase) HealthCheck(ctx context.Context) []domain.DependencyStatus {
+	args := m.Called(ctx)
+	return args.Get(0).([]domain.DependencyStatus)
+}
+
+// Close mocks the Close method of domain.Usecase
+func (m *MockUsecase) Close(ctx context.Context) {
+	m.Called(ctx)
 }
 
 // SetupRouter sets up a gin router for testing
@@ -52,7 +76,7 @@ func TestSendSuccess(t *testing.T) {
 	mockUsecase := new(MockUsecase)
 
 	// Expect Send to be called and return nil (success)
-	mockUsecase.On("Send", mock.Anything, mock.Anything).Return(nil).Once()
+	mockUsecase.On("Send", mock.Anything, mock.Anything).Return(domain.Receipt{}, nil).Once()
 
 	// Create a new handler instance
 	handler := httpHandler.NewHandler(mockUsecase)
@@ -127,7 +151,7 @@ func TestSendUsecaseError(t *testing.T) {
 	expectedErr := errors.New("failed to send message via usecase")
 
 	// Expect Send to be called and return an error
-	mockUsecase.On("Send", mock.Anything, mock.Anything).Return(expectedErr).Once()
+	mockUsecase.On("Send", mock.Anything, mock.Anything).Return(domain.Receipt{}, expectedErr).Once()
 
 	// Create a new handler instance
 	handler := httpHandler.NewHandler(mockUsecase)
@@ -162,3 +186,703 @@ func TestSendUsecaseError(t *testing.T) {
 	// Assert that the expected methods were called on the mock
 	mockUsecase.AssertExpectations(t)
 }
+
+// TestSendFanoutDestinations tests that the destinations of a fan-out are reported in the response
+func TestSendFanoutDestinations(t *testing.T) {
+	// Create a mock usecase
+	mockUsecase := new(MockUsecase)
+
+	// Expect Send to return the outcome of two destinations
+	receipt := domain.Receipt{
+		Destinations: []domain.Destination{
+			{Name: "kafka", Role: "primary", Delivered: true},
+			{Name: "redis-streams", Role: "shadow", Delivered: false, Error: "connection refused"},
+		},
+	}
+	mockUsecase.On("Send", mock.Anything, mock.Anything).Return(receipt, nil).Once()
+
+	// Create a new handler instance
+	handler := httpHandler.NewHandler(mockUsecase)
+
+	// Setup gin router and recorder
+	router := SetupRouter()
+	router.POST("/send", handler.Send)
+
+	// Create a new HTTP request
+	req, _ := http.NewRequest(http.MethodPost, "/send", bytes.NewBufferString(`{"content":"dGVzdA=="}`))
+	req.Header.Set("Content-Type", "application/json")
+
+	// Record the response
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	// Assert the response status code and destinations
+	assert.Equal(t, http.StatusOK, w.Code)
+	var response httpHandler.SendResponse
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	assert.NoError(t, err)
+	assert.Equal(t, receipt.Destinations, response.Destinations)
+
+	// Assert that the expected methods were called on the mock
+	mockUsecase.AssertExpectations(t)
+}
+
+// TestSendWithKeyAndHeaders tests that the key and headers of the body are passed to the usecase
+func TestSendWithKeyAndHeaders(t *testing.T) {
+	// Create a mock usecase
+	mockUsecase := new(MockUsecase)
+
+	// Expect Send to be called with the key and headers of the body
+	expectedMessage := domain.Message{
+		Key:     "message-key-123",
+		Headers: map[string]string{"source": "my-app"},
+		Content: []byte("Hello Kafka world!"),
+	}
+	mockUsecase.On("Send", mock.Anything, expectedMessage).Return(domain.Receipt{}, nil).Once()
+
+	// Create a new handler instance
+	handler := httpHandler.NewHandler(mockUsecase)
+
+	// Setup gin router and recorder
+	router := SetupRouter()
+	router.POST("/send", handler.Send)
+
+	// Create a request body as documented in the README
+	jsonBody := []byte(`{"key": "message-key-123", "headers": {"source": "my-app"}, "content": "SGVsbG8gS2Fma2Egd29ybGQh"}`)
+
+	// Create a new HTTP request
+	req, _ := http.NewRequest(http.MethodPost, "/send", bytes.NewBuffer(jsonBody))
+	req.Header.Set("Content-Type", "application/json")
+
+	// Record the response
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	// Assert the response status code
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Assert that the expected methods were called on the mock
+	mockUsecase.AssertExpectations(t)
+}
+
+// TestSendToTopic tests that the :topic route parameter overrides the topic of the body
+func TestSendToTopic(t *testing.T) {
+	// Create a mock usecase
+	mockUsecase := new(MockUsecase)
+
+	// Expect Send to be called with the topic of the route
+	expectedMessage := domain.Message{
+		Topic:   "orders",
+		Content: []byte("test-content"),
+	}
+	mockUsecase.On("Send", mock.Anything, expectedMessage).Return(domain.Receipt{}, nil).Once()
+
+	// Create a new handler instance
+	handler := httpHandler.NewHandler(mockUsecase)
+
+	// Setup gin router and recorder
+	router := SetupRouter()
+	router.POST("/topics/:topic/send", handler.Send)
+
+	// Create a sample request body selecting another topic
+	jsonBody, _ := json.Marshal(domain.Message{
+		Topic:   "payments",
+		Content: []byte("test-content"),
+	})
+
+	// Create a new HTTP request
+	req, _ := http.NewRequest(http.MethodPost, "/topics/orders/send", bytes.NewBuffer(jsonBody))
+	req.Header.Set("Content-Type", "application/json")
+
+	// Record the response
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	// Assert the response status code
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Assert that the expected methods were called on the mock
+	mockUsecase.AssertExpectations(t)
+}
+
+// TestSendTopicNotAllowed tests the Send method when the topic is not allowed
+func TestSendTopicNotAllowed(t *testing.T) {
+	// Create a mock usecase
+	mockUsecase := new(MockUsecase)
+
+	// Expect Send to be called and reject the topic
+	mockUsecase.On("Send", mock.Anything, mock.Anything).Return(domain.Receipt{}, domain.ErrTopicNotAllowed).Once()
+
+	// Create a new handler instance
+	handler := httpHandler.NewHandler(mockUsecase)
+
+	// Setup gin router and recorder
+	router := SetupRouter()
+	router.POST("/topics/:topic/send", handler.Send)
+
+	// Create a sample request body
+	jsonBody, _ := json.Marshal(domain.Message{Content: []byte("test-content")})
+
+	// Create a new HTTP request
+	req, _ := http.NewRequest(http.MethodPost, "/topics/payments/send", bytes.NewBuffer(jsonBody))
+	req.Header.Set("Content-Type", "application/json")
+
+	// Record the response
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	// Assert the response status code
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// Assert that the expected methods were called on the mock
+	mockUsecase.AssertExpectations(t)
+}
+
+// TestSendPolicyViolation tests that policy violations report the rule, with Retry-After for rate limits
+func TestSendPolicyViolation(t *testing.T) {
+	tests := []struct {
+		name               string
+		err                error
+		expectedCode       int
+		expectedRetryAfter string
+	}{
+		{
+			name:         "rule violation",
+			err:          &domain.PolicyError{Rule: "billing-invoices", Reason: "key not allowed"},
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:               "rate limited",
+			err:                &domain.PolicyError{Rule: "billing-invoices", Reason: "too fast", RetryAfter: 1500 * time.Millisecond},
+			expectedCode:       http.StatusTooManyRequests,
+			expectedRetryAfter: "2",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Create a mock usecase rejecting the message
+			mockUsecase := new(MockUsecase)
+			mockUsecase.On("Send", mock.Anything, mock.Anything).Return(domain.Receipt{}, tt.err).Once()
+
+			// Create a new handler instance
+			handler := httpHandler.NewHandler(mockUsecase)
+
+			// Setup gin router and recorder
+			router := SetupRouter()
+			router.POST("/send", handler.Send)
+
+			// Create a new HTTP request
+			req, _ := http.NewRequest(http.MethodPost, "/send", bytes.NewBufferString(`{"content": "dGVzdA=="}`))
+			req.Header.Set("Content-Type", "application/json")
+
+			// Record the response
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			// Assert the response status code, rule and Retry-After
+			assert.Equal(t, tt.expectedCode, w.Code)
+			assert.Equal(t, tt.expectedRetryAfter, w.Header().Get("Retry-After"))
+			var response struct {
+				Rule string `json:"rule"`
+			}
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+			assert.Equal(t, "billing-invoices", response.Rule)
+
+			// Assert that the expected methods were called on the mock
+			mockUsecase.AssertExpectations(t)
+		})
+	}
+}
+
+// TestSendInvalidMessage tests that messages not matching their schema are rejected with the violations
+func TestSendInvalidMessage(t *testing.T) {
+	// Create a mock usecase
+	mockUsecase := new(MockUsecase)
+
+	// Expect Send to be called and reject the content
+	validationErr := &domain.ValidationError{Schema: "orders", Violations: []string{"/: missing property 'id'"}}
+	mockUsecase.On("Send", mock.Anything, mock.Anything).Return(domain.Receipt{}, validationErr).Once()
+
+	// Create a new handler instance
+	handler := httpHandler.NewHandler(mockUsecase)
+
+	// Setup gin router and recorder
+	router := SetupRouter()
+	router.POST("/send", handler.Send)
+
+	// Create a new HTTP request
+	req, _ := http.NewRequest(http.MethodPost, "/send", bytes.NewBufferString(`{"topic": "orders", "content": {}}`))
+	req.Header.Set("Content-Type", "application/json")
+
+	// Record the response
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	// Assert the response status code and the violations
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	var response struct {
+		Violations []string `json:"violations"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, validationErr.Violations, response.Violations)
+
+	// Assert that the expected methods were called on the mock
+	mockUsecase.AssertExpectations(t)
+}
+
+// TestSendAttempts tests that the attempts of a retried message are reported, whether it was produced or not
+func TestSendAttempts(t *testing.T) {
+	tests := []struct {
+		name           string
+		err            error
+		expectedStatus int
+	}{
+		{name: "produced", expectedStatus: http.StatusOK},
+		{name: "failed", err: errors.New("broker down"), expectedStatus: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Create a mock usecase
+			mockUsecase := new(MockUsecase)
+
+			// Expect Send to be called and report three attempts
+			mockUsecase.On("Send", mock.Anything, mock.Anything).Return(domain.Receipt{Attempts: 3}, tt.err).Once()
+
+			// Create a new handler instance
+			handler := httpHandler.NewHandler(mockUsecase)
+
+			// Setup gin router and recorder
+			router := SetupRouter()
+			router.POST("/send", handler.Send)
+
+			// Create a new HTTP request
+			req, _ := http.NewRequest(http.MethodPost, "/send", bytes.NewBufferString(`{"content": "dGVzdA=="}`))
+			req.Header.Set("Content-Type", "application/json")
+
+			// Record the response
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			// Assert the response status code and the attempts header
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			assert.Equal(t, "3", w.Header().Get(httpHandler.ProduceAttemptsHeader))
+
+			// Assert that the expected methods were called on the mock
+			mockUsecase.AssertExpectations(t)
+		})
+	}
+}
+
+// TestSendDeadLettered tests that the error response tells whether the message was routed to the dead-letter queue
+func TestSendDeadLettered(t *testing.T) {
+	// Create a mock usecase
+	mockUsecase := new(MockUsecase)
+
+	// Expect Send to be called and route the failed message
+	mockUsecase.On("Send", mock.Anything, mock.Anything).Return(domain.Receipt{DeadLettered: true}, errors.New("broker down")).Once()
+
+	// Create a new handler instance
+	handler := httpHandler.NewHandler(mockUsecase)
+
+	// Setup gin router and recorder
+	router := SetupRouter()
+	router.POST("/send", handler.Send)
+
+	// Create a new HTTP request
+	req, _ := http.NewRequest(http.MethodPost, "/send", bytes.NewBufferString(`{"content": "dGVzdA=="}`))
+	req.Header.Set("Content-Type", "application/json")
+
+	// Record the response
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	// Assert the response status code and the dead-lettered header
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, "true", w.Header().Get(httpHandler.DeadLetteredHeader))
+
+	// Assert that the expected methods were called on the mock
+	mockUsecase.AssertExpectations(t)
+}
+
+// TestSendCircuitOpen tests that messages rejected by an open circuit breaker answer 503 with Retry-After
+func TestSendCircuitOpen(t *testing.T) {
+	// Create a mock usecase
+	mockUsecase := new(MockUsecase)
+
+	// Expect Send to be called and fail fast
+	circuitErr := &domain.CircuitOpenError{Backend: "kafka", RetryAfter: 12500 * time.Millisecond}
+	mockUsecase.On("Send", mock.Anything, mock.Anything).Return(domain.Receipt{}, circuitErr).Once()
+
+	// Create a new handler instance
+	handler := httpHandler.NewHandler(mockUsecase)
+
+	// Setup gin router and recorder
+	router := SetupRouter()
+	router.POST("/send", handler.Send)
+
+	// Create a new HTTP request
+	req, _ := http.NewRequest(http.MethodPost, "/send", bytes.NewBufferString(`{"content": "dGVzdA=="}`))
+	req.Header.Set("Content-Type", "application/json")
+
+	// Record the response
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	// Assert the response status code and the time until the breaker lets messages through
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "13", w.Header().Get("Retry-After"))
+	assert.Contains(t, w.Body.String(), "circuit breaker open: kafka")
+
+	// Assert that the expected methods were called on the mock
+	mockUsecase.AssertExpectations(t)
+}
+
+// TestSendDeduplicated tests that a message dropped as a repeat is reported in the response
+func TestSendDeduplicated(t *testing.T) {
+	// Create a mock usecase
+	mockUsecase := new(MockUsecase)
+
+	// Expect Send to be called and drop the message
+	mockUsecase.On("Send", mock.Anything, mock.Anything).Return(domain.Receipt{Deduplicated: true}, nil).Once()
+
+	// Create a new handler instance
+	handler := httpHandler.NewHandler(mockUsecase)
+
+	// Setup gin router and recorder
+	router := SetupRouter()
+	router.POST("/send", handler.Send)
+
+	// Create a new HTTP request
+	req, _ := http.NewRequest(http.MethodPost, "/send", bytes.NewBufferString(`{"content": "dGVzdA=="}`))
+	req.Header.Set("Content-Type", "application/json")
+
+	// Record the response
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	// Assert the response status code and body
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"deduplicated": true}`, w.Body.String())
+
+	// Assert that the expected methods were called on the mock
+	mockUsecase.AssertExpectations(t)
+}
+
+// TestSendIdempotency tests that repeated idempotency keys are flagged and keys reused for another message are rejected
+func TestSendIdempotency(t *testing.T) {
+	tests := []struct {
+		name           string
+		receipt        domain.Receipt
+		err            error
+		expectedStatus int
+		expectedHeader string
+	}{
+		{name: "first request", expectedStatus: http.StatusOK},
+		{name: "repeated request", receipt: domain.Receipt{Replayed: true}, expectedStatus: http.StatusOK, expectedHeader: "true"},
+		{name: "key reused", err: domain.ErrIdempotencyKeyReused, expectedStatus: http.StatusUnprocessableEntity},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Create a mock usecase
+			mockUsecase := new(MockUsecase)
+
+			// Expect Send to be called and return the outcome
+			mockUsecase.On("Send", mock.Anything, mock.Anything).Return(tt.receipt, tt.err).Once()
+
+			// Create a new handler instance
+			handler := httpHandler.NewHandler(mockUsecase)
+
+			// Setup gin router and recorder
+			router := SetupRouter()
+			router.POST("/send", handler.Send)
+
+			// Create a new HTTP request
+			req, _ := http.NewRequest(http.MethodPost, "/send", bytes.NewBufferString(`{"content": "dGVzdA=="}`))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Idempotency-Key", "key-1")
+
+			// Record the response
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			// Assert the response status code and the replay header
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			assert.Equal(t, tt.expectedHeader, w.Header().Get(httpHandler.IdempotentReplayedHeader))
+
+			// Assert that the expected methods were called on the mock
+			mockUsecase.AssertExpectations(t)
+		})
+	}
+}
+
+// TestSendBatch tests that the SendBatch method reports the outcome of every message
+func TestSendBatch(t *testing.T) {
+	// Create a mock usecase
+	mockUsecase := new(MockUsecase)
+
+	// Expect SendBatch to be called, drop the first message as a repeat and reject the second one
+	mockUsecase.On("SendBatch", mock.Anything, []domain.Message{
+		{Content: []byte("first")},
+		{Topic: "payments", Content: []byte("second")},
+	}).Return([]domain.Receipt{{Deduplicated: true}, {}}, []error{nil, domain.ErrTopicNotAllowed}).Once()
+
+	// Create a new handler instance
+	handler := httpHandler.NewHandler(mockUsecase, httpHandler.WithBatchLimits(10, 1024))
+
+	// Setup gin router and recorder
+	router := SetupRouter()
+	router.POST("/send/batch", handler.SendBatch)
+
+	// Create a sample request body
+	jsonBody, _ := json.Marshal([]domain.Message{
+		{Content: []byte("first")},
+		{Topic: "payments", Content: []byte("second")},
+	})
+
+	// Create a new HTTP request
+	req, _ := http.NewRequest(http.MethodPost, "/send/batch", bytes.NewBuffer(jsonBody))
+	req.Header.Set("Content-Type", "application/json")
+
+	// Record the response
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	// Assert the response status code
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Assert the per-message results
+	var response httpHandler.BatchResponse
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, response.Accepted)
+	assert.Equal(t, 1, response.Rejected)
+	assert.Equal(t, []httpHandler.BatchItemResult{
+		{Index: 0, Status: httpHandler.StatusAccepted, Deduplicated: true},
+		{Index: 1, Status: httpHandler.StatusRejected, Error: domain.ErrTopicNotAllowed.Error()},
+	}, response.Results)
+
+	// Assert that the expected methods were called on the mock
+	mockUsecase.AssertExpectations(t)
+}
+
+// TestSendBatchLimits tests that the SendBatch method rejects invalid or oversized batches
+func TestSendBatchLimits(t *testing.T) {
+	tests := []struct {
+		name           string
+		body           string
+		expectedStatus int
+	}{
+		{
+			name:           "too many messages",
+			body:           `[{"content": "YQ=="}, {"content": "Yg=="}, {"content": "Yw=="}]`,
+			expectedStatus: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:           "body too large",
+			body:           `[{"content": "` + string(bytes.Repeat([]byte("YWFh"), 100)) + `"}]`,
+			expectedStatus: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:           "empty batch",
+			body:           `[]`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "not an array",
+			body:           `{"content": "YQ=="}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Create a mock usecase (it should not be called)
+			mockUsecase := new(MockUsecase)
+
+			// Create a new handler instance with small limits
+			handler := httpHandler.NewHandler(mockUsecase, httpHandler.WithBatchLimits(2, 256))
+
+			// Setup gin router and recorder
+			router := SetupRouter()
+			router.POST("/send/batch", handler.SendBatch)
+
+			// Create a new HTTP request
+			req, _ := http.NewRequest(http.MethodPost, "/send/batch", bytes.NewBufferString(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+
+			// Record the response
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			// Assert the response status code
+			assert.Equal(t, tt.expectedStatus, w.Code)
+
+			// Assert that no methods were called on the mock usecase
+			mockUsecase.AssertExpectations(t)
+		})
+	}
+}
+
+// TestSendAsyncModes tests when the Send method buffers the message and answers 202 Accepted
+func TestSendAsyncModes(t *testing.T) {
+	tests := []struct {
+		name          string
+		mode          httpHandler.AsyncMode
+		prefer        string
+		expectedAsync bool
+	}{
+		{
+			name:          "off ignores the Prefer header",
+			mode:          httpHandler.AsyncOff,
+			prefer:        "respond-async",
+			expectedAsync: false,
+		},
+		{
+			name:          "optional without the Prefer header",
+			mode:          httpHandler.AsyncOptional,
+			expectedAsync: false,
+		},
+		{
+			name:          "optional with the Prefer header",
+			mode:          httpHandler.AsyncOptional,
+			prefer:        "wait=10, respond-async",
+			expectedAsync: true,
+		},
+		{
+			name:          "always",
+			mode:          httpHandler.AsyncAlways,
+			expectedAsync: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Create a mock usecase expecting the asynchronous or the synchronous send
+			mockUsecase := new(MockUsecase)
+			if tt.expectedAsync {
+				mockUsecase.On("SendAsync", mock.Anything, mock.Anything).Return(domain.Receipt{ID: "message-id"}, nil).Once()
+			} else {
+				mockUsecase.On("Send", mock.Anything, mock.Anything).Return(domain.Receipt{}, nil).Once()
+			}
+
+			// Create a new handler instance
+			handler := httpHandler.NewHandler(mockUsecase, httpHandler.WithAsyncMode(tt.mode))
+
+			// Setup gin router and recorder
+			router := SetupRouter()
+			router.POST("/send", handler.Send)
+
+			// Create a new HTTP request
+			jsonBody, _ := json.Marshal(domain.Message{Content: []byte("test-content")})
+			req, _ := http.NewRequest(http.MethodPost, "/send", bytes.NewBuffer(jsonBody))
+			req.Header.Set("Content-Type", "application/json")
+			if tt.prefer != "" {
+				req.Header.Set("Prefer", tt.prefer)
+			}
+
+			// Record the response
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			// Assert the response
+			if tt.expectedAsync {
+				assert.Equal(t, http.StatusAccepted, w.Code)
+				var response httpHandler.AsyncResponse
+				err := json.Unmarshal(w.Body.Bytes(), &response)
+				assert.NoError(t, err)
+				assert.Equal(t, "message-id", response.ID)
+			} else {
+				assert.Equal(t, http.StatusOK, w.Code)
+			}
+
+			// Assert that the expected methods were called on the mock
+			mockUsecase.AssertExpectations(t)
+		})
+	}
+}
+
+// TestSendAsyncDeduplicated tests that a message dropped as a repeat is reported as deduplicated without an ID
+func TestSendAsyncDeduplicated(t *testing.T) {
+	// Create a mock usecase dropping the message
+	mockUsecase := new(MockUsecase)
+	mockUsecase.On("SendAsync", mock.Anything, mock.Anything).Return(domain.Receipt{Deduplicated: true}, nil).Once()
+
+	// Create a new handler instance
+	handler := httpHandler.NewHandler(mockUsecase, httpHandler.WithAsyncMode(httpHandler.AsyncAlways))
+
+	// Setup gin router and recorder
+	router := SetupRouter()
+	router.POST("/send", handler.Send)
+
+	// Create a new HTTP request
+	jsonBody, _ := json.Marshal(domain.Message{Content: []byte("test-content")})
+	req, _ := http.NewRequest(http.MethodPost, "/send", bytes.NewBuffer(jsonBody))
+	req.Header.Set("Content-Type", "application/json")
+
+	// Record the response
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	// Assert the response
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.JSONEq(t, `{"deduplicated": true}`, w.Body.String())
+
+	// Assert that the expected methods were called on the mock
+	mockUsecase.AssertExpectations(t)
+}
+
+// TestSendAsyncBackpressure tests the response when the message cannot be buffered
+func TestSendAsyncBackpressure(t *testing.T) {
+	tests := []struct {
+		name               string
+		err                error
+		expectedStatus     int
+		expectedRetryAfter string
+	}{
+		{
+			name:               "buffer full",
+			err:                domain.ErrQueueFull,
+			expectedStatus:     http.StatusTooManyRequests,
+			expectedRetryAfter: "1",
+		},
+		{
+			name:           "shutting down",
+			err:            domain.ErrShuttingDown,
+			expectedStatus: http.StatusServiceUnavailable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Create a mock usecase that cannot buffer the message
+			mockUsecase := new(MockUsecase)
+			mockUsecase.On("SendAsync", mock.Anything, mock.Anything).Return(domain.Receipt{}, tt.err).Once()
+
+			// Create a new handler instance
+			handler := httpHandler.NewHandler(mockUsecase, httpHandler.WithAsyncMode(httpHandler.AsyncAlways))
+
+			// Setup gin router and recorder
+			router := SetupRouter()
+			router.POST("/send", handler.Send)
+
+			// Create a new HTTP request
+			jsonBody, _ := json.Marshal(domain.Message{Content: []byte("test-content")})
+			req, _ := http.NewRequest(http.MethodPost, "/send", bytes.NewBuffer(jsonBody))
+			req.Header.Set("Content-Type", "application/json")
+
+			// Record the response
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			// Assert the response status code and Retry-After header
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			assert.Equal(t, tt.expectedRetryAfter, w.Header().Get("Retry-After"))
+
+			// Assert that the expected methods were called on the mock
+			mockUsecase.AssertExpectations(t)
+		})
+	}
+}
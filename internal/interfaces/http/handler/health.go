@@ -0,0 +1,48 @@
+package handler
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"time"
+)
+
+const (
+	// StatusOK means the service can accept messages
+	StatusOK = "OK"
+	// StatusUnavailable means at least one dependency is unhealthy
+	StatusUnavailable = "UNAVAILABLE"
+)
+
+// readinessTimeout bounds the time spent checking the dependencies
+const readinessTimeout = 2 * time.Second
+
+// Live reports that the process is running, without checking any dependency
+func (h *Handler) Live(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"status":  StatusOK,
+		"message": "anyway API is running",
+	})
+}
+
+// Ready reports whether the dependencies needed to send messages are healthy.
+// It answers 503 when any required one is not, so traffic is not routed to this instance.
+func (h *Handler) Ready(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), readinessTimeout)
+	defer cancel()
+
+	dependencies := h.producerUsecase.HealthCheck(ctx)
+
+	response := ReadinessResponse{
+		Status:       StatusOK,
+		Dependencies: dependencies,
+	}
+	code := http.StatusOK
+	for _, dependency := range dependencies {
+		if !dependency.Healthy && !dependency.Optional {
+			response.Status = StatusUnavailable
+			code = http.StatusServiceUnavailable
+		}
+	}
+	c.JSON(code, response)
+}
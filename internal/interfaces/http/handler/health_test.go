@@ -0,0 +1,110 @@
+package handler_test
+
+import (
+	"anyway/internal/domain"
+	httpHandler "anyway/internal/interfaces/http/handler"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// TestLive tests that the liveness probe does not check any dependency
+func TestLive(t *testing.T) {
+	// Create a mock usecase (it should not be called)
+	mockUsecase := new(MockUsecase)
+
+	// Create a new handler instance
+	handler := httpHandler.NewHandler(mockUsecase)
+
+	// Setup gin router and recorder
+	router := SetupRouter()
+	router.GET("/health/live", handler.Live)
+
+	// Create a new HTTP request
+	req, _ := http.NewRequest(http.MethodGet, "/health/live", nil)
+
+	// Record the response
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	// Assert the response status code and body
+	assert.Equal(t, http.StatusOK, w.Code)
+	var response map[string]string
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	assert.NoError(t, err)
+	assert.Equal(t, httpHandler.StatusOK, response["status"])
+
+	// Assert that no methods were called on the mock usecase
+	mockUsecase.AssertExpectations(t)
+}
+
+// TestReady tests the readiness probe with healthy and unhealthy dependencies
+func TestReady(t *testing.T) {
+	tests := []struct {
+		name           string
+		dependencies   []domain.DependencyStatus
+		expectedStatus string
+		expectedCode   int
+	}{
+		{
+			name:           "healthy dependencies",
+			dependencies:   []domain.DependencyStatus{{Name: "kafka", Healthy: true}},
+			expectedStatus: httpHandler.StatusOK,
+			expectedCode:   http.StatusOK,
+		},
+		{
+			name: "unhealthy dependency",
+			dependencies: []domain.DependencyStatus{
+				{Name: "kafka", Healthy: false, Error: "no Kafka broker is reachable"},
+			},
+			expectedStatus: httpHandler.StatusUnavailable,
+			expectedCode:   http.StatusServiceUnavailable,
+		},
+		{
+			name: "unhealthy optional dependency",
+			dependencies: []domain.DependencyStatus{
+				{Name: "kafka", Healthy: true},
+				{Name: "redis-streams", Healthy: false, Optional: true, Error: "connection refused"},
+			},
+			expectedStatus: httpHandler.StatusOK,
+			expectedCode:   http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Create a mock usecase
+			mockUsecase := new(MockUsecase)
+			mockUsecase.On("HealthCheck", mock.Anything).Return(tt.dependencies).Once()
+
+			// Create a new handler instance
+			handler := httpHandler.NewHandler(mockUsecase)
+
+			// Setup gin router and recorder
+			router := SetupRouter()
+			router.GET("/health/ready", handler.Ready)
+
+			// Create a new HTTP request
+			req, _ := http.NewRequest(http.MethodGet, "/health/ready", nil)
+
+			// Record the response
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			// Assert the response status code and body
+			assert.Equal(t, tt.expectedCode, w.Code)
+			var response httpHandler.ReadinessResponse
+			err := json.Unmarshal(w.Body.Bytes(), &response)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedStatus, response.Status)
+			assert.Equal(t, tt.dependencies, response.Dependencies)
+
+			// Assert that the expected methods were called on the mock
+			mockUsecase.AssertExpectations(t)
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package handler
+
+import (
+	"anyway/internal/domain"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// MessageKeyHeader carries the key of the message of a raw request
+const MessageKeyHeader = "X-Message-Key"
+
+const (
+	// rawHeaderPrefix is the prefix of the request headers forwarded as message headers by SendRaw
+	rawHeaderPrefix = "X-Message-Header-"
+	// rawQueryHeaderPrefix is the prefix of the query parameters forwarded as message headers by SendRaw
+	rawQueryHeaderPrefix = "header."
+	// contentTypeHeader is the message header carrying the media type of the content
+	contentTypeHeader = "content-type"
+)
+
+// SendRaw processes the POST raw request, whose body is the message content, forwarded unchanged.
+// The topic is taken from the :topic route parameter or the topic query parameter, and the key
+// from the X-Message-Key header or else the key query parameter. The X-Message-Header-<name> request headers
+// and the header.<name> query parameters become message headers, and the Content-Type of the request,
+// with its parameters such as the charset, is forwarded as content-type.
+func (h *Handler) SendRaw(c *gin.Context) {
+	body := c.Request.Body
+	if h.rawMaxBytes > 0 {
+		body = http.MaxBytesReader(c.Writer, body, h.rawMaxBytes)
+	}
+	content, err := io.ReadAll(body)
+	if err != nil {
+		log.Error().Err(err).Msg(err.Error())
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+				"error": fmt.Sprintf("Body exceeds the maximum size of %d bytes", h.rawMaxBytes),
+			})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request: " + err.Error(),
+		})
+		return
+	}
+	if len(content) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request: the body is empty",
+		})
+		return
+	}
+
+	request := domain.Message{
+		Topic:   c.Query("topic"),
+		Key:     rawKey(c),
+		Headers: rawHeaders(c),
+		Content: content,
+	}
+	if topic := c.Param("topic"); topic != "" {
+		request.Topic = topic
+	}
+	h.send(c, request)
+}
+
+// rawKey returns the message key of a raw request, from its header or else its query parameter
+func rawKey(c *gin.Context) string {
+	if key := c.GetHeader(MessageKeyHeader); key != "" {
+		return key
+	}
+	return c.Query("key")
+}
+
+// rawHeaders collects the message headers of a raw request.
+// Header names are lower-cased; query parameters override request headers.
+func rawHeaders(c *gin.Context) map[string]string {
+	headers := map[string]string{}
+	if contentType := c.GetHeader("Content-Type"); contentType != "" {
+		headers[contentTypeHeader] = contentType
+	}
+	for name, values := range c.Request.Header {
+		if len(values) == 0 || !strings.HasPrefix(name, rawHeaderPrefix) {
+			continue
+		}
+		if name = strings.TrimPrefix(name, rawHeaderPrefix); name != "" {
+			headers[strings.ToLower(name)] = values[0]
+		}
+	}
+	for name, values := range c.Request.URL.Query() {
+		if len(values) == 0 || !strings.HasPrefix(name, rawQueryHeaderPrefix) {
+			continue
+		}
+		if name = strings.TrimPrefix(name, rawQueryHeaderPrefix); name != "" {
+			headers[strings.ToLower(name)] = values[0]
+		}
+	}
+	if len(headers) == 0 {
+		return nil
+	}
+	return headers
+}
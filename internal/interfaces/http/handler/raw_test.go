@@ -0,0 +1,155 @@
+package handler_test
+
+import (
+	"anyway/internal/domain"
+	httpHandler "anyway/internal/interfaces/http/handler"
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// TestSendRaw tests that the body is forwarded unchanged with the key, topic and headers of the request
+func TestSendRaw(t *testing.T) {
+	// Create a mock usecase
+	mockUsecase := new(MockUsecase)
+
+	// Expect Send to be called with the raw body and the mapped key, topic and headers
+	content := []byte("{\"id\":1}\n{\"id\":2}\n\x00\xff")
+	expectedMessage := domain.Message{
+		Topic: "orders",
+		Key:   "message-key",
+		Headers: map[string]string{
+			"content-type": "application/x-ndjson; charset=utf-8",
+			"source":       "my-app",
+			"tenant":       "query-tenant",
+		},
+		Content: content,
+	}
+	mockUsecase.On("Send", mock.Anything, expectedMessage).Return(domain.Receipt{}, nil).Once()
+
+	// Create a new handler instance
+	handler := httpHandler.NewHandler(mockUsecase)
+
+	// Setup gin router and recorder
+	router := SetupRouter()
+	router.POST("/topics/:topic/send/raw", handler.SendRaw)
+
+	// Create a new HTTP request with the raw body
+	req, _ := http.NewRequest(http.MethodPost, "/topics/orders/send/raw?topic=ignored&key=message-key&header.tenant=query-tenant", bytes.NewReader(content))
+	req.Header.Set("Content-Type", "application/x-ndjson; charset=utf-8")
+	req.Header.Set("X-Message-Header-Source", "my-app")
+	req.Header.Set("X-Message-Header-Tenant", "header-tenant")
+
+	// Record the response
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	// Assert the response status code
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Assert that the expected methods were called on the mock
+	mockUsecase.AssertExpectations(t)
+}
+
+// TestSendRawKeyHeader tests that the key of the X-Message-Key header takes precedence over the key query parameter
+func TestSendRawKeyHeader(t *testing.T) {
+	// Create a mock usecase
+	mockUsecase := new(MockUsecase)
+
+	// Expect Send to be called with the key of the header
+	mockUsecase.On("Send", mock.Anything, domain.Message{Key: "header-key", Content: []byte("raw")}).Return(domain.Receipt{}, nil).Once()
+
+	// Create a new handler instance
+	handler := httpHandler.NewHandler(mockUsecase)
+
+	// Setup gin router and recorder
+	router := SetupRouter()
+	router.POST("/send/raw", handler.SendRaw)
+
+	// Create a new HTTP request with the key in both the header and the query
+	req, _ := http.NewRequest(http.MethodPost, "/send/raw?key=query-key", bytes.NewReader([]byte("raw")))
+	req.Header.Set(httpHandler.MessageKeyHeader, "header-key")
+
+	// Record the response
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	// Assert the response status code
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Assert that the expected methods were called on the mock
+	mockUsecase.AssertExpectations(t)
+}
+
+// TestSendRawInvalidBody tests that empty and oversized bodies are rejected
+func TestSendRawInvalidBody(t *testing.T) {
+	tests := []struct {
+		name         string
+		body         string
+		expectedCode int
+	}{
+		{name: "empty body", body: "", expectedCode: http.StatusBadRequest},
+		{name: "body too large", body: "0123456789", expectedCode: http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Create a mock usecase (it should not be called)
+			mockUsecase := new(MockUsecase)
+
+			// Create a new handler instance with a small body limit
+			handler := httpHandler.NewHandler(mockUsecase, httpHandler.WithRawMaxBytes(8))
+
+			// Setup gin router and recorder
+			router := SetupRouter()
+			router.POST("/send/raw", handler.SendRaw)
+
+			// Create a new HTTP request
+			req, _ := http.NewRequest(http.MethodPost, "/send/raw", bytes.NewBufferString(tt.body))
+			req.Header.Set("Content-Type", "application/octet-stream")
+
+			// Record the response
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			// Assert the response status code
+			assert.Equal(t, tt.expectedCode, w.Code)
+
+			// Assert that no methods were called on the mock usecase
+			mockUsecase.AssertExpectations(t)
+		})
+	}
+}
+
+// TestSendRawAsync tests that raw requests follow the asynchronous mode
+func TestSendRawAsync(t *testing.T) {
+	// Create a mock usecase
+	mockUsecase := new(MockUsecase)
+
+	// Expect SendAsync to be called with the raw body
+	mockUsecase.On("SendAsync", mock.Anything, domain.Message{Content: []byte("raw")}).Return(domain.Receipt{ID: "test-id"}, nil).Once()
+
+	// Create a new handler instance that always produces in the background
+	handler := httpHandler.NewHandler(mockUsecase, httpHandler.WithAsyncMode(httpHandler.AsyncAlways))
+
+	// Setup gin router and recorder
+	router := SetupRouter()
+	router.POST("/send/raw", handler.SendRaw)
+
+	// Create a new HTTP request without Content-Type
+	req, _ := http.NewRequest(http.MethodPost, "/send/raw", bytes.NewBufferString("raw"))
+
+	// Record the response
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	// Assert the response status code
+	assert.Equal(t, http.StatusAccepted, w.Code)
+
+	// Assert that the expected methods were called on the mock
+	mockUsecase.AssertExpectations(t)
+}
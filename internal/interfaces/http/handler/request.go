@@ -0,0 +1,100 @@
+package handler
+
+import (
+	"anyway/internal/domain"
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+const (
+	// ContentEncodingBase64 means the content is a base64 encoded string
+	ContentEncodingBase64 = "base64"
+	// ContentEncodingJSON means the content is a JSON value produced as JSON
+	ContentEncodingJSON = "json"
+)
+
+// jsonContentType is the content-type header added to the messages with JSON content
+const jsonContentType = "application/json"
+
+// MessageRequest is a message of a send or batch request.
+// Content is a base64 encoded string, or any JSON value when ContentEncoding is "json".
+// Without ContentEncoding, strings are decoded as base64 and any other value is taken as JSON.
+type MessageRequest struct {
+	Topic           string            `json:"topic,omitempty"`
+	Key             string            `json:"key,omitempty"`
+	Headers         map[string]string `json:"headers,omitempty"`
+	Content         json.RawMessage   `json:"content"`
+	ContentEncoding string            `json:"content_encoding,omitempty"`
+}
+
+// message decodes the content of the request and returns the message to send.
+// JSON content is produced in its canonical form (compact, with sorted keys) along
+// with a content-type: application/json header, unless the request sets one.
+func (r MessageRequest) message() (domain.Message, error) {
+	message := domain.Message{
+		Topic:   r.Topic,
+		Key:     r.Key,
+		Headers: r.Headers,
+	}
+	encoding := r.ContentEncoding
+	if encoding == "" {
+		encoding = ContentEncodingBase64
+		if content := bytes.TrimSpace(r.Content); len(content) > 0 && content[0] != '"' && !bytes.Equal(content, []byte("null")) {
+			encoding = ContentEncodingJSON
+		}
+	}
+
+	switch encoding {
+	case ContentEncodingBase64:
+		if err := json.Unmarshal(r.content(), &message.Content); err != nil {
+			return message, fmt.Errorf("content must be a base64 encoded string: %w", err)
+		}
+	case ContentEncodingJSON:
+		content, err := canonicalJSON(r.Content)
+		if err != nil {
+			return message, fmt.Errorf("content must be a JSON value: %w", err)
+		}
+		message.Content = content
+		if _, ok := message.Headers[contentTypeHeader]; !ok {
+			headers := make(map[string]string, len(message.Headers)+1)
+			for k, v := range message.Headers {
+				headers[k] = v
+			}
+			headers[contentTypeHeader] = jsonContentType
+			message.Headers = headers
+		}
+	default:
+		return message, fmt.Errorf("unknown content_encoding %q, expected %s or %s", r.ContentEncoding, ContentEncodingBase64, ContentEncodingJSON)
+	}
+	return message, nil
+}
+
+// content returns the raw content, or null when it is missing
+func (r MessageRequest) content() json.RawMessage {
+	if len(r.Content) == 0 {
+		return json.RawMessage("null")
+	}
+	return r.Content
+}
+
+// canonicalJSON returns the compact form of the JSON value with the object keys sorted.
+// Numbers are kept as written, and <, > and & are not escaped.
+func canonicalJSON(data json.RawMessage) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("content is missing")
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(value); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buffer.Bytes(), []byte("\n")), nil
+}
@@ -0,0 +1,141 @@
+package handler_test
+
+import (
+	"anyway/internal/domain"
+	httpHandler "anyway/internal/interfaces/http/handler"
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// TestSendContentEncoding tests that the content is decoded as base64 or taken as JSON
+func TestSendContentEncoding(t *testing.T) {
+	tests := []struct {
+		name            string
+		body            string
+		expectedMessage domain.Message
+	}{
+		{
+			name:            "base64 string",
+			body:            `{"content": "SGVsbG8gS2Fma2Egd29ybGQh"}`,
+			expectedMessage: domain.Message{Content: []byte("Hello Kafka world!")},
+		},
+		{
+			name: "JSON object in canonical form",
+			body: `{"content": {"b": [1, 2.50], "a": {"z": null, "y": "text"}}}`,
+			expectedMessage: domain.Message{
+				Headers: map[string]string{"content-type": "application/json"},
+				Content: []byte(`{"a":{"y":"text","z":null},"b":[1,2.50]}`),
+			},
+		},
+		{
+			name: "JSON with HTML characters",
+			body: `{"content": {"query": "a<b && c>d"}}`,
+			expectedMessage: domain.Message{
+				Headers: map[string]string{"content-type": "application/json"},
+				Content: []byte(`{"query":"a<b && c>d"}`),
+			},
+		},
+		{
+			name: "JSON array",
+			body: `{"content": [ 1, 2, 3 ]}`,
+			expectedMessage: domain.Message{
+				Headers: map[string]string{"content-type": "application/json"},
+				Content: []byte(`[1,2,3]`),
+			},
+		},
+		{
+			name: "JSON string with explicit encoding",
+			body: `{"content": "plain text", "content_encoding": "json"}`,
+			expectedMessage: domain.Message{
+				Headers: map[string]string{"content-type": "application/json"},
+				Content: []byte(`"plain text"`),
+			},
+		},
+		{
+			name: "content-type of the body takes precedence",
+			body: `{"headers": {"content-type": "application/vnd.order+json"}, "content": {"id": 1}}`,
+			expectedMessage: domain.Message{
+				Headers: map[string]string{"content-type": "application/vnd.order+json"},
+				Content: []byte(`{"id":1}`),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Create a mock usecase
+			mockUsecase := new(MockUsecase)
+			mockUsecase.On("Send", mock.Anything, tt.expectedMessage).Return(domain.Receipt{}, nil).Once()
+
+			// Create a new handler instance
+			handler := httpHandler.NewHandler(mockUsecase)
+
+			// Setup gin router and recorder
+			router := SetupRouter()
+			router.POST("/send", handler.Send)
+
+			// Create a new HTTP request
+			req, _ := http.NewRequest(http.MethodPost, "/send", bytes.NewBufferString(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+
+			// Record the response
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			// Assert the response status code
+			assert.Equal(t, http.StatusOK, w.Code)
+
+			// Assert that the expected methods were called on the mock
+			mockUsecase.AssertExpectations(t)
+		})
+	}
+}
+
+// TestSendInvalidContent tests that content not matching its encoding is rejected
+func TestSendInvalidContent(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+		body string
+	}{
+		{name: "invalid base64", path: "/send", body: `{"content": "not base64!", "content_encoding": "base64"}`},
+		{name: "object as base64", path: "/send", body: `{"content": {"id": 1}, "content_encoding": "base64"}`},
+		{name: "unknown encoding", path: "/send", body: `{"content": "SGVsbG8=", "content_encoding": "hex"}`},
+		{name: "invalid batch item", path: "/send/batch", body: `[{"content": "SGVsbG8="}, {"content": "not base64!"}]`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Create a mock usecase (it should not be called)
+			mockUsecase := new(MockUsecase)
+
+			// Create a new handler instance
+			handler := httpHandler.NewHandler(mockUsecase)
+
+			// Setup gin router and recorder
+			router := SetupRouter()
+			router.POST("/send", handler.Send)
+			router.POST("/send/batch", handler.SendBatch)
+
+			// Create a new HTTP request
+			req, _ := http.NewRequest(http.MethodPost, tt.path, bytes.NewBufferString(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+
+			// Record the response
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			// Assert the response status code
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+
+			// Assert that the usecase was not called
+			mockUsecase.AssertNotCalled(t, "Send", mock.Anything, mock.Anything)
+			mockUsecase.AssertNotCalled(t, "SendBatch", mock.Anything, mock.Anything)
+		})
+	}
+}
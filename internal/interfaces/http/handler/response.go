@@ -0,0 +1,60 @@
+package handler
+
+import "anyway/internal/domain"
+
+const (
+	// IdempotentReplayedHeader is set on the responses to a repeated idempotency key, whose message was not sent again
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+	// ProduceAttemptsHeader reports how many times the message was produced when it was retried
+	ProduceAttemptsHeader = "X-Produce-Attempts"
+	// DeadLetteredHeader is set on the error responses whose message was routed to the dead-letter queue
+	DeadLetteredHeader = "X-Dead-Lettered"
+)
+
+const (
+	// StatusAccepted means the message was produced
+	StatusAccepted = "accepted"
+	// StatusRejected means the message was not produced
+	StatusRejected = "rejected"
+)
+
+// SendResponse is the response of a message produced synchronously.
+// Destinations is only present when the message is fanned out to several backends,
+// and Deduplicated when the message was dropped as a repeat of a recent one.
+type SendResponse struct {
+	Destinations []domain.Destination `json:"destinations,omitempty"`
+	Deduplicated bool                 `json:"deduplicated,omitempty"`
+}
+
+// AsyncResponse is the response of a message accepted to be produced in the background.
+// A message dropped as a repeat of a recent one is marked as Deduplicated and has no ID, since it is never produced.
+type AsyncResponse struct {
+	ID           string `json:"id,omitempty"`
+	Deduplicated bool   `json:"deduplicated,omitempty"`
+}
+
+// BatchResponse is the response of a batch request
+type BatchResponse struct {
+	Accepted int               `json:"accepted"`
+	Rejected int               `json:"rejected"`
+	Results  []BatchItemResult `json:"results"`
+}
+
+// BatchItemResult is the outcome of a single message of a batch request.
+// Violations lists why the content does not match its schema when the message is invalid,
+// and Rule names the policy rule the message breaks.
+// An accepted message is marked as Deduplicated when it was dropped as a repeat of a recent one.
+type BatchItemResult struct {
+	Index        int      `json:"index"`
+	Status       string   `json:"status"`
+	Deduplicated bool     `json:"deduplicated,omitempty"`
+	Error        string   `json:"error,omitempty"`
+	Violations   []string `json:"violations,omitempty"`
+	Rule         string   `json:"rule,omitempty"`
+}
+
+// ReadinessResponse is the response of the readiness probe
+type ReadinessResponse struct {
+	Status       string                    `json:"status"`
+	Dependencies []domain.DependencyStatus `json:"dependencies"`
+}
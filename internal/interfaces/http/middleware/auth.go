@@ -0,0 +1,169 @@
+package middleware
+
+import (
+	"anyway/internal/domain"
+	"bufio"
+	"crypto"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/rs/zerolog/log"
+)
+
+// APIKeyHeader carries the API key of a request
+const APIKeyHeader = "X-API-Key"
+
+// bearerPrefix starts the Authorization header carrying a JWT
+const bearerPrefix = "Bearer "
+
+// defaultCallerClaim is the claim identifying the caller of a JWT
+const defaultCallerClaim = "sub"
+
+// signingMethods are the asymmetric algorithms accepted for the tokens
+var signingMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+
+// Authentication holds the credentials accepted by Authenticate
+type Authentication struct {
+	// APIKeys maps each caller to the hex encoded SHA-256 hash of its API key
+	APIKeys map[string]string
+	// JWKS are the public keys verifying the bearer tokens, by key ID
+	JWKS map[string]crypto.PublicKey
+	// Issuer and Audience, when set, must match the iss and aud claims of the tokens
+	Issuer   string
+	Audience string
+	// CallerClaim is the claim of the tokens identifying the caller; "sub" when empty
+	CallerClaim string
+}
+
+// Enabled tells whether any credential is configured
+func (a Authentication) Enabled() bool {
+	return len(a.APIKeys) > 0 || len(a.JWKS) > 0
+}
+
+// Authenticate rejects the requests without a valid API key (in the X-API-Key header) or bearer token
+// with 401 Unauthorized, and stores the identity of the caller in the request metadata, so it is
+// forwarded as the caller message header. It must run after RequestMetadata.
+func Authenticate(auth Authentication) gin.HandlerFunc {
+	callers := make(map[string]string, len(auth.APIKeys))
+	for caller, hash := range auth.APIKeys {
+		if !validHash(hash) {
+			log.Warn().Msgf("Ignoring the API key of %s: expected a hex encoded SHA-256 hash", caller)
+			continue
+		}
+		callers[strings.ToLower(hash)] = caller
+	}
+	callerClaim := auth.CallerClaim
+	if callerClaim == "" {
+		callerClaim = defaultCallerClaim
+	}
+	options := []jwt.ParserOption{jwt.WithValidMethods(signingMethods), jwt.WithExpirationRequired()}
+	if auth.Issuer != "" {
+		options = append(options, jwt.WithIssuer(auth.Issuer))
+	}
+	if auth.Audience != "" {
+		options = append(options, jwt.WithAudience(auth.Audience))
+	}
+	parser := jwt.NewParser(options...)
+
+	return func(c *gin.Context) {
+		caller, claims, err := authenticate(c.Request, callers, parser, auth.JWKS, callerClaim)
+		if err != nil {
+			log.Ctx(c.Request.Context()).Warn().Err(err).Msg("Rejected unauthenticated request")
+			c.Header("WWW-Authenticate", `Bearer realm="anyway"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"error": "Unauthorized: " + err.Error(),
+			})
+			return
+		}
+		metadata := domain.MetadataFromContext(c.Request.Context())
+		metadata.Caller = caller
+		metadata.Claims = claims
+		c.Request = c.Request.WithContext(domain.WithMetadata(c.Request.Context(), metadata))
+		c.Next()
+	}
+}
+
+// authenticate returns the caller identified by the API key or the bearer token of the request,
+// along with the claims of the token
+func authenticate(r *http.Request, callers map[string]string, parser *jwt.Parser, keys map[string]crypto.PublicKey, callerClaim string) (string, map[string]interface{}, error) {
+	if apiKey := r.Header.Get(APIKeyHeader); apiKey != "" {
+		hash := sha256.Sum256([]byte(apiKey))
+		caller, ok := callers[hex.EncodeToString(hash[:])]
+		if !ok {
+			return "", nil, errors.New("invalid API key")
+		}
+		return caller, nil, nil
+	}
+
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), bearerPrefix)
+	if !ok || len(keys) == 0 {
+		return "", nil, errors.New("missing credentials")
+	}
+	claims := jwt.MapClaims{}
+	_, err := parser.ParseWithClaims(strings.TrimSpace(token), claims, func(token *jwt.Token) (interface{}, error) {
+		return verificationKey(token, keys)
+	})
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid token: %w", err)
+	}
+	caller, _ := claims[callerClaim].(string)
+	if caller == "" {
+		return "", nil, fmt.Errorf("invalid token: missing %s claim", callerClaim)
+	}
+	return caller, claims, nil
+}
+
+// verificationKey returns the key matching the kid of the token; tokens without kid
+// are accepted when there is a single key
+func verificationKey(token *jwt.Token, keys map[string]crypto.PublicKey) (crypto.PublicKey, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" && len(keys) == 1 {
+		for _, key := range keys {
+			return key, nil
+		}
+	}
+	key, ok := keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+	return key, nil
+}
+
+// LoadAPIKeys reads the API keys of a file with one caller=hash line per caller, where hash is the
+// hex encoded SHA-256 hash of the key. Empty lines and lines starting with # are skipped.
+func LoadAPIKeys(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	keys := map[string]string{}
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		caller, hash, found := strings.Cut(text, "=")
+		caller, hash = strings.TrimSpace(caller), strings.TrimSpace(hash)
+		if !found || caller == "" || !validHash(hash) {
+			return nil, fmt.Errorf("%s:%d: expected caller=sha256-hex", path, line)
+		}
+		keys[caller] = hash
+	}
+	return keys, scanner.Err()
+}
+
+// validHash tells whether the value is a hex encoded SHA-256 hash
+func validHash(value string) bool {
+	decoded, err := hex.DecodeString(value)
+	return err == nil && len(decoded) == sha256.Size
+}
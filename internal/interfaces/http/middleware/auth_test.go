@@ -0,0 +1,209 @@
+package middleware_test
+
+import (
+	"anyway/internal/domain"
+	"anyway/internal/interfaces/http/middleware"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+// hashKey returns the hex encoded SHA-256 hash of an API key
+func hashKey(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:])
+}
+
+// authenticatedCaller sends the request through the RequestMetadata and Authenticate middlewares
+// and returns the response and the caller stored in the request metadata
+func authenticatedCaller(auth middleware.Authentication, req *http.Request) (*httptest.ResponseRecorder, string) {
+	w, metadata := authenticatedMetadata(auth, req)
+	return w, metadata.Caller
+}
+
+// authenticatedMetadata sends the request through the RequestMetadata and Authenticate middlewares
+// and returns the response and the request metadata
+func authenticatedMetadata(auth middleware.Authentication, req *http.Request) (*httptest.ResponseRecorder, domain.Metadata) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(middleware.RequestMetadata())
+	router.Use(middleware.Authenticate(auth))
+
+	var metadata domain.Metadata
+	router.GET("/", func(c *gin.Context) {
+		metadata = domain.MetadataFromContext(c.Request.Context())
+		c.Status(http.StatusOK)
+	})
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w, metadata
+}
+
+// TestAuthenticateAPIKey tests that requests are authenticated by the hash of their API key
+func TestAuthenticateAPIKey(t *testing.T) {
+	auth := middleware.Authentication{
+		APIKeys: map[string]string{
+			"billing": hashKey("billing-secret"),
+			"broken":  "not-a-hash",
+		},
+	}
+	tests := []struct {
+		name           string
+		apiKey         string
+		expectedCode   int
+		expectedCaller string
+	}{
+		{name: "valid key", apiKey: "billing-secret", expectedCode: http.StatusOK, expectedCaller: "billing"},
+		{name: "unknown key", apiKey: "other-secret", expectedCode: http.StatusUnauthorized},
+		{name: "hash as key", apiKey: hashKey("billing-secret"), expectedCode: http.StatusUnauthorized},
+		{name: "missing key", expectedCode: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.apiKey != "" {
+				req.Header.Set(middleware.APIKeyHeader, tt.apiKey)
+			}
+			w, caller := authenticatedCaller(auth, req)
+
+			assert.Equal(t, tt.expectedCode, w.Code)
+			assert.Equal(t, tt.expectedCaller, caller)
+			if tt.expectedCode == http.StatusUnauthorized {
+				assert.NotEmpty(t, w.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
+
+// TestAuthenticateJWT tests that bearer tokens are verified with the key of their kid and their claims
+func TestAuthenticateJWT(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+
+	auth := middleware.Authentication{
+		JWKS: map[string]crypto.PublicKey{
+			"rsa-key": &rsaKey.PublicKey,
+			"ec-key":  &ecKey.PublicKey,
+		},
+		Issuer:      "https://issuer.example.com",
+		Audience:    "anyway",
+		CallerClaim: "client_id",
+	}
+	claims := func(overrides jwt.MapClaims) jwt.MapClaims {
+		result := jwt.MapClaims{
+			"iss":       "https://issuer.example.com",
+			"aud":       "anyway",
+			"sub":       "user-1",
+			"client_id": "checkout",
+			"exp":       time.Now().Add(time.Hour).Unix(),
+		}
+		for k, v := range overrides {
+			result[k] = v
+		}
+		return result
+	}
+	sign := func(method jwt.SigningMethod, kid string, key crypto.PrivateKey, claims jwt.MapClaims) string {
+		token := jwt.NewWithClaims(method, claims)
+		if kid != "" {
+			token.Header["kid"] = kid
+		}
+		signed, err := token.SignedString(key)
+		assert.NoError(t, err)
+		return signed
+	}
+
+	tests := []struct {
+		name           string
+		token          string
+		expectedCode   int
+		expectedCaller string
+	}{
+		{name: "RSA token", token: sign(jwt.SigningMethodRS256, "rsa-key", rsaKey, claims(nil)), expectedCode: http.StatusOK, expectedCaller: "checkout"},
+		{name: "EC token", token: sign(jwt.SigningMethodES256, "ec-key", ecKey, claims(nil)), expectedCode: http.StatusOK, expectedCaller: "checkout"},
+		{name: "unknown kid", token: sign(jwt.SigningMethodRS256, "other-key", otherKey, claims(nil)), expectedCode: http.StatusUnauthorized},
+		{name: "signed by another key", token: sign(jwt.SigningMethodRS256, "rsa-key", otherKey, claims(nil)), expectedCode: http.StatusUnauthorized},
+		{name: "without kid and several keys", token: sign(jwt.SigningMethodRS256, "", rsaKey, claims(nil)), expectedCode: http.StatusUnauthorized},
+		{name: "expired", token: sign(jwt.SigningMethodRS256, "rsa-key", rsaKey, claims(jwt.MapClaims{"exp": time.Now().Add(-time.Minute).Unix()})), expectedCode: http.StatusUnauthorized},
+		{name: "without expiration", token: sign(jwt.SigningMethodRS256, "rsa-key", rsaKey, claims(jwt.MapClaims{"exp": nil})), expectedCode: http.StatusUnauthorized},
+		{name: "wrong issuer", token: sign(jwt.SigningMethodRS256, "rsa-key", rsaKey, claims(jwt.MapClaims{"iss": "https://other.example.com"})), expectedCode: http.StatusUnauthorized},
+		{name: "wrong audience", token: sign(jwt.SigningMethodRS256, "rsa-key", rsaKey, claims(jwt.MapClaims{"aud": "other"})), expectedCode: http.StatusUnauthorized},
+		{name: "missing caller claim", token: sign(jwt.SigningMethodRS256, "rsa-key", rsaKey, claims(jwt.MapClaims{"client_id": nil})), expectedCode: http.StatusUnauthorized},
+		{name: "symmetric algorithm", token: sign(jwt.SigningMethodHS256, "rsa-key", []byte("secret"), claims(nil)), expectedCode: http.StatusUnauthorized},
+		{name: "malformed token", token: "not-a-token", expectedCode: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+			w, caller := authenticatedCaller(auth, req)
+
+			assert.Equal(t, tt.expectedCode, w.Code)
+			assert.Equal(t, tt.expectedCaller, caller)
+		})
+	}
+}
+
+// TestAuthenticateJWTSingleKey tests that tokens without kid are verified with the only key
+func TestAuthenticateJWTSingleKey(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	auth := middleware.Authentication{JWKS: map[string]crypto.PublicKey{"": &key.PublicKey}}
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"sub": "user-1",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}).SignedString(key)
+	assert.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w, metadata := authenticatedMetadata(auth, req)
+
+	// Assert that the caller and the claims of the token are stored
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "user-1", metadata.Caller)
+	assert.Equal(t, "user-1", metadata.Claims["sub"])
+}
+
+// TestLoadAPIKeys tests that the API keys file is parsed, skipping comments and empty lines
+func TestLoadAPIKeys(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "api-keys")
+	content := "# billing team\nbilling = " + hashKey("billing-secret") + "\n\ncheckout=" + hashKey("checkout-secret") + "\n"
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+
+	keys, err := middleware.LoadAPIKeys(path)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"billing":  hashKey("billing-secret"),
+		"checkout": hashKey("checkout-secret"),
+	}, keys)
+
+	// Plain keys are not accepted
+	assert.NoError(t, os.WriteFile(path, []byte("billing=billing-secret\n"), 0o600))
+	_, err = middleware.LoadAPIKeys(path)
+	assert.Error(t, err)
+
+	_, err = middleware.LoadAPIKeys(filepath.Join(dir, "missing"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,36 @@
+package middleware
+
+import (
+	"time"
+
+	"github.com/gin-contrib/cors"
+	"github.com/gin-gonic/gin"
+)
+
+// CORS handles Cross-Origin Resource Sharing for the given origins, or for every origin when there are none.
+// It allows the credential headers of Authenticate and the idempotency key besides the request ID headers.
+func CORS(allowedOrigins []string) gin.HandlerFunc {
+	config := cors.DefaultConfig()
+	if len(allowedOrigins) == 0 {
+		config.AllowAllOrigins = true
+	} else {
+		config.AllowOrigins = allowedOrigins
+	}
+	config.AllowMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE", "HEAD", "OPTIONS"}
+	config.AllowHeaders = []string{
+		"Origin",
+		"Content-Length",
+		"Content-Type",
+		"Authorization",
+		APIKeyHeader,
+		RoutingIDHeader,
+		CorrelationIDHeader,
+		RequestIDHeader,
+		IdempotencyKeyHeader,
+	}
+	config.ExposeHeaders = []string{"Content-Length", RequestIDHeader, CorrelationIDHeader, "Idempotent-Replayed", "X-Produce-Attempts", "X-Dead-Lettered"}
+	config.AllowCredentials = true
+	config.MaxAge = 12 * time.Hour
+
+	return cors.New(config)
+}
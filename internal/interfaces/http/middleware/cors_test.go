@@ -0,0 +1,50 @@
+package middleware_test
+
+import (
+	"anyway/internal/interfaces/http/middleware"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestCORS tests that preflight requests allow the credential headers for the allowed origins
+func TestCORS(t *testing.T) {
+	tests := []struct {
+		name           string
+		allowedOrigins []string
+		origin         string
+		expectedOrigin string
+	}{
+		{name: "every origin", origin: "https://app.example.com", expectedOrigin: "*"},
+		{name: "allowed origin", allowedOrigins: []string{"https://app.example.com"}, origin: "https://app.example.com", expectedOrigin: "https://app.example.com"},
+		{name: "other origin", allowedOrigins: []string{"https://app.example.com"}, origin: "https://evil.example.com", expectedOrigin: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			router := gin.New()
+			router.Use(middleware.CORS(tt.allowedOrigins))
+			router.POST("/", func(c *gin.Context) {
+				c.Status(http.StatusOK)
+			})
+
+			// Create a preflight request with an API key
+			req := httptest.NewRequest(http.MethodOptions, "/", nil)
+			req.Header.Set("Origin", tt.origin)
+			req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+			req.Header.Set("Access-Control-Request-Headers", "X-API-Key")
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedOrigin, w.Header().Get("Access-Control-Allow-Origin"))
+			if tt.expectedOrigin != "" {
+				assert.Contains(t, w.Header().Get("Access-Control-Allow-Headers"), "X-Api-Key")
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package middleware_test
+
+import (
+	"anyway/internal/interfaces/http/middleware"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeJWKS writes the keys as a JSON Web Key Set file
+func writeJWKS(t *testing.T, keys ...map[string]string) string {
+	data, err := json.Marshal(map[string]interface{}{"keys": keys})
+	assert.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	assert.NoError(t, os.WriteFile(path, data, 0o644))
+	return path
+}
+
+// encode returns the base64url encoding of the bytes
+func encode(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// TestLoadJWKS tests that RSA, EC and Ed25519 signing keys are loaded by key ID
+func TestLoadJWKS(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	assert.NoError(t, err)
+	edKey, _, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+
+	path := writeJWKS(t,
+		map[string]string{"kty": "RSA", "kid": "rsa", "use": "sig", "n": encode(rsaKey.N.Bytes()), "e": encode(big.NewInt(int64(rsaKey.E)).Bytes())},
+		map[string]string{"kty": "EC", "kid": "ec", "crv": "P-384", "x": encode(ecKey.X.Bytes()), "y": encode(ecKey.Y.Bytes())},
+		map[string]string{"kty": "OKP", "kid": "ed", "crv": "Ed25519", "x": encode(edKey)},
+		map[string]string{"kty": "RSA", "kid": "encryption", "use": "enc", "n": "AQAB", "e": "AQAB"},
+	)
+
+	keys, err := middleware.LoadJWKS(path)
+	assert.NoError(t, err)
+	assert.Len(t, keys, 3)
+	assert.True(t, rsaKey.PublicKey.Equal(keys["rsa"]))
+	assert.True(t, ecKey.PublicKey.Equal(keys["ec"]))
+	assert.True(t, edKey.Equal(keys["ed"]))
+}
+
+// TestLoadJWKSErrors tests that invalid key sets are reported
+func TestLoadJWKSErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		keys []map[string]string
+	}{
+		{name: "no keys"},
+		{name: "unsupported key type", keys: []map[string]string{{"kty": "oct", "k": "c2VjcmV0"}}},
+		{name: "unsupported curve", keys: []map[string]string{{"kty": "EC", "crv": "P-192", "x": "AQ", "y": "AQ"}}},
+		{name: "invalid RSA modulus", keys: []map[string]string{{"kty": "RSA", "n": "!!", "e": "AQAB"}}},
+		{name: "invalid Ed25519 key", keys: []map[string]string{{"kty": "OKP", "crv": "Ed25519", "x": "AQ"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := middleware.LoadJWKS(writeJWKS(t, tt.keys...))
+			assert.Error(t, err)
+		})
+	}
+
+	_, err := middleware.LoadJWKS(filepath.Join(t.TempDir(), "missing.json"))
+	assert.Error(t, err)
+}
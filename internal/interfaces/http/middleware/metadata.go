@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"anyway/internal/domain"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	// RequestIDHeader identifies a single request
+	RequestIDHeader = "X-Request-Id"
+	// CorrelationIDHeader identifies the flow a request belongs to, across services
+	CorrelationIDHeader = "X-Correlation-Id"
+	// RoutingIDHeader is the default message key of a request
+	RoutingIDHeader = "X-Routing-Id"
+	// IdempotencyKeyHeader identifies the retries of a request
+	IdempotencyKeyHeader = "Idempotency-Key"
+)
+
+// RequestMetadata stores the request, correlation and routing IDs and the idempotency key of the request as domain.Metadata
+// in the request context. A missing request ID is generated, and a missing correlation ID defaults
+// to the request ID; both are echoed back in the response headers.
+// It must run before the anysher logger middleware so it logs the same request ID.
+func RequestMetadata() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		metadata := domain.Metadata{
+			RequestID:      c.GetHeader(RequestIDHeader),
+			CorrelationID:  c.GetHeader(CorrelationIDHeader),
+			RoutingID:      c.GetHeader(RoutingIDHeader),
+			IdempotencyKey: c.GetHeader(IdempotencyKeyHeader),
+		}
+		if metadata.RequestID == "" {
+			metadata.RequestID = uuid.NewString()
+			c.Request.Header.Set(RequestIDHeader, metadata.RequestID)
+		}
+		if metadata.CorrelationID == "" {
+			metadata.CorrelationID = metadata.RequestID
+		}
+		c.Header(RequestIDHeader, metadata.RequestID)
+		c.Header(CorrelationIDHeader, metadata.CorrelationID)
+
+		c.Request = c.Request.WithContext(domain.WithMetadata(c.Request.Context(), metadata))
+		c.Next()
+	}
+}
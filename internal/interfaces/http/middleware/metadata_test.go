@@ -0,0 +1,71 @@
+package middleware_test
+
+import (
+	"anyway/internal/domain"
+	"anyway/internal/interfaces/http/middleware"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// serveWithMetadata sends the request through the RequestMetadata middleware and returns the metadata seen by the handler
+func serveWithMetadata(req *http.Request) (domain.Metadata, *httptest.ResponseRecorder) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(middleware.RequestMetadata())
+
+	var metadata domain.Metadata
+	router.GET("/", func(c *gin.Context) {
+		metadata = domain.MetadataFromContext(c.Request.Context())
+		c.Status(http.StatusOK)
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return metadata, w
+}
+
+// TestRequestMetadata tests that the IDs of the request headers are stored in the context and echoed back
+func TestRequestMetadata(t *testing.T) {
+	// Create a new HTTP request with every ID
+	req, _ := http.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Request-Id", "test-request-id")
+	req.Header.Set("X-Correlation-Id", "test-correlation-id")
+	req.Header.Set("X-Routing-Id", "test-routing-id")
+	req.Header.Set("Idempotency-Key", "test-idempotency-key")
+
+	metadata, w := serveWithMetadata(req)
+
+	// Assert the metadata and the response headers
+	assert.Equal(t, domain.Metadata{
+		RequestID:      "test-request-id",
+		CorrelationID:  "test-correlation-id",
+		RoutingID:      "test-routing-id",
+		IdempotencyKey: "test-idempotency-key",
+	}, metadata)
+	assert.Equal(t, "test-request-id", w.Header().Get("X-Request-Id"))
+	assert.Equal(t, "test-correlation-id", w.Header().Get("X-Correlation-Id"))
+}
+
+// TestRequestMetadataGeneratesIDs tests that a missing request ID is generated and used as correlation ID
+func TestRequestMetadataGeneratesIDs(t *testing.T) {
+	// Create a new HTTP request without IDs
+	req, _ := http.NewRequest(http.MethodGet, "/", nil)
+
+	metadata, w := serveWithMetadata(req)
+
+	// Assert that the generated request ID is a UUID used as correlation ID and echoed back
+	_, err := uuid.Parse(metadata.RequestID)
+	assert.NoError(t, err)
+	assert.Equal(t, metadata.RequestID, metadata.CorrelationID)
+	assert.Empty(t, metadata.RoutingID)
+	assert.Equal(t, metadata.RequestID, w.Header().Get("X-Request-Id"))
+	assert.Equal(t, metadata.RequestID, w.Header().Get("X-Correlation-Id"))
+
+	// Assert that the request header is set, so later middlewares log the same ID
+	assert.Equal(t, metadata.RequestID, req.Header.Get("X-Request-Id"))
+}
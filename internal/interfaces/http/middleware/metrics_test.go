@@ -0,0 +1,37 @@
+package middleware_test
+
+import (
+	"anyway/internal/interfaces/http/middleware"
+	"anyway/internal/metrics"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestMetrics tests that the requests are counted by route and status code
+func TestMetrics(t *testing.T) {
+	// Setup a gin router with the metrics middleware
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(middleware.Metrics())
+	router.GET("/items/:id", func(c *gin.Context) {
+		c.Status(http.StatusAccepted)
+	})
+
+	before := testutil.ToFloat64(metrics.HTTPRequests.WithLabelValues(http.MethodGet, "/items/:id", "202"))
+	beforeUnmatched := testutil.ToFloat64(metrics.HTTPRequests.WithLabelValues(http.MethodGet, "unmatched", "404"))
+
+	// Send a request to a route and another one that does not match any route
+	for _, path := range []string{"/items/1", "/unknown"} {
+		req, _ := http.NewRequest(http.MethodGet, path, nil)
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	// Assert that the requests were counted with the route template, not the path
+	assert.Equal(t, before+1, testutil.ToFloat64(metrics.HTTPRequests.WithLabelValues(http.MethodGet, "/items/:id", "202")))
+	assert.Equal(t, beforeUnmatched+1, testutil.ToFloat64(metrics.HTTPRequests.WithLabelValues(http.MethodGet, "unmatched", "404")))
+}
@@ -0,0 +1,84 @@
+package middleware
+
+import (
+	"anyway/internal/domain"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// deniedHeaders are the credentials never forwarded as message headers, whatever the rules say
+var deniedHeaders = []string{"Authorization", "Proxy-Authorization", APIKeyHeader, "Cookie", "Set-Cookie"}
+
+// HeaderPropagation are the rules selecting the request headers forwarded as message headers
+type HeaderPropagation struct {
+	// Allow are the request headers to forward; entries ending in "*" match every header with that prefix
+	Allow []string
+	// Rename maps a request header to the name of the message header it is forwarded as; renamed
+	// headers are forwarded without being allowed. The rest keep their canonical name (e.g. "X-Tenant-Id").
+	Rename map[string]string
+	// Deny are request headers never forwarded, besides the credentials and cookies
+	Deny []string
+	// Static are headers added to every message, overriding the forwarded ones
+	Static map[string]string
+}
+
+// PropagateHeaders stores the request headers selected by the rules in the request metadata,
+// so they are forwarded as message headers. It must run after RequestMetadata.
+func PropagateHeaders(rules HeaderPropagation) gin.HandlerFunc {
+	allow := map[string]bool{}
+	var prefixes []string
+	for _, name := range rules.Allow {
+		if prefix, ok := strings.CutSuffix(name, "*"); ok {
+			prefixes = append(prefixes, http.CanonicalHeaderKey(prefix))
+			continue
+		}
+		allow[http.CanonicalHeaderKey(name)] = true
+	}
+	deny := map[string]bool{}
+	for _, name := range append(deniedHeaders, rules.Deny...) {
+		deny[http.CanonicalHeaderKey(name)] = true
+	}
+	rename := make(map[string]string, len(rules.Rename))
+	for from, to := range rules.Rename {
+		rename[http.CanonicalHeaderKey(from)] = to
+	}
+	allowed := func(name string) bool {
+		if deny[name] {
+			return false
+		}
+		if _, renamed := rename[name]; allow[name] || renamed {
+			return true
+		}
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(name, prefix) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return func(c *gin.Context) {
+		headers := map[string]string{}
+		for name, values := range c.Request.Header {
+			if len(values) == 0 || !allowed(name) {
+				continue
+			}
+			if renamed, ok := rename[name]; ok {
+				name = renamed
+			}
+			headers[name] = values[0]
+		}
+		for name, value := range rules.Static {
+			headers[name] = value
+		}
+		if len(headers) > 0 {
+			ctx := c.Request.Context()
+			metadata := domain.MetadataFromContext(ctx)
+			metadata.Headers = headers
+			c.Request = c.Request.WithContext(domain.WithMetadata(ctx, metadata))
+		}
+		c.Next()
+	}
+}
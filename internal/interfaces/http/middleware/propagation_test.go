@@ -0,0 +1,82 @@
+package middleware_test
+
+import (
+	"anyway/internal/domain"
+	"anyway/internal/interfaces/http/middleware"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// propagatedHeaders sends the request through the RequestMetadata and PropagateHeaders middlewares
+// and returns the headers stored in the request metadata
+func propagatedHeaders(rules middleware.HeaderPropagation, req *http.Request) map[string]string {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(middleware.RequestMetadata())
+	router.Use(middleware.PropagateHeaders(rules))
+
+	var metadata domain.Metadata
+	router.GET("/", func(c *gin.Context) {
+		metadata = domain.MetadataFromContext(c.Request.Context())
+		c.Status(http.StatusOK)
+	})
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	return metadata.Headers
+}
+
+// TestPropagateHeaders tests that allowed, prefixed, renamed and static headers are propagated
+func TestPropagateHeaders(t *testing.T) {
+	rules := middleware.HeaderPropagation{
+		Allow:  []string{"content-type", "X-Forward-*", "Authorization", "X-Forward-Cookie"},
+		Rename: map[string]string{"X-Tenant-Id": "tenant_id"},
+		Deny:   []string{"X-Forward-Secret"},
+		Static: map[string]string{"environment": "production"},
+	}
+
+	// Create a new HTTP request with allowed, denied and unknown headers
+	req, _ := http.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Forward-Region", "eu-west-1")
+	req.Header.Set("X-Forward-Secret", "secret")
+	req.Header.Set("X-Tenant-Id", "acme")
+	req.Header.Set("Authorization", "Bearer token")
+	req.Header.Set("Cookie", "session=1")
+	req.Header.Set("User-Agent", "curl")
+
+	// Assert that only the selected headers are propagated
+	assert.Equal(t, map[string]string{
+		"Content-Type":     "application/json",
+		"X-Forward-Region": "eu-west-1",
+		"tenant_id":        "acme",
+		"environment":      "production",
+	}, propagatedHeaders(rules, req))
+}
+
+// TestPropagateHeadersCredentials tests that the credentials are not propagated even when their prefix is allowed
+func TestPropagateHeadersCredentials(t *testing.T) {
+	rules := middleware.HeaderPropagation{Allow: []string{"X-A*", "Authorization"}}
+
+	// Create a new HTTP request with an API key, a bearer token and a header to forward
+	req, _ := http.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(middleware.APIKeyHeader, "secret-key")
+	req.Header.Set("Authorization", "Bearer token")
+	req.Header.Set("X-Account-Id", "acme")
+
+	// Assert that only the header to forward is propagated
+	assert.Equal(t, map[string]string{"X-Account-Id": "acme"}, propagatedHeaders(rules, req))
+}
+
+// TestPropagateHeadersWithoutRules tests that no header is propagated by default
+func TestPropagateHeadersWithoutRules(t *testing.T) {
+	// Create a new HTTP request with some headers
+	req, _ := http.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer token")
+
+	// Assert that nothing is propagated
+	assert.Empty(t, propagatedHeaders(middleware.HeaderPropagation{}, req))
+}
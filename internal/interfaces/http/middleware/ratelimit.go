@@ -0,0 +1,276 @@
+package middleware
+
+import (
+	"anyway/internal/domain"
+	"anyway/internal/metrics"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/time/rate"
+)
+
+const (
+	// RateLimitByAPIKey identifies the clients as the caller authenticated by their API key or bearer token, or else by their IP
+	RateLimitByAPIKey = "api_key"
+	// RateLimitByIP identifies the clients by their IP
+	RateLimitByIP = "ip"
+	// RateLimitByHeaderPrefix followed by a header name identifies the clients by that header, or else by their IP
+	RateLimitByHeaderPrefix = "header:"
+)
+
+// Rate limit response headers
+const (
+	RateLimitLimitHeader     = "X-RateLimit-Limit"
+	RateLimitRemainingHeader = "X-RateLimit-Remaining"
+	RateLimitResetHeader     = "X-RateLimit-Reset"
+)
+
+// limiterSweepInterval is how often the limiters of idle clients are dropped
+const limiterSweepInterval = time.Minute
+
+// RateLimits are the token bucket limits applied by RateLimit. Zero rates are not limited.
+// Each bucket holds one second of its rate, so a client may burst up to its rate at once.
+// A body larger than one second of a byte rate waits until the bucket is full, and is then charged over time.
+type RateLimits struct {
+	// KeyBy selects what identifies a client: api_key (the default), ip or header:<name>
+	KeyBy string
+	// RequestsPerSecond and BytesPerSecond limit every client
+	RequestsPerSecond float64
+	BytesPerSecond    float64
+	// GlobalRequestsPerSecond and GlobalBytesPerSecond limit all the clients together
+	GlobalRequestsPerSecond float64
+	GlobalBytesPerSecond    float64
+}
+
+// Enabled tells whether any limit is configured
+func (l RateLimits) Enabled() bool {
+	return l.RequestsPerSecond > 0 || l.BytesPerSecond > 0 || l.GlobalRequestsPerSecond > 0 || l.GlobalBytesPerSecond > 0
+}
+
+// clientLimiters are the buckets of a client
+type clientLimiters struct {
+	requests *rate.Limiter
+	bytes    *rate.Limiter
+	lastSeen time.Time
+}
+
+// rateLimiter holds the buckets of every client and the global ones
+type rateLimiter struct {
+	limits         RateLimits
+	header         string
+	globalRequests *rate.Limiter
+	globalBytes    *rate.Limiter
+
+	mu        sync.Mutex
+	clients   map[string]*clientLimiters
+	lastSweep time.Time
+}
+
+// RateLimit rejects the requests over the limits with 429 Too Many Requests and Retry-After.
+// The request body is counted by its Content-Length; a body exceeding a byte bucket is let through once the bucket
+// is full and delays the next requests until it is paid. Bodies of unknown length are charged in full once read.
+// The X-RateLimit-* headers report the requests left to the client.
+// It must run after Authenticate, so the clients are identified by their verified caller.
+func RateLimit(limits RateLimits) gin.HandlerFunc {
+	limiter := &rateLimiter{
+		limits:         limits,
+		globalRequests: newLimiter(limits.GlobalRequestsPerSecond),
+		globalBytes:    newLimiter(limits.GlobalBytesPerSecond),
+		clients:        map[string]*clientLimiters{},
+		lastSweep:      time.Now(),
+	}
+	switch header, ok := strings.CutPrefix(limits.KeyBy, RateLimitByHeaderPrefix); {
+	case ok && header != "":
+		limiter.header = http.CanonicalHeaderKey(header)
+	case limits.KeyBy == "", limits.KeyBy == RateLimitByAPIKey, limits.KeyBy == RateLimitByIP:
+	default:
+		log.Warn().Msgf("Unknown rate limit key %q, limiting by IP", limits.KeyBy)
+	}
+
+	return func(c *gin.Context) {
+		now := time.Now()
+		client := limiter.client(limiter.clientKey(c), now)
+
+		size := c.Request.ContentLength
+		costs := []bucketCost{
+			{bucket: client.requests, scope: "client", n: 1},
+			{bucket: limiter.globalRequests, scope: "global", n: 1},
+		}
+		if size >= 0 {
+			costs = append(costs,
+				bucketCost{bucket: client.bytes, scope: "client", n: size},
+				bucketCost{bucket: limiter.globalBytes, scope: "global", n: size},
+			)
+		}
+
+		var reservations []*rate.Reservation
+		var oversized []bucketCost
+		var delay time.Duration
+		scope := ""
+		for _, cost := range costs {
+			if cost.bucket == nil {
+				continue
+			}
+			// A body larger than the bucket could never be reserved at once, so it waits for a full bucket instead
+			if cost.n > int64(cost.bucket.Burst()) {
+				if d := untilFull(cost.bucket, now); d > delay {
+					delay, scope = d, cost.scope
+				}
+				oversized = append(oversized, cost)
+				continue
+			}
+			reservation := cost.bucket.ReserveN(now, int(cost.n))
+			reservations = append(reservations, reservation)
+			if d := reservation.DelayFrom(now); d > delay {
+				delay, scope = d, cost.scope
+			}
+		}
+
+		if delay > 0 {
+			for _, reservation := range reservations {
+				reservation.CancelAt(now)
+			}
+			setRateLimitHeaders(c, client.requests, now)
+			metrics.RateLimited.WithLabelValues(scope).Inc()
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+				"error": fmt.Sprintf("Rate limit exceeded, retry in %s", delay.Round(time.Millisecond)),
+			})
+			return
+		}
+		for _, cost := range oversized {
+			charge(cost.bucket, now, cost.n)
+		}
+		setRateLimitHeaders(c, client.requests, now)
+
+		// Count the bodies of unknown length once they are read; the next requests wait for them
+		var counter *countingReader
+		if size < 0 && (client.bytes != nil || limiter.globalBytes != nil) && c.Request.Body != nil {
+			counter = &countingReader{ReadCloser: c.Request.Body}
+			c.Request.Body = counter
+		}
+
+		c.Next()
+
+		if counter != nil && counter.n > 0 {
+			for _, bucket := range []*rate.Limiter{client.bytes, limiter.globalBytes} {
+				if bucket != nil {
+					charge(bucket, time.Now(), counter.n)
+				}
+			}
+		}
+	}
+}
+
+// bucketCost is the number of tokens a request takes from a bucket
+type bucketCost struct {
+	bucket *rate.Limiter
+	scope  string
+	n      int64
+}
+
+// clientKey identifies the client of the request, by the configured header or authenticated caller, or else by its IP.
+// Credentials are only trusted once verified, so unauthenticated requests cannot get a bucket of their own.
+func (l *rateLimiter) clientKey(c *gin.Context) string {
+	r := c.Request
+	switch {
+	case l.header != "":
+		if value := r.Header.Get(l.header); value != "" {
+			return "header:" + value
+		}
+	case l.limits.KeyBy == "" || l.limits.KeyBy == RateLimitByAPIKey:
+		if caller := domain.MetadataFromContext(r.Context()).Caller; caller != "" {
+			return "caller:" + caller
+		}
+	}
+	return "ip:" + c.ClientIP()
+}
+
+// client returns the buckets of the client, creating them on its first request
+func (l *rateLimiter) client(key string, now time.Time) *clientLimiters {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.lastSweep) > limiterSweepInterval {
+		l.sweep(now)
+	}
+	client, ok := l.clients[key]
+	if !ok {
+		client = &clientLimiters{
+			requests: newLimiter(l.limits.RequestsPerSecond),
+			bytes:    newLimiter(l.limits.BytesPerSecond),
+		}
+		l.clients[key] = client
+	}
+	client.lastSeen = now
+	return client
+}
+
+// sweep drops the clients idle for longer than the sweep interval, whose buckets are full again
+func (l *rateLimiter) sweep(now time.Time) {
+	for key, client := range l.clients {
+		if now.Sub(client.lastSeen) > limiterSweepInterval {
+			delete(l.clients, key)
+		}
+	}
+	l.lastSweep = now
+}
+
+// newLimiter creates a bucket holding one second of the rate, or nil when the rate is not limited
+func newLimiter(perSecond float64) *rate.Limiter {
+	if perSecond <= 0 {
+		return nil
+	}
+	return rate.NewLimiter(rate.Limit(perSecond), int(math.Max(1, math.Ceil(perSecond))))
+}
+
+// untilFull is how long the bucket takes to be full again
+func untilFull(bucket *rate.Limiter, now time.Time) time.Duration {
+	missing := float64(bucket.Burst()) - bucket.TokensAt(now)
+	if missing <= 0 {
+		return 0
+	}
+	return time.Duration(missing / float64(bucket.Limit()) * float64(time.Second))
+}
+
+// charge takes n tokens from the bucket, however many they are, so the next requests wait for them.
+// Costs larger than the bucket are taken one bucket at a time.
+func charge(bucket *rate.Limiter, now time.Time, n int64) {
+	burst := int64(bucket.Burst())
+	for ; n > 0; n -= burst {
+		bucket.ReserveN(now, int(min(n, burst)))
+	}
+}
+
+// setRateLimitHeaders reports the request bucket of the client
+func setRateLimitHeaders(c *gin.Context, bucket *rate.Limiter, now time.Time) {
+	if bucket == nil {
+		return
+	}
+	tokens := bucket.TokensAt(now)
+	remaining := int(math.Max(0, math.Floor(tokens)))
+	reset := math.Ceil((float64(bucket.Burst()) - tokens) / float64(bucket.Limit()))
+	c.Header(RateLimitLimitHeader, strconv.Itoa(bucket.Burst()))
+	c.Header(RateLimitRemainingHeader, strconv.Itoa(remaining))
+	c.Header(RateLimitResetHeader, strconv.Itoa(int(math.Max(0, reset))))
+}
+
+// countingReader counts the bytes read from the request body
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+// Read implements io.Reader
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,221 @@
+package middleware_test
+
+import (
+	"anyway/internal/domain"
+	"anyway/internal/interfaces/http/middleware"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// testCallerHeader carries the caller the test requests are authenticated as
+const testCallerHeader = "X-Test-Caller"
+
+// rateLimitedRouter creates a router that reads the request body behind the RateLimit middleware.
+// The requests are authenticated as the caller of the test caller header.
+func rateLimitedRouter(limits middleware.RateLimits) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		if caller := c.GetHeader(testCallerHeader); caller != "" {
+			c.Request = c.Request.WithContext(domain.WithMetadata(c.Request.Context(), domain.Metadata{Caller: caller}))
+		}
+	})
+	router.Use(middleware.RateLimit(limits))
+	router.POST("/", func(c *gin.Context) {
+		_, _ = io.Copy(io.Discard, c.Request.Body)
+		c.Status(http.StatusOK)
+	})
+	return router
+}
+
+// rateLimitedRequest sends a request with the given body and headers through the router
+func rateLimitedRequest(router *gin.Engine, body string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	req.RemoteAddr = "192.0.2.1:1234"
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// TestRateLimitRequests tests that the requests over the client limit are rejected with Retry-After
+func TestRateLimitRequests(t *testing.T) {
+	router := rateLimitedRouter(middleware.RateLimits{RequestsPerSecond: 2})
+
+	w := rateLimitedRequest(router, "", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "2", w.Header().Get(middleware.RateLimitLimitHeader))
+	assert.Equal(t, "1", w.Header().Get(middleware.RateLimitRemainingHeader))
+	assert.Equal(t, "1", w.Header().Get(middleware.RateLimitResetHeader))
+
+	w = rateLimitedRequest(router, "", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "0", w.Header().Get(middleware.RateLimitRemainingHeader))
+
+	w = rateLimitedRequest(router, "", nil)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+	assert.Equal(t, "0", w.Header().Get(middleware.RateLimitRemainingHeader))
+	assert.Contains(t, w.Body.String(), "Rate limit exceeded")
+}
+
+// TestRateLimitKeys tests that every client has its own buckets
+func TestRateLimitKeys(t *testing.T) {
+	tests := []struct {
+		name    string
+		keyBy   string
+		first   map[string]string
+		second  map[string]string
+		sharing bool
+	}{
+		{
+			name:   "callers",
+			keyBy:  middleware.RateLimitByAPIKey,
+			first:  map[string]string{testCallerHeader: "billing"},
+			second: map[string]string{testCallerHeader: "orders"},
+		},
+		{
+			name:   "callers by default",
+			first:  map[string]string{testCallerHeader: "billing"},
+			second: map[string]string{testCallerHeader: "orders"},
+		},
+		{
+			name:    "unverified credentials fall back to the ip",
+			first:   map[string]string{middleware.APIKeyHeader: "fake-1", "Authorization": "Bearer fake-1"},
+			second:  map[string]string{middleware.APIKeyHeader: "fake-2", "Authorization": "Bearer fake-2"},
+			sharing: true,
+		},
+		{
+			name:   "configured header",
+			keyBy:  middleware.RateLimitByHeaderPrefix + "x-tenant-id",
+			first:  map[string]string{"X-Tenant-Id": "acme"},
+			second: map[string]string{"X-Tenant-Id": "globex"},
+		},
+		{
+			name:    "ip ignores the caller",
+			keyBy:   middleware.RateLimitByIP,
+			first:   map[string]string{testCallerHeader: "billing"},
+			second:  map[string]string{testCallerHeader: "orders"},
+			sharing: true,
+		},
+		{
+			name:    "missing header falls back to the ip",
+			keyBy:   middleware.RateLimitByHeaderPrefix + "X-Tenant-Id",
+			sharing: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := rateLimitedRouter(middleware.RateLimits{KeyBy: tt.keyBy, RequestsPerSecond: 1})
+
+			assert.Equal(t, http.StatusOK, rateLimitedRequest(router, "", tt.first).Code)
+			assert.Equal(t, http.StatusTooManyRequests, rateLimitedRequest(router, "", tt.first).Code)
+
+			w := rateLimitedRequest(router, "", tt.second)
+			if tt.sharing {
+				assert.Equal(t, http.StatusTooManyRequests, w.Code)
+			} else {
+				assert.Equal(t, http.StatusOK, w.Code)
+			}
+		})
+	}
+}
+
+// TestRateLimitBytes tests that the request bodies are limited by their size
+func TestRateLimitBytes(t *testing.T) {
+	router := rateLimitedRouter(middleware.RateLimits{BytesPerSecond: 10})
+
+	assert.Equal(t, http.StatusOK, rateLimitedRequest(router, "123456", nil).Code)
+
+	w := rateLimitedRequest(router, "123456", nil)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+	// No request limit, no request headers
+	assert.Empty(t, w.Header().Get(middleware.RateLimitLimitHeader))
+
+	// A rejected request does not take any tokens
+	assert.Equal(t, http.StatusOK, rateLimitedRequest(router, "1234", nil).Code)
+}
+
+// TestRateLimitBytesTooLarge tests that a body larger than one second of the byte rate waits for a full bucket
+// and is then charged over time
+func TestRateLimitBytesTooLarge(t *testing.T) {
+	router := rateLimitedRouter(middleware.RateLimits{BytesPerSecond: 10, GlobalBytesPerSecond: 100})
+
+	// A body of three seconds of the rate is let through with a full bucket, leaving a debt of two seconds
+	body := strings.Repeat("x", 30)
+	assert.Equal(t, http.StatusOK, rateLimitedRequest(router, body, nil).Code)
+	w := rateLimitedRequest(router, "1", nil)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "3", w.Header().Get("Retry-After"))
+
+	// Another large body waits until the bucket is full again, without taking any tokens
+	other := rateLimitedRouter(middleware.RateLimits{BytesPerSecond: 10})
+	assert.Equal(t, http.StatusOK, rateLimitedRequest(other, "12345", nil).Code)
+	w = rateLimitedRequest(other, body, nil)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+	assert.Equal(t, http.StatusOK, rateLimitedRequest(other, "12345", nil).Code)
+}
+
+// TestRateLimitBytesUnknownLength tests that bodies of unknown length are charged once read
+func TestRateLimitBytesUnknownLength(t *testing.T) {
+	router := rateLimitedRouter(middleware.RateLimits{BytesPerSecond: 10})
+
+	send := func() int {
+		req := httptest.NewRequest(http.MethodPost, "/", io.NopCloser(strings.NewReader("1234567890")))
+		req.ContentLength = -1
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// The first body is read with an empty debt, the second one waits for it
+	assert.Equal(t, http.StatusOK, send())
+	assert.Equal(t, http.StatusOK, send())
+	assert.Equal(t, http.StatusTooManyRequests, rateLimitedRequest(router, "1", nil).Code)
+}
+
+// TestRateLimitGlobal tests that the global limits are shared by every client
+func TestRateLimitGlobal(t *testing.T) {
+	router := rateLimitedRouter(middleware.RateLimits{RequestsPerSecond: 5, GlobalRequestsPerSecond: 1})
+
+	first := map[string]string{testCallerHeader: "billing"}
+	second := map[string]string{testCallerHeader: "orders"}
+	assert.Equal(t, http.StatusOK, rateLimitedRequest(router, "", first).Code)
+
+	w := rateLimitedRequest(router, "", second)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	// The client bucket is untouched by the rejected request
+	assert.Equal(t, "5", w.Header().Get(middleware.RateLimitRemainingHeader))
+}
+
+// TestRateLimitsEnabled tests that the limits are only enabled with a rate
+func TestRateLimitsEnabled(t *testing.T) {
+	assert.False(t, middleware.RateLimits{KeyBy: middleware.RateLimitByIP}.Enabled())
+	assert.True(t, middleware.RateLimits{BytesPerSecond: 1}.Enabled())
+	assert.True(t, middleware.RateLimits{GlobalRequestsPerSecond: 1}.Enabled())
+}
+
+// TestRateLimitBytesUnknownLengthFullCost tests that a body of unknown length larger than the bucket is charged in full
+func TestRateLimitBytesUnknownLengthFullCost(t *testing.T) {
+	router := rateLimitedRouter(middleware.RateLimits{BytesPerSecond: 10})
+
+	// A body of three seconds of the rate leaves a debt of two seconds
+	req := httptest.NewRequest(http.MethodPost, "/", io.NopCloser(strings.NewReader(strings.Repeat("x", 30))))
+	req.ContentLength = -1
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	w := rateLimitedRequest(router, "1", nil)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "3", w.Header().Get("Retry-After"))
+}
@@ -1,37 +1,108 @@
 package http
 
 import (
+	"anyway/config"
 	"anyway/internal/domain"
 	"anyway/internal/interfaces/http/handler"
+	httpmiddleware "anyway/internal/interfaces/http/middleware"
+	"anyway/internal/metrics"
 	"github.com/narumayase/anysher/middleware"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 
 	"github.com/gin-gonic/gin"
 )
 
+// Option configures optional routes of the router
+type Option func(*routerOptions)
+
+// routerOptions holds the dependencies of the optional routes
+type routerOptions struct {
+	spool          domain.SpoolInspector
+	authentication httpmiddleware.Authentication
+}
+
+// WithSpool exposes the depth of the local disk spool on GET /admin/spool
+func WithSpool(spool domain.SpoolInspector) Option {
+	return func(o *routerOptions) {
+		o.spool = spool
+	}
+}
+
+// WithAuthentication requires an API key or a bearer token on the /api/v1 and /admin routes
+func WithAuthentication(auth httpmiddleware.Authentication) Option {
+	return func(o *routerOptions) {
+		o.authentication = auth
+	}
+}
+
 // SetupRouter configures the API routes
-func SetupRouter(chatUseCase domain.Usecase) *gin.Engine {
+func SetupRouter(cfg config.Config, chatUseCase domain.Usecase, opts ...Option) *gin.Engine {
+	var options routerOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
 	router := gin.Default()
 
 	// Add middlewares
+	router.Use(otelgin.Middleware(cfg.TracingServiceName))
 	router.Use(middleware.Logger())
-	router.Use(middleware.CORS())
+	router.Use(httpmiddleware.Metrics())
+	router.Use(httpmiddleware.CORS(cfg.CORSAllowedOrigins))
 	router.Use(middleware.ErrorHandler())
-	router.Use(middleware.HeadersToContext())
+	router.Use(httpmiddleware.RequestMetadata())
+	router.Use(httpmiddleware.PropagateHeaders(httpmiddleware.HeaderPropagation{
+		Allow:  cfg.PropagateHeaders,
+		Rename: cfg.PropagateHeadersRename,
+		Deny:   cfg.PropagateHeadersDeny,
+		Static: cfg.StaticHeaders,
+	}))
 	router.Use(middleware.RequestIDToLogger())
 
 	// Create the controller
-	chatHandler := handler.NewHandler(chatUseCase)
+	chatHandler := handler.NewHandler(chatUseCase,
+		handler.WithBatchLimits(cfg.BatchMaxItems, cfg.BatchMaxBytes),
+		handler.WithRawMaxBytes(cfg.RawMaxBytes),
+		handler.WithAsyncMode(handler.AsyncMode(cfg.AsyncMode)),
+	)
 
 	// API routes group
 	api := router.Group("/api/v1")
+	rateLimits := httpmiddleware.RateLimits{
+		KeyBy:                   cfg.RateLimitKey,
+		RequestsPerSecond:       cfg.RateLimitRequests,
+		BytesPerSecond:          cfg.RateLimitBytes,
+		GlobalRequestsPerSecond: cfg.RateLimitGlobalRequests,
+		GlobalBytesPerSecond:    cfg.RateLimitGlobalBytes,
+	}
+	if options.authentication.Enabled() {
+		api.Use(httpmiddleware.Authenticate(options.authentication))
+	}
+	if rateLimits.Enabled() {
+		api.Use(httpmiddleware.RateLimit(rateLimits))
+	}
 	api.POST("/send", chatHandler.Send)
+	api.POST("/send/batch", chatHandler.SendBatch)
+	api.POST("/send/raw", chatHandler.SendRaw)
+	api.POST("/topics/:topic/send", chatHandler.Send)
+	api.POST("/topics/:topic/send/batch", chatHandler.SendBatch)
+	api.POST("/topics/:topic/send/raw", chatHandler.SendRaw)
+
+	// Health check routes; /health is kept as an alias of the liveness probe
+	router.GET("/health", chatHandler.Live)
+	router.GET("/health/live", chatHandler.Live)
+	router.GET("/health/ready", chatHandler.Ready)
+
+	// Prometheus metrics route
+	router.GET("/metrics", gin.WrapH(metrics.Handler()))
 
-	// Health check route
-	router.GET("/health", func(c *gin.Context) {
-		c.JSON(200, gin.H{
-			"status":  "OK",
-			"message": "anyway API is running",
-		})
-	})
+	// Admin routes, behind the same credentials as the API
+	if options.spool != nil {
+		admin := router.Group("/admin")
+		if options.authentication.Enabled() {
+			admin.Use(httpmiddleware.Authenticate(options.authentication))
+		}
+		adminHandler := handler.NewAdminHandler(options.spool)
+		admin.GET("/spool", adminHandler.Spool)
+	}
 	return router
 }
@@ -1,10 +1,14 @@
 package http_test
 
 import (
+	"anyway/config"
 	"anyway/internal/domain"
 	httpRouter "anyway/internal/interfaces/http"
+	httpMiddleware "anyway/internal/interfaces/http/middleware"
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"net/http"
@@ -12,8 +16,13 @@ import (
 	"testing"
 
 	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // MockUsecase is a mock implementation of domain.Usecase
@@ -22,9 +31,32 @@ type MockUsecase struct {
 }
 
 // Send mocks the Send method of domain.Usecase
-func (m *MockUsecase) Send(ctx context.Context, message domain.Message) error {
+func (m *MockUsecase) Send(ctx context.Context, message domain.Message) (domain.Receipt, error) {
 	args := m.Called(ctx, message)
-	return args.Error(0)
+	return args.Get(0).(domain.Receipt), args.Error(1)
+}
+
+// SendAsync mocks the SendAsync method of domain.Usecase
+func (m *MockUsecase) SendAsync(ctx context.Context, message domain.Message) (domain.Receipt, error) {
+	args := m.Called(ctx, message)
+	return args.Get(0).(domain.Receipt), args.Error(1)
+}
+
+// SendBatch mocks the SendBatch method of domain.Usecase
+func (m *MockUsecase) SendBatch(ctx context.Context, messages []domain.Message) ([]domain.Receipt, []error) {
+	args := m.Called(ctx, messages)
+	return args.Get(0).([]domain.Receipt), args.Get(1).([]error)
+}
+
+// HealthCheck mocks the HealthCheck method of domain.Usecase
+func (m *MockUsecase) HealthCheck(ctx context.Context) []domain.DependencyStatus {
+	args := m.Called(ctx)
+	return args.Get(0).([]domain.DependencyStatus)
+}
+
+// Close mocks the Close method of domain.Usecase
+func (m *MockUsecase) Close(ctx context.Context) {
+	m.Called(ctx)
 }
 
 // TestSetupRouterHealthCheck tests the /health endpoint
@@ -34,7 +66,7 @@ func TestSetupRouterHealthCheck(t *testing.T) {
 
 	// Setup the router
 	gin.SetMode(gin.TestMode)
-	router := httpRouter.SetupRouter(mockUsecase)
+	router := httpRouter.SetupRouter(config.Config{}, mockUsecase)
 
 	// Create a new HTTP request to the health endpoint
 	req, _ := http.NewRequest(http.MethodGet, "/health", nil)
@@ -60,11 +92,11 @@ func TestSetupRouterSendSuccess(t *testing.T) {
 	mockUsecase := new(MockUsecase)
 
 	// Expect Send to be called and return nil (success)
-	mockUsecase.On("Send", mock.Anything, mock.Anything).Return(nil).Once()
+	mockUsecase.On("Send", mock.Anything, mock.Anything).Return(domain.Receipt{}, nil).Once()
 
 	// Setup the router
 	gin.SetMode(gin.TestMode)
-	router := httpRouter.SetupRouter(mockUsecase)
+	router := httpRouter.SetupRouter(config.Config{}, mockUsecase)
 
 	// Create a sample request body
 	message := domain.Message{
@@ -87,6 +119,220 @@ func TestSetupRouterSendSuccess(t *testing.T) {
 	mockUsecase.AssertExpectations(t)
 }
 
+// TestSetupRouterRequestMetadata tests that the usecase receives the request metadata echoed in the response
+func TestSetupRouterRequestMetadata(t *testing.T) {
+	// Create a mock usecase
+	mockUsecase := new(MockUsecase)
+
+	// Expect Send to be called with the request metadata in the context
+	var metadata domain.Metadata
+	mockUsecase.On("Send", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		metadata = domain.MetadataFromContext(args.Get(0).(context.Context))
+	}).Return(domain.Receipt{}, nil).Once()
+
+	// Setup the router
+	gin.SetMode(gin.TestMode)
+	router := httpRouter.SetupRouter(config.Config{}, mockUsecase)
+
+	// Create a new HTTP request to the send endpoint with a correlation ID and no request ID
+	req, _ := http.NewRequest(http.MethodPost, "/api/v1/send", bytes.NewBufferString(`{"content":"dGVzdA=="}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Correlation-Id", "test-correlation-id")
+
+	// Record the response
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	// Assert that the generated request ID and the correlation ID are echoed back
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotEmpty(t, metadata.RequestID)
+	assert.Equal(t, metadata.RequestID, w.Header().Get("X-Request-Id"))
+	assert.Equal(t, "test-correlation-id", metadata.CorrelationID)
+	assert.Equal(t, "test-correlation-id", w.Header().Get("X-Correlation-Id"))
+
+	// Assert that the expected methods were called on the mock
+	mockUsecase.AssertExpectations(t)
+}
+
+// TestSetupRouterAuthentication tests that the API requires credentials and the caller reaches the usecase
+func TestSetupRouterAuthentication(t *testing.T) {
+	// Create a mock usecase
+	mockUsecase := new(MockUsecase)
+
+	// Expect Send to be called once, with the caller in the request metadata
+	var metadata domain.Metadata
+	mockUsecase.On("Send", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		metadata = domain.MetadataFromContext(args.Get(0).(context.Context))
+	}).Return(domain.Receipt{}, nil).Once()
+
+	// Setup the router with an API key
+	hash := sha256.Sum256([]byte("billing-secret"))
+	gin.SetMode(gin.TestMode)
+	router := httpRouter.SetupRouter(config.Config{}, mockUsecase, httpRouter.WithAuthentication(httpMiddleware.Authentication{
+		APIKeys: map[string]string{"billing": hex.EncodeToString(hash[:])},
+	}))
+
+	// Requests without credentials are rejected
+	req, _ := http.NewRequest(http.MethodPost, "/api/v1/send", bytes.NewBufferString(`{"content":"dGVzdA=="}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// Requests with an API key are sent on behalf of its caller
+	req, _ = http.NewRequest(http.MethodPost, "/api/v1/send", bytes.NewBufferString(`{"content":"dGVzdA=="}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-API-Key", "billing-secret")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "billing", metadata.Caller)
+
+	// The health checks stay open
+	req, _ = http.NewRequest(http.MethodGet, "/health/live", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Assert that the expected methods were called on the mock
+	mockUsecase.AssertExpectations(t)
+}
+
+// TestSetupRouterDoesNotLogCredentials tests that the credentials of a request never reach the logs
+func TestSetupRouterDoesNotLogCredentials(t *testing.T) {
+	// Capture the logs of the request
+	var logs bytes.Buffer
+	logger := log.Logger
+	log.Logger = zerolog.New(&logs)
+	defer func() { log.Logger = logger }()
+
+	// Create a mock usecase
+	mockUsecase := new(MockUsecase)
+	mockUsecase.On("Send", mock.Anything, mock.Anything).Return(domain.Receipt{}, nil).Once()
+
+	// Setup the router with an API key
+	hash := sha256.Sum256([]byte("billing-secret"))
+	gin.SetMode(gin.TestMode)
+	router := httpRouter.SetupRouter(config.Config{}, mockUsecase, httpRouter.WithAuthentication(httpMiddleware.Authentication{
+		APIKeys: map[string]string{"billing": hex.EncodeToString(hash[:])},
+	}))
+
+	// Send a request with credentials
+	req, _ := http.NewRequest(http.MethodPost, "/api/v1/send", bytes.NewBufferString(`{"content":"dGVzdA=="}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-API-Key", "billing-secret")
+	req.Header.Set("Cookie", "session=cookie-secret")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	// Assert that the request was logged without its credentials
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, logs.String(), "HTTP Request")
+	assert.NotContains(t, logs.String(), "billing-secret")
+	assert.NotContains(t, logs.String(), "cookie-secret")
+	mockUsecase.AssertExpectations(t)
+}
+
+// TestSetupRouterRateLimit tests that the API requests over the rate limit are rejected
+func TestSetupRouterRateLimit(t *testing.T) {
+	// Create a mock usecase
+	mockUsecase := new(MockUsecase)
+
+	// Expect Send to be called once, the second request is rate limited
+	mockUsecase.On("Send", mock.Anything, mock.Anything).Return(domain.Receipt{}, nil).Once()
+
+	// Setup the router with one request per second
+	gin.SetMode(gin.TestMode)
+	router := httpRouter.SetupRouter(config.Config{RateLimitKey: "ip", RateLimitRequests: 1}, mockUsecase)
+
+	for _, expected := range []int{http.StatusOK, http.StatusTooManyRequests} {
+		req, _ := http.NewRequest(http.MethodPost, "/api/v1/send", bytes.NewBufferString(`{"content":"dGVzdA=="}`))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, expected, w.Code)
+		assert.Equal(t, "1", w.Header().Get("X-RateLimit-Limit"))
+	}
+
+	// The health checks are not limited
+	req, _ := http.NewRequest(http.MethodGet, "/health/live", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Assert that the expected methods were called on the mock
+	mockUsecase.AssertExpectations(t)
+}
+
+// TestSetupRouterRateLimitAfterAuthentication tests that the rate limits apply to the authenticated callers,
+// so unauthenticated requests do not drain their buckets
+func TestSetupRouterRateLimitAfterAuthentication(t *testing.T) {
+	// Create a mock usecase
+	mockUsecase := new(MockUsecase)
+	mockUsecase.On("Send", mock.Anything, mock.Anything).Return(domain.Receipt{}, nil).Once()
+
+	// Setup the router with an API key and one request per second for all the clients
+	hash := sha256.Sum256([]byte("billing-secret"))
+	gin.SetMode(gin.TestMode)
+	router := httpRouter.SetupRouter(config.Config{RateLimitGlobalRequests: 1}, mockUsecase, httpRouter.WithAuthentication(httpMiddleware.Authentication{
+		APIKeys: map[string]string{"billing": hex.EncodeToString(hash[:])},
+	}))
+
+	for _, tt := range []struct {
+		apiKey   string
+		expected int
+	}{
+		{apiKey: "fake-1", expected: http.StatusUnauthorized},
+		{apiKey: "fake-2", expected: http.StatusUnauthorized},
+		{apiKey: "billing-secret", expected: http.StatusOK},
+		{apiKey: "billing-secret", expected: http.StatusTooManyRequests},
+	} {
+		req, _ := http.NewRequest(http.MethodPost, "/api/v1/send", bytes.NewBufferString(`{"content":"dGVzdA=="}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-API-Key", tt.apiKey)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, tt.expected, w.Code)
+	}
+
+	// Assert that the expected methods were called on the mock
+	mockUsecase.AssertExpectations(t)
+}
+
+// TestSetupRouterTraceContext tests that the trace context of the traceparent header reaches the usecase
+func TestSetupRouterTraceContext(t *testing.T) {
+	// Propagate the W3C trace context, disabling it afterwards
+	defer otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator())
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	// Create a mock usecase keeping the span of the context it receives
+	mockUsecase := new(MockUsecase)
+	var spanContext trace.SpanContext
+	mockUsecase.On("Send", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		spanContext = trace.SpanContextFromContext(args.Get(0).(context.Context))
+	}).Return(domain.Receipt{}, nil).Once()
+
+	// Setup the router
+	gin.SetMode(gin.TestMode)
+	router := httpRouter.SetupRouter(config.Config{}, mockUsecase)
+
+	// Create a new HTTP request to the send endpoint with a traceparent header
+	req, _ := http.NewRequest(http.MethodPost, "/api/v1/send", bytes.NewBufferString(`{"content":"dGVzdA=="}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929b0e0e4736-00f067aa0ba902b7-01")
+
+	// Record the response
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	// Assert that the usecase runs within the incoming trace
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929b0e0e4736", spanContext.TraceID().String())
+
+	// Assert that the expected methods were called on the mock
+	mockUsecase.AssertExpectations(t)
+}
+
 // TestSetupRouterSendUsecaseError tests the /api/v1/send endpoint when usecase returns an error
 func TestSetupRouterSendUsecaseError(t *testing.T) {
 	// Create a mock usecase
@@ -96,11 +342,11 @@ func TestSetupRouterSendUsecaseError(t *testing.T) {
 	expectedErr := errors.New("failed to send message via usecase")
 
 	// Expect Send to be called and return an error
-	mockUsecase.On("Send", mock.Anything, mock.Anything).Return(expectedErr).Once()
+	mockUsecase.On("Send", mock.Anything, mock.Anything).Return(domain.Receipt{}, expectedErr).Once()
 
 	// Setup the router
 	gin.SetMode(gin.TestMode)
-	router := httpRouter.SetupRouter(mockUsecase)
+	router := httpRouter.SetupRouter(config.Config{}, mockUsecase)
 
 	// Create a sample request body
 	message := domain.Message{
@@ -128,3 +374,171 @@ func TestSetupRouterSendUsecaseError(t *testing.T) {
 	// Assert that the expected methods were called on the mock
 	mockUsecase.AssertExpectations(t)
 }
+
+// TestSetupRouterSendToTopic tests the /api/v1/topics/:topic/send endpoint
+func TestSetupRouterSendToTopic(t *testing.T) {
+	// Create a mock usecase
+	mockUsecase := new(MockUsecase)
+
+	// Expect Send to be called with the topic of the route
+	mockUsecase.On("Send", mock.Anything, domain.Message{
+		Topic:   "orders",
+		Content: []byte("test-content"),
+	}).Return(domain.Receipt{}, nil).Once()
+
+	// Setup the router
+	gin.SetMode(gin.TestMode)
+	router := httpRouter.SetupRouter(config.Config{}, mockUsecase)
+
+	// Create a sample request body
+	jsonBody, _ := json.Marshal(domain.Message{Content: []byte("test-content")})
+
+	// Create a new HTTP request to the topic send endpoint
+	req, _ := http.NewRequest(http.MethodPost, "/api/v1/topics/orders/send", bytes.NewBuffer(jsonBody))
+	req.Header.Set("Content-Type", "application/json")
+
+	// Record the response
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	// Assert the response status code
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Assert that the expected methods were called on the mock
+	mockUsecase.AssertExpectations(t)
+}
+
+// TestSetupRouterSendBatch tests the /api/v1/send/batch endpoint
+func TestSetupRouterSendBatch(t *testing.T) {
+	// Create a mock usecase
+	mockUsecase := new(MockUsecase)
+
+	// Expect SendBatch to be called and return nil errors (success)
+	mockUsecase.On("SendBatch", mock.Anything, mock.Anything).Return([]domain.Receipt{{}}, []error{nil}).Once()
+
+	// Setup the router
+	gin.SetMode(gin.TestMode)
+	router := httpRouter.SetupRouter(config.Config{BatchMaxItems: 10, BatchMaxBytes: 1024}, mockUsecase)
+
+	// Create a sample request body
+	jsonBody, _ := json.Marshal([]domain.Message{{Content: []byte("test-content")}})
+
+	// Create a new HTTP request to the batch endpoint
+	req, _ := http.NewRequest(http.MethodPost, "/api/v1/send/batch", bytes.NewBuffer(jsonBody))
+	req.Header.Set("Content-Type", "application/json")
+
+	// Record the response
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	// Assert the response status code
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Assert that the expected methods were called on the mock
+	mockUsecase.AssertExpectations(t)
+}
+
+// TestSetupRouterReadiness tests the /health/ready endpoint
+func TestSetupRouterReadiness(t *testing.T) {
+	// Create a mock usecase reporting an unhealthy dependency
+	mockUsecase := new(MockUsecase)
+	mockUsecase.On("HealthCheck", mock.Anything).Return([]domain.DependencyStatus{
+		{Name: "kafka", Healthy: false, Error: "no Kafka broker is reachable"},
+	}).Once()
+
+	// Setup the router
+	gin.SetMode(gin.TestMode)
+	router := httpRouter.SetupRouter(config.Config{}, mockUsecase)
+
+	// Create a new HTTP request to the readiness endpoint
+	req, _ := http.NewRequest(http.MethodGet, "/health/ready", nil)
+
+	// Record the response
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	// Assert the response status code
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+	// Assert that the expected methods were called on the mock
+	mockUsecase.AssertExpectations(t)
+}
+
+// TestSetupRouterMetrics tests the /metrics endpoint
+func TestSetupRouterMetrics(t *testing.T) {
+	// Create a mock usecase (not used for metrics, but required by SetupRouter)
+	mockUsecase := new(MockUsecase)
+
+	// Setup the router
+	gin.SetMode(gin.TestMode)
+	router := httpRouter.SetupRouter(config.Config{}, mockUsecase)
+
+	// Send a request so the HTTP metrics have a value
+	req, _ := http.NewRequest(http.MethodGet, "/health", nil)
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	// Create a new HTTP request to the metrics endpoint
+	req, _ = http.NewRequest(http.MethodGet, "/metrics", nil)
+
+	// Record the response
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	// Assert the response status code and body
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `anyway_http_requests_total{method="GET",route="/health",status="200"}`)
+}
+
+// stubSpoolInspector returns fixed spool stats
+type stubSpoolInspector struct{}
+
+// SpoolStats returns fixed spool stats
+func (stubSpoolInspector) SpoolStats() domain.SpoolStats {
+	return domain.SpoolStats{Messages: 2}
+}
+
+// TestSetupRouterAdminSpool tests that /admin/spool is only exposed when the spool is enabled
+func TestSetupRouterAdminSpool(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockUsecase := new(MockUsecase)
+
+	// Assert that the route does not exist without a spool
+	router := httpRouter.SetupRouter(config.Config{}, mockUsecase)
+	req, _ := http.NewRequest(http.MethodGet, "/admin/spool", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// Assert that the route reports the spool depth when enabled
+	router = httpRouter.SetupRouter(config.Config{}, mockUsecase, httpRouter.WithSpool(stubSpoolInspector{}))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"messages": 2, "bytes": 0, "segments": 0, "max_bytes": 0}`, w.Body.String())
+}
+
+// TestSetupRouterAdminSpoolAuthentication tests that /admin/spool requires the same credentials as the API
+func TestSetupRouterAdminSpoolAuthentication(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockUsecase := new(MockUsecase)
+
+	// Setup the router with a spool and an API key
+	hash := sha256.Sum256([]byte("ops-secret"))
+	router := httpRouter.SetupRouter(config.Config{}, mockUsecase,
+		httpRouter.WithSpool(stubSpoolInspector{}),
+		httpRouter.WithAuthentication(httpMiddleware.Authentication{
+			APIKeys: map[string]string{"ops": hex.EncodeToString(hash[:])},
+		}))
+
+	// Requests without credentials are rejected
+	req, _ := http.NewRequest(http.MethodGet, "/admin/spool", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// Requests with an API key get the spool depth
+	req.Header.Set("X-API-Key", "ops-secret")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
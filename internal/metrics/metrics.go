@@ -0,0 +1,215 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "anyway"
+
+// Registry holds every metric exposed by the application
+var Registry = prometheus.NewRegistry()
+
+var (
+	// HTTPRequests counts the HTTP requests by method, route and status code
+	HTTPRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by method, route and status code.",
+	}, []string{"method", "route", "status"})
+
+	// HTTPRequestDuration observes the latency of the HTTP requests by method and route
+	HTTPRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Latency of the HTTP requests by method and route.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	// MessagesProduced counts the messages delivered by topic
+	MessagesProduced = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_produced_total",
+		Help:      "Messages delivered to the broker by topic.",
+	}, []string{"topic"})
+
+	// MessagesFailed counts the messages that could not be delivered by topic
+	MessagesFailed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_failed_total",
+		Help:      "Messages that could not be delivered to the broker by topic.",
+	}, []string{"topic"})
+
+	// MessageSize observes the size of the message payloads by topic
+	MessageSize = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "message_size_bytes",
+		Help:      "Size of the message payloads by topic.",
+		Buckets:   prometheus.ExponentialBuckets(64, 4, 9),
+	}, []string{"topic"})
+
+	// ProduceDuration observes the time until the broker acknowledges a message by topic
+	ProduceDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "produce_duration_seconds",
+		Help:      "Time until the broker acknowledges or rejects a message by topic.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"topic"})
+
+	// AsyncBufferedMessages is the number of asynchronous messages waiting to be produced
+	AsyncBufferedMessages = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "async_buffered_messages",
+		Help:      "Asynchronous messages waiting in the buffer to be produced.",
+	})
+
+	// AsyncMessages counts the asynchronous messages by outcome: sent, failed or rejected because the buffer was full
+	AsyncMessages = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "async_messages_total",
+		Help:      "Asynchronous messages by outcome (sent, failed, rejected, dropped).",
+	}, []string{"outcome"})
+
+	// SpoolMessages is the number of messages waiting in the spool of every backend
+	SpoolMessages = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "spool_messages",
+		Help:      "Messages waiting in the local disk spool by backend.",
+	}, []string{"backend"})
+
+	// SpoolBytes is the size of the messages waiting in the spool of every backend
+	SpoolBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "spool_bytes",
+		Help:      "Size in bytes of the messages waiting in the local disk spool by backend.",
+	}, []string{"backend"})
+
+	// SpoolAppended counts the messages written to the spool
+	SpoolAppended = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "spool_appended_total",
+		Help:      "Messages written to the local disk spool.",
+	})
+
+	// SpoolReplayed counts the spooled messages produced once the broker recovered
+	SpoolReplayed = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "spool_replayed_total",
+		Help:      "Spooled messages produced once the broker recovered.",
+	})
+
+	// SpoolDropped counts the messages lost by the spool by reason
+	SpoolDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "spool_dropped_total",
+		Help:      "Messages lost by the local disk spool by reason (append_failed, corrupted, torn, rejected).",
+	}, []string{"reason"})
+
+	// FanoutDeliveries counts the messages forwarded by a fan-out by destination and outcome: delivered or failed
+	FanoutDeliveries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "fanout_deliveries_total",
+		Help:      "Messages forwarded by the fan-out by destination and outcome (delivered, failed).",
+	}, []string{"destination", "outcome"})
+
+	// ValidationFailures counts the messages that do not match their schema by topic and action: rejected or warned
+	ValidationFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "validation_failures_total",
+		Help:      "Messages that do not match their JSON schema by topic and action (rejected, warned).",
+	}, []string{"topic", "action"})
+
+	// PolicyViolations counts the messages rejected by the authorisation policy by rule
+	PolicyViolations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "policy_violations_total",
+		Help:      "Messages rejected by the authorisation policy by rule.",
+	}, []string{"rule"})
+
+	// RateLimited counts the HTTP requests rejected by the rate limits by scope: client or global
+	RateLimited = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limited_requests_total",
+		Help:      "HTTP requests rejected by the rate limits by scope (client, global).",
+	}, []string{"scope"})
+
+	// ProduceRetries counts the messages produced again after a retryable error by backend
+	ProduceRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "produce_retries_total",
+		Help:      "Messages produced again after a retryable error by backend.",
+	}, []string{"backend"})
+
+	// CircuitBreakerState is the state of the circuit breaker of every backend: 0 closed, 1 half-open, 2 open
+	CircuitBreakerState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "circuit_breaker_state",
+		Help:      "State of the circuit breaker by backend (0 closed, 1 half-open, 2 open).",
+	}, []string{"backend"})
+
+	// CircuitBreakerRejected counts the messages rejected by an open circuit breaker by backend
+	CircuitBreakerRejected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "circuit_breaker_rejected_total",
+		Help:      "Messages rejected without calling the backend because its circuit breaker is open, by backend.",
+	}, []string{"backend"})
+
+	// DeduplicatedMessages counts the messages dropped as repeats of a recent one by topic
+	DeduplicatedMessages = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "deduplicated_messages_total",
+		Help:      "Messages dropped because an identical one was sent within the deduplication window by topic.",
+	}, []string{"topic"})
+
+	// DeadLetters counts the messages routed to the dead-letter queue by reason and outcome: published or failed
+	DeadLetters = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "dead_letters_total",
+		Help:      "Rejected or undeliverable messages routed to the dead-letter queue by reason and outcome (published or failed).",
+	}, []string{"reason", "outcome"})
+
+	// IdempotentReplays counts the repeated idempotency keys answered with the recorded receipt
+	IdempotentReplays = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "idempotent_replays_total",
+		Help:      "Messages with a repeated idempotency key answered without producing them again.",
+	})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		HTTPRequests,
+		HTTPRequestDuration,
+		MessagesProduced,
+		MessagesFailed,
+		MessageSize,
+		ProduceDuration,
+		AsyncBufferedMessages,
+		AsyncMessages,
+		SpoolMessages,
+		SpoolBytes,
+		SpoolAppended,
+		SpoolReplayed,
+		SpoolDropped,
+		FanoutDeliveries,
+		ValidationFailures,
+		PolicyViolations,
+		RateLimited,
+		IdempotentReplays,
+		DeduplicatedMessages,
+		ProduceRetries,
+		CircuitBreakerState,
+		CircuitBreakerRejected,
+		DeadLetters,
+	)
+}
+
+// Handler returns the HTTP handler that exposes the metrics in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
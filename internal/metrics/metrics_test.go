@@ -0,0 +1,33 @@
+package metrics_test
+
+import (
+	"anyway/internal/metrics"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestHandler tests that the handler exposes the application metrics in the Prometheus text format
+func TestHandler(t *testing.T) {
+	// Record some values so the vectors are exposed
+	metrics.MessagesProduced.WithLabelValues("metrics-test-topic").Inc()
+	metrics.MessageSize.WithLabelValues("metrics-test-topic").Observe(128)
+
+	// Create a new HTTP request to the metrics handler
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+
+	// Record the response
+	w := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(w, req)
+
+	// Assert the response status code and body
+	assert.Equal(t, http.StatusOK, w.Code)
+	body, err := io.ReadAll(w.Body)
+	assert.NoError(t, err)
+	assert.Contains(t, string(body), `anyway_messages_produced_total{topic="metrics-test-topic"} 1`)
+	assert.Contains(t, string(body), `anyway_message_size_bytes_bucket{topic="metrics-test-topic",le="256"} 1`)
+	assert.Contains(t, string(body), "go_goroutines")
+}
@@ -0,0 +1,55 @@
+package tracing_test
+
+import (
+	"anyway/internal/tracing"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// restoreGlobals disables the global tracer provider and propagator when the test ends
+func restoreGlobals(t *testing.T) {
+	t.Cleanup(func() {
+		otel.SetTracerProvider(noop.NewTracerProvider())
+		otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator())
+	})
+}
+
+// TestSetup tests that the supported exporters are set up and unknown ones are rejected
+func TestSetup(t *testing.T) {
+	restoreGlobals(t)
+
+	for _, exporter := range []string{tracing.ExporterNone, tracing.ExporterStdout} {
+		shutdown, err := tracing.Setup(context.Background(), exporter, "anyway-test")
+		assert.NoError(t, err)
+		assert.NoError(t, shutdown(context.Background()))
+	}
+
+	_, err := tracing.Setup(context.Background(), "zipkin", "anyway-test")
+	assert.ErrorContains(t, err, "unknown tracing exporter")
+}
+
+// TestInject tests that the trace context is added to the headers once the propagator is set up
+func TestInject(t *testing.T) {
+	restoreGlobals(t)
+	_, err := tracing.Setup(context.Background(), tracing.ExporterNone, "anyway-test")
+	assert.NoError(t, err)
+
+	// Create a context with a remote span, as received in a traceparent header
+	ctx := otel.GetTextMapPropagator().Extract(context.Background(), propagation.MapCarrier{
+		"traceparent": "00-4bf92f3577b34da6a3ce929b0e0e4736-00f067aa0ba902b7-01",
+		"tracestate":  "vendor=value",
+	})
+	assert.True(t, trace.SpanContextFromContext(ctx).IsValid())
+
+	// Assert that the trace context is injected unchanged
+	headers := map[string]string{}
+	tracing.Inject(ctx, headers)
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929b0e0e4736-00f067aa0ba902b7-01", headers["traceparent"])
+	assert.Equal(t, "vendor=value", headers["tracestate"])
+}
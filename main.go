@@ -4,28 +4,168 @@ import (
 	"anyway/cmd/server"
 	"anyway/config"
 	"anyway/internal/application"
+	"anyway/internal/domain"
+	"anyway/internal/infrastructure/idempotency"
+	"anyway/internal/infrastructure/policy"
+	"anyway/internal/infrastructure/registry"
 	"anyway/internal/infrastructure/repository"
-	"github.com/narumayase/anysher/kafka"
+	"anyway/internal/infrastructure/schema"
+	httphandler "anyway/internal/interfaces/http"
+	"anyway/internal/interfaces/http/handler"
+	httpmiddleware "anyway/internal/interfaces/http/middleware"
+	"anyway/internal/tracing"
+	"context"
 	"github.com/rs/zerolog/log"
+	"io"
+	"os"
 )
 
 func main() {
 	// Load configuration
 	cfg := config.Load()
+	if err := cfg.Validate(); err != nil {
+		log.Fatal().Msgf("invalid configuration: %v", err)
+	}
 
-	kafkaRepository, err := kafka.NewRepository()
+	// Set up tracing
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.TracingExporter, cfg.TracingServiceName)
 	if err != nil {
-		log.Fatal().Msgf("failed to create Kafka repository: %v", err)
+		log.Fatal().Msgf("failed to set up tracing: %v", err)
+	}
+
+	// The Kafka backend and the dead-letter queue share a single Kafka producer
+	kafkaProducers := repository.NewKafkaProducers(repository.KafkaMessageTimeout(cfg))
+
+	// Create the dead-letter queue
+	var deadLetterQueue domain.DeadLetterQueue
+	if cfg.DeadLetterBackend != "" {
+		deadLetterQueue, err = repository.NewDeadLetterQueue(cfg, kafkaProducers)
+		if err != nil {
+			log.Fatal().Msgf("failed to create dead-letter queue: %v", err)
+		}
 	}
 
-	// Create repository based on configuration
-	producerRepository := repository.NewKafkaRepository(kafkaRepository)
+	// Create repository based on configuration, every backend with its own local disk spool
+	producerRepository, err := repository.NewProducer(cfg, kafkaProducers, deadLetterQueue)
 	if err != nil {
-		log.Error().Err(err).Msgf("failed to create Kafka repository: %v", err)
+		log.Fatal().Msgf("failed to create producer repository: %v", err)
+	}
+	var routerOptions []httphandler.Option
+	if inspector, ok := producerRepository.(domain.SpoolInspector); ok && cfg.SpoolDir != "" {
+		routerOptions = append(routerOptions, httphandler.WithSpool(inspector))
 	}
 
 	// Create use case
-	usecase := application.NewUsecase(producerRepository)
+	options := []application.Option{
+		application.WithTopics(cfg.KafkaTopic, cfg.KafkaAllowedTopics),
+	}
+	if handler.AsyncMode(cfg.AsyncMode) != handler.AsyncOff {
+		options = append(options, application.WithAsync(cfg.AsyncBufferSize, cfg.AsyncWorkers))
+	}
+	var idempotencyStore domain.IdempotencyStore
+	if cfg.IdempotencyStore != idempotency.StoreNone {
+		idempotencyStore, err = idempotency.NewStore(cfg)
+		if err != nil {
+			log.Fatal().Msgf("failed to open idempotency store: %v", err)
+		}
+		options = append(options, application.WithIdempotency(idempotencyStore))
+	}
+	if cfg.DedupWindow > 0 {
+		options = append(options, application.WithDeduplication(cfg.DedupWindow))
+	}
+	if deadLetterQueue != nil {
+		options = append(options, application.WithDeadLetter(deadLetterQueue))
+	}
+	if cfg.PolicyFile != "" {
+		engine, err := policy.Load(cfg.PolicyFile)
+		if err != nil {
+			log.Fatal().Msgf("failed to load policy: %v", err)
+		}
+		options = append(options, application.WithPolicy(engine))
+	}
+	if cfg.SchemaDir != "" {
+		validator, err := schema.NewValidator(cfg.SchemaDir)
+		if err != nil {
+			log.Fatal().Msgf("failed to load schemas: %v", err)
+		}
+		options = append(options, application.WithValidation(validator, cfg.SchemaValidationMode == "warn"))
+	}
+	switch {
+	case cfg.SchemaRegistryFile != "":
+		fileRegistry, err := registry.NewFileRegistry(cfg.SchemaRegistryFile)
+		if err != nil {
+			log.Fatal().Msgf("failed to load schema registry file: %v", err)
+		}
+		options = append(options, application.WithEncoder(registry.NewEncoder(fileRegistry)))
+	case cfg.SchemaRegistryURL != "":
+		httpRegistry := registry.NewHTTPRegistry(cfg.SchemaRegistryURL, cfg.SchemaRegistryUsername, cfg.SchemaRegistryPassword, cfg.SchemaRegistryCacheTTL)
+		options = append(options, application.WithEncoder(registry.NewEncoder(httpRegistry)))
+	}
+	usecase := application.NewUsecase(producerRepository, options...)
+
+	// Require credentials on the API
+	auth, err := authentication(cfg)
+	if err != nil {
+		log.Fatal().Msgf("failed to load credentials: %v", err)
+	}
+	if auth.Enabled() {
+		routerOptions = append(routerOptions, httphandler.WithAuthentication(auth))
+	}
+
+	// The usecase is closed by then, so no send is in flight and the producer can be closed.
+	// The server bounds the whole sequence by what is left of the shutdown timeout.
+	release := func(ctx context.Context) {
+		producerRepository.Close()
+		log.Info().Msg("Producer closed")
+		if deadLetterQueue != nil {
+			deadLetterQueue.Close()
+		}
+		kafkaProducers.Close()
+		if closer, ok := idempotencyStore.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				log.Error().Err(err).Msg("Failed to close idempotency store")
+			}
+		}
+
+		// Export the pending spans
+		if err := shutdownTracing(ctx); err != nil {
+			log.Error().Err(err).Msg("Failed to stop tracing")
+		}
+	}
+
+	err = server.Run(cfg, usecase, release, routerOptions...)
+	if err != nil {
+		log.Error().Err(err).Msg("Server stopped with errors")
+		os.Exit(1)
+	}
+}
 
-	server.Run(cfg, usecase)
+// authentication loads the API keys and the JSON Web Key Set of the configuration
+func authentication(cfg config.Config) (httpmiddleware.Authentication, error) {
+	auth := httpmiddleware.Authentication{
+		APIKeys:     map[string]string{},
+		Issuer:      cfg.AuthJWTIssuer,
+		Audience:    cfg.AuthJWTAudience,
+		CallerClaim: cfg.AuthJWTCallerClaim,
+	}
+	for caller, hash := range cfg.AuthAPIKeys {
+		auth.APIKeys[caller] = hash
+	}
+	if cfg.AuthAPIKeysFile != "" {
+		keys, err := httpmiddleware.LoadAPIKeys(cfg.AuthAPIKeysFile)
+		if err != nil {
+			return auth, err
+		}
+		for caller, hash := range keys {
+			auth.APIKeys[caller] = hash
+		}
+	}
+	if cfg.AuthJWKSFile != "" {
+		keys, err := httpmiddleware.LoadJWKS(cfg.AuthJWKSFile)
+		if err != nil {
+			return auth, err
+		}
+		auth.JWKS = keys
+	}
+	return auth, nil
 }